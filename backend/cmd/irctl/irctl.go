@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/api"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/formats"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
+	"github.com/Light-Keeper/ir-remote/internal/lirc"
+	"github.com/Light-Keeper/ir-remote/internal/reverse"
+	"github.com/Light-Keeper/ir-remote/internal/waveform"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `irctl - decode, encode, convert and send IR commands
+
+Usage:
+  irctl decode  [-format auto] [file]
+  irctl encode  -protocol name [state flags] [-format json]
+  irctl convert [-from auto] -to format [file]
+  irctl render  [-protocol name] [-format auto] [-output ascii|svg|png] [-width 100] [file]
+  irctl diff    [-format auto] file[@param=value,...] file[@param=value,...]...
+  irctl analyze [-format auto] [-msb] [-protocol name] [-codec file.go] labelled files...
+  irctl send    [-server url] [-device id] (-protocol name [state flags] | [-format auto] file)
+  irctl set     [-server url] [-device id] [-force] [state flags]
+  irctl status  [-server url] [-device id]
+  irctl learn   [-lirc /dev/lirc0] [-timeout 30s] [-format json]
+  irctl replay  [-speed 1] [-secret key] recording
+
+Signals are read from the file or stdin. Formats: %v.
+AC protocols: %v.
+`
+
+type subcommand struct {
+	name string
+	run  func(args []string) error
+}
+
+var subcommands = []subcommand{
+	{"decode", runDecode},
+	{"encode", runEncode},
+	{"convert", runConvert},
+	{"render", runRender},
+	{"diff", runDiff},
+	{"analyze", runAnalyze},
+	{"send", runSend},
+	{"set", runSet},
+	{"status", runStatus},
+	{"learn", runLearn},
+	{"replay", runReplay},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range subcommands {
+		if cmd.name == os.Args[1] {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, "Error:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, usage, strings.Join(formats.Formats, ", "), strings.Join(commands.AcProtocols(), ", "))
+}
+
+func runDecode(args []string) error {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	format := fs.String("format", "auto", "input format")
+	_ = fs.Parse(args)
+
+	signal, err := readSignal(fs.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Timings:  ", len(signal.Timings))
+	fmt.Println("Carrier:  ", signal.Carrier)
+
+	protocol, cmd, err := commands.Decode(signal.Timings)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Protocol: ", protocol)
+	if debug, ok := cmd.(interface{ DebugString() string }); ok {
+		fmt.Println("Data:     ", debug.DebugString())
+	}
+	if ac, ok := cmd.(commands.AcCommand); ok {
+		fmt.Println("State:    ", ac.State())
+	}
+	return nil
+}
+
+func runEncode(args []string) error {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	protocol := fs.String("protocol", "", "AC protocol")
+	format := fs.String("format", formats.FormatJson, "output format")
+	state := acStateFlags(fs)
+	_ = fs.Parse(args)
+
+	cmd, err := encodeState(*protocol, state)
+	if err != nil {
+		return err
+	}
+
+	return writeSignal(formats.Signal{Timings: cmd.ToSignalSequence(), Carrier: cmd.Carrier()}, *format)
+}
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", "auto", "input format")
+	to := fs.String("to", formats.FormatJson, "output format")
+	_ = fs.Parse(args)
+
+	signal, err := readSignal(fs.Arg(0), *from)
+	if err != nil {
+		return err
+	}
+	return writeSignal(signal, *to)
+}
+
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	protocol := fs.String("protocol", "", "protocol to decode with, every known protocol if empty")
+	format := fs.String("format", "auto", "input format")
+	output := fs.String("output", "ascii", "output: ascii, svg or png")
+	width := fs.Int("width", 100, "terminal width for ascii output")
+	_ = fs.Parse(args)
+
+	signal, err := readSignal(fs.Arg(0), *format)
+	if err != nil {
+		return err
+	}
+
+	w := waveform.Describe(signal.Timings, *protocol)
+	var data []byte
+	switch *output {
+	case "ascii":
+		data = []byte(waveform.RenderAscii(w, *width))
+	case "svg":
+		data = waveform.RenderSvg(w)
+	case "png":
+		if data, err = waveform.RenderPng(w); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output %q", *output)
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// runDiff compares captures given as paths, optionally followed by the
+// remote settings they were captured with: cold20.json@mode=cold,temperature=20
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := fs.String("format", "auto", "input format")
+	_ = fs.Parse(args)
+
+	captures := make([]commands.Capture, 0, fs.NArg())
+	for _, arg := range fs.Args() {
+		path, paramsText, _ := strings.Cut(arg, "@")
+		signal, err := readSignal(path, *format)
+		if err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+
+		params := make(map[string]string)
+		for _, param := range strings.Split(paramsText, ",") {
+			if param == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(param, "=")
+			if !ok {
+				return fmt.Errorf("invalid parameter %q, expected name=value", param)
+			}
+			params[name] = value
+		}
+
+		captures = append(captures, commands.Capture{Name: path, Signal: signal.Timings, Params: params})
+	}
+
+	result, err := commands.Diff(captures)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(result)
+	return nil
+}
+
+// runAnalyze infers the layout of an unknown protocol from captures whose
+// names carry the remote settings, like cool_20.ir, see reverse.ParseLabel.
+func runAnalyze(args []string) error {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	format := fs.String("format", "auto", "input format")
+	msbFirst := fs.Bool("msb", false, "bits are sent MSB first")
+	protocol := fs.String("protocol", "unknown", "protocol name for the generated codec")
+	codec := fs.String("codec", "", "write a draft codec for the commands package to the file")
+	_ = fs.Parse(args)
+
+	samples := make([]reverse.Sample, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		signal, err := readSignal(path, *format)
+		if err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		samples = append(samples, reverse.Sample{Name: path, Signal: signal.Timings, Params: reverse.ParseLabel(path)})
+	}
+
+	analysis, err := reverse.Analyze(samples, *msbFirst)
+	if err != nil {
+		return err
+	}
+	fmt.Print(analysis.Report())
+
+	if *codec == "" {
+		return nil
+	}
+
+	source, err := reverse.GenerateCodec(*protocol, &analysis)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(*codec, source, 0644)
+}
+
+func runSend(args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	client := apiClientFlags(fs)
+	device := fs.String("device", "default", "device id")
+	protocol := fs.String("protocol", "", "AC protocol, sends the state given by the flags instead of a signal")
+	format := fs.String("format", "auto", "input format")
+	state := acStateFlags(fs)
+	_ = fs.Parse(args)
+
+	request := api.SendCommandRequest{}
+	if *protocol != "" {
+		acState, err := state()
+		if err != nil {
+			return err
+		}
+		request.Protocol = *protocol
+		request.State = &acState
+	} else {
+		signal, err := readSignal(fs.Arg(0), *format)
+		if err != nil {
+			return err
+		}
+		request.Signal = signal.Timings
+		request.Carrier = &signal.Carrier
+	}
+
+	err := client.do(http.MethodPost, "/api/devices/"+url.PathEscape(*device)+"/commands", request, nil)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Command sent")
+	return nil
+}
+
+func runSet(args []string) error {
+	fs := flag.NewFlagSet("set", flag.ExitOnError)
+	client := apiClientFlags(fs)
+	device := fs.String("device", "default", "device id")
+	force := fs.Bool("force", false, "send even if the AC is believed to be in the state")
+	state := acStateFlags(fs)
+	_ = fs.Parse(args)
+
+	request := api.DesiredStateRequest{Force: *force}
+	var err error
+	if request.State, err = state(); err != nil {
+		return err
+	}
+
+	result := acstate.SetResult{}
+	if err := client.do(http.MethodPut, "/api/devices/"+url.PathEscape(*device)+"/desired-state", request, &result); err != nil {
+		return err
+	}
+
+	if !result.Sent {
+		fmt.Println("AC is in the state already, nothing sent")
+		return nil
+	}
+	for _, change := range result.Changes {
+		fmt.Println(change)
+	}
+	fmt.Println("Command sent, AC is", result.State)
+	return nil
+}
+
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	client := apiClientFlags(fs)
+	device := fs.String("device", "", "device id, all devices if empty")
+	_ = fs.Parse(args)
+
+	devices := make([]api.DeviceResponse, 0)
+	if *device == "" {
+		if err := client.do(http.MethodGet, "/api/devices", nil, &devices); err != nil {
+			return err
+		}
+	} else {
+		response := api.DeviceResponse{}
+		if err := client.do(http.MethodGet, "/api/devices/"+url.PathEscape(*device), nil, &response); err != nil {
+			return err
+		}
+		devices = append(devices, response)
+	}
+
+	for _, d := range devices {
+		online := "offline"
+		if d.Online {
+			online = "online"
+		}
+
+		lastSeen := "never"
+		if d.LastSeen != nil {
+			lastSeen = d.LastSeen.Format(time.RFC3339)
+		}
+
+		room := ""
+		if d.Status.Temperature != nil {
+			room = fmt.Sprintf(", room %.1f°C", *d.Status.Temperature)
+		}
+		if d.Thermostat != nil && d.Thermostat.Config.Enabled {
+			room += ", thermostat " + d.Thermostat.Config.String()
+		}
+
+		fmt.Printf("%v: %v, last seen %v, last command %v, AC %v%v\n", d.Id, online, lastSeen, d.Status.LastCommandSequenceNumber, d.AcState, room)
+		if d.Status.FirmwareVersion != "" {
+			fmt.Printf("  firmware %v, rssi %v dBm, free heap %v, reset reason %v\n", d.Status.FirmwareVersion, d.Status.Rssi, d.Status.FreeHeap, d.Status.ResetReason)
+		}
+		if d.Status.UptimeSeconds != nil {
+			fmt.Printf("  uptime %v\n", time.Duration(*d.Status.UptimeSeconds)*time.Second)
+		}
+		if d.Health != nil && d.Health.RebootLoop {
+			fmt.Printf("  reboot loop: %v reboots, last reset reason %v\n", d.Health.Reboots, d.Health.LastResetReason)
+		}
+	}
+	return nil
+}
+
+// runLearn captures a signal with the IR receiver of this machine
+func runLearn(args []string) error {
+	fs := flag.NewFlagSet("learn", flag.ExitOnError)
+	path := fs.String("lirc", "/dev/lirc0", "LIRC device of the IR receiver")
+	timeout := fs.Duration("timeout", 30*time.Second, "how long to wait for a signal")
+	format := fs.String("format", formats.FormatJson, "output format")
+	_ = fs.Parse(args)
+
+	device, err := lirc.OpenDevice(*path)
+	if err != nil {
+		return err
+	}
+	defer device.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	fmt.Fprintln(os.Stderr, "Press a button of the remote...")
+	signal, err := device.Receive(ctx)
+	if err != nil {
+		return err
+	}
+	return writeSignal(signal, *format)
+}
+
+// runReplay runs a session against the recording file given as the argument,
+// like one the server writes to IR_RECORD_FILE, and tells how the recorded
+// commands fare
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	speed := fs.Float64("speed", 1, "how many times faster than recorded")
+	secret := fs.String("secret", "", "shared secret of the remote, the messages are not encrypted if empty")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	records, err := transport.ReadRecording(file)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return errors.New("recording is empty")
+	}
+
+	messageEncoder := encoder.NewDummyEncoder()
+	if *secret != "" {
+		messageEncoder = encoder.NewAesEncoder(*secret)
+	}
+
+	fmt.Printf("Replaying %v packets of %v\n", len(records), records[len(records)-1].Time.Sub(records[0].Time))
+	results, err := irremote.Replay(context.Background(), records, messageEncoder, *speed)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		outcome := "acknowledged"
+		if result.Err != nil {
+			outcome = result.Err.Error()
+		}
+		fmt.Printf("+%v command %v: %v\n", result.Offset, result.SequenceNumber, outcome)
+	}
+	return nil
+}
+
+// acStateFlags registers the flags describing an AC state and returns a function reading them.
+func acStateFlags(fs *flag.FlagSet) func() (commands.AcState, error) {
+	power := fs.Bool("power", true, "power on")
+	mode := fs.String("mode", "cool", "mode: auto, cool, dry, fan, heat")
+	temperature := fs.Int("temp", 24, "temperature, °C")
+	fan := fs.String("fan", "auto", "fan speed: auto, low, medium, high")
+	swing := fs.Bool("swing", false, "swing")
+
+	return func() (commands.AcState, error) {
+		state := commands.AcState{
+			Power:       *power,
+			Temperature: *temperature,
+			Swing:       *swing,
+		}
+
+		var err error
+		if state.Mode, err = commands.ParseAcMode(*mode); err != nil {
+			return state, err
+		}
+		if state.Fan, err = commands.ParseAcFan(*fan); err != nil {
+			return state, err
+		}
+		return state, nil
+	}
+}
+
+func encodeState(protocol string, state func() (commands.AcState, error)) (commands.AcCommand, error) {
+	if protocol == "" {
+		return nil, errors.New("-protocol is required")
+	}
+
+	acState, err := state()
+	if err != nil {
+		return nil, err
+	}
+
+	cmd, err := commands.NewAcCommand(protocol)
+	if err != nil {
+		return nil, err
+	}
+	return cmd, cmd.SetState(acState)
+}
+
+func readSignal(path string, format string) (formats.Signal, error) {
+	var data []byte
+	var err error
+	if path == "" || path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return formats.Signal{}, err
+	}
+
+	signal, err := formats.Parse(format, data)
+	if err != nil {
+		return formats.Signal{}, err
+	}
+	if len(signal.Timings) == 0 {
+		return formats.Signal{}, errors.New("signal is empty")
+	}
+	return signal, nil
+}
+
+func writeSignal(signal formats.Signal, format string) error {
+	data, err := formats.Format(format, signal)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+type apiClient struct {
+	server *string
+	token  *string
+}
+
+func apiClientFlags(fs *flag.FlagSet) *apiClient {
+	server := os.Getenv("IRCTL_SERVER")
+	if server == "" {
+		server = "http://127.0.0.1:8080"
+	}
+
+	return &apiClient{
+		server: fs.String("server", server, "server API address, $IRCTL_SERVER"),
+		token:  fs.String("token", os.Getenv("IRCTL_TOKEN"), "API token, $IRCTL_TOKEN"),
+	}
+}
+
+func (c *apiClient) do(method string, path string, body any, into any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequest(method, strings.TrimRight(*c.server, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if *c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+*c.token)
+	}
+
+	// sending waits for the remote to acknowledge the command
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		apiError := api.ErrorResponse{}
+		if err := json.NewDecoder(response.Body).Decode(&apiError); err != nil || apiError.Error == "" {
+			return fmt.Errorf("server responded with %v", response.Status)
+		}
+		return errors.New(apiError.Error)
+	}
+
+	if into == nil {
+		return nil
+	}
+	return json.NewDecoder(response.Body).Decode(into)
+}
@@ -2,45 +2,166 @@ package main
 
 import (
 	"context"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/api"
 	bot2 "github.com/Light-Keeper/ir-remote/internal/bot"
+	"github.com/Light-Keeper/ir-remote/internal/health"
+	"github.com/Light-Keeper/ir-remote/internal/homeassistant"
 	"github.com/Light-Keeper/ir-remote/internal/irremote"
 	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
 	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
+	"github.com/Light-Keeper/ir-remote/internal/lirc"
+	"github.com/Light-Keeper/ir-remote/internal/ota"
+	"github.com/Light-Keeper/ir-remote/internal/telemetry"
+	"github.com/Light-Keeper/ir-remote/internal/thermostat"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 )
 
-var udpListenIp = mustGetEnvString("IR_LISTEN_IP")
-var irListenUdpPort = mustGetEnvInt("IR_LISTEN_PORT")
+// irTransport is how the remote is reached: udp, listening on IR_LISTEN_IP
+// and IR_LISTEN_PORT, where IR_LISTEN_IP may list several comma separated
+// addresses like "0.0.0.0,::" or "[::1]:4945", with the buffers sized by IR_UDP_QUEUE_SIZE,
+// IR_UDP_READ_BUFFER and IR_UDP_WRITE_BUFFER, mqtt through the broker at IR_MQTT_URL, or websocket
+// listening on IR_WS_LISTEN_ADDR with the IR_WS_CERT and IR_WS_KEY TLS
+// certificate, or serial on the port IR_SERIAL_PORT at IR_SERIAL_BAUD. Several
+// comma separated transports are served at once.
+var irTransport = getEnvString("IR_TRANSPORT", "udp")
 var irSharedSecret = mustGetEnvString("IR_SHARED_SECRET")
 var botApiKey = mustGetEnvString("BOT_API")
 var botAuthorizedUsers = mustGetEnvString("BOT_AUTHORIZED_USERS")
+var apiListenAddr = getEnvString("API_LISTEN_ADDR", "127.0.0.1:8080")
+var apiToken = getEnvString("API_TOKEN", "")
+
+// acProtocol is the protocol of the AC, its states are set with the captures
+// of the original remote if empty
+var acProtocol = getEnvString("AC_PROTOCOL", "")
+
+var telemetryDb = getEnvString("TELEMETRY_DB", "telemetry.db")
+
+// otaDir holds the signed firmware images named <version>.bin, updates are
+// disabled if empty. The images are checked with the public key of the
+// firmware build and offered to the remotes under OTA_BASE_URL, the address
+// the remotes reach OTA_LISTEN_ADDR at, required with updates enabled.
+var otaDir = getEnvString("OTA_DIR", "")
+var otaPublicKey = getEnvString("OTA_PUBLIC_KEY", "public.key")
+
+// otaListenAddr serves only the images, apart from the API, the remotes
+// download them without authorization
+var otaListenAddr = getEnvString("OTA_LISTEN_ADDR", ":8081")
+
+// mqttUrl is the broker Home Assistant listens to, like tcp://localhost:1883.
+// The ACs are not exposed to Home Assistant if empty.
+var mqttUrl = getEnvString("MQTT_URL", "")
+var mqttUsername = getEnvString("MQTT_USERNAME", "")
+var mqttPassword = getEnvString("MQTT_PASSWORD", "")
+var haDiscoveryPrefix = getEnvString("HA_DISCOVERY_PREFIX", homeassistant.DefaultDiscoveryPrefix)
+
+var irUdpQueueSize = getEnvString("IR_UDP_QUEUE_SIZE", strconv.Itoa(transport.DefaultUdpOptions.ReceiveQueueSize))
+var irUdpReadBuffer = getEnvString("IR_UDP_READ_BUFFER", "0")
+var irUdpWriteBuffer = getEnvString("IR_UDP_WRITE_BUFFER", "0")
+var irMqttUrl = getEnvString("IR_MQTT_URL", mqttUrl)
+
+// irRecordFile is where the packets exchanged with the remotes are recorded
+// for irctl replay, nothing is recorded if empty. The payloads are recorded
+// decrypted too if irRecordDecrypt is true.
+var irRecordFile = getEnvString("IR_RECORD_FILE", "")
+var irRecordDecrypt = getEnvString("IR_RECORD_DECRYPT", "false")
+
+var irWsListenAddr = getEnvString("IR_WS_LISTEN_ADDR", ":8443")
+var irWsCert = getEnvString("IR_WS_CERT", "")
+var irWsKey = getEnvString("IR_WS_KEY", "")
+var irSerialBaud = getEnvString("IR_SERIAL_BAUD", strconv.Itoa(transport.DefaultSerialBaudRate))
+
+// lircDevice is a kernel LIRC device of this machine, like /dev/lirc0, to
+// control the AC with instead of the remote. Otherwise, if lircdConfig is set,
+// the AC is controlled through lircd at lircdSocket with the raw codes of
+// lircdRemote in that lircd configuration.
+var lircDevice = getEnvString("IR_LIRC_DEVICE", "")
+var lircdConfig = getEnvString("IR_LIRCD_CONFIG", "")
+var lircdSocket = getEnvString("IR_LIRCD_SOCKET", lirc.DefaultDaemonSocket)
+var lircdRemote = getEnvString("IR_LIRCD_REMOTE", "ir-remote")
+
+// DefaultDeviceId is the id the only remote is exposed under in the API
+const DefaultDeviceId = "default"
 
 func main() {
 	// aesEncoder := encoder.NewAesEncoder(irSharedSecret)
 	dummyEncoder := encoder.NewDummyEncoder()
-	udp := transport.NewUdpTransport()
-	session := irremote.NewSession(udp, dummyEncoder)
-	bot := bot2.NewBot(botApiKey, botAuthorizedUsers, session)
+	netLayer, runTransport := mustGetTransport()
+	if irRecordFile != "" {
+		var closeRecording func()
+		netLayer, runTransport, closeRecording = mustRecord(netLayer, runTransport, dummyEncoder)
+		defer closeRecording()
+	}
+	session := irremote.NewSession(netLayer, dummyEncoder)
+	states := acstate.NewTracker(acstate.DefaultStaleAfter)
+	acState := states.Device(DefaultDeviceId)
+	if local := mustGetLocalRemote(); local != nil {
+		acState.Control(local, mustGetEncoder())
+		acState.Watch(session)
+	} else {
+		acState.Control(session, mustGetEncoder())
+	}
+	roomThermostat := thermostat.New(session, acState)
+
+	telemetryStore, err := telemetry.Open(telemetryDb)
+	assertNoError(err)
+	defer telemetryStore.Close()
+	recorder := telemetry.NewRecorder(telemetryStore, DefaultDeviceId, session, acState)
+
+	monitor := health.NewMonitor()
+	monitor.Watch(session)
+
+	firmware := mustGetFirmware()
+	if firmware != nil {
+		firmware.AddDevice(DefaultDeviceId, session, monitor)
+	}
+
+	bot := bot2.NewBot(botApiKey, botAuthorizedUsers, bot2.Device{
+		Id:         DefaultDeviceId,
+		Session:    session,
+		State:      acState,
+		Thermostat: roomThermostat,
+		Telemetry:  recorder,
+		Health:     monitor,
+		Firmware:   firmware,
+	})
+	monitor.OnAlert(bot.Alert)
+	apiServer := api.NewServer(apiListenAddr, apiToken, map[string]*api.Device{
+		DefaultDeviceId: {Session: session, State: acState, Thermostat: roomThermostat, Health: monitor},
+	})
+	if firmware != nil {
+		firmware.OnChange(bot.ReportRollout)
+		apiServer.ServeFirmware(firmware)
+	}
+
+	var bridge *homeassistant.Bridge
+	if mqttUrl != "" {
+		options := mqtt.NewClientOptions().
+			AddBroker(mqttUrl).
+			SetClientID("ir-remote-backend").
+			SetUsername(mqttUsername).
+			SetPassword(mqttPassword)
+		bridge = homeassistant.NewBridge(options, haDiscoveryPrefix, map[string]homeassistant.Device{
+			DefaultDeviceId: {Remote: session, State: acState},
+		})
+	}
 
 	ctx, teardownApp := context.WithCancel(context.Background())
 
 	wg := &sync.WaitGroup{}
-	wg.Add(3)
+	wg.Add(9)
 
 	go func() {
 		defer wg.Done()
-		err := udp.ListenAndServe(ctx, &net.UDPAddr{
-			IP:   net.ParseIP(udpListenIp),
-			Port: irListenUdpPort,
-		})
-		if err != nil {
+		if err := runTransport(ctx); err != nil {
 			panic(err)
 		}
 	}()
@@ -50,6 +171,43 @@ func main() {
 		session.RunSession(ctx)
 	}()
 
+	go func() {
+		defer wg.Done()
+		roomThermostat.Run(ctx)
+	}()
+
+	go func() {
+		defer wg.Done()
+		recorder.Run(ctx)
+	}()
+
+	go func() {
+		defer wg.Done()
+		if firmware != nil {
+			firmware.Run(ctx)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if firmware == nil {
+			return
+		}
+		if err := firmware.Repository().ListenAndServe(ctx, otaListenAddr); err != nil {
+			panic(err)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		if bridge == nil {
+			return
+		}
+		if err := bridge.Run(ctx); err != nil {
+			panic(err)
+		}
+	}()
+
 	go func() {
 		defer wg.Done()
 		err := bot.Run(ctx)
@@ -58,6 +216,14 @@ func main() {
 		}
 	}()
 
+	go func() {
+		defer wg.Done()
+		err := apiServer.Run(ctx)
+		if err != nil {
+			panic(err)
+		}
+	}()
+
 	// graceful shutdown
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
@@ -69,8 +235,158 @@ func main() {
 	}
 }
 
+func mustGetEncoder() acstate.Encoder {
+	if acProtocol == "" {
+		return bot2.LearnedEncoder()
+	}
+	encoder, err := acstate.ProtocolEncoder(acProtocol)
+	assertNoError(err)
+	return encoder
+}
+
+// mustGetTransport creates the transports selected by IR_TRANSPORT and the
+// function that runs them
+func mustGetTransport() (transport.Transport, func(ctx context.Context) error) {
+	names := strings.Split(irTransport, ",")
+	if len(names) == 1 {
+		return mustGetSingleTransport(names[0])
+	}
+
+	transports := make([]transport.Transport, 0, len(names))
+	runners := make([]func(ctx context.Context) error, 0, len(names))
+	for _, name := range names {
+		t, run := mustGetSingleTransport(strings.TrimSpace(name))
+		transports = append(transports, t)
+		runners = append(runners, run)
+	}
+	multiplex, err := transport.NewMultiplexTransport(transports...)
+	assertNoError(err)
+
+	return multiplex, func(ctx context.Context) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go multiplex.Run(ctx)
+
+		errs := make(chan error, len(runners))
+		for _, run := range runners {
+			go func(run func(ctx context.Context) error) {
+				errs <- run(ctx)
+			}(run)
+		}
+
+		// the first failing transport stops the others
+		var result error
+		for range runners {
+			if err := <-errs; err != nil && result == nil {
+				result = err
+				cancel()
+			}
+		}
+		return result
+	}
+}
+
+// mustRecord records the packets of the transport to IR_RECORD_FILE
+func mustRecord(netLayer transport.Transport, run func(ctx context.Context) error, encoder encoder.Encoder) (transport.Transport, func(ctx context.Context) error, func()) {
+	file, err := os.OpenFile(irRecordFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	assertNoError(err)
+	decrypt, err := strconv.ParseBool(irRecordDecrypt)
+	assertNoError(err)
+
+	var decrypter transport.Decrypter
+	if decrypt {
+		decrypter = encoder
+	}
+	recording := transport.NewRecordingTransport(netLayer, file, decrypter)
+	log.Println("Recording the packets to", irRecordFile)
+
+	return recording, func(ctx context.Context) error {
+		go recording.Run(ctx)
+		return run(ctx)
+	}, func() { _ = file.Close() }
+}
+
+func mustGetSingleTransport(name string) (transport.Transport, func(ctx context.Context) error) {
+	switch name {
+	case "udp":
+		udp := transport.NewUdpTransportWithOptions(transport.UdpOptions{
+			ReceiveQueueSize: mustParseInt(irUdpQueueSize),
+			ReadBufferSize:   mustParseInt(irUdpReadBuffer),
+			WriteBufferSize:  mustParseInt(irUdpWriteBuffer),
+		})
+		addrs, err := transport.ParseUdpAddrs(mustGetEnvString("IR_LISTEN_IP"), mustGetEnvInt("IR_LISTEN_PORT"))
+		assertNoError(err)
+		return udp, func(ctx context.Context) error {
+			return udp.Run(ctx, addrs...)
+		}
+
+	case "mqtt":
+		if irMqttUrl == "" {
+			panic("Missing required environment variable: IR_MQTT_URL")
+		}
+		options := mqtt.NewClientOptions().
+			AddBroker(irMqttUrl).
+			SetClientID("ir-remote-backend-" + DefaultDeviceId).
+			SetUsername(mqttUsername).
+			SetPassword(mqttPassword)
+		mqttTransport := transport.NewMqttTransport(options, DefaultDeviceId)
+		return mqttTransport, mqttTransport.Run
+
+	case "websocket":
+		websocket := transport.NewWebsocketTransport(irSharedSecret)
+		return websocket, func(ctx context.Context) error {
+			return websocket.ListenAndServe(ctx, irWsListenAddr, irWsCert, irWsKey)
+		}
+
+	case "serial":
+		serial := transport.NewSerialTransport(mustGetEnvString("IR_SERIAL_PORT"), mustParseInt(irSerialBaud))
+		return serial, serial.Run
+
+	default:
+		panic("Unknown IR_TRANSPORT " + name + ", expected udp, mqtt, websocket or serial")
+	}
+}
+
+// mustGetLocalRemote returns the IR output of this machine the AC is
+// controlled with, nil if the AC is controlled with the remote
+func mustGetLocalRemote() *lirc.Remote {
+	switch {
+	case lircDevice != "":
+		device, err := lirc.OpenDevice(lircDevice)
+		assertNoError(err)
+		return lirc.NewRemote(device)
+
+	case lircdConfig != "":
+		config, err := os.ReadFile(lircdConfig)
+		assertNoError(err)
+		codes, err := lirc.ParseRawCodes(config, lircdRemote)
+		assertNoError(err)
+		return lirc.NewRemote(lirc.NewDaemon(lircdSocket, lircdRemote, codes))
+
+	default:
+		return nil
+	}
+}
+
+func mustGetFirmware() *ota.Manager {
+	if otaDir == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(otaPublicKey)
+	assertNoError(err)
+	publicKey, err := ota.ParsePublicKey(pem)
+	assertNoError(err)
+	images, err := ota.OpenRepository(otaDir, publicKey)
+	assertNoError(err)
+	return ota.NewManager(images, mustGetEnvString("OTA_BASE_URL"))
+}
+
 func mustGetEnvInt(key string) int {
-	val, err := strconv.Atoi(os.Getenv(key))
+	return mustParseInt(os.Getenv(key))
+}
+
+func mustParseInt(value string) int {
+	val, err := strconv.Atoi(value)
 	assertNoError(err)
 	return val
 }
@@ -83,6 +399,14 @@ func mustGetEnvString(key string) string {
 	return val
 }
 
+func getEnvString(key string, fallback string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback
+	}
+	return val
+}
+
 func assertNoError(err error) {
 	if err != nil {
 		panic(err)
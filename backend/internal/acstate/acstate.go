@@ -0,0 +1,193 @@
+package acstate
+
+import (
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultStaleAfter is how long a believed state is trusted without commands:
+// after that somebody has likely used the physical remote.
+const DefaultStaleAfter = 12 * time.Hour
+
+// Reasons a believed state is uncertain
+const ReasonUnknown = "no command has been sent yet"
+const ReasonNotAcknowledged = "the last command was not acknowledged by the remote"
+const ReasonUnknownCommand = "the last command has an unknown effect"
+const ReasonRemoteUsed = "the physical remote may have been used"
+
+// BelievedState is what the AC is supposed to be doing after the commands
+// sent to it. IR is one way, so it may be wrong: Uncertain is set when there
+// is a reason to doubt it.
+type BelievedState struct {
+	State commands.AcState `json:"state"`
+	// OffAt is when a timer will turn the AC off
+	OffAt     *time.Time `json:"off_at,omitempty"`
+	Uncertain bool       `json:"uncertain"`
+	Reason    string     `json:"reason,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+func (s BelievedState) String() string {
+	result := s.State.String()
+	if s.UpdatedAt == nil {
+		result = "unknown"
+	}
+	if s.OffAt != nil {
+		result += ", off at " + s.OffAt.Format("15:04")
+	}
+	if s.Uncertain {
+		result += " (uncertain: " + s.Reason + ")"
+	}
+	return result
+}
+
+// Tracker keeps the believed state of the AC behind every remote.
+type Tracker struct {
+	staleAfter time.Duration
+	now        func() time.Time
+
+	mx      sync.Mutex
+	devices map[string]*Device
+}
+
+func NewTracker(staleAfter time.Duration) *Tracker {
+	return &Tracker{
+		staleAfter: staleAfter,
+		now:        time.Now,
+		devices:    make(map[string]*Device),
+	}
+}
+
+// Device returns the tracked state of the device, creating it on first use.
+func (t *Tracker) Device(id string) *Device {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	device, ok := t.devices[id]
+	if !ok {
+		device = &Device{tracker: t}
+		t.devices[id] = device
+	}
+	return device
+}
+
+// Devices returns the ids of the tracked devices.
+func (t *Tracker) Devices() []string {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	result := make([]string, 0, len(t.devices))
+	for id := range t.devices {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Device is the believed state of a single AC.
+type Device struct {
+	tracker *Tracker
+	sendMx  sync.Mutex
+
+	mx        sync.Mutex
+	state     commands.AcState
+	known     bool
+	offAt     time.Time
+	uncertain string
+	updatedAt time.Time
+	remote    Remote
+	encoder   Encoder
+}
+
+// Watch applies every command sent through the remote.
+func (d *Device) Watch(remote Remote) {
+	remote.AddCommandListener(d.Observe)
+}
+
+// Observe applies a command sent to the AC. Acknowledged AC commands replace
+// the state, anything else makes it uncertain.
+func (d *Device) Observe(command commands.Command, err error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if err != nil {
+		d.uncertain = ReasonNotAcknowledged
+		return
+	}
+
+	acCommand, ok := command.(commands.AcCommand)
+	if !ok {
+		// raw signals may still be a known protocol
+		if _, decoded, decodeErr := commands.Decode(command.ToSignalSequence()); decodeErr == nil {
+			acCommand, ok = decoded.(commands.AcCommand)
+		}
+	}
+	if !ok {
+		d.uncertain = ReasonUnknownCommand
+		return
+	}
+
+	d.set(acCommand.State())
+}
+
+// Correct replaces the state with the one reported by the user, e.g. after
+// looking at the AC display.
+func (d *Device) Correct(state commands.AcState) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.set(state)
+}
+
+func (d *Device) set(state commands.AcState) {
+	d.state = state
+	d.known = true
+	d.uncertain = ""
+	d.updatedAt = d.tracker.now()
+	if !state.Power {
+		d.offAt = time.Time{}
+	}
+}
+
+// MarkUncertain records a reason to doubt the state, e.g. the remote was used.
+func (d *Device) MarkUncertain(reason string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.uncertain = reason
+}
+
+// SetOffTimer records when a timer will turn the AC off, zero time for none.
+func (d *Device) SetOffTimer(at time.Time) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.offAt = at
+}
+
+func (d *Device) State() BelievedState {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	result := BelievedState{State: d.state}
+	if !d.offAt.IsZero() {
+		offAt := d.offAt
+		result.OffAt = &offAt
+	}
+
+	switch {
+	case !d.known:
+		result.Reason = ReasonUnknown
+	case d.uncertain != "":
+		result.Reason = d.uncertain
+	case d.tracker.staleAfter > 0 && d.tracker.now().Sub(d.updatedAt) > d.tracker.staleAfter:
+		result.Reason = fmt.Sprintf("%v, no commands for %v", ReasonRemoteUsed, d.tracker.now().Sub(d.updatedAt).Round(time.Minute))
+	}
+	result.Uncertain = result.Reason != ""
+
+	if d.known {
+		updatedAt := d.updatedAt
+		result.UpdatedAt = &updatedAt
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package acstate
+
+import (
+	"errors"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var cool24 = commands.AcState{Power: true, Mode: commands.AcModeCool, Temperature: 24, Fan: commands.AcFanLow}
+
+func newTestDevice() (*Device, *time.Time) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	tracker := NewTracker(time.Hour)
+	tracker.now = func() time.Time { return now }
+	return tracker.Device("default"), &now
+}
+
+func TestDevice_Unknown(t *testing.T) {
+	device, _ := newTestDevice()
+
+	state := device.State()
+	require.True(t, state.Uncertain)
+	require.Equal(t, ReasonUnknown, state.Reason)
+	require.Nil(t, state.UpdatedAt)
+	require.Equal(t, "unknown (uncertain: "+ReasonUnknown+")", state.String())
+}
+
+func TestDevice_AcknowledgedCommand(t *testing.T) {
+	device, now := newTestDevice()
+
+	device.Observe(commands.NewCapturedCommand([]int{9000, 4500, 560}, cool24), nil)
+
+	state := device.State()
+	require.False(t, state.Uncertain)
+	require.Equal(t, cool24, state.State)
+	require.Equal(t, *now, *state.UpdatedAt)
+}
+
+func TestDevice_LostAcknowledgement(t *testing.T) {
+	device, _ := newTestDevice()
+	device.Observe(commands.NewCapturedCommand([]int{560}, cool24), nil)
+
+	off := commands.NewCapturedCommand([]int{560}, commands.AcState{})
+	device.Observe(off, errors.New("failed to send command, no response from remote"))
+
+	state := device.State()
+	require.True(t, state.Uncertain)
+	require.Equal(t, ReasonNotAcknowledged, state.Reason)
+	require.Equal(t, cool24, state.State)
+
+	device.Observe(off, nil)
+	require.False(t, device.State().Uncertain)
+	require.False(t, device.State().State.Power)
+}
+
+func TestDevice_RawCommand(t *testing.T) {
+	device, _ := newTestDevice()
+
+	gree := commands.NewGreeCommand()
+	require.NoError(t, gree.SetState(cool24))
+	device.Observe(commands.NewRawCommand(gree.ToSignalSequence()), nil)
+	require.False(t, device.State().Uncertain)
+	require.Equal(t, gree.State(), device.State().State)
+
+	device.Observe(commands.NewRawCommand([]int{9000, 4500, 560}), nil)
+	require.True(t, device.State().Uncertain)
+	require.Equal(t, ReasonUnknownCommand, device.State().Reason)
+}
+
+func TestDevice_Stale(t *testing.T) {
+	device, now := newTestDevice()
+	device.Observe(commands.NewCapturedCommand([]int{560}, cool24), nil)
+
+	*now = now.Add(2 * time.Hour)
+	state := device.State()
+	require.True(t, state.Uncertain)
+	require.Equal(t, ReasonRemoteUsed+", no commands for 2h0m0s", state.Reason)
+
+	device.Correct(commands.AcState{Power: true, Mode: commands.AcModeHeat, Temperature: 22})
+	require.False(t, device.State().Uncertain)
+	require.Equal(t, commands.AcModeHeat, device.State().State.Mode)
+}
+
+func TestDevice_OffTimer(t *testing.T) {
+	device, now := newTestDevice()
+	device.Observe(commands.NewCapturedCommand([]int{560}, cool24), nil)
+
+	device.SetOffTimer(now.Add(30 * time.Minute))
+	require.Equal(t, now.Add(30*time.Minute), *device.State().OffAt)
+	require.Equal(t, "cool 24°C fan:low swing:false, off at 12:30", device.State().String())
+
+	device.Observe(commands.NewCapturedCommand([]int{560}, commands.AcState{}), nil)
+	require.Nil(t, device.State().OffAt)
+}
@@ -0,0 +1,162 @@
+package acstate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+)
+
+// Remote sends commands to the AC, usually an *irremote.Session.
+type Remote interface {
+	SendCommand(ctx context.Context, command commands.Command) error
+	AddCommandListener(listener irremote.CommandListener)
+}
+
+var ErrNoRemote = errors.New("device has no remote to send commands with")
+var ErrUnsupportedState = errors.New("state can not be encoded")
+
+// Encoder makes the single full state command that puts the AC into the state.
+type Encoder func(state commands.AcState) (commands.AcCommand, error)
+
+// ProtocolEncoder encodes states with one of the commands.AcProtocols.
+func ProtocolEncoder(protocol string) (Encoder, error) {
+	if _, err := commands.NewAcCommand(protocol); err != nil {
+		return nil, err
+	}
+
+	return func(state commands.AcState) (commands.AcCommand, error) {
+		command, err := commands.NewAcCommand(protocol)
+		if err != nil {
+			return nil, err
+		}
+		return command, command.SetState(state)
+	}, nil
+}
+
+// CaptureEncoder sends captures of the original remote, for ACs with an
+// unsupported protocol. Only the captured states can be set; a single
+// capture with power off, under the zero state, turns the AC off.
+func CaptureEncoder(captures map[commands.AcState][]int) Encoder {
+	return func(state commands.AcState) (commands.AcCommand, error) {
+		if !state.Power {
+			state = commands.AcState{}
+		}
+		capture, ok := captures[state]
+		if !ok {
+			return nil, fmt.Errorf("no capture of the original remote for %v", state)
+		}
+		return commands.NewCapturedCommand(capture, state), nil
+	}
+}
+
+// SetResult tells what SetDesiredState did.
+type SetResult struct {
+	// Sent is false if the AC is believed to be in the desired state already
+	Sent bool `json:"sent"`
+	// Changes are the differences from the believed state, empty if it was unknown
+	Changes []string      `json:"changes"`
+	State   BelievedState `json:"state"`
+}
+
+// Control makes the device send its commands through the remote, encoded by
+// the encoder, and applies every command sent through the remote.
+func (d *Device) Control(remote Remote, encoder Encoder) {
+	d.mx.Lock()
+	d.remote = remote
+	d.encoder = encoder
+	d.mx.Unlock()
+
+	d.Watch(remote)
+}
+
+// SetDesiredState makes the AC of the device be in the state. The command is
+// skipped if the AC is believed to be in the state already, unless forced or
+// the believed state is uncertain.
+func (t *Tracker) SetDesiredState(ctx context.Context, device string, state commands.AcState, force bool) (SetResult, error) {
+	return t.Device(device).SetDesiredState(ctx, state, force)
+}
+
+func (d *Device) SetDesiredState(ctx context.Context, state commands.AcState, force bool) (SetResult, error) {
+	// one command at a time, so the diff is made against the result of the previous one
+	d.sendMx.Lock()
+	defer d.sendMx.Unlock()
+
+	d.mx.Lock()
+	remote, encoder := d.remote, d.encoder
+	d.mx.Unlock()
+	if remote == nil {
+		return SetResult{}, ErrNoRemote
+	}
+
+	believed := d.State()
+	result := SetResult{Changes: make([]string, 0)}
+	if believed.UpdatedAt != nil {
+		result.Changes = Changes(believed.State, state)
+	}
+
+	if !force && !believed.Uncertain && len(result.Changes) == 0 {
+		result.State = believed
+		return result, nil
+	}
+
+	if !state.Power && believed.UpdatedAt != nil {
+		// remotes keep the settings when turning the AC off
+		off := believed.State
+		off.Power = false
+		state = off
+	} else if !state.Power && state.Temperature == 0 {
+		// nothing to keep, the encoders still need valid settings
+		state.Mode, state.Temperature = DefaultOffState.Mode, DefaultOffState.Temperature
+	}
+
+	command, err := encoder(state)
+	if err != nil {
+		return SetResult{}, fmt.Errorf("%w: %v", ErrUnsupportedState, err)
+	}
+
+	if err := remote.SendCommand(ctx, command); err != nil {
+		return SetResult{}, err
+	}
+	result.Sent = true
+	result.State = d.State()
+	return result, nil
+}
+
+// DefaultOffState has the settings sent with power off when the state of
+// the AC is not known.
+var DefaultOffState = commands.AcState{Mode: commands.AcModeCool, Temperature: 24}
+
+// Changes lists the settings that differ between the states. Settings of an
+// AC that is off do not matter.
+func Changes(from commands.AcState, to commands.AcState) []string {
+	result := make([]string, 0)
+	if from.Power != to.Power {
+		result = append(result, fmt.Sprintf("power: %v → %v", onOff(from.Power), onOff(to.Power)))
+	}
+	if !to.Power {
+		return result
+	}
+
+	if from.Mode != to.Mode {
+		result = append(result, fmt.Sprintf("mode: %v → %v", from.Mode, to.Mode))
+	}
+	if from.Temperature != to.Temperature {
+		result = append(result, fmt.Sprintf("temperature: %v → %v", from.Temperature, to.Temperature))
+	}
+	if from.Fan != to.Fan {
+		result = append(result, fmt.Sprintf("fan: %v → %v", from.Fan, to.Fan))
+	}
+	if from.Swing != to.Swing {
+		result = append(result, fmt.Sprintf("swing: %v → %v", from.Swing, to.Swing))
+	}
+	return result
+}
+
+func onOff(power bool) string {
+	if power {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,145 @@
+package acstate
+
+import (
+	"context"
+	"errors"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// fakeRemote acknowledges commands unless err is set
+type fakeRemote struct {
+	listeners []irremote.CommandListener
+	sent      []commands.Command
+	err       error
+}
+
+func (f *fakeRemote) SendCommand(_ context.Context, command commands.Command) error {
+	f.sent = append(f.sent, command)
+	for _, listener := range f.listeners {
+		listener(command, f.err)
+	}
+	return f.err
+}
+
+func (f *fakeRemote) AddCommandListener(listener irremote.CommandListener) {
+	f.listeners = append(f.listeners, listener)
+}
+
+func newControlledDevice(t *testing.T) (*Device, *fakeRemote) {
+	device, _ := newTestDevice()
+	remote := &fakeRemote{}
+	encoder, err := ProtocolEncoder("gree")
+	require.NoError(t, err)
+	device.Control(remote, encoder)
+	return device, remote
+}
+
+func TestDevice_SetDesiredState(t *testing.T) {
+	device, remote := newControlledDevice(t)
+	ctx := context.Background()
+
+	// unknown state is always sent
+	result, err := device.SetDesiredState(ctx, cool24, false)
+	require.NoError(t, err)
+	require.True(t, result.Sent)
+	require.Empty(t, result.Changes)
+	require.Len(t, remote.sent, 1)
+	require.Equal(t, cool24, result.State.State)
+	require.False(t, result.State.Uncertain)
+
+	result, err = device.SetDesiredState(ctx, cool24, false)
+	require.NoError(t, err)
+	require.False(t, result.Sent)
+	require.Len(t, remote.sent, 1)
+
+	result, err = device.SetDesiredState(ctx, cool24, true)
+	require.NoError(t, err)
+	require.True(t, result.Sent)
+	require.Len(t, remote.sent, 2)
+
+	cool20 := cool24
+	cool20.Temperature = 20
+	result, err = device.SetDesiredState(ctx, cool20, false)
+	require.NoError(t, err)
+	require.True(t, result.Sent)
+	require.Equal(t, []string{"temperature: 24 → 20"}, result.Changes)
+
+	gree := commands.NewGreeCommand()
+	require.NoError(t, gree.ParseFromSignalSequence(remote.sent[2].ToSignalSequence()))
+	require.Equal(t, cool20, gree.State())
+}
+
+func TestDevice_SetDesiredState_Off(t *testing.T) {
+	device, remote := newControlledDevice(t)
+	device.Correct(commands.AcState{Mode: commands.AcModeHeat})
+
+	// settings of an AC that is off do not matter
+	result, err := device.SetDesiredState(context.Background(), commands.AcState{Mode: commands.AcModeCool}, false)
+	require.NoError(t, err)
+	require.False(t, result.Sent)
+	require.Empty(t, remote.sent)
+}
+
+func TestDevice_SetDesiredState_OffUnknown(t *testing.T) {
+	for _, protocol := range commands.AcProtocols() {
+		t.Run(protocol, func(t *testing.T) {
+			device, _ := newTestDevice()
+			remote := &fakeRemote{}
+			encoder, err := ProtocolEncoder(protocol)
+			require.NoError(t, err)
+			device.Control(remote, encoder)
+
+			// the bot off button, the off timer and "mode: off" of Home Assistant
+			result, err := device.SetDesiredState(context.Background(), commands.AcState{}, false)
+			require.NoError(t, err)
+			require.True(t, result.Sent)
+			require.Len(t, remote.sent, 1)
+			require.False(t, device.State().State.Power)
+		})
+	}
+}
+
+func TestDevice_SetDesiredState_Uncertain(t *testing.T) {
+	device, remote := newControlledDevice(t)
+	device.Correct(cool24)
+	device.MarkUncertain(ReasonRemoteUsed)
+
+	result, err := device.SetDesiredState(context.Background(), cool24, false)
+	require.NoError(t, err)
+	require.True(t, result.Sent)
+	require.False(t, result.State.Uncertain)
+
+	remote.err = errors.New("failed to send command, no response from remote")
+	_, err = device.SetDesiredState(context.Background(), commands.AcState{}, false)
+	require.Error(t, err)
+	require.Equal(t, ReasonNotAcknowledged, device.State().Reason)
+	require.Len(t, remote.sent, 2)
+}
+
+func TestCaptureEncoder(t *testing.T) {
+	encoder := CaptureEncoder(map[commands.AcState][]int{
+		{}:     {9000, 4500, 560},
+		cool24: {9000, 4500, 560, 560, 560},
+	})
+
+	command, err := encoder(commands.AcState{Mode: commands.AcModeDry})
+	require.NoError(t, err)
+	require.Equal(t, []int{9000, 4500, 560}, command.ToSignalSequence())
+	require.Equal(t, commands.AcState{}, command.State())
+
+	command, err = encoder(cool24)
+	require.NoError(t, err)
+	require.Equal(t, cool24, command.State())
+
+	_, err = encoder(commands.AcState{Power: true, Temperature: 30})
+	require.Error(t, err)
+}
+
+func TestDevice_SetDesiredState_NoRemote(t *testing.T) {
+	device, _ := newTestDevice()
+	_, err := device.SetDesiredState(context.Background(), cool24, false)
+	require.Error(t, err)
+}
@@ -0,0 +1,376 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/health"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/Light-Keeper/ir-remote/internal/ota"
+	"github.com/Light-Keeper/ir-remote/internal/thermostat"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type DeviceResponse struct {
+	Id       string          `json:"id"`
+	Online   bool            `json:"online"`
+	LastSeen *time.Time      `json:"last_seen,omitempty"`
+	Status   irremote.Status `json:"status"`
+	// AcState is the believed state of the AC the remote controls
+	AcState    acstate.BelievedState `json:"ac_state"`
+	Thermostat *thermostat.Status    `json:"thermostat,omitempty"`
+	Health     *health.Status        `json:"health,omitempty"`
+}
+
+// SendCommandRequest is either a raw signal with an optional carrier, or an
+// AC protocol name with the state to encode.
+type SendCommandRequest struct {
+	Signal   []int             `json:"signal,omitempty"`
+	Carrier  *commands.Carrier `json:"carrier,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	State    *commands.AcState `json:"state,omitempty"`
+}
+
+// DesiredStateRequest sets the state of the AC, skipping the command if the AC
+// is believed to be in the state already unless Force is set.
+type DesiredStateRequest struct {
+	State commands.AcState `json:"state"`
+	Force bool             `json:"force,omitempty"`
+}
+
+// RolloutRequest updates the devices, all of them if none are given, to the
+// version, the latest image if it is empty.
+type RolloutRequest struct {
+	Version string   `json:"version,omitempty"`
+	Devices []string `json:"devices,omitempty"`
+}
+
+type FirmwareResponse struct {
+	Images   []ota.Image       `json:"images"`
+	Devices  map[string]string `json:"devices"`
+	Rollouts []ota.Rollout     `json:"rollouts"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Device is a remote with the AC it controls. Thermostat and Health are optional.
+type Device struct {
+	Session    *irremote.Session
+	State      *acstate.Device
+	Thermostat *thermostat.Thermostat
+	Health     *health.Monitor
+}
+
+type Server struct {
+	addr    string
+	token   string
+	devices map[string]*Device
+	// firmware is nil unless updates are distributed
+	firmware *ota.Manager
+}
+
+// NewServer creates the HTTP API. If token is not empty, every request must
+// carry it as a bearer token.
+func NewServer(addr string, token string, devices map[string]*Device) *Server {
+	return &Server{
+		addr:    addr,
+		token:   token,
+		devices: devices,
+	}
+}
+
+// ServeFirmware enables the firmware API. The images are not served here, the
+// remotes download them from the listener of the repository.
+func (s *Server) ServeFirmware(manager *ota.Manager) {
+	s.firmware = manager
+}
+
+func (s *Server) Run(ctx context.Context) error {
+	server := &http.Server{
+		Addr:    s.addr,
+		Handler: s.Handler(),
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.Println("API listening on", s.addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/devices", s.handleDevices)
+	mux.HandleFunc("/api/devices/", s.handleDevice)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	if s.firmware != nil {
+		mux.HandleFunc("/api/firmware", s.handleFirmware)
+		mux.HandleFunc("/api/firmware/rollouts", s.handleRollouts)
+	}
+	return s.authorize(mux)
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := []byte(r.Header.Get("Authorization"))
+		if s.token != "" && subtle.ConstantTimeCompare(authorization, []byte("Bearer "+s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	ids := s.deviceIds()
+	result := make([]DeviceResponse, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, s.describeDevice(id))
+	}
+	writeJson(w, http.StatusOK, result)
+}
+
+func (s *Server) deviceIds() []string {
+	ids := make([]string, 0, len(s.devices))
+	for id := range s.devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// handleDevice serves /api/devices/{id} and /api/devices/{id}/{action}
+func (s *Server) handleDevice(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/devices/"), "/")
+	device, ok := s.devices[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown device %q", id))
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, s.describeDevice(id))
+	case action == "commands" && r.Method == http.MethodPost:
+		s.handleSendCommand(w, r, device.Session)
+	case action == "state" && r.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, device.State.State())
+	case action == "state" && r.Method == http.MethodPut:
+		s.handleCorrectState(w, r, device.State)
+	case action == "desired-state" && r.Method == http.MethodPut:
+		s.handleSetDesiredState(w, r, device.State)
+	case action == "remote-used" && r.Method == http.MethodPost:
+		device.State.MarkUncertain(acstate.ReasonRemoteUsed)
+		writeJson(w, http.StatusOK, device.State.State())
+	case action == "thermostat" && device.Thermostat == nil:
+		writeError(w, http.StatusNotFound, errors.New("device has no thermostat"))
+	case action == "thermostat" && r.Method == http.MethodGet:
+		writeJson(w, http.StatusOK, device.Thermostat.Status())
+	case action == "thermostat" && r.Method == http.MethodPut:
+		s.handleConfigureThermostat(w, r, device.Thermostat)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (s *Server) handleSendCommand(w http.ResponseWriter, r *http.Request, session *irremote.Session) {
+	request := SendCommandRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	command, err := request.Command()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := session.SendCommand(r.Context(), command); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCorrectState replaces the believed state with the one the user sees on the AC
+func (s *Server) handleCorrectState(w http.ResponseWriter, r *http.Request, device *acstate.Device) {
+	state := commands.AcState{}
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	device.Correct(state)
+	writeJson(w, http.StatusOK, device.State())
+}
+
+func (s *Server) handleSetDesiredState(w http.ResponseWriter, r *http.Request, device *acstate.Device) {
+	request := DesiredStateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := device.SetDesiredState(r.Context(), request.State, request.Force)
+	switch {
+	case errors.Is(err, acstate.ErrUnsupportedState):
+		writeError(w, http.StatusBadRequest, err)
+	case errors.Is(err, acstate.ErrNoRemote):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusBadGateway, err)
+	default:
+		writeJson(w, http.StatusOK, result)
+	}
+}
+
+func (s *Server) handleConfigureThermostat(w http.ResponseWriter, r *http.Request, t *thermostat.Thermostat) {
+	config := t.Config()
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := t.SetConfig(config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJson(w, http.StatusOK, t.Status())
+}
+
+func (s *Server) handleFirmware(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	versions := make(map[string]string, len(s.devices))
+	for id := range s.devices {
+		versions[id] = s.firmware.FirmwareVersion(id)
+	}
+	writeJson(w, http.StatusOK, FirmwareResponse{
+		Images:   s.firmware.Images(),
+		Devices:  versions,
+		Rollouts: s.firmware.Rollouts(),
+	})
+}
+
+func (s *Server) handleRollouts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, http.StatusOK, s.firmware.Rollouts())
+
+	case http.MethodPost:
+		request := RolloutRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if request.Version == "" {
+			latest, ok := s.firmware.Latest()
+			if !ok {
+				writeError(w, http.StatusNotFound, errors.New("no firmware images"))
+				return
+			}
+			request.Version = latest.Version
+		}
+
+		rollouts, err := s.firmware.Rollout(r.Context(), request.Version, request.Devices)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJson(w, http.StatusOK, rollouts)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+// Command builds the command described by the request.
+func (r SendCommandRequest) Command() (commands.Command, error) {
+	switch {
+	case r.Protocol != "" && r.State != nil:
+		command, err := commands.NewAcCommand(r.Protocol)
+		if err != nil {
+			return nil, err
+		}
+		if err := command.SetState(*r.State); err != nil {
+			return nil, err
+		}
+		return command, nil
+
+	case len(r.Signal) > 0:
+		carrier := commands.ProtocolCarrier(r.Protocol)
+		if r.Carrier != nil {
+			carrier = *r.Carrier
+		}
+		return commands.NewRawCommandWithCarrier(r.Signal, carrier), nil
+
+	default:
+		return nil, errors.New("either signal or protocol and state are required")
+	}
+}
+
+func (s *Server) describeDevice(id string) DeviceResponse {
+	device := s.devices[id]
+	status, lastSeen := device.Session.LastStatus()
+	response := DeviceResponse{
+		Id:      id,
+		Online:  device.Session.IsOnline(),
+		Status:  status,
+		AcState: device.State.State(),
+	}
+	if device.Thermostat != nil {
+		thermostatStatus := device.Thermostat.Status()
+		response.Thermostat = &thermostatStatus
+	}
+	if device.Health != nil {
+		healthStatus := device.Health.Status(time.Now())
+		response.Health = &healthStatus
+	}
+	if !lastSeen.IsZero() {
+		response.LastSeen = &lastSeen
+	}
+	return response
+}
+
+func writeJson(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("failed to write response", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJson(w, code, ErrorResponse{Error: err.Error()})
+}
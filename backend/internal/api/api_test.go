@@ -0,0 +1,351 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/health"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
+	"github.com/Light-Keeper/ir-remote/internal/ota"
+	"github.com/Light-Keeper/ir-remote/internal/thermostat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRemote acknowledges every command it receives
+type fakeRemote struct {
+	encoder  encoder.Encoder
+	receive  chan transport.Packet
+	commands []irremote.Command
+}
+
+func newFakeRemote() *fakeRemote {
+	return &fakeRemote{
+		encoder: encoder.NewDummyEncoder(),
+		receive: make(chan transport.Packet, 10),
+	}
+}
+
+func (f *fakeRemote) Name() string {
+	return transport.UdpTransportName
+}
+
+func (f *fakeRemote) Send(_ context.Context, packet transport.Packet) error {
+	cmd := irremote.Command{}
+	if err := f.encoder.Decrypt(packet.Data, &cmd); err != nil {
+		return err
+	}
+	f.commands = append(f.commands, cmd)
+	f.report(irremote.Status{LastCommandSequenceNumber: cmd.SequenceNumber})
+	return nil
+}
+
+func (f *fakeRemote) Receive() <-chan transport.Packet {
+	return f.receive
+}
+
+func (f *fakeRemote) report(status irremote.Status) {
+	f.receive <- transport.Packet{
+		Peer: transport.UdpPeer(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4944}),
+		Data: f.encoder.Encrypt(status),
+	}
+}
+
+func newTestServer(t *testing.T, token string) (*httptest.Server, *fakeRemote) {
+	return newTestServerWithFirmware(t, token, nil)
+}
+
+func newTestServerWithFirmware(t *testing.T, token string, firmware *ota.Manager) (*httptest.Server, *fakeRemote) {
+	remote := newFakeRemote()
+	session := irremote.NewSession(remote, encoder.NewDummyEncoder())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go session.RunSession(ctx)
+
+	remote.report(irremote.Status{})
+	require.Eventually(t, session.IsOnline, time.Second, 10*time.Millisecond)
+
+	states := acstate.NewTracker(acstate.DefaultStaleAfter)
+	encoder, err := acstate.ProtocolEncoder("gree")
+	require.NoError(t, err)
+	states.Device("default").Control(session, encoder)
+
+	monitor := health.NewMonitor()
+	monitor.Watch(session)
+
+	device := &Device{
+		Session:    session,
+		State:      states.Device("default"),
+		Thermostat: thermostat.New(session, states.Device("default")),
+		Health:     monitor,
+	}
+	api := NewServer("", token, map[string]*Device{"default": device})
+	if firmware != nil {
+		firmware.AddDevice("default", session, monitor)
+		api.ServeFirmware(firmware)
+	}
+	server := httptest.NewServer(api.Handler())
+	t.Cleanup(server.Close)
+	return server, remote
+}
+
+func TestApi_Devices(t *testing.T) {
+	server, _ := newTestServer(t, "")
+
+	response, err := http.Get(server.URL + "/api/devices")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	devices := make([]DeviceResponse, 0)
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&devices))
+	require.Len(t, devices, 1)
+	assert.Equal(t, "default", devices[0].Id)
+	assert.True(t, devices[0].Online)
+	assert.NotNil(t, devices[0].LastSeen)
+}
+
+func TestApi_SendCommand(t *testing.T) {
+	server, remote := newTestServer(t, "")
+
+	body := `{"protocol": "gree", "state": {"power": true, "mode": "cool", "temperature": 22, "fan": "auto"}}`
+	response, err := http.Post(server.URL+"/api/devices/default/commands", "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	require.Len(t, remote.commands, 1)
+	assert.Equal(t, 38000, remote.commands[0].Frequency)
+
+	response, err = http.Post(server.URL+"/api/devices/default/commands", "application/json", bytes.NewBufferString(`{}`))
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	response, err = http.Post(server.URL+"/api/devices/unknown/commands", "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestApi_Token(t *testing.T) {
+	server, _ := newTestServer(t, "secret")
+
+	response, err := http.Get(server.URL + "/api/devices")
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/api/devices", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestApi_Firmware(t *testing.T) {
+	images, err := ota.OpenRepository(t.TempDir(), nil)
+	require.NoError(t, err)
+	server, _ := newTestServerWithFirmware(t, "secret", ota.NewManager(images, "http://backend"))
+
+	// the images are served apart from the API
+	response, err := http.Get(server.URL + "/ota/1.0.0.bin")
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	response, err = http.Get(server.URL + "/api/firmware")
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/api/firmware", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	firmware := FirmwareResponse{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&firmware))
+	response.Body.Close()
+	assert.Empty(t, firmware.Images)
+	assert.Equal(t, map[string]string{"default": ""}, firmware.Devices)
+
+	request, _ = http.NewRequest(http.MethodPost, server.URL+"/api/firmware/rollouts", strings.NewReader(`{}`))
+	request.Header.Set("Authorization", "Bearer secret")
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestApi_State(t *testing.T) {
+	server, _ := newTestServer(t, "")
+
+	getState := func() acstate.BelievedState {
+		response, err := http.Get(server.URL + "/api/devices/default/state")
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		state := acstate.BelievedState{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&state))
+		return state
+	}
+
+	require.True(t, getState().Uncertain)
+
+	body := `{"protocol": "gree", "state": {"power": true, "mode": "cool", "temperature": 22, "fan": "auto"}}`
+	response, err := http.Post(server.URL+"/api/devices/default/commands", "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	state := getState()
+	assert.False(t, state.Uncertain)
+	assert.Equal(t, commands.AcState{Power: true, Mode: commands.AcModeCool, Temperature: 22}, state.State)
+
+	response, err = http.Post(server.URL+"/api/devices/default/remote-used", "application/json", nil)
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, acstate.ReasonRemoteUsed, getState().Reason)
+
+	request, _ := http.NewRequest(http.MethodPut, server.URL+"/api/devices/default/state", bytes.NewBufferString(`{"power": false}`))
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	assert.False(t, getState().Uncertain)
+	assert.False(t, getState().State.Power)
+}
+
+func TestApi_DesiredState(t *testing.T) {
+	server, remote := newTestServer(t, "")
+
+	setState := func(body string) (int, acstate.SetResult) {
+		request, _ := http.NewRequest(http.MethodPut, server.URL+"/api/devices/default/desired-state", bytes.NewBufferString(body))
+		response, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		result := acstate.SetResult{}
+		if response.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(response.Body).Decode(&result))
+		}
+		return response.StatusCode, result
+	}
+
+	code, result := setState(`{"state": {"power": true, "mode": "cool", "temperature": 24}}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, result.Sent)
+	assert.Len(t, remote.commands, 1)
+
+	code, result = setState(`{"state": {"power": true, "mode": "cool", "temperature": 24}}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, result.Sent)
+	assert.Len(t, remote.commands, 1)
+
+	code, result = setState(`{"state": {"power": true, "mode": "cool", "temperature": 24}, "force": true}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.True(t, result.Sent)
+	assert.Len(t, remote.commands, 2)
+
+	code, _ = setState(`{"state": {"power": true, "mode": "cool", "temperature": 99}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestApi_Thermostat(t *testing.T) {
+	server, remote := newTestServer(t, "")
+
+	temperature := 26.5
+	remote.report(irremote.Status{Temperature: &temperature})
+	require.Eventually(t, func() bool {
+		response, err := http.Get(server.URL + "/api/devices/default")
+		require.NoError(t, err)
+		defer response.Body.Close()
+
+		device := DeviceResponse{}
+		require.NoError(t, json.NewDecoder(response.Body).Decode(&device))
+		return device.Status.Temperature != nil && *device.Status.Temperature == temperature
+	}, time.Second, 10*time.Millisecond)
+
+	request, _ := http.NewRequest(http.MethodPut, server.URL+"/api/devices/default/thermostat", bytes.NewBufferString(`{"enabled": true, "low": 22, "high": 24}`))
+	response, err := http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	status := thermostat.Status{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&status))
+	assert.True(t, status.Config.Enabled)
+	assert.Equal(t, commands.AcModeCool, status.Config.Mode)
+	assert.Equal(t, 22.0, status.Config.Low)
+	assert.Equal(t, thermostat.DefaultConfig.MinOnSeconds, status.Config.MinOnSeconds)
+
+	request, _ = http.NewRequest(http.MethodPut, server.URL+"/api/devices/default/thermostat", bytes.NewBufferString(`{"low": 25, "high": 24}`))
+	response, err = http.DefaultClient.Do(request)
+	require.NoError(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestApi_Metrics(t *testing.T) {
+	server, remote := newTestServer(t, "")
+
+	uptime := int64(120)
+	temperature := 24.5
+	remote.report(irremote.Status{
+		FirmwareVersion:  "1.4.0",
+		UptimeSeconds:    &uptime,
+		Rssi:             -67,
+		FreeHeap:         23456,
+		ResetReason:      "Power On",
+		MaxCommandLength: 300,
+		Temperature:      &temperature,
+	})
+
+	var body string
+	require.Eventually(t, func() bool {
+		response, err := http.Get(server.URL + "/metrics")
+		require.NoError(t, err)
+		defer response.Body.Close()
+		require.Equal(t, http.StatusOK, response.StatusCode)
+
+		data, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+		body = string(data)
+		return strings.Contains(body, "ir_remote_uptime_seconds")
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Contains(t, body, `ir_remote_online{device="default"} 1`)
+	assert.Contains(t, body, `ir_remote_info{device="default",version="1.4.0",protocol_version="0"} 1`)
+	assert.Contains(t, body, `ir_remote_uptime_seconds{device="default"} 120`)
+	assert.Contains(t, body, `ir_remote_wifi_rssi_dbm{device="default"} -67`)
+	assert.Contains(t, body, `ir_remote_free_heap_bytes{device="default"} 23456`)
+	assert.Contains(t, body, `ir_remote_max_command_length{device="default"} 300`)
+	assert.Contains(t, body, `ir_remote_reboots_total{device="default"} 0`)
+	assert.Contains(t, body, "# TYPE ir_remote_reboots_total counter")
+	assert.Contains(t, body, `ir_remote_room_temperature_celsius{device="default"} 24.5`)
+	assert.Contains(t, body, "# TYPE ir_remote_reboot_loop gauge")
+	assert.NotContains(t, body, "ir_remote_room_humidity_percent")
+
+	response, err := http.Get(server.URL + "/api/devices/default")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	device := DeviceResponse{}
+	require.NoError(t, json.NewDecoder(response.Body).Decode(&device))
+	require.NotNil(t, device.Health)
+	assert.Equal(t, "Power On", device.Health.LastResetReason)
+	assert.Equal(t, "1.4.0", device.Status.FirmwareVersion)
+}
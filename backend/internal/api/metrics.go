@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// metric is a gauge in the Prometheus text format, with a sample per device.
+// Counters set kind to "counter".
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	samples []string
+}
+
+func (m *metric) add(labels string, value float64) {
+	m.samples = append(m.samples, fmt.Sprintf("%v{%v} %v", m.name, labels, strconv.FormatFloat(value, 'f', -1, 64)))
+}
+
+func (m *metric) write(w io.Writer) {
+	if len(m.samples) == 0 {
+		return
+	}
+	kind := m.kind
+	if kind == "" {
+		kind = "gauge"
+	}
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, kind)
+	for _, sample := range m.samples {
+		fmt.Fprintln(w, sample)
+	}
+}
+
+// handleMetrics exposes the device statuses to Prometheus
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+
+	online := &metric{name: "ir_remote_online", help: "Whether the remote reports its status."}
+	lastSeen := &metric{name: "ir_remote_last_seen_timestamp_seconds", help: "When the remote reported its status last."}
+	info := &metric{name: "ir_remote_info", help: "Firmware of the remote."}
+	uptime := &metric{name: "ir_remote_uptime_seconds", help: "Time since the remote booted."}
+	rssi := &metric{name: "ir_remote_wifi_rssi_dbm", help: "Wi-Fi signal strength."}
+	freeHeap := &metric{name: "ir_remote_free_heap_bytes", help: "Free memory of the remote."}
+	commandLength := &metric{name: "ir_remote_max_command_length", help: "Timings the remote can buffer."}
+	reboots := &metric{name: "ir_remote_reboots_total", help: "Reboots seen since the server started.", kind: "counter"}
+	rebootLoop := &metric{name: "ir_remote_reboot_loop", help: "Whether the remote keeps rebooting."}
+	temperature := &metric{name: "ir_remote_room_temperature_celsius", help: "Room temperature measured by the remote."}
+	humidity := &metric{name: "ir_remote_room_humidity_percent", help: "Room humidity measured by the remote."}
+	acPower := &metric{name: "ir_remote_ac_power", help: "Whether the AC is believed to be on."}
+
+	for _, id := range s.deviceIds() {
+		d := s.describeDevice(id)
+		labels := fmt.Sprintf("device=%q", id)
+
+		online.add(labels, boolValue(d.Online))
+		if d.LastSeen != nil {
+			lastSeen.add(labels, float64(d.LastSeen.Unix()))
+		}
+		if d.Status.FirmwareVersion != "" {
+			info.add(fmt.Sprintf("%v,version=%q,protocol_version=\"%v\"", labels, d.Status.FirmwareVersion, d.Status.ProtocolVersion), 1)
+		}
+		if d.Status.UptimeSeconds != nil {
+			uptime.add(labels, float64(*d.Status.UptimeSeconds))
+		}
+		if d.Status.Rssi != 0 {
+			rssi.add(labels, float64(d.Status.Rssi))
+		}
+		if d.Status.FreeHeap != 0 {
+			freeHeap.add(labels, float64(d.Status.FreeHeap))
+		}
+		if d.Status.MaxCommandLength != 0 {
+			commandLength.add(labels, float64(d.Status.MaxCommandLength))
+		}
+		if d.Health != nil {
+			reboots.add(labels, float64(d.Health.Reboots))
+			rebootLoop.add(labels, boolValue(d.Health.RebootLoop))
+		}
+		if d.Status.Temperature != nil {
+			temperature.add(labels, *d.Status.Temperature)
+		}
+		if d.Status.Humidity != nil {
+			humidity.add(labels, *d.Status.Humidity)
+		}
+		if d.AcState.UpdatedAt != nil {
+			acPower.add(labels, boolValue(d.AcState.State.Power))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	result := &strings.Builder{}
+	for _, m := range []*metric{online, lastSeen, info, uptime, rssi, freeHeap, commandLength, reboots, rebootLoop, temperature, humidity, acPower} {
+		m.write(result)
+	}
+	_, _ = io.WriteString(w, result.String())
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
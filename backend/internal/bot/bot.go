@@ -2,30 +2,51 @@ package bot
 
 import (
 	"context"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/health"
 	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/Light-Keeper/ir-remote/internal/ota"
+	"github.com/Light-Keeper/ir-remote/internal/telemetry"
+	"github.com/Light-Keeper/ir-remote/internal/thermostat"
+	"github.com/Light-Keeper/ir-remote/internal/waveform"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 	_ "time/tzdata"
 )
 
+// Device is the remote the bot controls with the AC behind it. Firmware is
+// nil unless updates are distributed.
+type Device struct {
+	Id         string
+	Session    *irremote.Session
+	State      *acstate.Device
+	Thermostat *thermostat.Thermostat
+	Telemetry  *telemetry.Recorder
+	Health     *health.Monitor
+	Firmware   *ota.Manager
+}
+
 type Bot struct {
-	session            *irremote.Session
+	device             Device
 	api                *tgbotapi.BotAPI
 	botAuthorizedUsers []int
 	offAt              time.Time
 	offCancel          context.CancelFunc
 }
 
-func NewBot(apikey string, botAuthorizedUsers string, session *irremote.Session) *Bot {
+func NewBot(apikey string, botAuthorizedUsers string, device Device) *Bot {
 	api, err := tgbotapi.NewBotAPI(apikey)
 	if err != nil {
 		panic(err)
 	}
 
 	return &Bot{
-		session:            session,
+		device:             device,
 		api:                api,
 		botAuthorizedUsers: parseAuthorizedUsers(botAuthorizedUsers),
 	}
@@ -72,6 +93,26 @@ func (b *Bot) Run(ctx context.Context) error {
 				continue
 			}
 
+			if update.Message.IsCommand() && update.Message.Command() == "signal" {
+				b.sendSignalPicture(ctx, update.Message.Chat.ID, update.Message.CommandArguments())
+				continue
+			}
+
+			if update.Message.IsCommand() && update.Message.Command() == "thermostat" {
+				b.configureThermostat(ctx, update.Message.Chat.ID, update.Message.CommandArguments())
+				continue
+			}
+
+			if update.Message.IsCommand() && update.Message.Command() == "chart" {
+				b.sendChart(ctx, update.Message.Chat.ID, update.Message.CommandArguments())
+				continue
+			}
+
+			if update.Message.IsCommand() && update.Message.Command() == "update" {
+				b.updateFirmware(ctx, update.Message.Chat.ID, update.Message.CommandArguments())
+				continue
+			}
+
 			chatId := update.Message.Chat.ID
 			handler := lookupHandler(update.Message.Text)
 			handler(b, ctx, chatId)
@@ -86,17 +127,59 @@ var buttons = [][]struct {
 	{
 		{"🔴выкл", handleButtonOff},
 		{"🔴⏳60м", handleTimer(60)},
-		{"🥶+24", sendCommandHandler(commandCold24)},
-		{"💧+24", sendCommandHandler(commandWater24)},
+		{"🥶+24", setStateHandler(coolState(24))},
+		{"💧+24", setStateHandler(dryState(24))},
 	},
 	{
 		{"? статус", handleButtonStatus},
 		{"🔴⏳30м", handleTimer(30)},
-		{"🥶+20", sendCommandHandler(commandCold20)},
-		{"💧+20", sendCommandHandler(commandWater20)},
+		{"🥶+20", setStateHandler(coolState(20))},
+		{"💧+20", setStateHandler(dryState(20))},
 	},
 }
 
+// learnedCommands are the captures of the original remote, by name for /signal
+var learnedCommands = map[string][]int{
+	"off":     commandOff,
+	"cold20":  commandCold20,
+	"cold22":  commandCold22,
+	"cold24":  commandCold24,
+	"water20": commandWater20,
+	"water23": commandWater23,
+	"water24": commandWater24,
+}
+
+// offState is the state after commandOff, the other buttons switch the AC on
+var offState = commands.AcState{}
+
+// learnedStates are the states the captures of the original remote set
+var learnedStates = map[commands.AcState][]int{
+	offState:      commandOff,
+	coolState(20): commandCold20,
+	coolState(22): commandCold22,
+	coolState(24): commandCold24,
+	dryState(20):  commandWater20,
+	dryState(23):  commandWater23,
+	dryState(24):  commandWater24,
+}
+
+// LearnedEncoder sets the AC states the original remote was captured in.
+func LearnedEncoder() acstate.Encoder {
+	captures := make(map[commands.AcState][]int, len(learnedStates))
+	for state, capture := range learnedStates {
+		captures[state] = cleanCapture(capture)
+	}
+	return acstate.CaptureEncoder(captures)
+}
+
+func coolState(temperature int) commands.AcState {
+	return commands.AcState{Power: true, Mode: commands.AcModeCool, Temperature: temperature}
+}
+
+func dryState(temperature int) commands.AcState {
+	return commands.AcState{Power: true, Mode: commands.AcModeDry, Temperature: temperature}
+}
+
 var customKeyboard tgbotapi.ReplyKeyboardMarkup
 
 func init() {
@@ -129,8 +212,9 @@ func handleButtonOff(b *Bot, ctx context.Context, chatId int64) {
 		b.offCancel()
 		b.offAt = time.Time{}
 		b.offCancel = nil
+		b.device.State.SetOffTimer(time.Time{})
 	}
-	b.sendCommandAndReplay(ctx, commandOff, chatId)
+	b.setStateAndReplay(ctx, offState, false, chatId)
 }
 
 func handleButtonStatus(b *Bot, ctx context.Context, chatId int64) {
@@ -141,24 +225,113 @@ func handleUnknown(b *Bot, ctx context.Context, chatId int64) {
 	b.respond(ctx, chatId, "Неизвестная команда")
 }
 
-func sendCommandHandler(command []int) func(b *Bot, ctx context.Context, chatId int64) {
+func setStateHandler(state commands.AcState) func(b *Bot, ctx context.Context, chatId int64) {
 	return func(b *Bot, ctx context.Context, chatId int64) {
-		b.sendCommandAndReplay(ctx, command, chatId)
+		b.setStateAndReplay(ctx, state, false, chatId)
 	}
 }
 
-func (b *Bot) sendCommandAndReplay(ctx context.Context, command []int, chatId int64) {
-	err := b.session.SendCommand(ctx, command)
-	if err != nil {
+func (b *Bot) setStateAndReplay(ctx context.Context, state commands.AcState, force bool, chatId int64) {
+	result, err := b.device.State.SetDesiredState(ctx, state, force)
+	switch {
+	case err != nil:
 		b.respond(ctx, chatId, "Error: "+err.Error())
-	} else {
+	case !result.Sent:
+		b.respond(ctx, chatId, "Кондиционер уже в этом состоянии, команда не отправлена")
+	default:
 		b.respond(ctx, chatId, "Команда успешно отправлена (но неизвестно, принята ли она кондиционером)")
 	}
 }
 
+// cleanCapture removes receiver jitter from a capture of the original remote
+func cleanCapture(capture []int) []int {
+	result, err := commands.Normalize(capture, commands.DefaultNormalizeOptions)
+	if err != nil {
+		return capture
+	}
+	return result.Signal
+}
+
+// sendSignalPicture replies with the waveform of a learned command
+func (b *Bot) sendSignalPicture(ctx context.Context, chatId int64, name string) {
+	capture, ok := learnedCommands[strings.TrimSpace(name)]
+	if !ok {
+		names := make([]string, 0, len(learnedCommands))
+		for name := range learnedCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		b.respond(ctx, chatId, "Использование: /signal <"+strings.Join(names, "|")+">")
+		return
+	}
+
+	w := waveform.Describe(capture, "")
+	picture, err := waveform.RenderPng(w)
+	if err != nil {
+		b.respond(ctx, chatId, "Error: "+err.Error())
+		return
+	}
+
+	photo := tgbotapi.NewPhotoUpload(chatId, tgbotapi.FileBytes{Name: "signal.png", Bytes: picture})
+	photo.Caption = w.Title
+	if w.FailureReason != "" {
+		photo.Caption += "\n" + w.FailureReason
+	}
+
+	if _, err := b.api.Send(photo); err != nil {
+		println(err.Error())
+	}
+}
+
+// sendChart replies with the room temperature chart for /chart [24h|7d]
+func (b *Bot) sendChart(ctx context.Context, chatId int64, arguments string) {
+	window, err := parseWindow(arguments)
+	if err != nil {
+		b.respond(ctx, chatId, "Использование: /chart 24h или /chart 7d")
+		return
+	}
+
+	ukraine, _ := time.LoadLocation("Europe/Kiev")
+	picture, err := b.device.Telemetry.Chart(window, ukraine)
+	if err != nil {
+		b.respond(ctx, chatId, "Error: "+err.Error())
+		return
+	}
+
+	photo := tgbotapi.NewPhotoUpload(chatId, tgbotapi.FileBytes{Name: "chart.png", Bytes: picture})
+	photo.Caption = "Температура в комнате за " + strings.TrimSpace(arguments) + ", кондиционер включен на голубом фоне, синяя линия - его температура"
+	if _, err := b.api.Send(photo); err != nil {
+		println(err.Error())
+	}
+}
+
+// parseWindow reads a duration like 24h or 7d, 24 hours if empty
+func parseWindow(text string) (time.Duration, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 24 * time.Hour, nil
+	}
+	if days, ok := strings.CutSuffix(text, "d"); ok {
+		count, err := strconv.Atoi(days)
+		if err != nil || count <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+		return time.Duration(count) * 24 * time.Hour, nil
+	}
+
+	window, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, err
+	}
+	if window <= 0 {
+		return 0, fmt.Errorf("invalid window %v", window)
+	}
+	return window, nil
+}
+
 func (b *Bot) respond(_ context.Context, chatId int64, text string) {
 	var statusMessage string
-	if b.session.IsOnline() {
+	if b.device.Session.IsOnline() {
 		statusMessage = "Статус пульта: 🟢онлайн"
 	} else {
 		statusMessage = "Статус пульта: 🚫недоступен"
@@ -169,7 +342,27 @@ func (b *Bot) respond(_ context.Context, chatId int64, text string) {
 		timerMessage = "\nЗапланировано выключение в " + b.offAt.Format("15:04")
 	}
 
-	text += "\n" + statusMessage + timerMessage
+	acMessage := "\nКондиционер: " + describeAcState(b.device.State.State())
+
+	var roomMessage string
+	if status, _ := b.device.Session.LastStatus(); status.Temperature != nil {
+		roomMessage = fmt.Sprintf("\nВ комнате: %.1f°C", *status.Temperature)
+		if status.Humidity != nil {
+			roomMessage += fmt.Sprintf(", влажность %.0f%%", *status.Humidity)
+		}
+	}
+
+	var thermostatMessage string
+	if config := b.device.Thermostat.Config(); config.Enabled {
+		thermostatMessage = "\nТермостат: " + config.String()
+	}
+
+	var diagnosticsMessage string
+	if status, _ := b.device.Session.LastStatus(); status.FirmwareVersion != "" {
+		diagnosticsMessage = "\n" + describeDiagnostics(status, b.device.Health.Status(time.Now()))
+	}
+
+	text += "\n" + statusMessage + timerMessage + acMessage + roomMessage + thermostatMessage + diagnosticsMessage
 	message := tgbotapi.NewMessage(chatId, text)
 	message.ReplyMarkup = customKeyboard
 
@@ -179,6 +372,152 @@ func (b *Bot) respond(_ context.Context, chatId int64, text string) {
 	}
 }
 
+// describeDiagnostics tells how the remote is doing, for firmware that reports it
+func describeDiagnostics(status irremote.Status, health health.Status) string {
+	parts := []string{"прошивка " + status.FirmwareVersion}
+	if status.UptimeSeconds != nil {
+		uptime := time.Duration(*status.UptimeSeconds) * time.Second
+		parts = append(parts, "работает "+uptime.Round(time.Minute).String())
+	}
+	if status.Rssi != 0 {
+		parts = append(parts, fmt.Sprintf("Wi-Fi %v dBm", status.Rssi))
+	}
+	if status.FreeHeap != 0 {
+		parts = append(parts, fmt.Sprintf("свободно %v КБ", status.FreeHeap/1024))
+	}
+	if status.MaxCommandLength != 0 {
+		parts = append(parts, fmt.Sprintf("буфер %v", status.MaxCommandLength))
+	}
+	if status.ResetReason != "" {
+		parts = append(parts, "причина перезагрузки: "+status.ResetReason)
+	}
+
+	result := "Пульт: " + strings.Join(parts, ", ")
+	if health.RebootLoop {
+		result += "\n⚠️ пульт постоянно перезагружается"
+	}
+	return result
+}
+
+// Alert sends the message to every authorized user.
+func (b *Bot) Alert(message string) {
+	for _, user := range b.botAuthorizedUsers {
+		if _, err := b.api.Send(tgbotapi.NewMessage(int64(user), "⚠️ "+message)); err != nil {
+			println(err.Error())
+		}
+	}
+}
+
+// ReportRollout tells every authorized user how a firmware update goes.
+func (b *Bot) ReportRollout(rollout ota.Rollout) {
+	for _, user := range b.botAuthorizedUsers {
+		if _, err := b.api.Send(tgbotapi.NewMessage(int64(user), "Обновление прошивки "+rollout.String())); err != nil {
+			println(err.Error())
+		}
+	}
+}
+
+// updateFirmware handles /update, /update <version> and /update <version> all
+func (b *Bot) updateFirmware(ctx context.Context, chatId int64, arguments string) {
+	if b.device.Firmware == nil {
+		b.respond(ctx, chatId, "Обновление прошивки не настроено")
+		return
+	}
+
+	fields := strings.Fields(arguments)
+	switch {
+	case len(fields) == 0:
+		b.respond(ctx, chatId, describeFirmware(b.device.Firmware, b.device.Id))
+		return
+	case len(fields) > 2 || (len(fields) == 2 && fields[1] != "all"):
+		b.respond(ctx, chatId, updateUsage)
+		return
+	}
+
+	ids := []string{b.device.Id}
+	if len(fields) == 2 {
+		ids = nil
+	}
+	rollouts, err := b.device.Firmware.Rollout(ctx, fields[0], ids)
+	if err != nil {
+		b.respond(ctx, chatId, "Error: "+err.Error())
+		return
+	}
+
+	lines := make([]string, 0, len(rollouts))
+	for _, rollout := range rollouts {
+		lines = append(lines, rollout.String())
+	}
+	b.respond(ctx, chatId, "Обновление прошивки:\n"+strings.Join(lines, "\n"))
+}
+
+const updateUsage = "Использование: /update, /update <версия> или /update <версия> all"
+
+// describeFirmware lists the images and the last update of the device
+func describeFirmware(firmware *ota.Manager, id string) string {
+	versions := make([]string, 0)
+	for _, image := range firmware.Images() {
+		versions = append(versions, image.Version)
+	}
+	if len(versions) == 0 {
+		versions = append(versions, "нет")
+	}
+
+	result := "Прошивка пульта: " + firmware.FirmwareVersion(id) + "\nДоступные версии: " + strings.Join(versions, ", ")
+	for _, rollout := range firmware.Rollouts() {
+		if rollout.Device == id {
+			result += "\nПоследнее обновление: " + rollout.String()
+		}
+	}
+	return result + "\n" + updateUsage
+}
+
+// describeAcState tells the believed state of the AC and why it may be wrong
+func describeAcState(state acstate.BelievedState) string {
+	result := "неизвестно"
+	if state.UpdatedAt != nil {
+		result = state.State.String()
+	}
+	if state.Uncertain {
+		result += " (не точно: " + state.Reason + ")"
+	}
+	return result
+}
+
+// configureThermostat handles /thermostat off and /thermostat <cool|heat|auto> <low> <high>
+func (b *Bot) configureThermostat(ctx context.Context, chatId int64, arguments string) {
+	config := b.device.Thermostat.Config()
+	fields := strings.Fields(arguments)
+
+	switch {
+	case len(fields) == 1 && fields[0] == "off":
+		config.Enabled = false
+	case len(fields) == 3:
+		mode, err := commands.ParseAcMode(fields[0])
+		low, lowErr := strconv.ParseFloat(fields[1], 64)
+		high, highErr := strconv.ParseFloat(fields[2], 64)
+		if err != nil || lowErr != nil || highErr != nil {
+			b.respond(ctx, chatId, thermostatUsage)
+			return
+		}
+		config.Enabled = true
+		config.Mode = mode
+		config.Low = low
+		config.High = high
+	default:
+		b.respond(ctx, chatId, thermostatUsage)
+		return
+	}
+
+	if err := b.device.Thermostat.SetConfig(config); err != nil {
+		b.respond(ctx, chatId, "Error: "+err.Error())
+		return
+	}
+	b.respond(ctx, chatId, "Термостат: "+config.String())
+}
+
+const thermostatUsage = "Использование: /thermostat off или /thermostat <cool|heat|auto> <от °C> <до °C>"
+
 func (b *Bot) isAuthorized(id int) bool {
 	for _, user := range b.botAuthorizedUsers {
 		if user == id {
@@ -201,6 +540,7 @@ func handleTimer(timeout int) func(b *Bot, ctx context.Context, chatId int64) {
 			Now().
 			In(ukraine).
 			Add(time.Duration(timeout) * time.Minute)
+		b.device.State.SetOffTimer(b.offAt)
 
 		b.respond(ctx, chatId, "Таймер запущен. Кондиционер будет выключен через "+strconv.Itoa(timeout)+" минут.")
 
@@ -212,7 +552,9 @@ func handleTimer(timeout int) func(b *Bot, ctx context.Context, chatId int64) {
 				b.offCancel()
 				b.offCancel = nil
 				b.offAt = time.Time{}
-				b.sendCommandAndReplay(ctx, commandOff, chatId)
+				b.device.State.SetOffTimer(time.Time{})
+				// the timer turns the AC off even if the remote was used since
+				b.setStateAndReplay(ctx, offState, true, chatId)
 			}
 		}()
 	}
@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/health"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/Light-Keeper/ir-remote/internal/ota"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestLearnedEncoder(t *testing.T) {
+	encoder := LearnedEncoder()
+
+	for _, state := range []commands.AcState{offState, coolState(20), coolState(24), dryState(20), dryState(24)} {
+		command, err := encoder(state)
+		require.NoError(t, err, state)
+		require.Equal(t, state, command.State())
+	}
+
+	_, err := encoder(coolState(30))
+	require.Error(t, err)
+}
+
+func TestParseWindow(t *testing.T) {
+	window, err := parseWindow("")
+	require.NoError(t, err)
+	require.Equal(t, 24*time.Hour, window)
+
+	window, err = parseWindow("7d")
+	require.NoError(t, err)
+	require.Equal(t, 7*24*time.Hour, window)
+
+	window, err = parseWindow(" 90m ")
+	require.NoError(t, err)
+	require.Equal(t, 90*time.Minute, window)
+
+	_, err = parseWindow("week")
+	require.Error(t, err)
+	_, err = parseWindow("-1h")
+	require.Error(t, err)
+}
+
+func TestDescribeDiagnostics(t *testing.T) {
+	uptime := int64(3*3600 + 12*60 + 5)
+	status := irremote.Status{
+		FirmwareVersion:  "1.4.0",
+		UptimeSeconds:    &uptime,
+		Rssi:             -67,
+		FreeHeap:         23 * 1024,
+		MaxCommandLength: 300,
+		ResetReason:      "Exception",
+	}
+
+	require.Equal(t,
+		"Пульт: прошивка 1.4.0, работает 3h12m0s, Wi-Fi -67 dBm, свободно 23 КБ, буфер 300, причина перезагрузки: Exception",
+		describeDiagnostics(status, health.Status{}))
+	require.Equal(t,
+		"Пульт: прошивка 1.4.0\n⚠️ пульт постоянно перезагружается",
+		describeDiagnostics(irremote.Status{FirmwareVersion: "1.4.0"}, health.Status{RebootLoop: true}))
+}
+
+func TestDescribeFirmware(t *testing.T) {
+	images, err := ota.OpenRepository(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	require.Equal(t,
+		"Прошивка пульта: \nДоступные версии: нет\n"+updateUsage,
+		describeFirmware(ota.NewManager(images, "http://backend"), "default"))
+}
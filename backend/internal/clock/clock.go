@@ -0,0 +1,158 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits, so scenarios can run on simulated time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the clock of the system.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Fake is a clock that only moves when told to. Timers fire on the elapsed
+// time, so Jump moves the time told by Now without firing them, like a
+// system clock set by NTP.
+type Fake struct {
+	mx      sync.Mutex
+	start   time.Time
+	elapsed time.Duration
+	offset  time.Duration
+	timers  []*fakeTimer
+	created int64
+	fired   int64
+	counter int64
+}
+
+type fakeTimer struct {
+	deadline time.Duration
+	// order keeps timers with the same deadline in the order they were made
+	order int64
+	ch    chan time.Time
+	f     func()
+}
+
+// NewFake makes a clock telling start until it is advanced.
+func NewFake(start time.Time) *Fake {
+	return &Fake{start: start}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.now()
+}
+
+func (f *Fake) now() time.Time {
+	return f.start.Add(f.elapsed + f.offset)
+}
+
+// Elapsed is how far the clock was advanced, jumps excluded.
+func (f *Fake) Elapsed() time.Duration {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.elapsed
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	f.add(d, ch, nil)
+	return ch
+}
+
+// AfterFunc calls fn from Step or Advance once d has elapsed.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) {
+	f.add(d, nil, fn)
+}
+
+func (f *Fake) add(d time.Duration, ch chan time.Time, fn func()) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.counter++
+	if ch != nil {
+		f.created++
+	}
+	f.timers = append(f.timers, &fakeTimer{deadline: f.elapsed + d, order: f.counter, ch: ch, f: fn})
+	sort.Slice(f.timers, func(i, j int) bool {
+		if f.timers[i].deadline != f.timers[j].deadline {
+			return f.timers[i].deadline < f.timers[j].deadline
+		}
+		return f.timers[i].order < f.timers[j].order
+	})
+}
+
+// Created counts the channels returned by After, to tell whether the code
+// waiting on the clock has made progress.
+func (f *Fake) Created() int64 {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.created
+}
+
+// Fired counts the channels returned by After that fired.
+func (f *Fake) Fired() int64 {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.fired
+}
+
+// Step fires the next timer due by until and returns true, or moves the
+// clock to until and returns false if there is none.
+func (f *Fake) Step(until time.Duration) bool {
+	f.mx.Lock()
+	if len(f.timers) == 0 || f.timers[0].deadline > until {
+		if until > f.elapsed {
+			f.elapsed = until
+		}
+		f.mx.Unlock()
+		return false
+	}
+
+	timer := f.timers[0]
+	f.timers = f.timers[1:]
+	if timer.deadline > f.elapsed {
+		f.elapsed = timer.deadline
+	}
+	if timer.ch != nil {
+		f.fired++
+	}
+	now := f.now()
+	f.mx.Unlock()
+
+	if timer.f != nil {
+		timer.f()
+	} else {
+		timer.ch <- now
+	}
+	return true
+}
+
+// Advance fires the timers due in d in order and moves the clock by d.
+func (f *Fake) Advance(d time.Duration) {
+	until := f.Elapsed() + d
+	for f.Step(until) {
+	}
+}
+
+// Jump moves the time told by Now by d, backwards if negative, without
+// firing timers.
+func (f *Fake) Jump(d time.Duration) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.offset += d
+}
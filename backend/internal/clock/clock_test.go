@@ -0,0 +1,38 @@
+package clock
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFake(start)
+
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "second") })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "first") })
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "third") })
+	after := clock.After(3 * time.Second)
+	require.Equal(t, int64(1), clock.Created())
+
+	clock.Advance(2 * time.Second)
+	require.Equal(t, []string{"first", "second", "third"}, fired)
+	require.Equal(t, start.Add(2*time.Second), clock.Now())
+	select {
+	case <-after:
+		t.Fatal("fired early")
+	default:
+	}
+
+	// jumps move the time told, not the timers
+	clock.Jump(-time.Hour)
+	require.Equal(t, start.Add(2*time.Second-time.Hour), clock.Now())
+	require.Equal(t, 2*time.Second, clock.Elapsed())
+	require.True(t, clock.Step(time.Minute))
+	require.Equal(t, start.Add(3*time.Second-time.Hour), <-after)
+	require.Equal(t, int64(1), clock.Fired())
+	require.False(t, clock.Step(time.Minute))
+	require.Equal(t, time.Minute, clock.Elapsed())
+}
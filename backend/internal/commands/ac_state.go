@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+)
+
+type AcMode int
+
+const (
+	AcModeAuto AcMode = iota
+	AcModeCool
+	AcModeDry
+	AcModeFan
+	AcModeHeat
+)
+
+func (m AcMode) String() string {
+	switch m {
+	case AcModeAuto:
+		return "auto"
+	case AcModeCool:
+		return "cool"
+	case AcModeDry:
+		return "dry"
+	case AcModeFan:
+		return "fan"
+	case AcModeHeat:
+		return "heat"
+	default:
+		return fmt.Sprintf("mode(%d)", int(m))
+	}
+}
+
+func (m AcMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *AcMode) UnmarshalText(text []byte) error {
+	mode, err := ParseAcMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+func ParseAcMode(text string) (AcMode, error) {
+	for mode := AcModeAuto; mode <= AcModeHeat; mode++ {
+		if mode.String() == text {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown mode %q", text)
+}
+
+type AcFan int
+
+const (
+	AcFanAuto AcFan = iota
+	AcFanLow
+	AcFanMedium
+	AcFanHigh
+)
+
+func (f AcFan) String() string {
+	switch f {
+	case AcFanAuto:
+		return "auto"
+	case AcFanLow:
+		return "low"
+	case AcFanMedium:
+		return "medium"
+	case AcFanHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("fan(%d)", int(f))
+	}
+}
+
+func (f AcFan) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *AcFan) UnmarshalText(text []byte) error {
+	fan, err := ParseAcFan(string(text))
+	if err != nil {
+		return err
+	}
+	*f = fan
+	return nil
+}
+
+func ParseAcFan(text string) (AcFan, error) {
+	for fan := AcFanAuto; fan <= AcFanHigh; fan++ {
+		if fan.String() == text {
+			return fan, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fan speed %q", text)
+}
+
+// AcState is the brand independent state of an air conditioner. AC remotes
+// send the whole state in every frame, so a state maps to exactly one command.
+type AcState struct {
+	Power       bool   `json:"power"`
+	Mode        AcMode `json:"mode"`
+	Temperature int    `json:"temperature"`
+	Fan         AcFan  `json:"fan"`
+	Swing       bool   `json:"swing"`
+}
+
+func (s AcState) String() string {
+	if !s.Power {
+		return "off"
+	}
+	return fmt.Sprintf("%v %v°C fan:%v swing:%v", s.Mode, s.Temperature, s.Fan, s.Swing)
+}
+
+// AcCommand is a Command that carries a full air conditioner state.
+type AcCommand interface {
+	Command
+	State() AcState
+	SetState(state AcState) error
+}
+
+var acProtocols = map[string]func() AcCommand{
+	"daikin":           func() AcCommand { return NewDaikinCommand() },
+	"gree":             func() AcCommand { return NewGreeCommand() },
+	"lg":               func() AcCommand { return NewLgCommand() },
+	"mitsubishi-heavy": func() AcCommand { return NewMitsubishiHeavyCommand() },
+}
+
+// NewAcCommand creates a command for the AC protocol with the given name.
+func NewAcCommand(protocol string) (AcCommand, error) {
+	factory, ok := acProtocols[protocol]
+	if !ok {
+		return nil, fmt.Errorf("unknown AC protocol %q", protocol)
+	}
+	return factory(), nil
+}
+
+// AcProtocols returns the names accepted by NewAcCommand.
+func AcProtocols() []string {
+	result := make([]string, 0, len(acProtocols))
+	for name := range acProtocols {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func validateTemperature(temperature int, min int, max int) error {
+	if temperature < min || temperature > max {
+		return fmt.Errorf("temperature %v is out of range [%v, %v]", temperature, min, max)
+	}
+	return nil
+}
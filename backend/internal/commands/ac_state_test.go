@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewAcCommand(t *testing.T) {
+	state := AcState{Power: true, Mode: AcModeCool, Temperature: 22, Fan: AcFanAuto}
+
+	for _, protocol := range AcProtocols() {
+		cmd, err := NewAcCommand(protocol)
+		require.NoError(t, err, protocol)
+		require.NoError(t, cmd.SetState(state), protocol)
+
+		// a frame of one brand must not be accepted by the others
+		for _, other := range AcProtocols() {
+			if other == protocol {
+				continue
+			}
+			otherCmd, _ := NewAcCommand(other)
+			require.Error(t, otherCmd.ParseFromSignalSequence(cmd.ToSignalSequence()), "%v parsed as %v", protocol, other)
+		}
+	}
+
+	_, err := NewAcCommand("unknown")
+	require.Error(t, err)
+}
+
+func TestAcState_Json(t *testing.T) {
+	state := AcState{Power: true, Mode: AcModeHeat, Temperature: 21, Fan: AcFanMedium}
+
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"power":true,"mode":"heat","temperature":21,"fan":"medium","swing":false}`, string(data))
+
+	parsed := AcState{}
+	require.NoError(t, json.Unmarshal(data, &parsed))
+	require.Equal(t, state, parsed)
+
+	require.Error(t, json.Unmarshal([]byte(`{"mode":"turbo"}`), &parsed))
+}
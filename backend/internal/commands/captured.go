@@ -0,0 +1,24 @@
+package commands
+
+import "errors"
+
+// CapturedCommand is a capture of an original remote button together with the
+// state the button puts the AC into. The state of a capture can not be changed.
+type CapturedCommand struct {
+	RawCommand
+	state AcState
+}
+
+var _ AcCommand = &CapturedCommand{}
+
+func NewCapturedCommand(signal []int, state AcState) *CapturedCommand {
+	return &CapturedCommand{RawCommand: *NewRawCommand(signal), state: state}
+}
+
+func (c *CapturedCommand) State() AcState {
+	return c.state
+}
+
+func (c *CapturedCommand) SetState(AcState) error {
+	return errors.New("captured command has a fixed state")
+}
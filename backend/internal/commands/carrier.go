@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"fmt"
+)
+
+// DEFAULT_CARRIER_FREQUENCY is the carrier, in Hz, used by the vast majority
+// of remotes and by the firmware versions that do not support other ones
+const DEFAULT_CARRIER_FREQUENCY = 38000
+const DEFAULT_DUTY_CYCLE = 50
+
+// Carrier is the modulation of the IR LED while a mark is being sent.
+type Carrier struct {
+	// Frequency in Hz
+	Frequency int `json:"frequency"`
+	// DutyCycle in percent
+	DutyCycle int `json:"duty_cycle"`
+}
+
+var DefaultCarrier = Carrier{Frequency: DEFAULT_CARRIER_FREQUENCY, DutyCycle: DEFAULT_DUTY_CYCLE}
+
+var protocolCarriers = map[string]Carrier{
+	"nec":              DefaultCarrier,
+	"daikin":           DefaultCarrier,
+	"gree":             DefaultCarrier,
+	"lg":               DefaultCarrier,
+	"mitsubishi-heavy": DefaultCarrier,
+	"rc5":              {Frequency: 36000, DutyCycle: 25},
+	"rc6":              {Frequency: 36000, DutyCycle: 33},
+	"sony":             {Frequency: 40000, DutyCycle: 33},
+	"panasonic":        {Frequency: 36700, DutyCycle: 50},
+	"jvc":              DefaultCarrier,
+	"samsung":          DefaultCarrier,
+}
+
+// ProtocolCarrier returns the carrier the given protocol is sent with, or the
+// default carrier if the protocol is unknown.
+func ProtocolCarrier(protocol string) Carrier {
+	carrier, ok := protocolCarriers[protocol]
+	if !ok {
+		return DefaultCarrier
+	}
+	return carrier
+}
+
+func (c Carrier) Validate() error {
+	if c.Frequency < 10000 || c.Frequency > 500000 {
+		return fmt.Errorf("carrier frequency %v Hz is out of range", c.Frequency)
+	}
+	if c.DutyCycle <= 0 || c.DutyCycle > 100 {
+		return fmt.Errorf("duty cycle %v%% is out of range", c.DutyCycle)
+	}
+	return nil
+}
+
+func (c Carrier) String() string {
+	return fmt.Sprintf("%.1f kHz %d%%", float64(c.Frequency)/1000, c.DutyCycle)
+}
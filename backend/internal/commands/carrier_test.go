@@ -0,0 +1,25 @@
+package commands
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestProtocolCarrier(t *testing.T) {
+	require.Equal(t, 36000, ProtocolCarrier("rc5").Frequency)
+	require.Equal(t, 40000, ProtocolCarrier("sony").Frequency)
+	require.Equal(t, DefaultCarrier, ProtocolCarrier("unknown"))
+
+	for _, protocol := range AcProtocols() {
+		cmd, err := NewAcCommand(protocol)
+		require.NoError(t, err)
+		require.Equal(t, ProtocolCarrier(protocol), cmd.Carrier(), protocol)
+		require.NoError(t, cmd.Carrier().Validate())
+	}
+}
+
+func TestCarrier_Validate(t *testing.T) {
+	require.NoError(t, Carrier{Frequency: 36000, DutyCycle: 25}.Validate())
+	require.Error(t, Carrier{Frequency: 38, DutyCycle: 50}.Validate())
+	require.Error(t, Carrier{Frequency: 38000, DutyCycle: 0}.Validate())
+}
@@ -0,0 +1,42 @@
+package commands
+
+// Checksums used by AC protocols, computed over a part of the state.
+
+func SumBytes(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+func XorBytes(data []byte) byte {
+	var result byte
+	for _, b := range data {
+		result ^= b
+	}
+	return result
+}
+
+// Crc8 computes a CRC with a zero initial value and no final xor. Reflected
+// CRCs process bits LSB first and take the reversed polynomial, e.g. 0x8C
+// for Dallas/Maxim.
+func Crc8(poly byte, reflected bool, data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			switch {
+			case reflected && crc&0x01 != 0:
+				crc = crc>>1 ^ poly
+			case reflected:
+				crc >>= 1
+			case crc&0x80 != 0:
+				crc = crc<<1 ^ poly
+			default:
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,16 @@
+package commands
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	data := []byte("123456789")
+
+	require.Equal(t, byte(0xDD), SumBytes(data))
+	require.Equal(t, byte(0x31), XorBytes(data))
+	// check values of the catalogued CRC-8 and CRC-8/MAXIM
+	require.Equal(t, byte(0xF4), Crc8(0x07, false, data))
+	require.Equal(t, byte(0xA1), Crc8(0x8C, true, data))
+}
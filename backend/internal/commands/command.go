@@ -3,4 +3,5 @@ package commands
 type Command interface {
 	ParseFromSignalSequence(signalSequence []int) error
 	ToSignalSequence() []int
+	Carrier() Carrier
 }
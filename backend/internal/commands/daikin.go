@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const DAIKIN_HDR_MARK = 3650
+const DAIKIN_HDR_SPACE = 1623
+const DAIKIN_BIT_MARK = 428
+const DAIKIN_ZERO_SPACE = 428
+const DAIKIN_ONE_SPACE = 1280
+const DAIKIN_GAP = 29000
+
+const DAIKIN_LEADER_BITS = 5
+const DAIKIN_STATE_LENGTH = 35
+const DAIKIN_MIN_TEMP = 10
+const DAIKIN_MAX_TEMP = 32
+
+// sections of the state, each one is sent as a separate frame ending with a checksum
+var daikinSections = [][2]int{{0, 8}, {8, 16}, {16, DAIKIN_STATE_LENGTH}}
+
+var daikinSignature = []byte{0x11, 0xDA, 0x27, 0x00}
+
+var daikinEncoding = pulseDistance{
+	headerMark:  DAIKIN_HDR_MARK,
+	headerSpace: DAIKIN_HDR_SPACE,
+	bitMark:     DAIKIN_BIT_MARK,
+	oneSpace:    DAIKIN_ONE_SPACE,
+	zeroSpace:   DAIKIN_ZERO_SPACE,
+}
+
+// DaikinCommand is the 280 bit Daikin protocol: a short leader followed by
+// three frames, the last one carrying the state. Every frame ends with a
+// checksum byte that is the sum of the preceding bytes of the frame.
+type DaikinCommand struct {
+	state [DAIKIN_STATE_LENGTH]byte
+}
+
+var _ AcCommand = &DaikinCommand{}
+var _ PayloadCommand = &DaikinCommand{}
+
+func NewDaikinCommand() *DaikinCommand {
+	cmd := &DaikinCommand{}
+	copy(cmd.state[0:], daikinSignature)
+	cmd.state[4] = 0xC5
+	copy(cmd.state[8:], daikinSignature)
+	cmd.state[12] = 0x42
+	copy(cmd.state[16:], daikinSignature)
+	cmd.state[21] = 0x08
+	cmd.state[24] = 0xA0
+	cmd.state[27] = 0x06
+	cmd.state[28] = 0x60
+	cmd.state[31] = 0xC0
+	_ = cmd.SetState(AcState{Mode: AcModeCool, Temperature: 24})
+	return cmd
+}
+
+func (d *DaikinCommand) ParseFromSignalSequence(signalSequence []int) error {
+	reader := newSignalReader(daikinEncoding, signalSequence)
+
+	if _, err := reader.readBits(DAIKIN_LEADER_BITS, false); err != nil {
+		return err
+	}
+	if err := reader.readFooter(DAIKIN_GAP); err != nil {
+		return err
+	}
+
+	var state [DAIKIN_STATE_LENGTH]byte
+	for _, section := range daikinSections {
+		if err := reader.readHeader(); err != nil {
+			return err
+		}
+
+		data, err := reader.readBytesLSB(section[1] - section[0])
+		if err != nil {
+			return err
+		}
+		copy(state[section[0]:], data)
+
+		if err := reader.readFooter(DAIKIN_GAP); err != nil {
+			return err
+		}
+	}
+
+	if err := reader.done(); err != nil {
+		return err
+	}
+
+	if err := validateDaikinState(state); err != nil {
+		return err
+	}
+
+	d.state = state
+	return nil
+}
+
+func validateDaikinState(state [DAIKIN_STATE_LENGTH]byte) error {
+	for _, section := range daikinSections {
+		if !bytes.Equal(state[section[0]:section[0]+len(daikinSignature)], daikinSignature) {
+			return fmt.Errorf("invalid daikin frame. unexpected signature % X", state[section[0]:section[0]+len(daikinSignature)])
+		}
+
+		expected := SumBytes(state[section[0] : section[1]-1])
+		if state[section[1]-1] != expected {
+			return fmt.Errorf("invalid daikin frame. expected checksum %#02x, got %#02x", expected, state[section[1]-1])
+		}
+	}
+	return nil
+}
+
+func (d *DaikinCommand) ToSignalSequence() []int {
+	seq := make([]int, 0, 2*(DAIKIN_LEADER_BITS+8*DAIKIN_STATE_LENGTH)+16)
+	seq = daikinEncoding.appendBits(seq, 0, DAIKIN_LEADER_BITS, false)
+	seq = daikinEncoding.appendFooter(seq, DAIKIN_ZERO_SPACE+DAIKIN_GAP)
+
+	for i, section := range daikinSections {
+		seq = daikinEncoding.appendHeader(seq)
+		seq = daikinEncoding.appendBytesLSB(seq, d.state[section[0]:section[1]])
+		if i == len(daikinSections)-1 {
+			seq = daikinEncoding.appendFooter(seq, 0)
+		} else {
+			seq = daikinEncoding.appendFooter(seq, DAIKIN_ZERO_SPACE+DAIKIN_GAP)
+		}
+	}
+
+	return seq
+}
+
+func (d *DaikinCommand) State() AcState {
+	state := AcState{
+		Power:       d.state[21]&0x01 != 0,
+		Temperature: int(d.state[22]) / 2,
+		Swing:       d.state[24]&0x0F == 0x0F,
+	}
+
+	switch (d.state[21] >> 4) & 0x07 {
+	case 0:
+		state.Mode = AcModeAuto
+	case 2:
+		state.Mode = AcModeDry
+	case 3:
+		state.Mode = AcModeCool
+	case 4:
+		state.Mode = AcModeHeat
+	case 6:
+		state.Mode = AcModeFan
+	}
+
+	switch fan := d.state[24] >> 4; {
+	case fan == 0x0A:
+		state.Fan = AcFanAuto
+	case fan <= 4 || fan == 0x0B:
+		state.Fan = AcFanLow
+	case fan <= 6:
+		state.Fan = AcFanMedium
+	default:
+		state.Fan = AcFanHigh
+	}
+
+	return state
+}
+
+func (d *DaikinCommand) SetState(state AcState) error {
+	if err := validateTemperature(state.Temperature, DAIKIN_MIN_TEMP, DAIKIN_MAX_TEMP); err != nil {
+		return err
+	}
+
+	var mode byte
+	switch state.Mode {
+	case AcModeAuto:
+		mode = 0
+	case AcModeDry:
+		mode = 2
+	case AcModeCool:
+		mode = 3
+	case AcModeHeat:
+		mode = 4
+	case AcModeFan:
+		mode = 6
+	default:
+		return fmt.Errorf("unsupported mode %v", state.Mode)
+	}
+
+	var fan byte
+	switch state.Fan {
+	case AcFanAuto:
+		fan = 0x0A
+	case AcFanLow:
+		fan = 3
+	case AcFanMedium:
+		fan = 5
+	case AcFanHigh:
+		fan = 7
+	default:
+		return fmt.Errorf("unsupported fan speed %v", state.Fan)
+	}
+
+	var swing byte
+	if state.Swing {
+		swing = 0x0F
+	}
+
+	d.state[21] = d.state[21]&0x8E | mode<<4
+	if state.Power {
+		d.state[21] |= 0x01
+	}
+	d.state[22] = byte(state.Temperature * 2)
+	d.state[24] = fan<<4 | swing
+
+	d.updateChecksums()
+	return nil
+}
+
+func (d *DaikinCommand) updateChecksums() {
+	for _, section := range daikinSections {
+		d.state[section[1]-1] = SumBytes(d.state[section[0] : section[1]-1])
+	}
+}
+
+func (d *DaikinCommand) Carrier() Carrier {
+	return ProtocolCarrier("daikin")
+}
+
+func (d *DaikinCommand) DebugString() string {
+	return fmt.Sprintf("Daikin Command: % X", d.state)
+}
+
+func (d *DaikinCommand) PayloadBits() []bool {
+	return bytesToBitsLSB(d.state[:])
+}
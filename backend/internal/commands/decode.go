@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Protocols returns the names of all protocols Decode recognizes.
+func Protocols() []string {
+	return append([]string{"nec-chained"}, AcProtocols()...)
+}
+
+// NewCommand creates an empty command of the named protocol.
+func NewCommand(protocol string) (Command, error) {
+	if protocol == "nec-chained" {
+		return &NecChainedCommand{}, nil
+	}
+	return NewAcCommand(protocol)
+}
+
+// DecodeError lists why every protocol rejected the signal.
+type DecodeError struct {
+	Failures map[string]error
+}
+
+func (e *DecodeError) Error() string {
+	failures := make([]string, 0, len(e.Failures))
+	for protocol, err := range e.Failures {
+		failures = append(failures, protocol+": "+err.Error())
+	}
+
+	sort.Strings(failures)
+	return fmt.Sprintf("no protocol matched the signal:\n%v", strings.Join(failures, "\n"))
+}
+
+// Furthest returns the protocol that got furthest into the signal before
+// failing, which is usually the one the signal was meant to be.
+func (e *DecodeError) Furthest() (string, *SignalError, bool) {
+	protocols := make([]string, 0, len(e.Failures))
+	for protocol := range e.Failures {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	var best *SignalError
+	bestProtocol := ""
+	for _, protocol := range protocols {
+		var signalError *SignalError
+		if errors.As(e.Failures[protocol], &signalError) && (best == nil || signalError.Position > best.Position) {
+			best = signalError
+			bestProtocol = protocol
+		}
+	}
+	return bestProtocol, best, best != nil
+}
+
+// Decode tries every known protocol on the signal and returns the first one
+// that accepts it. If none does, the error is a *DecodeError.
+func Decode(signal []int) (string, Command, error) {
+	failures := make(map[string]error)
+	for _, protocol := range Protocols() {
+		cmd, err := NewCommand(protocol)
+		if err != nil {
+			return "", nil, err
+		}
+
+		err = cmd.ParseFromSignalSequence(signal)
+		if err == nil {
+			return protocol, cmd, nil
+		}
+		failures[protocol] = err
+	}
+
+	return "", nil, &DecodeError{Failures: failures}
+}
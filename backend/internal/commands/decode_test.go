@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	protocol, cmd, err := Decode(commandCold22)
+	require.NoError(t, err)
+	require.Equal(t, "nec-chained", protocol)
+	require.IsType(t, &NecChainedCommand{}, cmd)
+
+	gree := NewGreeCommand()
+	protocol, cmd, err = Decode(gree.ToSignalSequence())
+	require.NoError(t, err)
+	require.Equal(t, "gree", protocol)
+	require.Equal(t, gree.State(), cmd.(AcCommand).State())
+
+	_, _, err = Decode([]int{100, 200, 300})
+	require.Error(t, err)
+}
+
+func TestDecode_Furthest(t *testing.T) {
+	signal := NewGreeCommand().ToSignalSequence()
+	signal[21] = 3000
+
+	_, _, err := Decode(signal)
+	decodeError, ok := err.(*DecodeError)
+	require.True(t, ok)
+
+	protocol, signalError, ok := decodeError.Furthest()
+	require.True(t, ok)
+	require.Equal(t, "gree", protocol)
+	require.Equal(t, 21, signalError.Position)
+
+	position, ok := ErrorPosition(decodeError.Failures["gree"])
+	require.True(t, ok)
+	require.Equal(t, 21, position)
+}
@@ -0,0 +1,256 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PayloadCommand is a command exposing its decoded payload bits in the order
+// they are sent. Headers, footers and repeated frames are left out.
+type PayloadCommand interface {
+	Command
+	PayloadBits() []bool
+}
+
+// Capture is a signal together with the remote settings it was captured with,
+// e.g. {"mode": "cold", "temperature": "20"}. Params may be empty for AC
+// protocols, whose state is decoded from the signal.
+type Capture struct {
+	Name   string
+	Signal []int
+	Params map[string]string
+}
+
+// FieldDiff is a parameter that has different values in the captures.
+type FieldDiff struct {
+	Name string
+	// Values by capture
+	Values []string
+}
+
+// BitDiff is a payload bit that has different values in the captures.
+type BitDiff struct {
+	Index int
+	// Values by capture
+	Values []bool
+	// Fields whose values determine the bit: captures that agree on such a
+	// field also agree on the bit.
+	Fields []string
+}
+
+type DiffResult struct {
+	Protocol string
+	Names    []string
+	Payloads [][]bool
+	Fields   []FieldDiff
+	Bits     []BitDiff
+}
+
+// Diff decodes captures of the same protocol and reports the payload bits and
+// the parameters that differ between them.
+func Diff(captures []Capture) (DiffResult, error) {
+	if len(captures) < 2 {
+		return DiffResult{}, errors.New("at least two captures are required")
+	}
+
+	result := DiffResult{
+		Names:    make([]string, len(captures)),
+		Payloads: make([][]bool, len(captures)),
+		Fields:   make([]FieldDiff, 0),
+		Bits:     make([]BitDiff, 0),
+	}
+
+	params := make([]map[string]string, len(captures))
+	for i, capture := range captures {
+		protocol, cmd, err := Decode(capture.Signal)
+		if err != nil {
+			return DiffResult{}, fmt.Errorf("capture %v: %w", capture.Name, err)
+		}
+		if i > 0 && protocol != result.Protocol {
+			return DiffResult{}, fmt.Errorf("capture %v is %v, while %v is %v", capture.Name, protocol, captures[0].Name, result.Protocol)
+		}
+
+		payload, ok := cmd.(PayloadCommand)
+		if !ok {
+			return DiffResult{}, fmt.Errorf("protocol %v does not expose its payload", protocol)
+		}
+
+		result.Protocol = protocol
+		result.Names[i] = capture.Name
+		result.Payloads[i] = payload.PayloadBits()
+		if len(result.Payloads[i]) != len(result.Payloads[0]) {
+			return DiffResult{}, fmt.Errorf("capture %v has %v payload bits, while %v has %v", capture.Name, len(result.Payloads[i]), captures[0].Name, len(result.Payloads[0]))
+		}
+
+		params[i] = make(map[string]string)
+		if ac, ok := cmd.(AcCommand); ok {
+			for name, value := range acStateParams(ac.State()) {
+				params[i][name] = value
+			}
+		}
+		for name, value := range capture.Params {
+			params[i][name] = value
+		}
+	}
+
+	result.Fields = diffFields(params)
+
+	for bit := range result.Payloads[0] {
+		values := make([]bool, len(captures))
+		changed := false
+		for i, payload := range result.Payloads {
+			values[i] = payload[bit]
+			changed = changed || values[i] != values[0]
+		}
+		if !changed {
+			continue
+		}
+
+		fields := make([]string, 0)
+		for _, field := range result.Fields {
+			if determines(field.Values, values) {
+				fields = append(fields, field.Name)
+			}
+		}
+		result.Bits = append(result.Bits, BitDiff{Index: bit, Values: values, Fields: fields})
+	}
+
+	return result, nil
+}
+
+func acStateParams(state AcState) map[string]string {
+	return map[string]string{
+		"power":       fmt.Sprint(state.Power),
+		"mode":        state.Mode.String(),
+		"temperature": fmt.Sprint(state.Temperature),
+		"fan":         state.Fan.String(),
+		"swing":       fmt.Sprint(state.Swing),
+	}
+}
+
+func diffFields(params []map[string]string) []FieldDiff {
+	names := make(map[string]bool)
+	for _, p := range params {
+		for name := range p {
+			names[name] = true
+		}
+	}
+
+	result := make([]FieldDiff, 0)
+	for name := range names {
+		values := make([]string, len(params))
+		changed := false
+		for i, p := range params {
+			values[i] = p[name]
+			changed = changed || values[i] != values[0]
+		}
+		if changed {
+			result = append(result, FieldDiff{Name: name, Values: values})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// determines checks that captures with equal field values have equal bits.
+func determines(fieldValues []string, bits []bool) bool {
+	bitByValue := make(map[string]bool)
+	for i, value := range fieldValues {
+		if bit, ok := bitByValue[value]; ok && bit != bits[i] {
+			return false
+		}
+		bitByValue[value] = bits[i]
+	}
+	return true
+}
+
+// String formats the result as a table of payloads, eight bits per group,
+// with the differing bits marked under it, followed by the changed
+// parameters and bits.
+func (r DiffResult) String() string {
+	nameWidth := 0
+	for _, name := range r.Names {
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+	}
+
+	changed := make(map[int]bool)
+	for _, bit := range r.Bits {
+		changed[bit.Index] = true
+	}
+
+	result := &strings.Builder{}
+	fmt.Fprintf(result, "protocol: %v\n\n", r.Protocol)
+	for i, payload := range r.Payloads {
+		fmt.Fprintf(result, "%-*v %v\n", nameWidth, r.Names[i], formatBits(payload, func(_ int, bit bool) string {
+			if bit {
+				return "1"
+			}
+			return "0"
+		}))
+	}
+	if len(r.Payloads) > 0 {
+		fmt.Fprintf(result, "%-*v %v\n", nameWidth, "", strings.TrimRight(formatBits(r.Payloads[0], func(i int, _ bool) string {
+			if changed[i] {
+				return "^"
+			}
+			return " "
+		}), " "))
+	}
+
+	if len(r.Fields) > 0 {
+		result.WriteString("\nchanged parameters:\n")
+		for _, field := range r.Fields {
+			fmt.Fprintf(result, "  %v: %v\n", field.Name, strings.Join(field.Values, " → "))
+		}
+	}
+
+	result.WriteString("\nchanged bits:\n")
+	if len(r.Bits) == 0 {
+		result.WriteString("  none\n")
+	}
+	for _, bit := range r.Bits {
+		values := make([]string, len(bit.Values))
+		for i, value := range bit.Values {
+			values[i] = "0"
+			if value {
+				values[i] = "1"
+			}
+		}
+
+		fields := ""
+		if len(bit.Fields) > 0 {
+			fields = " (" + strings.Join(bit.Fields, ", ") + ")"
+		}
+		fmt.Fprintf(result, "  bit %v, byte %v bit %v: %v%v\n", bit.Index, bit.Index/8, bit.Index%8, strings.Join(values, " → "), fields)
+	}
+	return result.String()
+}
+
+func formatBits(bits []bool, format func(i int, bit bool) string) string {
+	result := &strings.Builder{}
+	for i, bit := range bits {
+		if i > 0 && i%8 == 0 {
+			result.WriteString(" ")
+		}
+		result.WriteString(format(i, bit))
+	}
+	return result.String()
+}
+
+// bytesToBitsLSB lists the bits of the bytes in the order they are sent by appendBytesLSB
+func bytesToBitsLSB(data []byte) []bool {
+	result := make([]bool, 0, 8*len(data))
+	for _, b := range data {
+		for i := 0; i < 8; i++ {
+			result = append(result, b&(1<<uint(i)) != 0)
+		}
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDiff_NecChained(t *testing.T) {
+	result, err := Diff([]Capture{
+		{Name: "cold20", Signal: commandCold20, Params: map[string]string{"temperature": "20"}},
+		{Name: "cold24", Signal: commandCold24, Params: map[string]string{"temperature": "24"}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "nec-chained", result.Protocol)
+	require.Equal(t, []FieldDiff{{Name: "temperature", Values: []string{"20", "24"}}}, result.Fields)
+
+	// the README table: 20 is 0010 and 24 is 0100 in the temperature
+	// nibble of the third data byte, and its complement changes too
+	indexes := make([]int, 0)
+	for _, bit := range result.Bits {
+		indexes = append(indexes, bit.Index)
+		require.Equal(t, []string{"temperature"}, bit.Fields)
+	}
+	require.Equal(t, []int{33, 34, 41, 42}, indexes)
+}
+
+func TestDiff_AcState(t *testing.T) {
+	cool := NewGreeCommand()
+	heat := NewGreeCommand()
+	require.NoError(t, heat.SetState(AcState{Power: true, Mode: AcModeHeat, Temperature: 24}))
+	warmer := NewGreeCommand()
+	require.NoError(t, warmer.SetState(AcState{Power: true, Mode: AcModeHeat, Temperature: 26}))
+
+	result, err := Diff([]Capture{
+		{Name: "cool", Signal: cool.ToSignalSequence()},
+		{Name: "heat", Signal: heat.ToSignalSequence()},
+		{Name: "warmer", Signal: warmer.ToSignalSequence()},
+	})
+	require.NoError(t, err)
+
+	names := make([]string, 0)
+	for _, field := range result.Fields {
+		names = append(names, field.Name)
+	}
+	require.Equal(t, []string{"mode", "power", "temperature"}, names)
+
+	// the temperature nibble of the second byte depends on the temperature only
+	for _, bit := range result.Bits {
+		if bit.Index >= 8 && bit.Index < 12 {
+			require.Contains(t, bit.Fields, "temperature")
+		}
+	}
+}
+
+func TestDiff_DifferentProtocols(t *testing.T) {
+	_, err := Diff([]Capture{
+		{Name: "gree", Signal: NewGreeCommand().ToSignalSequence()},
+		{Name: "lg", Signal: NewLgCommand().ToSignalSequence()},
+	})
+	require.Error(t, err)
+}
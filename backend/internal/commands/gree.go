@@ -0,0 +1,196 @@
+package commands
+
+import (
+	"fmt"
+)
+
+const GREE_HDR_MARK = 9000
+const GREE_HDR_SPACE = 4500
+const GREE_BIT_MARK = 620
+const GREE_ONE_SPACE = 1600
+const GREE_ZERO_SPACE = 540
+const GREE_MSG_SPACE = 19980
+
+// GREE_BLOCK_FOOTER is sent between the two halves of the state
+const GREE_BLOCK_FOOTER = 0b010
+const GREE_BLOCK_FOOTER_BITS = 3
+
+const GREE_STATE_LENGTH = 8
+const GREE_MIN_TEMP = 16
+const GREE_MAX_TEMP = 30
+
+var greeEncoding = pulseDistance{
+	headerMark:  GREE_HDR_MARK,
+	headerSpace: GREE_HDR_SPACE,
+	bitMark:     GREE_BIT_MARK,
+	oneSpace:    GREE_ONE_SPACE,
+	zeroSpace:   GREE_ZERO_SPACE,
+}
+
+// GreeCommand is the 64 bit Gree protocol, also used by many rebranded units.
+// The state is sent in two blocks of four bytes separated by a fixed three
+// bit footer and a long space. The upper nibble of the last byte is a checksum.
+type GreeCommand struct {
+	state [GREE_STATE_LENGTH]byte
+}
+
+var _ AcCommand = &GreeCommand{}
+var _ PayloadCommand = &GreeCommand{}
+
+func NewGreeCommand() *GreeCommand {
+	cmd := &GreeCommand{
+		state: [GREE_STATE_LENGTH]byte{0x00, 0x00, 0x20, 0x50, 0x00, 0x20, 0x00, 0x00},
+	}
+	_ = cmd.SetState(AcState{Mode: AcModeCool, Temperature: 24})
+	return cmd
+}
+
+func (g *GreeCommand) ParseFromSignalSequence(signalSequence []int) error {
+	reader := newSignalReader(greeEncoding, signalSequence)
+
+	if err := reader.readHeader(); err != nil {
+		return err
+	}
+
+	first, err := reader.readBytesLSB(GREE_STATE_LENGTH / 2)
+	if err != nil {
+		return err
+	}
+
+	footer, err := reader.readBits(GREE_BLOCK_FOOTER_BITS, false)
+	if err != nil {
+		return err
+	}
+	if footer != GREE_BLOCK_FOOTER {
+		return fmt.Errorf("invalid gree frame. expected block footer %03b, got %03b", GREE_BLOCK_FOOTER, footer)
+	}
+
+	if err := reader.readFooter(GREE_MSG_SPACE / 2); err != nil {
+		return err
+	}
+
+	second, err := reader.readBytesLSB(GREE_STATE_LENGTH / 2)
+	if err != nil {
+		return err
+	}
+
+	if err := reader.readFooter(0); err != nil {
+		return err
+	}
+
+	if err := reader.done(); err != nil {
+		return err
+	}
+
+	var state [GREE_STATE_LENGTH]byte
+	copy(state[:], first)
+	copy(state[GREE_STATE_LENGTH/2:], second)
+
+	expected := greeChecksum(state)
+	if state[7]>>4 != expected {
+		return fmt.Errorf("invalid gree frame. expected checksum %#x, got %#x", expected, state[7]>>4)
+	}
+
+	g.state = state
+	return nil
+}
+
+func greeChecksum(state [GREE_STATE_LENGTH]byte) byte {
+	var sum byte = 10
+	for i := 0; i < 4; i++ {
+		sum += state[i] & 0x0F
+	}
+	for i := 4; i < GREE_STATE_LENGTH-1; i++ {
+		sum += state[i] >> 4
+	}
+	return sum & 0x0F
+}
+
+func (g *GreeCommand) ToSignalSequence() []int {
+	seq := make([]int, 0, 2*(8*GREE_STATE_LENGTH+GREE_BLOCK_FOOTER_BITS+2)+1)
+	seq = greeEncoding.appendHeader(seq)
+	seq = greeEncoding.appendBytesLSB(seq, g.state[:GREE_STATE_LENGTH/2])
+	seq = greeEncoding.appendBits(seq, GREE_BLOCK_FOOTER, GREE_BLOCK_FOOTER_BITS, false)
+	seq = greeEncoding.appendFooter(seq, GREE_MSG_SPACE)
+	seq = greeEncoding.appendBytesLSB(seq, g.state[GREE_STATE_LENGTH/2:])
+	seq = greeEncoding.appendFooter(seq, 0)
+	return seq
+}
+
+func (g *GreeCommand) State() AcState {
+	state := AcState{
+		Power:       g.state[0]&0x08 != 0,
+		Temperature: int(g.state[1]&0x0F) + GREE_MIN_TEMP,
+		Fan:         AcFan((g.state[0] >> 4) & 0x03),
+		Swing:       g.state[0]&0x40 != 0,
+	}
+
+	switch g.state[0] & 0x07 {
+	case 0:
+		state.Mode = AcModeAuto
+	case 1:
+		state.Mode = AcModeCool
+	case 2:
+		state.Mode = AcModeDry
+	case 3:
+		state.Mode = AcModeFan
+	case 4:
+		state.Mode = AcModeHeat
+	}
+
+	return state
+}
+
+func (g *GreeCommand) SetState(state AcState) error {
+	if err := validateTemperature(state.Temperature, GREE_MIN_TEMP, GREE_MAX_TEMP); err != nil {
+		return err
+	}
+
+	var mode byte
+	switch state.Mode {
+	case AcModeAuto:
+		mode = 0
+	case AcModeCool:
+		mode = 1
+	case AcModeDry:
+		mode = 2
+	case AcModeFan:
+		mode = 3
+	case AcModeHeat:
+		mode = 4
+	default:
+		return fmt.Errorf("unsupported mode %v", state.Mode)
+	}
+
+	// gree fan speeds map one to one: auto, 1, 2, 3
+	if state.Fan < AcFanAuto || state.Fan > AcFanHigh {
+		return fmt.Errorf("unsupported fan speed %v", state.Fan)
+	}
+
+	g.state[0] = g.state[0]&0x80 | byte(state.Fan)<<4 | mode
+	if state.Power {
+		g.state[0] |= 0x08
+	}
+	if state.Swing {
+		g.state[0] |= 0x40
+		g.state[4] = g.state[4]&0xF0 | 0x01
+	} else {
+		g.state[4] &= 0xF0
+	}
+
+	g.state[1] = g.state[1]&0xF0 | byte(state.Temperature-GREE_MIN_TEMP)
+	g.state[7] = g.state[7]&0x0F | greeChecksum(g.state)<<4
+	return nil
+}
+
+func (g *GreeCommand) Carrier() Carrier {
+	return ProtocolCarrier("gree")
+}
+
+func (g *GreeCommand) DebugString() string {
+	return fmt.Sprintf("Gree Command: % X", g.state)
+}
+
+func (g *GreeCommand) PayloadBits() []bool {
+	return bytesToBitsLSB(g.state[:])
+}
@@ -0,0 +1,29 @@
+package commands
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestGree_RoundTrip(t *testing.T) {
+	state := AcState{Power: true, Mode: AcModeCool, Temperature: 18, Fan: AcFanLow, Swing: true}
+
+	cmd := NewGreeCommand()
+	require.NoError(t, cmd.SetState(state))
+
+	parsed := NewGreeCommand()
+	err := parsed.ParseFromSignalSequence(cmd.ToSignalSequence())
+	require.NoError(t, err)
+	require.Equal(t, state, parsed.State())
+}
+
+func TestGree_Checksum(t *testing.T) {
+	// power on, cool, 24°C: 10 + 0x9 + 0x8 + 0x0 + 0x0 + 0x0 + 0x2 + 0x0 = 0x1D
+	state := [GREE_STATE_LENGTH]byte{0x09, 0x08, 0x20, 0x50, 0x00, 0x20, 0x00, 0x00}
+	require.Equal(t, byte(0x0D), greeChecksum(state))
+
+	cmd := NewGreeCommand()
+	cmd.state = state
+	err := NewGreeCommand().ParseFromSignalSequence(cmd.ToSignalSequence())
+	require.ErrorContains(t, err, "checksum")
+}
@@ -0,0 +1,197 @@
+package commands
+
+import (
+	"fmt"
+)
+
+const LG_HDR_MARK = 8500
+const LG_HDR_SPACE = 4250
+const LG_BIT_MARK = 550
+const LG_ONE_SPACE = 1600
+const LG_ZERO_SPACE = 550
+
+const LG_BITS = 28
+const LG_SIGNATURE = 0x88
+const LG_TEMP_OFFSET = 15
+const LG_MIN_TEMP = 16
+const LG_MAX_TEMP = 30
+
+// LG_OFF_COMMAND is the fixed frame LG remotes send to switch the unit off
+const LG_OFF_COMMAND = 0x88C0051
+
+var lgEncoding = pulseDistance{
+	headerMark:  LG_HDR_MARK,
+	headerSpace: LG_HDR_SPACE,
+	bitMark:     LG_BIT_MARK,
+	oneSpace:    LG_ONE_SPACE,
+	zeroSpace:   LG_ZERO_SPACE,
+}
+
+// LgCommand is the 28 bit LG AC protocol, sent MSB first:
+//
+//	ssssssss pp000mmm tttt ffff cccc
+//
+// s - signature, p - power, m - mode, t - temperature, f - fan, c - checksum.
+// The checksum is the sum of the four nibbles between the signature and itself.
+type LgCommand struct {
+	raw uint32
+	// the off frame carries no settings, keep the ones sent last
+	settings uint32
+}
+
+var _ AcCommand = &LgCommand{}
+var _ PayloadCommand = &LgCommand{}
+
+func NewLgCommand() *LgCommand {
+	cmd := &LgCommand{}
+	_ = cmd.SetState(AcState{Mode: AcModeCool, Temperature: 24})
+	return cmd
+}
+
+func (l *LgCommand) ParseFromSignalSequence(signalSequence []int) error {
+	reader := newSignalReader(lgEncoding, signalSequence)
+
+	if err := reader.readHeader(); err != nil {
+		return err
+	}
+
+	value, err := reader.readBits(LG_BITS, true)
+	if err != nil {
+		return err
+	}
+
+	if err := reader.readFooter(0); err != nil {
+		return err
+	}
+
+	if err := reader.done(); err != nil {
+		return err
+	}
+
+	raw := uint32(value)
+	if raw>>20 != LG_SIGNATURE {
+		return fmt.Errorf("invalid lg frame. expected signature %#02x, got %#02x", LG_SIGNATURE, raw>>20)
+	}
+
+	if expected := lgChecksum(raw); raw&0x0F != expected {
+		return fmt.Errorf("invalid lg frame. expected checksum %#x, got %#x", expected, raw&0x0F)
+	}
+
+	l.raw = raw
+	if raw != LG_OFF_COMMAND {
+		l.settings = raw
+	}
+	return nil
+}
+
+func lgChecksum(raw uint32) uint32 {
+	var sum uint32
+	for shift := 4; shift < 20; shift += 4 {
+		sum += (raw >> uint(shift)) & 0x0F
+	}
+	return sum & 0x0F
+}
+
+func (l *LgCommand) ToSignalSequence() []int {
+	seq := make([]int, 0, 2*(LG_BITS+1)+1)
+	seq = lgEncoding.appendHeader(seq)
+	seq = lgEncoding.appendBits(seq, uint64(l.raw), LG_BITS, true)
+	seq = lgEncoding.appendFooter(seq, 0)
+	return seq
+}
+
+func (l *LgCommand) State() AcState {
+	state := AcState{
+		Power:       l.raw != LG_OFF_COMMAND && (l.raw>>18)&0x03 == 0,
+		Temperature: int((l.settings>>8)&0x0F) + LG_TEMP_OFFSET,
+	}
+
+	switch (l.settings >> 12) & 0x07 {
+	case 0:
+		state.Mode = AcModeCool
+	case 1:
+		state.Mode = AcModeDry
+	case 2:
+		state.Mode = AcModeFan
+	case 3:
+		state.Mode = AcModeAuto
+	case 4:
+		state.Mode = AcModeHeat
+	}
+
+	switch (l.settings >> 4) & 0x0F {
+	case 5:
+		state.Fan = AcFanAuto
+	case 0, 9, 10:
+		state.Fan = AcFanLow
+	case 2:
+		state.Fan = AcFanMedium
+	default:
+		state.Fan = AcFanHigh
+	}
+
+	return state
+}
+
+// SetState updates the frame. LG has a separate swing command, so Swing is ignored.
+func (l *LgCommand) SetState(state AcState) error {
+	if err := validateTemperature(state.Temperature, LG_MIN_TEMP, LG_MAX_TEMP); err != nil {
+		return err
+	}
+
+	var mode uint32
+	switch state.Mode {
+	case AcModeCool:
+		mode = 0
+	case AcModeDry:
+		mode = 1
+	case AcModeFan:
+		mode = 2
+	case AcModeAuto:
+		mode = 3
+	case AcModeHeat:
+		mode = 4
+	default:
+		return fmt.Errorf("unsupported mode %v", state.Mode)
+	}
+
+	var fan uint32
+	switch state.Fan {
+	case AcFanAuto:
+		fan = 5
+	case AcFanLow:
+		fan = 9
+	case AcFanMedium:
+		fan = 2
+	case AcFanHigh:
+		fan = 4
+	default:
+		return fmt.Errorf("unsupported fan speed %v", state.Fan)
+	}
+
+	settings := uint32(LG_SIGNATURE)<<20 | mode<<12 | uint32(state.Temperature-LG_TEMP_OFFSET)<<8 | fan<<4
+	l.settings = settings | lgChecksum(settings)
+
+	if state.Power {
+		l.raw = l.settings
+	} else {
+		l.raw = LG_OFF_COMMAND
+	}
+	return nil
+}
+
+func (l *LgCommand) Carrier() Carrier {
+	return ProtocolCarrier("lg")
+}
+
+func (l *LgCommand) DebugString() string {
+	return fmt.Sprintf("LG Command: %07X", l.raw)
+}
+
+func (l *LgCommand) PayloadBits() []bool {
+	result := make([]bool, LG_BITS)
+	for i := range result {
+		result[i] = l.raw&(1<<uint(LG_BITS-1-i)) != 0
+	}
+	return result
+}
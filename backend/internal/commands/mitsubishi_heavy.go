@@ -0,0 +1,198 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const MITSUBISHI_HEAVY_HDR_MARK = 3140
+const MITSUBISHI_HEAVY_HDR_SPACE = 1630
+const MITSUBISHI_HEAVY_BIT_MARK = 370
+const MITSUBISHI_HEAVY_ZERO_SPACE = 420
+const MITSUBISHI_HEAVY_ONE_SPACE = 1220
+
+const MITSUBISHI_HEAVY_STATE_LENGTH = 11
+const MITSUBISHI_HEAVY_MIN_TEMP = 17
+const MITSUBISHI_HEAVY_MAX_TEMP = 31
+
+var mitsubishiHeavySignature = []byte{0xAD, 0x51, 0x3C, 0xD9, 0x26}
+
+var mitsubishiHeavyEncoding = pulseDistance{
+	headerMark:  MITSUBISHI_HEAVY_HDR_MARK,
+	headerSpace: MITSUBISHI_HEAVY_HDR_SPACE,
+	bitMark:     MITSUBISHI_HEAVY_BIT_MARK,
+	oneSpace:    MITSUBISHI_HEAVY_ONE_SPACE,
+	zeroSpace:   MITSUBISHI_HEAVY_ZERO_SPACE,
+}
+
+// MitsubishiHeavyCommand is the 88 bit Mitsubishi Heavy Industries protocol.
+// After the signature every data byte is followed by its complement, which is
+// what the protocol uses instead of a checksum.
+type MitsubishiHeavyCommand struct {
+	state [MITSUBISHI_HEAVY_STATE_LENGTH]byte
+}
+
+var _ AcCommand = &MitsubishiHeavyCommand{}
+var _ PayloadCommand = &MitsubishiHeavyCommand{}
+
+func NewMitsubishiHeavyCommand() *MitsubishiHeavyCommand {
+	cmd := &MitsubishiHeavyCommand{}
+	copy(cmd.state[:], mitsubishiHeavySignature)
+	_ = cmd.SetState(AcState{Mode: AcModeCool, Temperature: 24})
+	return cmd
+}
+
+func (m *MitsubishiHeavyCommand) ParseFromSignalSequence(signalSequence []int) error {
+	reader := newSignalReader(mitsubishiHeavyEncoding, signalSequence)
+
+	if err := reader.readHeader(); err != nil {
+		return err
+	}
+
+	data, err := reader.readBytesLSB(MITSUBISHI_HEAVY_STATE_LENGTH)
+	if err != nil {
+		return err
+	}
+
+	if err := reader.readFooter(0); err != nil {
+		return err
+	}
+
+	if err := reader.done(); err != nil {
+		return err
+	}
+
+	var state [MITSUBISHI_HEAVY_STATE_LENGTH]byte
+	copy(state[:], data)
+
+	if err := validateMitsubishiHeavyState(state); err != nil {
+		return err
+	}
+
+	m.state = state
+	return nil
+}
+
+func validateMitsubishiHeavyState(state [MITSUBISHI_HEAVY_STATE_LENGTH]byte) error {
+	if !bytes.Equal(state[:len(mitsubishiHeavySignature)], mitsubishiHeavySignature) {
+		return fmt.Errorf("invalid mitsubishi heavy frame. unexpected signature % X", state[:len(mitsubishiHeavySignature)])
+	}
+
+	for i := len(mitsubishiHeavySignature); i < MITSUBISHI_HEAVY_STATE_LENGTH; i += 2 {
+		if state[i]^state[i+1] != 0xFF {
+			return fmt.Errorf("invalid mitsubishi heavy frame. expected byte %v to be the complement of byte %v, got %#02x and %#02x", i+1, i, state[i+1], state[i])
+		}
+	}
+	return nil
+}
+
+func (m *MitsubishiHeavyCommand) ToSignalSequence() []int {
+	seq := make([]int, 0, 2*(8*MITSUBISHI_HEAVY_STATE_LENGTH+1)+1)
+	seq = mitsubishiHeavyEncoding.appendHeader(seq)
+	seq = mitsubishiHeavyEncoding.appendBytesLSB(seq, m.state[:])
+	seq = mitsubishiHeavyEncoding.appendFooter(seq, 0)
+	return seq
+}
+
+func (m *MitsubishiHeavyCommand) State() AcState {
+	state := AcState{
+		Power:       m.state[9]&0x08 != 0,
+		Temperature: int(m.state[9]>>4) + MITSUBISHI_HEAVY_MIN_TEMP,
+		Swing:       m.state[5]&0x02 != 0,
+	}
+
+	switch m.state[9] & 0x07 {
+	case 0:
+		state.Mode = AcModeAuto
+	case 1:
+		state.Mode = AcModeCool
+	case 2:
+		state.Mode = AcModeDry
+	case 3:
+		state.Mode = AcModeFan
+	case 4:
+		state.Mode = AcModeHeat
+	}
+
+	switch m.state[7] >> 5 {
+	case 0:
+		state.Fan = AcFanAuto
+	case 2:
+		state.Fan = AcFanLow
+	case 3:
+		state.Fan = AcFanMedium
+	default:
+		state.Fan = AcFanHigh
+	}
+
+	return state
+}
+
+func (m *MitsubishiHeavyCommand) SetState(state AcState) error {
+	if err := validateTemperature(state.Temperature, MITSUBISHI_HEAVY_MIN_TEMP, MITSUBISHI_HEAVY_MAX_TEMP); err != nil {
+		return err
+	}
+
+	var mode byte
+	switch state.Mode {
+	case AcModeAuto:
+		mode = 0
+	case AcModeCool:
+		mode = 1
+	case AcModeDry:
+		mode = 2
+	case AcModeFan:
+		mode = 3
+	case AcModeHeat:
+		mode = 4
+	default:
+		return fmt.Errorf("unsupported mode %v", state.Mode)
+	}
+
+	var fan byte
+	switch state.Fan {
+	case AcFanAuto:
+		fan = 0
+	case AcFanLow:
+		fan = 2
+	case AcFanMedium:
+		fan = 3
+	case AcFanHigh:
+		fan = 4
+	default:
+		return fmt.Errorf("unsupported fan speed %v", state.Fan)
+	}
+
+	m.state[5] &^= 0x02
+	if state.Swing {
+		m.state[5] |= 0x02
+	}
+
+	m.state[7] = m.state[7]&0x1F | fan<<5
+
+	m.state[9] = byte(state.Temperature-MITSUBISHI_HEAVY_MIN_TEMP)<<4 | mode
+	if state.Power {
+		m.state[9] |= 0x08
+	}
+
+	m.updateComplements()
+	return nil
+}
+
+func (m *MitsubishiHeavyCommand) updateComplements() {
+	for i := len(mitsubishiHeavySignature); i < MITSUBISHI_HEAVY_STATE_LENGTH; i += 2 {
+		m.state[i+1] = ^m.state[i]
+	}
+}
+
+func (m *MitsubishiHeavyCommand) Carrier() Carrier {
+	return ProtocolCarrier("mitsubishi-heavy")
+}
+
+func (m *MitsubishiHeavyCommand) DebugString() string {
+	return fmt.Sprintf("Mitsubishi Heavy Command: % X", m.state)
+}
+
+func (m *MitsubishiHeavyCommand) PayloadBits() []bool {
+	return bytesToBitsLSB(m.state[:])
+}
@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMitsubishiHeavy_RoundTrip(t *testing.T) {
+	state := AcState{Power: true, Mode: AcModeDry, Temperature: 26, Fan: AcFanMedium, Swing: true}
+
+	cmd := NewMitsubishiHeavyCommand()
+	require.NoError(t, cmd.SetState(state))
+
+	parsed := NewMitsubishiHeavyCommand()
+	err := parsed.ParseFromSignalSequence(cmd.ToSignalSequence())
+	require.NoError(t, err)
+	require.Equal(t, state, parsed.State())
+}
+
+func TestMitsubishiHeavy_Complement(t *testing.T) {
+	cmd := NewMitsubishiHeavyCommand()
+	require.NoError(t, cmd.SetState(AcState{Power: true, Mode: AcModeCool, Temperature: 22}))
+	require.Equal(t, ^cmd.state[9], cmd.state[10])
+
+	cmd.state[10]++
+	err := NewMitsubishiHeavyCommand().ParseFromSignalSequence(cmd.ToSignalSequence())
+	require.ErrorContains(t, err, "complement")
+}
+
+func TestMitsubishiHeavy_KnownFrame(t *testing.T) {
+	// from the test data of IRremoteESP8266: on, dry, 25°C, auto fan
+	frame := [MITSUBISHI_HEAVY_STATE_LENGTH]byte{0xAD, 0x51, 0x3C, 0xD9, 0x26, 0x48, 0xB7, 0x00, 0xFF, 0x8A, 0x75}
+	signal := (&MitsubishiHeavyCommand{state: frame}).ToSignalSequence()
+
+	cmd := NewMitsubishiHeavyCommand()
+	require.NoError(t, cmd.ParseFromSignalSequence(signal))
+	state := AcState{Power: true, Mode: AcModeDry, Temperature: 25, Fan: AcFanAuto}
+	require.Equal(t, state, cmd.State())
+
+	require.NoError(t, cmd.SetState(state))
+	require.Equal(t, frame, cmd.state)
+	require.Equal(t, signal, cmd.ToSignalSequence())
+}
@@ -12,11 +12,14 @@ const NEC_LONG = 1687
 const NEC_INITIATOR = 4300
 const NEC_FILLER = 9000 - NEC_INITIATOR
 
+// NEC_TIMINGS are the nominal durations of the protocol
+var NEC_TIMINGS = []int{NEC_SHORT, NEC_LONG, NEC_INITIATOR, NEC_FILLER}
+
 type NecChainedCommand struct {
 	cmd [3]byte
 }
 
-var _ Command = &NecChainedCommand{}
+var _ PayloadCommand = &NecChainedCommand{}
 
 // ParseFromSignalSequence parses a signal sequence into a command
 func (n *NecChainedCommand) ParseFromSignalSequence(signalSequence []int) error {
@@ -25,7 +28,7 @@ func (n *NecChainedCommand) ParseFromSignalSequence(signalSequence []int) error
 
 	prelude := false
 
-	for _, signal := range signalSequence {
+	for i, signal := range signalSequence {
 		val := closestValue(signal)
 		if val == NEC_INITIATOR || val == NEC_FILLER {
 			if len(currentList) > 0 {
@@ -45,13 +48,13 @@ func (n *NecChainedCommand) ParseFromSignalSequence(signalSequence []int) error
 				currentList = append(currentList, 1)
 				prelude = false
 			} else {
-				return errors.New(fmt.Sprintf("invalid signal sequence. expected short or long signal, got %v", val))
+				return signalErrorf(i, "invalid signal sequence. expected short or long signal, got %v", val)
 			}
 		} else {
 			if val == NEC_SHORT {
 				prelude = true
 			} else {
-				return errors.New(fmt.Sprintf("invalid signal sequence. expected short signal, got %v", val))
+				return signalErrorf(i, "invalid signal sequence. expected short signal, got %v", val)
 			}
 		}
 	}
@@ -107,9 +110,8 @@ func (n *NecChainedCommand) ParseFromSignalSequence(signalSequence []int) error
 }
 
 func closestValue(x int) int {
-	candidates := []int{NEC_SHORT, NEC_LONG, NEC_INITIATOR, NEC_FILLER}
-	closest := candidates[0]
-	for _, candidate := range candidates {
+	closest := NEC_TIMINGS[0]
+	for _, candidate := range NEC_TIMINGS {
 		if abs(candidate-x) < abs(closest-x) {
 			closest = candidate
 		}
@@ -151,6 +153,15 @@ func (n *NecChainedCommand) ToSignalSequence() []int {
 	return nil
 }
 
+func (n *NecChainedCommand) Carrier() Carrier {
+	return ProtocolCarrier("nec")
+}
+
 func (n *NecChainedCommand) DebugString() string {
 	return fmt.Sprintf("NEC Chained Command: %08b %08b %08b", n.cmd[0], n.cmd[1], n.cmd[2])
 }
+
+// PayloadBits returns one frame, every byte followed by its complement
+func (n *NecChainedCommand) PayloadBits() []bool {
+	return bytesToBitsLSB([]byte{n.cmd[0], ^n.cmd[0], n.cmd[1], ^n.cmd[1], n.cmd[2], ^n.cmd[2]})
+}
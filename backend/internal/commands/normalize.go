@@ -0,0 +1,185 @@
+package commands
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// NormalizeOptions control how Normalize cleans up a captured signal.
+type NormalizeOptions struct {
+	// Nominal durations of the protocol. Clusters close enough to one of them
+	// are snapped to it, the rest are snapped to their own average. May be empty.
+	Nominal []int
+	// TolerancePercent is how far two durations may be apart, relative to the
+	// shorter one, to still belong to the same cluster, and how far a cluster
+	// may be from a nominal duration to be snapped to it.
+	TolerancePercent int
+	// Resolution of the receiver in microseconds, added to the tolerance.
+	Resolution int
+	// MinPulse is the shortest mark that is not considered noise when found
+	// at the edges of the signal.
+	MinPulse int
+}
+
+var DefaultNormalizeOptions = NormalizeOptions{
+	TolerancePercent: 10,
+	Resolution:       100,
+	MinPulse:         100,
+}
+
+// Cluster is a group of similar durations of the same kind, marks or spaces.
+type Cluster struct {
+	Mark    bool
+	Min     int
+	Max     int
+	Count   int
+	Average int
+	// Value all durations of the cluster are snapped to
+	Value int
+	// Nominal is true if Value is one of the nominal durations
+	Nominal bool
+}
+
+type NormalizeResult struct {
+	Signal   []int
+	Clusters []Cluster
+	// Quality from 0 to 1: one minus the average relative deviation of the
+	// captured durations from their snapped values, multiplied by the share
+	// of durations that were snapped to a nominal value when those are given.
+	Quality float64
+}
+
+// Normalize removes receiver jitter from a captured mark/space sequence:
+// noise at the edges is trimmed, marks and spaces are clustered separately
+// and every duration is replaced by the value of its cluster.
+func Normalize(signal []int, options NormalizeOptions) (NormalizeResult, error) {
+	signal = trimNoise(signal, options.MinPulse)
+	if len(signal) == 0 {
+		return NormalizeResult{}, errors.New("signal is empty")
+	}
+
+	marks := make([]int, 0, len(signal)/2+1)
+	spaces := make([]int, 0, len(signal)/2)
+	for i, duration := range signal {
+		if duration <= 0 {
+			return NormalizeResult{}, errors.New("signal contains non positive durations")
+		}
+		if i%2 == 0 {
+			marks = append(marks, duration)
+		} else {
+			spaces = append(spaces, duration)
+		}
+	}
+
+	clusters := append(
+		clusterDurations(marks, true, options),
+		clusterDurations(spaces, false, options)...,
+	)
+
+	result := NormalizeResult{
+		Signal:   make([]int, len(signal)),
+		Clusters: clusters,
+	}
+
+	deviation := 0.0
+	nominal := 0
+	for i, duration := range signal {
+		cluster := findCluster(clusters, duration, i%2 == 0)
+		result.Signal[i] = cluster.Value
+		deviation += math.Abs(float64(duration-cluster.Value)) / float64(cluster.Value)
+		if cluster.Nominal {
+			nominal++
+		}
+	}
+
+	result.Quality = math.Max(0, 1-deviation/float64(len(signal)))
+	if len(options.Nominal) > 0 {
+		result.Quality *= float64(nominal) / float64(len(signal))
+	}
+
+	return result, nil
+}
+
+// trimNoise drops glitch marks, together with their spaces, from both ends
+// of the signal and the trailing space, if any.
+func trimNoise(signal []int, minPulse int) []int {
+	for len(signal) >= 2 && signal[0] < minPulse {
+		signal = signal[2:]
+	}
+
+	if len(signal)%2 == 0 && len(signal) > 0 {
+		signal = signal[:len(signal)-1]
+	}
+
+	for len(signal) >= 3 && signal[len(signal)-1] < minPulse {
+		signal = signal[:len(signal)-2]
+	}
+
+	if len(signal) == 1 && signal[0] < minPulse {
+		return nil
+	}
+	return signal
+}
+
+func clusterDurations(durations []int, mark bool, options NormalizeOptions) []Cluster {
+	sorted := append([]int(nil), durations...)
+	sort.Ints(sorted)
+
+	clusters := make([]Cluster, 0)
+	sum := 0
+	for i, duration := range sorted {
+		if i == 0 || !withinTolerance(sorted[i-1], duration, options) {
+			if i > 0 {
+				clusters[len(clusters)-1].Average = roundedDiv(sum, clusters[len(clusters)-1].Count)
+			}
+			clusters = append(clusters, Cluster{Mark: mark, Min: duration})
+			sum = 0
+		}
+
+		cluster := &clusters[len(clusters)-1]
+		cluster.Max = duration
+		cluster.Count++
+		sum += duration
+	}
+	if len(clusters) > 0 {
+		clusters[len(clusters)-1].Average = roundedDiv(sum, clusters[len(clusters)-1].Count)
+	}
+
+	for i := range clusters {
+		clusters[i].Value = clusters[i].Average
+		best := -1
+		for _, nominal := range options.Nominal {
+			if withinTolerance(nominal, clusters[i].Average, options) && (best < 0 || abs(nominal-clusters[i].Average) < abs(best-clusters[i].Average)) {
+				best = nominal
+			}
+		}
+		if best > 0 {
+			clusters[i].Value = best
+			clusters[i].Nominal = true
+		}
+	}
+
+	return clusters
+}
+
+func withinTolerance(a int, b int, options NormalizeOptions) bool {
+	shorter := a
+	if b < shorter {
+		shorter = b
+	}
+	return abs(a-b) <= shorter*options.TolerancePercent/100+options.Resolution
+}
+
+func findCluster(clusters []Cluster, duration int, mark bool) Cluster {
+	for _, cluster := range clusters {
+		if cluster.Mark == mark && duration >= cluster.Min && duration <= cluster.Max {
+			return cluster
+		}
+	}
+	panic("duration does not belong to any cluster")
+}
+
+func roundedDiv(a int, b int) int {
+	return (a + b/2) / b
+}
@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNormalize_RemovesJitter(t *testing.T) {
+	result, err := Normalize(commandWater24, DefaultNormalizeOptions)
+	require.NoError(t, err)
+
+	require.Len(t, result.Signal, len(commandWater24))
+	assert.NotContains(t, result.Signal, 300)
+	assert.Greater(t, result.Quality, 0.9)
+
+	// marks: bit mark and header mark
+	// spaces: short, long, header and frame gap
+	assert.Len(t, result.Clusters, 6)
+
+	original := NecChainedCommand{}
+	require.NoError(t, original.ParseFromSignalSequence(commandWater24))
+	normalized := NecChainedCommand{}
+	require.NoError(t, normalized.ParseFromSignalSequence(result.Signal))
+	assert.Equal(t, original.cmd, normalized.cmd)
+}
+
+func TestNormalize_SnapsToNominal(t *testing.T) {
+	options := DefaultNormalizeOptions
+	options.Nominal = NEC_TIMINGS
+
+	result, err := Normalize(commandCold20, options)
+	require.NoError(t, err)
+
+	for i := 2; i < 10; i++ {
+		assert.Contains(t, []int{NEC_SHORT, NEC_LONG}, result.Signal[i])
+	}
+
+	// the frame gap is not a nominal NEC duration
+	assert.Less(t, result.Quality, 1.0)
+	assert.Greater(t, result.Quality, 0.8)
+}
+
+func TestNormalize_TrimsNoise(t *testing.T) {
+	signal := []int{40, 900, 9000, 4500, 560, 1690, 560, 560, 560, 30000, 50}
+	result, err := Normalize(signal, DefaultNormalizeOptions)
+	require.NoError(t, err)
+	assert.Equal(t, []int{9000, 4500, 560, 1690, 560, 560, 560}, result.Signal)
+
+	_, err = Normalize([]int{20}, DefaultNormalizeOptions)
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package commands
+
+// SIGNAL_TOLERANCE_PERCENT is how far a measured duration may deviate from
+// the nominal one and still match it. Receivers tend to stretch marks, so
+// SIGNAL_TOLERANCE_EXCESS is added on top.
+const SIGNAL_TOLERANCE_PERCENT = 25
+const SIGNAL_TOLERANCE_EXCESS = 100
+
+// pulseDistance describes a pulse distance encoding: every bit is a mark of
+// fixed length followed by a short (zero) or long (one) space.
+type pulseDistance struct {
+	headerMark  int
+	headerSpace int
+	bitMark     int
+	oneSpace    int
+	zeroSpace   int
+}
+
+func (p pulseDistance) appendHeader(seq []int) []int {
+	return append(seq, p.headerMark, p.headerSpace)
+}
+
+func (p pulseDistance) appendBytesLSB(seq []int, data []byte) []int {
+	for _, b := range data {
+		seq = p.appendBits(seq, uint64(b), 8, false)
+	}
+	return seq
+}
+
+func (p pulseDistance) appendBits(seq []int, value uint64, nbits int, msbFirst bool) []int {
+	for i := 0; i < nbits; i++ {
+		shift := i
+		if msbFirst {
+			shift = nbits - 1 - i
+		}
+
+		if value&(1<<uint(shift)) != 0 {
+			seq = append(seq, p.bitMark, p.oneSpace)
+		} else {
+			seq = append(seq, p.bitMark, p.zeroSpace)
+		}
+	}
+	return seq
+}
+
+// appendFooter terminates a frame with a bit mark. If gap is not zero, it is
+// followed by a space separating the frame from the next one.
+func (p pulseDistance) appendFooter(seq []int, gap int) []int {
+	seq = append(seq, p.bitMark)
+	if gap > 0 {
+		seq = append(seq, gap)
+	}
+	return seq
+}
+
+func matchesDuration(measured int, nominal int) bool {
+	tolerance := nominal*SIGNAL_TOLERANCE_PERCENT/100 + SIGNAL_TOLERANCE_EXCESS
+	return abs(measured-nominal) <= tolerance
+}
+
+// signalReader consumes a mark/space sequence using a pulseDistance encoding.
+type signalReader struct {
+	encoding pulseDistance
+	seq      []int
+	pos      int
+}
+
+func newSignalReader(encoding pulseDistance, seq []int) *signalReader {
+	return &signalReader{encoding: encoding, seq: seq}
+}
+
+func (r *signalReader) expect(nominal int, what string) error {
+	if r.pos >= len(r.seq) {
+		return signalErrorf(r.pos, "invalid signal sequence. expected %v at position %v, got end of sequence", what, r.pos)
+	}
+
+	if !matchesDuration(r.seq[r.pos], nominal) {
+		return signalErrorf(r.pos, "invalid signal sequence. expected %v (%v) at position %v, got %v", what, nominal, r.pos, r.seq[r.pos])
+	}
+
+	r.pos++
+	return nil
+}
+
+func (r *signalReader) readHeader() error {
+	if err := r.expect(r.encoding.headerMark, "header mark"); err != nil {
+		return err
+	}
+	return r.expect(r.encoding.headerSpace, "header space")
+}
+
+func (r *signalReader) readBit() (bool, error) {
+	if err := r.expect(r.encoding.bitMark, "bit mark"); err != nil {
+		return false, err
+	}
+
+	if r.pos >= len(r.seq) {
+		return false, signalErrorf(r.pos, "invalid signal sequence. expected bit space at position %v, got end of sequence", r.pos)
+	}
+
+	space := r.seq[r.pos]
+	r.pos++
+
+	switch {
+	case matchesDuration(space, r.encoding.oneSpace):
+		return true, nil
+	case matchesDuration(space, r.encoding.zeroSpace):
+		return false, nil
+	default:
+		return false, signalErrorf(r.pos-1, "invalid signal sequence. expected short or long space at position %v, got %v", r.pos-1, space)
+	}
+}
+
+func (r *signalReader) readBits(nbits int, msbFirst bool) (uint64, error) {
+	var value uint64
+	for i := 0; i < nbits; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+
+		if !bit {
+			continue
+		}
+
+		if msbFirst {
+			value |= 1 << uint(nbits-1-i)
+		} else {
+			value |= 1 << uint(i)
+		}
+	}
+	return value, nil
+}
+
+func (r *signalReader) readBytesLSB(n int) ([]byte, error) {
+	result := make([]byte, n)
+	for i := range result {
+		b, err := r.readBits(8, false)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = byte(b)
+	}
+	return result, nil
+}
+
+// readFooter consumes the terminating bit mark and, unless the sequence ends
+// right after it, a space of at least minGap microseconds.
+func (r *signalReader) readFooter(minGap int) error {
+	if err := r.expect(r.encoding.bitMark, "footer mark"); err != nil {
+		return err
+	}
+
+	if r.pos >= len(r.seq) {
+		return nil
+	}
+
+	if r.seq[r.pos] < minGap {
+		return signalErrorf(r.pos, "invalid signal sequence. expected gap of at least %v at position %v, got %v", minGap, r.pos, r.seq[r.pos])
+	}
+
+	r.pos++
+	return nil
+}
+
+func (r *signalReader) done() error {
+	if r.pos != len(r.seq) {
+		return signalErrorf(r.pos, "invalid signal sequence. expected end of sequence at position %v, got %v more values", r.pos, len(r.seq)-r.pos)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package commands
+
+// RawCommand is a signal sequence sent as is, e.g. a capture of the original remote.
+type RawCommand struct {
+	Signal  []int
+	carrier Carrier
+}
+
+var _ Command = &RawCommand{}
+
+func NewRawCommand(signal []int) *RawCommand {
+	return &RawCommand{Signal: signal, carrier: DefaultCarrier}
+}
+
+func NewRawCommandWithCarrier(signal []int, carrier Carrier) *RawCommand {
+	return &RawCommand{Signal: signal, carrier: carrier}
+}
+
+func (r *RawCommand) ParseFromSignalSequence(signalSequence []int) error {
+	r.Signal = signalSequence
+	return nil
+}
+
+func (r *RawCommand) ToSignalSequence() []int {
+	return r.Signal
+}
+
+func (r *RawCommand) Carrier() Carrier {
+	return r.carrier
+}
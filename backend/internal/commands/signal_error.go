@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SignalError is returned by decoders when a duration of the signal does not
+// fit the protocol. Position is the index of the offending duration, it is
+// equal to the signal length when the signal ends too early.
+type SignalError struct {
+	Position int
+	Message  string
+}
+
+func (e *SignalError) Error() string {
+	return e.Message
+}
+
+func signalErrorf(position int, format string, args ...any) error {
+	return &SignalError{Position: position, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorPosition returns the position of the signal at which decoding failed,
+// if the error carries one.
+func ErrorPosition(err error) (int, bool) {
+	var signalError *SignalError
+	if errors.As(err, &signalError) {
+		return signalError.Position, true
+	}
+	return 0, false
+}
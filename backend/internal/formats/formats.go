@@ -0,0 +1,388 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Signal is a mark/space sequence in microseconds together with its carrier.
+type Signal struct {
+	Timings []int            `json:"signal"`
+	Carrier commands.Carrier `json:"carrier"`
+}
+
+const FormatJson = "json"
+const FormatPronto = "pronto"
+const FormatLirc = "lirc"
+const FormatMode2 = "mode2"
+const FormatFlipper = "flipper"
+const FormatRaw = "raw"
+
+var Formats = []string{FormatJson, FormatPronto, FormatLirc, FormatMode2, FormatFlipper, FormatRaw}
+
+// TRAILING_GAP terminates signals in formats that only store mark/space pairs
+const TRAILING_GAP = 10000
+
+// pronto frequency code is the carrier period in units of this clock, in microseconds
+const PRONTO_CLOCK = 0.241246
+
+// Detect guesses the format of the data.
+func Detect(data []byte) string {
+	text := strings.TrimSpace(string(data))
+	switch {
+	case strings.HasPrefix(text, "{"):
+		return FormatJson
+	case strings.HasPrefix(text, "Filetype:"):
+		return FormatFlipper
+	case strings.Contains(text, "begin remote"):
+		return FormatLirc
+	case strings.HasPrefix(text, "pulse") || strings.HasPrefix(text, "space") || strings.HasPrefix(text, "carrier"):
+		return FormatMode2
+	case strings.HasPrefix(text, "0000 "):
+		return FormatPronto
+	default:
+		return FormatRaw
+	}
+}
+
+// Parse reads a signal in the given format, "auto" detects it.
+func Parse(format string, data []byte) (Signal, error) {
+	if format == "auto" {
+		format = Detect(data)
+	}
+
+	switch format {
+	case FormatJson:
+		return parseJson(data)
+	case FormatPronto:
+		return parsePronto(data)
+	case FormatLirc:
+		return parseLirc(data)
+	case FormatMode2:
+		return parseMode2(data)
+	case FormatFlipper:
+		return parseFlipper(data)
+	case FormatRaw:
+		timings, err := parseInts(strings.NewReplacer(",", " ", "[", " ", "]", " ").Replace(string(data)))
+		return Signal{Timings: timings, Carrier: commands.DefaultCarrier}, err
+	default:
+		return Signal{}, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// Format writes the signal in the given format.
+func Format(format string, signal Signal) ([]byte, error) {
+	switch format {
+	case FormatJson:
+		return json.MarshalIndent(signal, "", "  ")
+	case FormatPronto:
+		return formatPronto(signal)
+	case FormatLirc:
+		return formatLirc(signal), nil
+	case FormatMode2:
+		return formatMode2(signal), nil
+	case FormatFlipper:
+		return formatFlipper(signal), nil
+	case FormatRaw:
+		return []byte(joinInts(signal.Timings) + "\n"), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func parseJson(data []byte) (Signal, error) {
+	signal := Signal{Carrier: commands.DefaultCarrier}
+	if err := json.Unmarshal(data, &signal); err != nil {
+		return Signal{}, err
+	}
+	return signal, nil
+}
+
+func parsePronto(data []byte) (Signal, error) {
+	words := strings.Fields(string(data))
+	values := make([]int, len(words))
+	for i, word := range words {
+		value, err := strconv.ParseUint(word, 16, 16)
+		if err != nil {
+			return Signal{}, fmt.Errorf("invalid pronto word %q", word)
+		}
+		values[i] = int(value)
+	}
+
+	if len(values) < 4 || values[0] != 0 || values[1] == 0 {
+		return Signal{}, fmt.Errorf("only learned pronto codes (0000) are supported")
+	}
+
+	pairs := values[2] + values[3]
+	if pairs == 0 {
+		return Signal{}, fmt.Errorf("pronto code has no burst pairs")
+	}
+	if len(values) != 4+2*pairs {
+		return Signal{}, fmt.Errorf("invalid pronto code. expected %v pairs, got %v words", pairs, len(values)-4)
+	}
+
+	period := float64(values[1]) * PRONTO_CLOCK
+	signal := Signal{
+		Carrier: commands.Carrier{
+			Frequency: int(math.Round(1000000 / period)),
+			DutyCycle: commands.DEFAULT_DUTY_CYCLE,
+		},
+	}
+	for _, cycles := range values[4:] {
+		signal.Timings = append(signal.Timings, int(math.Round(float64(cycles)*period)))
+	}
+
+	// pronto stores pairs, the last space is the gap after the signal
+	signal.Timings = signal.Timings[:len(signal.Timings)-1]
+	return signal, nil
+}
+
+func formatPronto(signal Signal) ([]byte, error) {
+	if signal.Carrier.Frequency <= 0 {
+		return nil, fmt.Errorf("invalid carrier frequency %v", signal.Carrier.Frequency)
+	}
+	code := int(math.Round(1000000 / (float64(signal.Carrier.Frequency) * PRONTO_CLOCK)))
+	if code == 0 || code > 0xFFFF {
+		return nil, fmt.Errorf("carrier frequency %v can not be stored in pronto", signal.Carrier.Frequency)
+	}
+	period := float64(code) * PRONTO_CLOCK
+
+	timings := pairs(signal.Timings)
+	words := []string{"0000", fmt.Sprintf("%04X", code), fmt.Sprintf("%04X", len(timings)/2), "0000"}
+	for _, t := range timings {
+		words = append(words, fmt.Sprintf("%04X", int(math.Round(float64(t)/period))))
+	}
+	return []byte(strings.Join(words, " ") + "\n"), nil
+}
+
+func parseLirc(data []byte) (Signal, error) {
+	signal := Signal{Carrier: commands.DefaultCarrier}
+	inRawCodes := false
+	inCode := false
+	var codes strings.Builder
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch {
+		case len(fields) >= 2 && fields[0] == "frequency":
+			frequency, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return Signal{}, fmt.Errorf("invalid lirc frequency %q", fields[1])
+			}
+			signal.Carrier.Frequency = frequency
+		case len(fields) >= 2 && fields[0] == "duty_cycle":
+			dutyCycle, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return Signal{}, fmt.Errorf("invalid lirc duty cycle %q", fields[1])
+			}
+			signal.Carrier.DutyCycle = dutyCycle
+		case len(fields) >= 2 && fields[0] == "begin" && fields[1] == "raw_codes":
+			inRawCodes = true
+		case len(fields) >= 2 && fields[0] == "end" && fields[1] == "raw_codes":
+			inRawCodes = false
+		case inRawCodes && fields[0] == "name":
+			// only the first code of the file is read
+			if inCode {
+				inRawCodes = false
+			}
+			inCode = true
+		case inRawCodes && inCode:
+			codes.WriteString(strings.Join(fields, " ") + " ")
+		}
+	}
+
+	timings, err := parseInts(codes.String())
+	if err != nil {
+		return Signal{}, err
+	}
+	if len(timings) == 0 {
+		return Signal{}, fmt.Errorf("lirc file does not contain raw codes")
+	}
+	signal.Timings = timings
+	return signal, nil
+}
+
+func formatLirc(signal Signal) []byte {
+	var out strings.Builder
+	out.WriteString("begin remote\n")
+	out.WriteString("  name  irctl\n")
+	out.WriteString("  flags RAW_CODES\n")
+	out.WriteString("  eps   30\n")
+	out.WriteString("  aeps  100\n")
+	out.WriteString(fmt.Sprintf("  gap   %d\n", TRAILING_GAP))
+	out.WriteString(fmt.Sprintf("  frequency %d\n", signal.Carrier.Frequency))
+	out.WriteString(fmt.Sprintf("  duty_cycle %d\n", signal.Carrier.DutyCycle))
+	out.WriteString("  begin raw_codes\n")
+	out.WriteString("    name command\n")
+	for i := 0; i < len(signal.Timings); i += 6 {
+		end := i + 6
+		if end > len(signal.Timings) {
+			end = len(signal.Timings)
+		}
+		out.WriteString("      " + joinInts(signal.Timings[i:end]) + "\n")
+	}
+	out.WriteString("  end raw_codes\n")
+	out.WriteString("end remote\n")
+	return []byte(out.String())
+}
+
+// parseMode2 reads the output of the LIRC mode2 tool: "pulse N" and "space N" lines.
+func parseMode2(data []byte) (Signal, error) {
+	signal := Signal{Carrier: commands.DefaultCarrier}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return Signal{}, fmt.Errorf("invalid mode2 line %q", scanner.Text())
+		}
+
+		switch fields[0] {
+		case "pulse":
+			if len(signal.Timings)%2 == 1 {
+				// two pulses in a row, merge them
+				signal.Timings[len(signal.Timings)-1] += value
+			} else {
+				signal.Timings = append(signal.Timings, value)
+			}
+		case "space":
+			if len(signal.Timings) == 0 {
+				// leading space is the silence before the signal
+				continue
+			}
+			if len(signal.Timings)%2 == 0 {
+				signal.Timings[len(signal.Timings)-1] += value
+			} else {
+				signal.Timings = append(signal.Timings, value)
+			}
+		case "carrier":
+			signal.Carrier.Frequency = value
+		}
+	}
+
+	if len(signal.Timings)%2 == 0 && len(signal.Timings) > 0 {
+		signal.Timings = signal.Timings[:len(signal.Timings)-1]
+	}
+	return signal, nil
+}
+
+func formatMode2(signal Signal) []byte {
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("carrier %d\n", signal.Carrier.Frequency))
+	for i, t := range signal.Timings {
+		if i%2 == 0 {
+			out.WriteString(fmt.Sprintf("pulse %d\n", t))
+		} else {
+			out.WriteString(fmt.Sprintf("space %d\n", t))
+		}
+	}
+	return []byte(out.String())
+}
+
+// parseFlipper reads the first raw signal of a Flipper Zero .ir file.
+func parseFlipper(data []byte) (Signal, error) {
+	signal := Signal{Carrier: commands.DefaultCarrier}
+	found := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "name":
+			if found {
+				return signal, nil
+			}
+		case "type":
+			if value != "raw" {
+				return Signal{}, fmt.Errorf("only raw flipper signals are supported, got %q", value)
+			}
+		case "frequency":
+			frequency, err := strconv.Atoi(value)
+			if err != nil {
+				return Signal{}, fmt.Errorf("invalid flipper frequency %q", value)
+			}
+			signal.Carrier.Frequency = frequency
+		case "duty_cycle":
+			dutyCycle, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return Signal{}, fmt.Errorf("invalid flipper duty cycle %q", value)
+			}
+			signal.Carrier.DutyCycle = int(math.Round(dutyCycle * 100))
+		case "data":
+			timings, err := parseInts(value)
+			if err != nil {
+				return Signal{}, err
+			}
+			signal.Timings = timings
+			found = true
+		}
+	}
+
+	if !found {
+		return Signal{}, fmt.Errorf("flipper file does not contain raw data")
+	}
+	return signal, nil
+}
+
+func formatFlipper(signal Signal) []byte {
+	var out strings.Builder
+	out.WriteString("Filetype: IR signals file\n")
+	out.WriteString("Version: 1\n")
+	out.WriteString("# \n")
+	out.WriteString("name: command\n")
+	out.WriteString("type: raw\n")
+	out.WriteString(fmt.Sprintf("frequency: %d\n", signal.Carrier.Frequency))
+	out.WriteString(fmt.Sprintf("duty_cycle: %.6f\n", float64(signal.Carrier.DutyCycle)/100))
+	out.WriteString("data: " + joinInts(signal.Timings) + "\n")
+	return []byte(out.String())
+}
+
+func pairs(timings []int) []int {
+	if len(timings)%2 == 0 {
+		return timings
+	}
+	return append(append([]int(nil), timings...), TRAILING_GAP)
+}
+
+func parseInts(text string) ([]int, error) {
+	fields := strings.Fields(text)
+	result := make([]int, 0, len(fields))
+	for _, field := range fields {
+		value, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timing %q", field)
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+func joinInts(values []int) string {
+	words := make([]string, len(values))
+	for i, value := range values {
+		words[i] = strconv.Itoa(value)
+	}
+	return strings.Join(words, " ")
+}
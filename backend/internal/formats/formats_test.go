@@ -0,0 +1,57 @@
+package formats
+
+import (
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var testSignal = Signal{
+	Timings: []int{9000, 4500, 560, 1690, 560, 560, 560, 1690, 560},
+	Carrier: commands.Carrier{Frequency: 38000, DutyCycle: 33},
+}
+
+func TestFormats_RoundTrip(t *testing.T) {
+	for _, format := range []string{FormatJson, FormatLirc, FormatMode2, FormatFlipper} {
+		data, err := Format(format, testSignal)
+		require.NoError(t, err, format)
+		assert.Equal(t, format, Detect(data), format)
+
+		signal, err := Parse("auto", data)
+		require.NoError(t, err, format)
+		assert.Equal(t, testSignal.Timings, signal.Timings, format)
+		assert.Equal(t, testSignal.Carrier.Frequency, signal.Carrier.Frequency, format)
+	}
+}
+
+func TestFormats_Pronto(t *testing.T) {
+	data, err := Format(FormatPronto, testSignal)
+	require.NoError(t, err)
+	assert.Equal(t, "0000 006D 0005 0000 0156 00AB 0015 0040 0015 0015 0015 0040 0015 017C\n", string(data))
+
+	signal, err := Parse(FormatPronto, data)
+	require.NoError(t, err)
+	assert.Len(t, signal.Timings, len(testSignal.Timings))
+	assert.InDelta(t, 38000, signal.Carrier.Frequency, 100)
+	for i := range signal.Timings {
+		// pronto stores timings in carrier periods, about 26µs at 38kHz
+		assert.InDelta(t, testSignal.Timings[i], signal.Timings[i], 14)
+	}
+
+	_, err = Parse(FormatPronto, []byte("0000 006D 0000 0000"))
+	assert.Error(t, err)
+
+	_, err = Format(FormatPronto, Signal{Timings: testSignal.Timings})
+	assert.Error(t, err)
+}
+
+func TestFormats_Raw(t *testing.T) {
+	signal, err := Parse("auto", []byte("[9000, 4500, 560]"))
+	require.NoError(t, err)
+	assert.Equal(t, []int{9000, 4500, 560}, signal.Timings)
+	assert.Equal(t, commands.DefaultCarrier, signal.Carrier)
+
+	_, err = Parse(FormatRaw, []byte("9000 abc"))
+	assert.Error(t, err)
+}
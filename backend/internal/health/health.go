@@ -0,0 +1,167 @@
+package health
+
+import (
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"log"
+	"sync"
+	"time"
+)
+
+// RebootLoopCount reboots within RebootLoopWindow are reported as a reboot loop
+const RebootLoopCount = 3
+const RebootLoopWindow = 15 * time.Minute
+
+// AlertQueueSize alerts wait to be sent, later ones are dropped
+const AlertQueueSize = 10
+
+// firmware counting the uptime with the 32 bit millis() wraps to 0 after
+// millisWrap, about 49.7 days, which is not a reboot
+const millisWrap = (1 << 32) * time.Millisecond
+const millisWrapSlack = time.Minute
+
+// Status summarises the reboots of a remote.
+type Status struct {
+	Reboots         int        `json:"reboots"`
+	LastReboot      *time.Time `json:"last_reboot,omitempty"`
+	LastResetReason string     `json:"last_reset_reason,omitempty"`
+	RebootLoop      bool       `json:"reboot_loop"`
+}
+
+// Monitor detects reboots of a remote from the statuses it reports: the uptime
+// going back, or for old firmware the command sequence number starting over.
+type Monitor struct {
+	mx          sync.Mutex
+	seen        bool
+	last        irremote.Status
+	lastAt      time.Time
+	reboots     []time.Time
+	total       int
+	lastReboot  time.Time
+	resetReason string
+	looping     bool
+	alerts      []func(message string)
+	pending     chan string
+}
+
+func NewMonitor() *Monitor {
+	m := &Monitor{pending: make(chan string, AlertQueueSize)}
+	go m.sendAlerts()
+	return m
+}
+
+// Watch checks every status the session receives.
+func (m *Monitor) Watch(session *irremote.Session) {
+	session.AddStatusListener(m.Observe)
+}
+
+// OnAlert registers a function called when a reboot loop starts. It is called
+// from a goroutine of the monitor, so a slow alert does not hold up the session.
+func (m *Monitor) OnAlert(alert func(message string)) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.alerts = append(m.alerts, alert)
+}
+
+func (m *Monitor) Observe(status irremote.Status, at time.Time) {
+	var message string
+
+	func() {
+		m.mx.Lock()
+		defer m.mx.Unlock()
+
+		rebooted, rebootedAt := isReboot(m.last, m.lastAt, status, at)
+		rebooted = rebooted && m.seen
+		m.seen = true
+		m.last = status
+		m.lastAt = at
+		if status.ResetReason != "" {
+			m.resetReason = status.ResetReason
+		}
+		m.forgetOld(at)
+		if !rebooted {
+			return
+		}
+
+		m.total++
+		m.reboots = append(m.reboots, rebootedAt)
+		m.lastReboot = rebootedAt
+
+		if len(m.reboots) >= RebootLoopCount && !m.looping {
+			m.looping = true
+			message = fmt.Sprintf("remote rebooted %v times in %v", len(m.reboots), RebootLoopWindow)
+			if status.ResetReason != "" {
+				message += ", last reset reason: " + status.ResetReason
+			}
+		}
+	}()
+
+	if message == "" {
+		return
+	}
+	select {
+	case m.pending <- message:
+	default:
+		log.Println("Dropping alert, too many are being sent:", message)
+	}
+}
+
+func (m *Monitor) sendAlerts() {
+	for message := range m.pending {
+		m.mx.Lock()
+		alerts := append(([]func(message string))(nil), m.alerts...)
+		m.mx.Unlock()
+
+		for _, alert := range alerts {
+			alert(message)
+		}
+	}
+}
+
+// isReboot tells whether the remote rebooted between the statuses, and when
+func isReboot(previous irremote.Status, previousAt time.Time, current irremote.Status, at time.Time) (bool, time.Time) {
+	if previous.UptimeSeconds != nil && current.UptimeSeconds != nil {
+		if *current.UptimeSeconds >= *previous.UptimeSeconds {
+			return false, time.Time{}
+		}
+		uptime := time.Duration(*previous.UptimeSeconds)*time.Second + at.Sub(previousAt)
+		// how far the uptime is from the one expected had millis() wrapped
+		off := uptime - millisWrap - time.Duration(*current.UptimeSeconds)*time.Second
+		if time.Duration(*previous.UptimeSeconds)*time.Second <= millisWrap && off >= -millisWrapSlack && off <= millisWrapSlack {
+			return false, time.Time{}
+		}
+		return true, at.Add(-time.Duration(*current.UptimeSeconds) * time.Second)
+	}
+	// old firmware forgets the commands it has executed on reboot
+	return current.LastCommandSequenceNumber < previous.LastCommandSequenceNumber, at
+}
+
+func (m *Monitor) forgetOld(now time.Time) {
+	recent := m.reboots[:0]
+	for _, reboot := range m.reboots {
+		if now.Sub(reboot) <= RebootLoopWindow {
+			recent = append(recent, reboot)
+		}
+	}
+	m.reboots = recent
+	if len(m.reboots) < RebootLoopCount {
+		m.looping = false
+	}
+}
+
+func (m *Monitor) Status(now time.Time) Status {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.forgetOld(now)
+	result := Status{
+		Reboots:         m.total,
+		LastResetReason: m.resetReason,
+		RebootLoop:      m.looping,
+	}
+	if !m.lastReboot.IsZero() {
+		lastReboot := m.lastReboot
+		result.LastReboot = &lastReboot
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package health
+
+import (
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+func uptime(seconds int64) irremote.Status {
+	return irremote.Status{UptimeSeconds: &seconds, ResetReason: "Exception"}
+}
+
+func TestMonitor_RebootLoop(t *testing.T) {
+	monitor := NewMonitor()
+	alerts := make(chan string, 10)
+	monitor.OnAlert(func(message string) {
+		alerts <- message
+	})
+
+	now := start
+	monitor.Observe(uptime(3600), now)
+	require.Equal(t, 0, monitor.Status(now).Reboots)
+
+	for i := 0; i < 3; i++ {
+		now = now.Add(10 * time.Second)
+		monitor.Observe(uptime(5), now)
+		now = now.Add(2 * time.Minute)
+		monitor.Observe(uptime(125), now)
+	}
+
+	status := monitor.Status(now)
+	require.Equal(t, 3, status.Reboots)
+	require.True(t, status.RebootLoop)
+	require.Equal(t, "Exception", status.LastResetReason)
+	require.Equal(t, now.Add(-125*time.Second), *status.LastReboot)
+	require.Equal(t, "remote rebooted 3 times in 15m0s, last reset reason: Exception", <-alerts)
+
+	// a single alert per loop
+	monitor.Observe(uptime(1), now.Add(time.Second))
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, alerts)
+
+	now = now.Add(time.Hour)
+	status = monitor.Status(now)
+	require.False(t, status.RebootLoop)
+	require.Equal(t, 4, status.Reboots)
+}
+
+func TestMonitor_OldFirmware(t *testing.T) {
+	monitor := NewMonitor()
+
+	monitor.Observe(irremote.Status{LastCommandSequenceNumber: 5}, start)
+	monitor.Observe(irremote.Status{LastCommandSequenceNumber: 7}, start.Add(time.Minute))
+	require.Equal(t, 0, monitor.Status(start).Reboots)
+
+	monitor.Observe(irremote.Status{}, start.Add(2*time.Minute))
+	status := monitor.Status(start.Add(2 * time.Minute))
+	require.Equal(t, 1, status.Reboots)
+	require.False(t, status.RebootLoop)
+	require.Equal(t, "", status.LastResetReason)
+}
+
+func TestMonitor_SlowAlert(t *testing.T) {
+	monitor := NewMonitor()
+	sending := make(chan struct{})
+	monitor.OnAlert(func(string) {
+		<-sending
+	})
+
+	// the statuses are handled while the alert is being sent
+	now := start
+	monitor.Observe(uptime(3600), now)
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		monitor.Observe(uptime(5), now)
+		now = now.Add(time.Minute)
+		monitor.Observe(uptime(65), now)
+	}
+	require.True(t, monitor.Status(now).RebootLoop)
+	close(sending)
+}
+
+func TestMonitor_MillisWrap(t *testing.T) {
+	// millis() wraps after 4294967.296 seconds
+	monitor := NewMonitor()
+	monitor.Observe(uptime(4294965), start)
+	monitor.Observe(uptime(3), start.Add(5*time.Second))
+	require.Equal(t, 0, monitor.Status(start).Reboots)
+
+	// a reboot near the wrap seen much later
+	monitor.Observe(uptime(4294965), start.Add(10*time.Second))
+	monitor.Observe(uptime(3), start.Add(time.Hour))
+	require.Equal(t, 1, monitor.Status(start).Reboots)
+
+	// firmware reporting a 64 bit uptime goes on past it
+	monitor = NewMonitor()
+	monitor.Observe(uptime(4294965), start)
+	monitor.Observe(uptime(4294970), start.Add(5*time.Second))
+	monitor.Observe(uptime(3), start.Add(10*time.Second))
+	require.Equal(t, 1, monitor.Status(start).Reboots)
+}
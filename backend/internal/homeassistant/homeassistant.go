@@ -0,0 +1,410 @@
+// Package homeassistant exposes the ACs to Home Assistant over MQTT as
+// climate entities found by MQTT discovery.
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultDiscoveryPrefix = "homeassistant"
+
+// TopicPrefix is the root of the state and command topics of the devices
+const TopicPrefix = "ir-remote"
+
+const PayloadOnline = "online"
+const PayloadOffline = "offline"
+
+const MinTemperature = 16
+const MaxTemperature = 30
+
+// defaultTemperature is set when the AC is switched on with an unknown temperature
+const defaultTemperature = 24
+
+const publishInterval = 10 * time.Second
+const commandTimeout = 30 * time.Second
+
+// commandQueueSize commands of a device wait to be set, later ones are dropped
+const commandQueueSize = 16
+
+// Remote is the remote of a device, irremote.Session implements it.
+type Remote interface {
+	IsOnline() bool
+	LastStatus() (irremote.Status, time.Time)
+	AddCommandListener(listener irremote.CommandListener)
+}
+
+// Device is an AC exposed as a climate entity.
+type Device struct {
+	Remote Remote
+	State  *acstate.Device
+}
+
+// Bridge publishes the discovery config, availability and state of the
+// devices and sets the states Home Assistant commands.
+type Bridge struct {
+	client          mqtt.Client
+	discoveryPrefix string
+	devices         map[string]Device
+
+	// queues hold the commands of each device, set one at a time in order
+	queues map[string]chan command
+
+	mx        sync.Mutex
+	published map[string]string
+}
+
+// command is a message of Home Assistant to a command topic
+type command struct {
+	topic     string
+	attribute string
+	payload   string
+}
+
+// NewBridge creates the MQTT client from options. Its will marks every device
+// unavailable when the backend loses the broker.
+func NewBridge(options *mqtt.ClientOptions, discoveryPrefix string, devices map[string]Device) *Bridge {
+	b := &Bridge{
+		discoveryPrefix: discoveryPrefix,
+		devices:         devices,
+		queues:          make(map[string]chan command, len(devices)),
+		published:       make(map[string]string),
+	}
+
+	options.SetWill(bridgeAvailabilityTopic(), PayloadOffline, 1, true)
+	options.SetOnConnectHandler(b.onConnect)
+	options.SetAutoReconnect(true)
+	b.client = mqtt.NewClient(options)
+
+	for id, device := range devices {
+		id := id
+		b.queues[id] = make(chan command, commandQueueSize)
+		device.Remote.AddCommandListener(func(_ commands.Command, _ error) {
+			b.publishState(id)
+		})
+	}
+	return b
+}
+
+// Run keeps the published state up to date until ctx is done.
+func (b *Bridge) Run(ctx context.Context) error {
+	token := b.client.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	// the commands received meanwhile wait in the queues
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for id := range b.devices {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			b.handleCommands(ctx, id)
+		}(id)
+	}
+
+	ticker := time.NewTicker(publishInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.publish(bridgeAvailabilityTopic(), PayloadOffline)
+			b.client.Disconnect(1000)
+			return nil
+		case <-ticker.C:
+			for _, id := range b.deviceIds() {
+				b.publishState(id)
+			}
+		}
+	}
+}
+
+// onConnect publishes everything again, the broker may have lost it
+func (b *Bridge) onConnect(client mqtt.Client) {
+	b.mx.Lock()
+	b.published = make(map[string]string)
+	b.mx.Unlock()
+
+	client.Subscribe(TopicPrefix+"/+/+/set", 1, b.onCommand)
+	for _, id := range b.deviceIds() {
+		config, err := json.Marshal(discoveryConfig(id, b.devices[id]))
+		if err != nil {
+			log.Println("failed to encode discovery config", err)
+			continue
+		}
+		b.publish(b.discoveryTopic(id), string(config))
+		b.publishState(id)
+	}
+	b.publish(bridgeAvailabilityTopic(), PayloadOnline)
+}
+
+func (b *Bridge) deviceIds() []string {
+	ids := make([]string, 0, len(b.devices))
+	for id := range b.devices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// publishState publishes the topics of the device that changed
+func (b *Bridge) publishState(id string) {
+	device := b.devices[id]
+	availability := PayloadOffline
+	if device.Remote.IsOnline() {
+		availability = PayloadOnline
+	}
+	b.publish(deviceTopic(id, "availability"), availability)
+
+	believed := device.State.State()
+	if believed.UpdatedAt != nil {
+		b.publish(deviceTopic(id, "mode"), modeName(believed.State))
+		b.publish(deviceTopic(id, "temperature"), strconv.Itoa(believed.State.Temperature))
+		b.publish(deviceTopic(id, "fan"), believed.State.Fan.String())
+	}
+
+	if status, _ := device.Remote.LastStatus(); status.Temperature != nil {
+		b.publish(deviceTopic(id, "current_temperature"), strconv.FormatFloat(*status.Temperature, 'f', 1, 64))
+	}
+}
+
+// publish sends a retained message unless the topic has the payload already
+func (b *Bridge) publish(topic string, payload string) {
+	b.mx.Lock()
+	if b.published[topic] == payload {
+		b.mx.Unlock()
+		return
+	}
+	b.published[topic] = payload
+	b.mx.Unlock()
+
+	token := b.client.Publish(topic, 1, true, payload)
+	go func() {
+		if token.WaitTimeout(commandTimeout) && token.Error() != nil {
+			log.Println("failed to publish", topic, token.Error())
+			b.mx.Lock()
+			delete(b.published, topic)
+			b.mx.Unlock()
+		}
+	}()
+}
+
+func (b *Bridge) onCommand(_ mqtt.Client, message mqtt.Message) {
+	parts := strings.Split(message.Topic(), "/")
+	id, attribute := parts[1], parts[2]
+	queue, ok := b.queues[id]
+	if !ok {
+		log.Println("command for unknown device", id)
+		return
+	}
+
+	// commands take seconds to be acknowledged, the client must keep reading
+	select {
+	case queue <- command{topic: message.Topic(), attribute: attribute, payload: string(message.Payload())}:
+	default:
+		log.Println("too many commands for device", id, "dropping", message.Topic())
+	}
+}
+
+// handleCommands sets the commands of the device one at a time until ctx is
+// done. Home Assistant publishes several commands for one change, like the
+// mode and then the temperature, so a command changes the state the previous
+// one set even if it is not acknowledged yet.
+func (b *Bridge) handleCommands(ctx context.Context, id string) {
+	queue := b.queues[id]
+	var desired *commands.AcState
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-queue:
+			state, err := b.handleCommand(ctx, id, desired, cmd.attribute, cmd.payload)
+			if err != nil {
+				log.Println("failed to handle", cmd.topic, err)
+				desired = nil
+			} else {
+				desired = &state
+			}
+			// the believed state changes by other means too once the commands are done
+			if len(queue) == 0 {
+				desired = nil
+			}
+			b.publishState(id)
+		}
+	}
+}
+
+// handleCommand changes the desired state, the believed one if nil, of the
+// device by the command and sets it
+func (b *Bridge) handleCommand(ctx context.Context, id string, desired *commands.AcState, attribute string, payload string) (commands.AcState, error) {
+	device := b.devices[id]
+	current := device.State.State().State
+	if desired != nil {
+		current = *desired
+	}
+	state, err := applyCommand(current, attribute, payload)
+	if err != nil {
+		return state, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	_, err = device.State.SetDesiredState(ctx, state, false)
+	return state, err
+}
+
+func applyCommand(state commands.AcState, attribute string, payload string) (commands.AcState, error) {
+	switch attribute {
+	case "mode":
+		if payload == "off" {
+			state.Power = false
+			return state, nil
+		}
+		mode, err := parseMode(payload)
+		if err != nil {
+			return state, err
+		}
+		state.Power = true
+		state.Mode = mode
+
+	case "temperature":
+		temperature, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return state, err
+		}
+		state.Temperature = int(math.Round(temperature))
+
+	case "fan":
+		fan, err := commands.ParseAcFan(payload)
+		if err != nil {
+			return state, err
+		}
+		state.Fan = fan
+
+	default:
+		return state, fmt.Errorf("unknown attribute %q", attribute)
+	}
+
+	if state.Power && state.Temperature == 0 {
+		state.Temperature = defaultTemperature
+	}
+	return state, nil
+}
+
+// modeName is the Home Assistant HVAC mode of the state
+func modeName(state commands.AcState) string {
+	switch {
+	case !state.Power:
+		return "off"
+	case state.Mode == commands.AcModeFan:
+		return "fan_only"
+	default:
+		return state.Mode.String()
+	}
+}
+
+func parseMode(name string) (commands.AcMode, error) {
+	if name == "fan_only" {
+		return commands.AcModeFan, nil
+	}
+	return commands.ParseAcMode(name)
+}
+
+// DiscoveryConfig is the config of an MQTT climate entity.
+type DiscoveryConfig struct {
+	Name                    string          `json:"name"`
+	UniqueId                string          `json:"unique_id"`
+	Availability            []Availability  `json:"availability"`
+	AvailabilityMode        string          `json:"availability_mode"`
+	Modes                   []string        `json:"modes"`
+	ModeCommandTopic        string          `json:"mode_command_topic"`
+	ModeStateTopic          string          `json:"mode_state_topic"`
+	TemperatureCommandTopic string          `json:"temperature_command_topic"`
+	TemperatureStateTopic   string          `json:"temperature_state_topic"`
+	FanModes                []string        `json:"fan_modes"`
+	FanModeCommandTopic     string          `json:"fan_mode_command_topic"`
+	FanModeStateTopic       string          `json:"fan_mode_state_topic"`
+	CurrentTemperatureTopic string          `json:"current_temperature_topic"`
+	MinTemp                 int             `json:"min_temp"`
+	MaxTemp                 int             `json:"max_temp"`
+	TempStep                float64         `json:"temp_step"`
+	TemperatureUnit         string          `json:"temperature_unit"`
+	Device                  DiscoveryDevice `json:"device"`
+}
+
+type Availability struct {
+	Topic string `json:"topic"`
+}
+
+type DiscoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	SwVersion    string   `json:"sw_version,omitempty"`
+}
+
+func discoveryConfig(id string, device Device) DiscoveryConfig {
+	status, _ := device.Remote.LastStatus()
+	return DiscoveryConfig{
+		Name:     "AC " + id,
+		UniqueId: objectId(id),
+		Availability: []Availability{
+			{Topic: bridgeAvailabilityTopic()},
+			{Topic: deviceTopic(id, "availability")},
+		},
+		AvailabilityMode:        "all",
+		Modes:                   []string{"off", "auto", "cool", "dry", "fan_only", "heat"},
+		ModeCommandTopic:        deviceTopic(id, "mode/set"),
+		ModeStateTopic:          deviceTopic(id, "mode"),
+		TemperatureCommandTopic: deviceTopic(id, "temperature/set"),
+		TemperatureStateTopic:   deviceTopic(id, "temperature"),
+		FanModes:                []string{"auto", "low", "medium", "high"},
+		FanModeCommandTopic:     deviceTopic(id, "fan/set"),
+		FanModeStateTopic:       deviceTopic(id, "fan"),
+		CurrentTemperatureTopic: deviceTopic(id, "current_temperature"),
+		MinTemp:                 MinTemperature,
+		MaxTemp:                 MaxTemperature,
+		TempStep:                1,
+		TemperatureUnit:         "C",
+		Device: DiscoveryDevice{
+			Identifiers:  []string{objectId(id)},
+			Name:         "IR remote " + id,
+			Manufacturer: "ir-remote",
+			SwVersion:    status.FirmwareVersion,
+		},
+	}
+}
+
+var invalidObjectId = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// objectId is the id of the device in Home Assistant
+func objectId(id string) string {
+	return "ir_remote_" + invalidObjectId.ReplaceAllString(id, "_")
+}
+
+func (b *Bridge) discoveryTopic(id string) string {
+	return b.discoveryPrefix + "/climate/" + objectId(id) + "/config"
+}
+
+func deviceTopic(id string, name string) string {
+	return TopicPrefix + "/" + id + "/" + name
+}
+
+func bridgeAvailabilityTopic() string {
+	return TopicPrefix + "/bridge/availability"
+}
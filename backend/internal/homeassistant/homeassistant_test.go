@@ -0,0 +1,189 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/Light-Keeper/ir-remote/internal/mqttbroker"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRemote acknowledges every command, after delay
+type fakeRemote struct {
+	mx        sync.Mutex
+	delay     time.Duration
+	online    bool
+	status    irremote.Status
+	sent      []commands.Command
+	listeners []irremote.CommandListener
+}
+
+func (f *fakeRemote) SendCommand(_ context.Context, command commands.Command) error {
+	f.mx.Lock()
+	f.sent = append(f.sent, command)
+	listeners, delay := f.listeners, f.delay
+	f.mx.Unlock()
+	time.Sleep(delay)
+	for _, listener := range listeners {
+		listener(command, nil)
+	}
+	return nil
+}
+
+func (f *fakeRemote) AddCommandListener(listener irremote.CommandListener) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.listeners = append(f.listeners, listener)
+}
+
+func (f *fakeRemote) IsOnline() bool {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.online
+}
+
+func (f *fakeRemote) LastStatus() (irremote.Status, time.Time) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.status, time.Now()
+}
+
+func (f *fakeRemote) sentCount() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return len(f.sent)
+}
+
+func startBridge(t *testing.T) (*Bridge, *mqttbroker.Broker, *fakeRemote, *acstate.Device) {
+	broker, err := mqttbroker.Start()
+	require.NoError(t, err)
+	t.Cleanup(broker.Close)
+
+	temperature := 26.5
+	remote := &fakeRemote{online: true, status: irremote.Status{FirmwareVersion: "1.4.0", Temperature: &temperature}}
+	device := acstate.NewTracker(acstate.DefaultStaleAfter).Device("default")
+	encoder, err := acstate.ProtocolEncoder("gree")
+	require.NoError(t, err)
+	device.Control(remote, encoder)
+
+	options := mqtt.NewClientOptions().AddBroker(broker.Url()).SetClientID("backend")
+	bridge := NewBridge(options, DefaultDiscoveryPrefix, map[string]Device{
+		"default": {Remote: remote, State: device},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- bridge.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+	return bridge, broker, remote, device
+}
+
+func requireRetained(t *testing.T, broker *mqttbroker.Broker, topic string, expected string) {
+	require.Eventually(t, func() bool {
+		payload, ok := broker.Retained(topic)
+		return ok && string(payload) == expected
+	}, 2*time.Second, 10*time.Millisecond, "%v should be %q", topic, expected)
+}
+
+func TestBridge_Discovery(t *testing.T) {
+	_, broker, _, _ := startBridge(t)
+
+	requireRetained(t, broker, "ir-remote/bridge/availability", PayloadOnline)
+	requireRetained(t, broker, "ir-remote/default/availability", PayloadOnline)
+	requireRetained(t, broker, "ir-remote/default/current_temperature", "26.5")
+
+	payload, ok := broker.Retained("homeassistant/climate/ir_remote_default/config")
+	require.True(t, ok)
+	config := DiscoveryConfig{}
+	require.NoError(t, json.Unmarshal(payload, &config))
+	require.Equal(t, "ir_remote_default", config.UniqueId)
+	require.Equal(t, "ir-remote/default/mode/set", config.ModeCommandTopic)
+	require.Equal(t, "ir-remote/default/temperature", config.TemperatureStateTopic)
+	require.Equal(t, "1.4.0", config.Device.SwVersion)
+	require.Len(t, config.Availability, 2)
+}
+
+func TestBridge_Commands(t *testing.T) {
+	bridge, broker, remote, device := startBridge(t)
+	requireRetained(t, broker, "ir-remote/bridge/availability", PayloadOnline)
+
+	broker.Publish("ir-remote/default/mode/set", []byte("cool"), false)
+	requireRetained(t, broker, "ir-remote/default/mode", "cool")
+	requireRetained(t, broker, "ir-remote/default/temperature", "24")
+	require.Equal(t, 1, remote.sentCount())
+
+	broker.Publish("ir-remote/default/temperature/set", []byte("21.6"), false)
+	requireRetained(t, broker, "ir-remote/default/temperature", "22")
+
+	broker.Publish("ir-remote/default/fan/set", []byte("high"), false)
+	requireRetained(t, broker, "ir-remote/default/fan", "high")
+
+	broker.Publish("ir-remote/default/mode/set", []byte("off"), false)
+	requireRetained(t, broker, "ir-remote/default/mode", "off")
+	require.Equal(t, commands.AcState{Mode: commands.AcModeCool, Temperature: 22, Fan: commands.AcFanHigh}, device.State().State)
+	require.Equal(t, 4, remote.sentCount())
+
+	// invalid commands are ignored
+	broker.Publish("ir-remote/default/mode/set", []byte("turbo"), false)
+	broker.Publish("ir-remote/unknown/mode/set", []byte("cool"), false)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 4, remote.sentCount())
+
+	remote.mx.Lock()
+	remote.online = false
+	remote.mx.Unlock()
+	bridge.publishState("default")
+	requireRetained(t, broker, "ir-remote/default/availability", PayloadOffline)
+}
+
+func TestBridge_CommandsBackToBack(t *testing.T) {
+	_, broker, remote, device := startBridge(t)
+	requireRetained(t, broker, "ir-remote/bridge/availability", PayloadOnline)
+	remote.mx.Lock()
+	remote.delay = 100 * time.Millisecond
+	remote.mx.Unlock()
+
+	// climate.set_temperature with hvac_mode, the mode is not acknowledged yet
+	broker.Publish("ir-remote/default/mode/set", []byte("heat"), false)
+	broker.Publish("ir-remote/default/temperature/set", []byte("26"), false)
+	require.Eventually(t, func() bool { return remote.sentCount() == 2 }, 2*time.Second, 10*time.Millisecond)
+	requireRetained(t, broker, "ir-remote/default/mode", "heat")
+	requireRetained(t, broker, "ir-remote/default/temperature", "26")
+	require.Equal(t, commands.AcState{Power: true, Mode: commands.AcModeHeat, Temperature: 26}, device.State().State)
+}
+
+func TestBridge_LostConnection(t *testing.T) {
+	_, broker, _, _ := startBridge(t)
+	requireRetained(t, broker, "ir-remote/bridge/availability", PayloadOnline)
+
+	// the will marks the devices unavailable, the client reconnects and publishes again
+	broker.Disconnect()
+	require.Eventually(t, func() bool {
+		return len(broker.Messages("ir-remote/bridge/availability")) == 3
+	}, 10*time.Second, 50*time.Millisecond)
+	payloads := broker.Messages("ir-remote/bridge/availability")
+	require.Equal(t, PayloadOffline, string(payloads[1]))
+	require.Equal(t, PayloadOnline, string(payloads[2]))
+}
+
+func TestApplyCommand(t *testing.T) {
+	state, err := applyCommand(commands.AcState{}, "mode", "fan_only")
+	require.NoError(t, err)
+	require.Equal(t, commands.AcState{Power: true, Mode: commands.AcModeFan, Temperature: 24}, state)
+
+	_, err = applyCommand(state, "swing", "on")
+	require.Error(t, err)
+	require.Equal(t, "fan_only", modeName(state))
+	require.Equal(t, "off", modeName(commands.AcState{Mode: commands.AcModeFan}))
+}
@@ -0,0 +1,195 @@
+package irremote
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Messages that do not fit into a single packet are split into fragments.
+// Every fragment starts with a header:
+//
+//	[4]byte  FragmentMagic
+//	uint32   message id
+//	uint8    fragment index
+//	uint8    fragment count
+//	uint16   offset of the payload in the message
+//	uint16   total message length
+//
+// All integers are big endian. A message is acknowledged as a whole by the
+// status of the remote, so on timeout all fragments are sent again.
+const FragmentMagic = "IRFR"
+const FragmentHeaderSize = len(FragmentMagic) + 4 + 1 + 1 + 2 + 2
+const FragmentPayloadSize = 1024
+const MaxFragments = 32
+const FragmentReassemblyTimeout = 5 * time.Second
+
+// fragments come from anyone who can send a packet, so the messages being
+// reassembled are limited in number and size until they time out
+const MaxPartialMessages = 16
+const MaxPartialBytes = 128 * 1024
+
+type fragmentHeader struct {
+	messageId uint32
+	index     int
+	count     int
+	offset    int
+	total     int
+}
+
+func isFragment(data []byte) bool {
+	return len(data) >= len(FragmentMagic) && bytes.Equal(data[:len(FragmentMagic)], []byte(FragmentMagic))
+}
+
+// splitIntoFragments splits the message into fragments of at most
+// payloadSize bytes of payload each.
+func splitIntoFragments(messageId uint32, message []byte, payloadSize int) ([][]byte, error) {
+	count := (len(message) + payloadSize - 1) / payloadSize
+	if count > MaxFragments || len(message) > 0xFFFF {
+		return nil, fmt.Errorf("message of %v bytes is too large to be fragmented", len(message))
+	}
+
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		offset := i * payloadSize
+		end := offset + payloadSize
+		if end > len(message) {
+			end = len(message)
+		}
+
+		fragment := make([]byte, FragmentHeaderSize, FragmentHeaderSize+end-offset)
+		copy(fragment, FragmentMagic)
+		binary.BigEndian.PutUint32(fragment[4:], messageId)
+		fragment[8] = byte(i)
+		fragment[9] = byte(count)
+		binary.BigEndian.PutUint16(fragment[10:], uint16(offset))
+		binary.BigEndian.PutUint16(fragment[12:], uint16(len(message)))
+		fragments = append(fragments, append(fragment, message[offset:end]...))
+	}
+	return fragments, nil
+}
+
+func parseFragment(data []byte) (fragmentHeader, []byte, error) {
+	if len(data) < FragmentHeaderSize || !isFragment(data) {
+		return fragmentHeader{}, nil, errors.New("invalid fragment. header is too short")
+	}
+
+	header := fragmentHeader{
+		messageId: binary.BigEndian.Uint32(data[4:]),
+		index:     int(data[8]),
+		count:     int(data[9]),
+		offset:    int(binary.BigEndian.Uint16(data[10:])),
+		total:     int(binary.BigEndian.Uint16(data[12:])),
+	}
+	payload := data[FragmentHeaderSize:]
+
+	if header.count == 0 || header.count > MaxFragments || header.index >= header.count {
+		return header, nil, fmt.Errorf("invalid fragment. index %v of %v", header.index, header.count)
+	}
+	if header.offset+len(payload) > header.total {
+		return header, nil, fmt.Errorf("invalid fragment. payload at %v of %v bytes does not fit into message of %v bytes", header.offset, len(payload), header.total)
+	}
+	return header, payload, nil
+}
+
+type partialMessage struct {
+	header   fragmentHeader
+	data     []byte
+	received []bool
+	// ranges are the [offset, end) of the received fragments. They may not
+	// overlap, so the message has no gaps once receivedBytes reaches its length.
+	ranges        [][2]int
+	receivedBytes int
+	remaining     int
+	startedAt     time.Time
+}
+
+// reassembler collects fragments of messages from remotes. Messages are
+// identified by sender and message id, incomplete ones are dropped after timeout.
+type reassembler struct {
+	timeout  time.Duration
+	messages map[string]*partialMessage
+	// bytes is the size of the messages being reassembled
+	bytes int
+}
+
+func newReassembler(timeout time.Duration) *reassembler {
+	return &reassembler{
+		timeout:  timeout,
+		messages: make(map[string]*partialMessage),
+	}
+}
+
+// add stores the fragment and returns the message once all of its fragments are received.
+func (r *reassembler) add(sender string, data []byte, now time.Time) ([]byte, bool, error) {
+	r.expire(now)
+
+	header, payload, err := parseFragment(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := fmt.Sprintf("%v/%v", sender, header.messageId)
+	message, ok := r.messages[key]
+	if !ok {
+		if len(r.messages) >= MaxPartialMessages || r.bytes+header.total > MaxPartialBytes {
+			return nil, false, fmt.Errorf("dropping fragment of message %v, too many messages are being reassembled", header.messageId)
+		}
+		r.bytes += header.total
+		message = &partialMessage{
+			header:    header,
+			data:      make([]byte, header.total),
+			received:  make([]bool, header.count),
+			ranges:    make([][2]int, header.count),
+			remaining: header.count,
+			startedAt: now,
+		}
+		r.messages[key] = message
+	}
+
+	if message.header.count != header.count || message.header.total != header.total {
+		r.remove(key)
+		return nil, false, fmt.Errorf("invalid fragment. message %v changed its size", header.messageId)
+	}
+
+	if !message.received[header.index] {
+		end := header.offset + len(payload)
+		for i, other := range message.ranges {
+			if message.received[i] && header.offset < other[1] && other[0] < end {
+				r.remove(key)
+				return nil, false, fmt.Errorf("invalid fragment. fragment %v of message %v overlaps fragment %v", header.index, header.messageId, i)
+			}
+		}
+
+		copy(message.data[header.offset:], payload)
+		message.received[header.index] = true
+		message.ranges[header.index] = [2]int{header.offset, end}
+		message.receivedBytes += len(payload)
+		message.remaining--
+	}
+
+	if message.remaining > 0 {
+		return nil, false, nil
+	}
+
+	r.remove(key)
+	if message.receivedBytes != header.total {
+		return nil, false, fmt.Errorf("invalid fragment. fragments of message %v cover %v of %v bytes", header.messageId, message.receivedBytes, header.total)
+	}
+	return message.data, true, nil
+}
+
+func (r *reassembler) remove(key string) {
+	r.bytes -= r.messages[key].header.total
+	delete(r.messages, key)
+}
+
+func (r *reassembler) expire(now time.Time) {
+	for key, message := range r.messages {
+		if now.Sub(message.startedAt) > r.timeout {
+			r.remove(key)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package irremote
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFragments_RoundTrip(t *testing.T) {
+	message := bytes.Repeat([]byte("0123456789"), 250)
+	fragments, err := splitIntoFragments(42, message, 1000)
+	require.NoError(t, err)
+	require.Len(t, fragments, 3)
+
+	r := newReassembler(time.Second)
+	now := time.Now()
+
+	// out of order and with a duplicate
+	for _, i := range []int{2, 0, 2} {
+		_, complete, err := r.add("remote", fragments[i], now)
+		require.NoError(t, err)
+		assert.False(t, complete)
+	}
+
+	result, complete, err := r.add("remote", fragments[1], now)
+	require.NoError(t, err)
+	assert.True(t, complete)
+	assert.Equal(t, message, result)
+	assert.Empty(t, r.messages)
+}
+
+func TestFragments_Timeout(t *testing.T) {
+	fragments, err := splitIntoFragments(1, make([]byte, 100), 60)
+	require.NoError(t, err)
+
+	r := newReassembler(time.Second)
+	now := time.Now()
+
+	_, _, err = r.add("remote", fragments[0], now)
+	require.NoError(t, err)
+
+	_, complete, err := r.add("remote", fragments[1], now.Add(2*time.Second))
+	require.NoError(t, err)
+	assert.False(t, complete)
+}
+
+func TestFragments_Invalid(t *testing.T) {
+	fragments, err := splitIntoFragments(1, make([]byte, 100), 60)
+	require.NoError(t, err)
+
+	r := newReassembler(time.Second)
+	_, _, err = r.add("remote", fragments[0][:FragmentHeaderSize-1], time.Now())
+	assert.Error(t, err)
+
+	// payload beyond the declared message length
+	_, _, err = r.add("remote", append(fragments[1], 1, 2, 3), time.Now())
+	assert.Error(t, err)
+
+	_, err = splitIntoFragments(1, make([]byte, MaxFragments*10+1), 10)
+	assert.Error(t, err)
+}
+
+func TestFragments_Gaps(t *testing.T) {
+	fragments, err := splitIntoFragments(1, make([]byte, 100), 60)
+	require.NoError(t, err)
+	r := newReassembler(time.Second)
+
+	// both fragments arrive, but the bytes from 50 to 60 do not
+	_, _, err = r.add("remote", fragments[0][:FragmentHeaderSize+50], time.Now())
+	require.NoError(t, err)
+	_, complete, err := r.add("remote", fragments[1], time.Now())
+	assert.ErrorContains(t, err, "cover 90 of 100 bytes")
+	assert.False(t, complete)
+	assert.Empty(t, r.messages)
+
+	// the second fragment moved to 50 overlaps the first one
+	overlapping := append([]byte{}, fragments[1]...)
+	overlapping[11] = 50
+	_, _, err = r.add("remote", fragments[0], time.Now())
+	require.NoError(t, err)
+	_, _, err = r.add("remote", overlapping, time.Now())
+	assert.ErrorContains(t, err, "overlaps fragment 0")
+	assert.Empty(t, r.messages)
+}
+
+func TestSplitForRemote(t *testing.T) {
+	message := make([]byte, 3000)
+
+	_, err := splitForRemote(1, message, Status{})
+	assert.Error(t, err)
+
+	packets, err := splitForRemote(1, message, Status{ProtocolVersion: ProtocolVersionFragments, MaxPacketSize: 1400, MaxMessageSize: 4096})
+	require.NoError(t, err)
+	assert.Len(t, packets, 3)
+
+	_, err = splitForRemote(1, message, Status{ProtocolVersion: ProtocolVersionFragments, MaxPacketSize: 1400, MaxMessageSize: 2048})
+	assert.Error(t, err)
+
+	packets, err = splitForRemote(1, message[:100], Status{})
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{message[:100]}, packets)
+}
+
+func TestFragments_Limits(t *testing.T) {
+	r := newReassembler(time.Second)
+	now := time.Now()
+
+	// incomplete messages from anyone pile up until they time out
+	for i := 0; i < MaxPartialMessages; i++ {
+		fragments, err := splitIntoFragments(uint32(i), make([]byte, 100), 60)
+		require.NoError(t, err)
+		_, _, err = r.add(fmt.Sprint("sender", i), fragments[0], now)
+		require.NoError(t, err)
+	}
+	fragments, err := splitIntoFragments(99, make([]byte, 100), 60)
+	require.NoError(t, err)
+	_, _, err = r.add("remote", fragments[0], now)
+	assert.Error(t, err)
+
+	_, _, err = r.add("remote", fragments[0], now.Add(2*time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 100, r.bytes)
+
+	// a single fragment can announce a message of 64 KB
+	r = newReassembler(time.Second)
+	announce := func(messageId byte) []byte {
+		return append([]byte(FragmentMagic), 0, 0, 0, messageId, 0, 2, 0, 0, 0xFF, 0xFF, 1)
+	}
+	for i := byte(0); i < MaxPartialBytes/0xFFFF; i++ {
+		_, _, err = r.add("remote", announce(i), now)
+		require.NoError(t, err)
+	}
+	_, _, err = r.add("remote", announce(99), now)
+	assert.Error(t, err)
+}
+
+func TestFragments_DaikinEndToEnd(t *testing.T) {
+	daikin, err := commands.NewAcCommand("daikin")
+	require.NoError(t, err)
+	require.NoError(t, daikin.SetState(commands.AcState{Power: true, Mode: commands.AcModeCool, Temperature: 24}))
+	timings := daikin.ToSignalSequence()
+
+	// what the firmware reports
+	status := Status{ProtocolVersion: ProtocolVersionOta, MaxCommandLength: 600, MaxPacketSize: 2048, MaxMessageSize: 4096}
+	require.NoError(t, checkCommandLength(timings, status))
+	require.Error(t, checkCommandLength(timings, Status{}))
+
+	dummy := encoder.NewDummyEncoder()
+	packets, err := splitForRemote(7, dummy.Encrypt(Command{Data: timings, SequenceNumber: 7}), status)
+	require.NoError(t, err)
+	require.Greater(t, len(packets), 1)
+
+	r := newReassembler(FragmentReassemblyTimeout)
+	var message []byte
+	for _, packet := range packets {
+		require.LessOrEqual(t, len(packet), status.MaxPacketSize)
+		var complete bool
+		message, complete, err = r.add("backend", packet, time.Now())
+		require.NoError(t, err)
+		if complete {
+			break
+		}
+	}
+
+	cmd := Command{}
+	require.NoError(t, dummy.Decrypt(message, &cmd))
+	require.Equal(t, int64(7), cmd.SequenceNumber)
+	require.Equal(t, timings, cmd.Data)
+}
@@ -1,10 +1,52 @@
 package irremote
 
 type Command struct {
-	Data           []int `json:"data"`
-	SequenceNumber int64 `json:"sequence"`
+	// Data is the timing array, Packed is the same array encoded with PackTimings.
+	// Only one of them is set, depending on the protocol version of the remote.
+	Data           []int  `json:"data,omitempty"`
+	Packed         []byte `json:"packed,omitempty"`
+	SequenceNumber int64  `json:"sequence"`
+	// Frequency of the carrier in Hz, DutyCycle in percent
+	Frequency int `json:"frequency"`
+	DutyCycle int `json:"duty_cycle"`
+	// Firmware offers an update instead of an IR command
+	Firmware *FirmwareOffer `json:"firmware,omitempty"`
+}
+
+// FirmwareOffer is a firmware image the remote downloads over HTTP and
+// installs. The remote checks the MD5 while downloading and the signature
+// appended to the image before booting it.
+type FirmwareOffer struct {
+	Version string `json:"version"`
+	Url     string `json:"url"`
+	Size    int    `json:"size"`
+	Md5     string `json:"md5"`
 }
 
 type Status struct {
 	LastCommandSequenceNumber int64 `json:"last_command_sequence_number"`
+	// carrier frequency range in Hz the device can modulate, zero for old firmware
+	MinCarrierFrequency int `json:"min_carrier_frequency,omitempty"`
+	MaxCarrierFrequency int `json:"max_carrier_frequency,omitempty"`
+	// ProtocolVersion is 0 for firmware that predates versioning
+	ProtocolVersion int `json:"protocol_version,omitempty"`
+	// MaxCommandLength is the number of timings the remote can buffer
+	MaxCommandLength int `json:"max_command_length,omitempty"`
+	// MaxPacketSize is the size of the largest packet the remote can receive
+	MaxPacketSize int `json:"max_packet_size,omitempty"`
+	// MaxMessageSize is the size of the largest fragmented message the remote can reassemble
+	MaxMessageSize int `json:"max_message_size,omitempty"`
+	// room temperature in °C and relative humidity in percent, if the remote has a sensor
+	Temperature *float64 `json:"temperature,omitempty"`
+	Humidity    *float64 `json:"humidity,omitempty"`
+	// diagnostics, missing from old firmware
+	FirmwareVersion string `json:"firmware_version,omitempty"`
+	// UptimeSeconds is nil for old firmware, zero right after boot
+	UptimeSeconds *int64 `json:"uptime_seconds,omitempty"`
+	// Rssi is the Wi-Fi signal strength in dBm
+	Rssi        int    `json:"rssi,omitempty"`
+	FreeHeap    int    `json:"free_heap,omitempty"`
+	ResetReason string `json:"reset_reason,omitempty"`
+	// UpdateError is why the last firmware update failed, empty if it did not
+	UpdateError string `json:"update_error,omitempty"`
 }
@@ -0,0 +1,25 @@
+package irremote
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestStatus_OldFirmware(t *testing.T) {
+	status := Status{}
+	require.NoError(t, json.Unmarshal([]byte(`{"last_command_sequence_number": 5}`), &status))
+	require.Equal(t, Status{LastCommandSequenceNumber: 5}, status)
+}
+
+func TestStatus_Diagnostics(t *testing.T) {
+	data := `{"last_command_sequence_number": 5, "firmware_version": "1.4.0", "uptime_seconds": 0, "rssi": -70, "free_heap": 30000, "reset_reason": "Power On"}`
+	status := Status{}
+	require.NoError(t, json.Unmarshal([]byte(data), &status))
+	require.Equal(t, "1.4.0", status.FirmwareVersion)
+	require.NotNil(t, status.UptimeSeconds)
+	require.Equal(t, int64(0), *status.UptimeSeconds)
+	require.Equal(t, -70, status.Rssi)
+	require.Equal(t, 30000, status.FreeHeap)
+	require.Equal(t, "Power On", status.ResetReason)
+}
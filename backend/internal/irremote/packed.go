@@ -0,0 +1,128 @@
+package irremote
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// PackedMaxDictionary is the most distinct timings the firmware unpacks,
+// commands with more are sent unpacked
+const PackedMaxDictionary = 64
+
+// PackTimings encodes a timing array into the compact form used by protocol
+// version 2. Captures use only a few distinct durations, so the durations are
+// stored once in a dictionary and every timing becomes a bit packed index:
+//
+//	uvarint   number of timings
+//	uvarint   dictionary size
+//	uvarint[] dictionary, ascending
+//	bits      index of every timing, MSB first, padded with zeros to a whole byte
+func PackTimings(timings []int) []byte {
+	dictionary := make([]int, 0)
+	indexes := make(map[int]int)
+	for _, t := range timings {
+		if _, ok := indexes[t]; !ok {
+			indexes[t] = 0
+			dictionary = append(dictionary, t)
+		}
+	}
+	sort.Ints(dictionary)
+	for i, t := range dictionary {
+		indexes[t] = i
+	}
+
+	out := make([]byte, 0, 2*binary.MaxVarintLen32+len(dictionary)*3+len(timings))
+	out = binary.AppendUvarint(out, uint64(len(timings)))
+	out = binary.AppendUvarint(out, uint64(len(dictionary)))
+	for _, t := range dictionary {
+		out = binary.AppendUvarint(out, uint64(t))
+	}
+
+	width := indexWidth(len(dictionary))
+	var acc uint32
+	var accBits int
+	for _, t := range timings {
+		acc = acc<<width | uint32(indexes[t])
+		accBits += width
+		for accBits >= 8 {
+			out = append(out, byte(acc>>(accBits-8)))
+			accBits -= 8
+		}
+	}
+	if accBits > 0 {
+		out = append(out, byte(acc<<(8-accBits)))
+	}
+
+	return out
+}
+
+// canPack tells whether the firmware can unpack the timings once packed
+func canPack(timings []int) bool {
+	distinct := make(map[int]bool)
+	for _, t := range timings {
+		distinct[t] = true
+		if len(distinct) > PackedMaxDictionary {
+			return false
+		}
+	}
+	return true
+}
+
+// UnpackTimings decodes the output of PackTimings.
+func UnpackTimings(data []byte) ([]int, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, errors.New("invalid packed timings. bad timings count")
+	}
+	data = data[n:]
+
+	size, n := binary.Uvarint(data)
+	if n <= 0 || size > uint64(len(data)) || size > PackedMaxDictionary {
+		return nil, errors.New("invalid packed timings. bad dictionary size")
+	}
+	data = data[n:]
+
+	dictionary := make([]int, size)
+	for i := range dictionary {
+		t, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid packed timings. bad dictionary entry %v", i)
+		}
+		dictionary[i] = int(t)
+		data = data[n:]
+	}
+
+	width := indexWidth(len(dictionary))
+	if count > 0 && len(dictionary) == 0 || count > uint64(len(data))*8/uint64(width) {
+		return nil, fmt.Errorf("invalid packed timings. expected %v timings, got %v bytes", count, len(data))
+	}
+
+	timings := make([]int, count)
+	var acc uint32
+	var accBits int
+	for i := range timings {
+		for accBits < width {
+			acc = acc<<8 | uint32(data[0])
+			data = data[1:]
+			accBits += 8
+		}
+		index := int(acc>>(accBits-width)) & (1<<width - 1)
+		accBits -= width
+		if index >= len(dictionary) {
+			return nil, fmt.Errorf("invalid packed timings. index %v is out of dictionary", index)
+		}
+		timings[i] = dictionary[index]
+	}
+
+	return timings, nil
+}
+
+func indexWidth(dictionarySize int) int {
+	if dictionarySize <= 2 {
+		return 1
+	}
+	return bits.Len(uint(dictionarySize - 1))
+}
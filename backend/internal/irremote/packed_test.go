@@ -0,0 +1,96 @@
+package irremote
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var capturedTimings = []int{
+	4350, 4300, 500, 1650, 500, 600, 450, 1650, 500, 1650, 500, 600, 500, 550,
+	500, 1650, 500, 600, 450, 600, 500, 1650, 500, 600, 450, 600, 500, 1600, 550,
+	1600, 550, 550, 500, 1650, 500, 600, 450, 1650, 500, 1650, 500, 1650, 500,
+	1650, 500, 600, 450, 1650, 500, 1650, 500, 1650, 500, 600, 450, 600, 500, 600,
+	450, 600, 500, 1600, 550, 550, 500, 600, 500, 1600, 550, 1600, 500, 1650, 500,
+	600, 500, 600, 450, 600, 500, 600, 450, 600, 500, 600, 450, 600, 500, 600,
+	450, 1650, 500, 1650, 500, 1650, 450, 1650, 500, 1650, 600, 5050, 4400, 4200,
+}
+
+func TestPackTimings_RoundTrip(t *testing.T) {
+	for _, timings := range [][]int{
+		capturedTimings,
+		{560},
+		{560, 560, 560},
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 65535},
+	} {
+		unpacked, err := UnpackTimings(PackTimings(timings))
+		require.NoError(t, err)
+		assert.Equal(t, timings, unpacked)
+	}
+}
+
+func TestPackTimings_Size(t *testing.T) {
+	packed := PackTimings(capturedTimings)
+	asJson, _ := json.Marshal(capturedTimings)
+	assert.Less(t, len(packed)*3, len(asJson))
+}
+
+func TestUnpackTimings_Invalid(t *testing.T) {
+	packed := PackTimings(capturedTimings)
+
+	_, err := UnpackTimings(packed[:len(packed)-1])
+	assert.Error(t, err)
+
+	_, err = UnpackTimings([]byte{})
+	assert.Error(t, err)
+
+	// a count that overflows when multiplied by the index width
+	_, err = UnpackTimings([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x01, 0x02, 0x01, 0x02, 0x00})
+	assert.Error(t, err)
+}
+
+func TestPackTimings_TooManyDistinct(t *testing.T) {
+	timings := make([]int, PackedMaxDictionary+1)
+	for i := range timings {
+		timings[i] = 500 + i
+	}
+	assert.True(t, canPack(timings[:PackedMaxDictionary]))
+	assert.False(t, canPack(timings))
+
+	remote := newAckTransport(transport.UdpTransportName)
+	session := NewSession(remote, remote.encoder)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go session.RunSession(ctx)
+	remote.report(Status{ProtocolVersion: ProtocolVersionOta})
+	require.Eventually(t, session.IsOnline, time.Second, time.Millisecond)
+
+	// the firmware would drop the packed command
+	require.NoError(t, session.SendCommand(ctx, commands.NewRawCommand(timings)))
+	cmd := <-remote.sent
+	assert.Nil(t, cmd.Packed)
+	assert.Equal(t, timings, cmd.Data)
+
+	// the acknowledgement of the fake remote has no protocol version
+	remote.report(Status{ProtocolVersion: ProtocolVersionOta, LastCommandSequenceNumber: 1})
+	require.Eventually(t, func() bool {
+		status, _ := session.LastStatus()
+		return status.ProtocolVersion == ProtocolVersionOta
+	}, time.Second, time.Millisecond)
+	require.NoError(t, session.SendCommand(ctx, commands.NewRawCommand(timings[:PackedMaxDictionary])))
+	cmd = <-remote.sent
+	assert.NotNil(t, cmd.Packed)
+}
+
+func TestCheckCommandLength(t *testing.T) {
+	assert.NoError(t, checkCommandLength(capturedTimings, Status{}))
+	assert.Error(t, checkCommandLength(make([]int, LegacyMaxCommandLength+1), Status{}))
+	assert.Error(t, checkCommandLength(capturedTimings, Status{MaxCommandLength: 50}))
+	assert.Error(t, checkCommandLength([]int{500, 70000}, Status{}))
+	assert.Error(t, checkCommandLength(nil, Status{}))
+}
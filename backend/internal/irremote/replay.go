@@ -0,0 +1,133 @@
+package irremote
+
+import (
+	"context"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReplayResult is the outcome of a recorded command sent again by Replay.
+type ReplayResult struct {
+	// Offset is when the command was sent, relative to the start of the recording
+	Offset time.Duration
+	// SequenceNumber is the one of the command in the recording
+	SequenceNumber int64
+	Command        Command
+	Err            error
+}
+
+// Replay runs a session against a recording: the received packets are fed
+// to the session at the recorded times, and the recorded commands are sent
+// again at the times they were first sent. Packets are replayed in the
+// recorded order, a received packet waits until the command sent before it
+// is sent again. Sending fails or succeeds like in the recorded session if
+// the bug is in the timing of the backend.
+func Replay(ctx context.Context, records []transport.Record, encoder encoder.Encoder, speed float64) ([]ReplayResult, error) {
+	replay := transport.NewReplayTransport(records, speed)
+	session := NewSession(replay, encoder)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go session.RunSession(ctx)
+
+	var mx sync.Mutex
+	var results []ReplayResult
+	wg := sync.WaitGroup{}
+	decoder := newCommandDecoder(encoder)
+	err := replay.Run(ctx, func(ctx context.Context, record transport.Record) {
+		cmd, ok := decoder.decode(record)
+		if !ok {
+			return
+		}
+
+		_, sent := replay.Sent()
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done)
+			result := ReplayResult{
+				Offset:         record.Time.Sub(records[0].Time),
+				SequenceNumber: cmd.SequenceNumber,
+				Command:        cmd,
+				Err:            resend(ctx, session, cmd),
+			}
+			mx.Lock()
+			results = append(results, result)
+			mx.Unlock()
+		}()
+
+		// the session may fail before sending anything
+		select {
+		case <-sent:
+		case <-done:
+		case <-ctx.Done():
+		}
+	})
+
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Offset < results[j].Offset
+	})
+	return results, err
+}
+
+// commandDecoder decrypts the commands sent in a recording, once per
+// sequence number as the session resends them until acknowledged
+type commandDecoder struct {
+	encoder   encoder.Encoder
+	fragments *reassembler
+	seen      map[int64]bool
+}
+
+func newCommandDecoder(encoder encoder.Encoder) *commandDecoder {
+	return &commandDecoder{
+		encoder:   encoder,
+		fragments: newReassembler(FragmentReassemblyTimeout),
+		seen:      map[int64]bool{},
+	}
+}
+
+// decode returns the command of a sent record, if it completes a command
+// not seen before
+func (d *commandDecoder) decode(record transport.Record) (Command, bool) {
+	if record.Direction != transport.DirectionOut || record.Error != "" {
+		return Command{}, false
+	}
+
+	data := append([]byte(nil), record.Data...)
+	if isFragment(data) {
+		message, complete, err := d.fragments.add(record.Peer, data, record.Time)
+		if err != nil || !complete {
+			return Command{}, false
+		}
+		data = message
+	}
+
+	cmd := Command{}
+	if d.encoder.Decrypt(data, &cmd) != nil || d.seen[cmd.SequenceNumber] {
+		return Command{}, false
+	}
+	d.seen[cmd.SequenceNumber] = true
+	return cmd, true
+}
+
+func resend(ctx context.Context, session *Session, cmd Command) error {
+	if cmd.Firmware != nil {
+		return session.OfferFirmware(ctx, *cmd.Firmware)
+	}
+
+	timings := cmd.Data
+	if cmd.Packed != nil {
+		var err error
+		if timings, err = UnpackTimings(cmd.Packed); err != nil {
+			return err
+		}
+	}
+	carrier := commands.Carrier{Frequency: cmd.Frequency, DutyCycle: cmd.DutyCycle}
+	return session.SendCommand(ctx, commands.NewRawCommandWithCarrier(timings, carrier))
+}
@@ -0,0 +1,66 @@
+package irremote
+
+import (
+	"bytes"
+	"context"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	// record a session with a remote that acknowledges every command
+	remote := newAckTransport(transport.UdpTransportName)
+	out := &bytes.Buffer{}
+	recording := transport.NewRecordingTransport(remote, out, encoder.NewDummyEncoder())
+	session := NewSession(recording, encoder.NewDummyEncoder())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go recording.Run(ctx)
+	go session.RunSession(ctx)
+
+	remote.report(Status{ProtocolVersion: ProtocolVersionPacked})
+	require.Eventually(t, session.IsOnline, time.Second, time.Millisecond)
+	signal := []int{9000, 4500, 560, 1690, 560}
+	require.NoError(t, session.SendCommand(ctx, commands.NewRawCommand(signal)))
+	<-remote.sent
+	cancel()
+
+	records, err := transport.ReadRecording(out)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	require.Contains(t, string(records[1].Message), `"sequence":1`)
+
+	results, err := Replay(context.Background(), records, encoder.NewDummyEncoder(), 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, int64(1), results[0].SequenceNumber)
+	timings, err := UnpackTimings(results[0].Command.Packed)
+	require.NoError(t, err)
+	require.Equal(t, signal, timings)
+}
+
+func TestReplay_NotAcknowledged(t *testing.T) {
+	dummy := encoder.NewDummyEncoder()
+	start := time.Now()
+	records := []transport.Record{
+		{Time: start, Direction: transport.DirectionIn, Peer: "udp:127.0.0.1:4944", Data: dummy.Encrypt(Status{})},
+		{Time: start.Add(10 * time.Millisecond), Direction: transport.DirectionOut, Peer: "udp:127.0.0.1:4944", Data: dummy.Encrypt(Command{SequenceNumber: 1, Data: []int{500}, Frequency: commands.DEFAULT_CARRIER_FREQUENCY, DutyCycle: commands.DEFAULT_DUTY_CYCLE})},
+		// a resend of the same command
+		{Time: start.Add(20 * time.Millisecond), Direction: transport.DirectionOut, Peer: "udp:127.0.0.1:4944", Data: dummy.Encrypt(Command{SequenceNumber: 1, Data: []int{500}, Frequency: commands.DEFAULT_CARRIER_FREQUENCY, DutyCycle: commands.DEFAULT_DUTY_CYCLE})},
+		{Time: start.Add(30 * time.Millisecond), Direction: transport.DirectionOut, Peer: "udp:127.0.0.1:4944", Data: []byte("garbage")},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	results, err := Replay(ctx, records, dummy, 1)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, 10*time.Millisecond, results[0].Offset)
+	require.ErrorIs(t, results[0].Err, context.DeadlineExceeded)
+}
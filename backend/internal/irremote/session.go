@@ -3,32 +3,74 @@ package irremote
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/clock"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
 	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
 	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
 	"log"
-	"net"
 	"sync"
 	"time"
 )
 
 const ExpectedPingInterval = 10
 
+// ResendInterval is how long a message waits for the acknowledgement before it is sent again
+const ResendInterval = 1 * time.Second
+
+// ProtocolVersionPacked is the first protocol version that accepts packed timings
+const ProtocolVersionPacked = 2
+
+// ProtocolVersionFragments is the first protocol version that reassembles fragmented messages
+const ProtocolVersionFragments = 3
+
+// ProtocolVersionOta is the first protocol version that installs offered firmware
+const ProtocolVersionOta = 4
+
+// limits of the firmware that does not report them
+const LegacyMaxCommandLength = 300
+const LegacyMaxPacketSize = 2048
+const MaxTimingValue = 0xFFFF
+
 type Session struct {
-	lastKnownRemoteAddress *net.UDPAddr
-	lastTimeSeen           int64
-	lastCommandNumber      int64
+	// lastKnownPeer is where the remote reported from last, commands are sent there
+	lastKnownPeer transport.Peer
+	// lastSeen is when the last status was received, zero if none was
+	lastSeen          time.Time
+	lastCommandNumber int64
+	lastStatus        Status
 
-	netLayer transport.Transport
-	encoder  encoder.Encoder
+	netLayer  transport.Transport
+	encoder   encoder.Encoder
+	fragments *reassembler
+	clock     clock.Clock
 
 	mx                     sync.Mutex
 	remoteMessageBroadcast map[int64]chan Status
+	commandListeners       []CommandListener
+	statusListeners        []StatusListener
 }
 
+// CommandListener is told about every command transmitted to the remote: err
+// is nil if the remote acknowledged it, otherwise the command may or may not
+// have reached the remote. Commands rejected before sending are not reported.
+type CommandListener func(command commands.Command, err error)
+
+// StatusListener is told about every status the remote reports.
+type StatusListener func(status Status, at time.Time)
+
 func NewSession(netLayer transport.Transport, encoder encoder.Encoder) *Session {
+	return NewSessionWithClock(netLayer, encoder, clock.Real)
+}
+
+// NewSessionWithClock makes a session that tells the time and waits for
+// acknowledgements with the clock, for simulations.
+func NewSessionWithClock(netLayer transport.Transport, encoder encoder.Encoder, clock clock.Clock) *Session {
 	return &Session{
 		netLayer:               netLayer,
 		encoder:                encoder,
+		fragments:              newReassembler(FragmentReassemblyTimeout),
+		clock:                  clock,
 		remoteMessageBroadcast: make(map[int64]chan Status),
 	}
 }
@@ -48,27 +90,100 @@ func (s *Session) RunSession(ctx context.Context) {
 func (s *Session) IsOnline() bool {
 	s.mx.Lock()
 	defer s.mx.Unlock()
-	return s.lastKnownRemoteAddress != nil && time.Now().Unix()-s.lastTimeSeen < 3*ExpectedPingInterval
+	if s.lastKnownPeer.IsZero() {
+		return false
+	}
+	// times without a monotonic reading follow the system clock back, a
+	// status from the future is as old as it gets
+	age := s.clock.Now().Sub(s.lastSeen)
+	return age >= 0 && age < 3*ExpectedPingInterval*time.Second
 }
 
-func (s *Session) SendCommand(ctx context.Context, cmdBytes []int) error {
+// LastStatus returns the last status reported by the remote and when it was received.
+func (s *Session) LastStatus() (Status, time.Time) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.lastStatus, s.lastSeen
+}
+
+// AddCommandListener registers a listener called after every transmitted command.
+func (s *Session) AddCommandListener(listener CommandListener) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.commandListeners = append(s.commandListeners, listener)
+}
+
+// AddStatusListener registers a listener called with every status received.
+func (s *Session) AddStatusListener(listener StatusListener) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.statusListeners = append(s.statusListeners, listener)
+}
+
+func (s *Session) SendCommand(ctx context.Context, command commands.Command) error {
 	if !s.IsOnline() {
 		return errors.New("session is offline")
 	}
-	onUpdate := make(chan Status, 10)
 
-	var addr *net.UDPAddr
-	var cmd Command
+	carrier := command.Carrier()
+	if err := carrier.Validate(); err != nil {
+		return err
+	}
+	if err := s.checkCarrierSupported(carrier); err != nil {
+		return err
+	}
+
+	cmd := Command{
+		Frequency: carrier.Frequency,
+		DutyCycle: carrier.DutyCycle,
+	}
+
+	status, _ := s.LastStatus()
+	timings := command.ToSignalSequence()
+	if err := checkCommandLength(timings, status); err != nil {
+		return err
+	}
+
+	if status.ProtocolVersion >= ProtocolVersionPacked && canPack(timings) {
+		cmd.Packed = PackTimings(timings)
+	} else {
+		cmd.Data = timings
+	}
+
+	sent, err := s.deliver(ctx, cmd, status)
+	if sent {
+		s.notifyCommandListeners(command, err)
+	}
+	return err
+}
+
+// OfferFirmware tells the remote to download and install the firmware image.
+// It returns once the remote has accepted the offer; the remote reboots into
+// the new firmware if the download succeeds.
+func (s *Session) OfferFirmware(ctx context.Context, offer FirmwareOffer) error {
+	if !s.IsOnline() {
+		return errors.New("session is offline")
+	}
+
+	status, _ := s.LastStatus()
+	if status.ProtocolVersion < ProtocolVersionOta {
+		return fmt.Errorf("the remote firmware does not support updates, protocol version %v", status.ProtocolVersion)
+	}
+
+	_, err := s.deliver(ctx, Command{Firmware: &offer}, status)
+	return err
+}
+
+// deliver sends the message until the remote acknowledges its sequence
+// number. sent tells whether the message was transmitted at least once.
+func (s *Session) deliver(ctx context.Context, cmd Command, status Status) (sent bool, err error) {
+	onUpdate := make(chan Status, 10)
 
 	func() {
 		s.mx.Lock()
 		defer s.mx.Unlock()
 		s.lastCommandNumber++
-		cmd = Command{
-			Data:           cmdBytes,
-			SequenceNumber: s.lastCommandNumber,
-		}
-		addr = s.lastKnownRemoteAddress
+		cmd.SequenceNumber = s.lastCommandNumber
 		s.remoteMessageBroadcast[cmd.SequenceNumber] = onUpdate
 	}()
 
@@ -78,9 +193,9 @@ func (s *Session) SendCommand(ctx context.Context, cmdBytes []int) error {
 		delete(s.remoteMessageBroadcast, cmd.SequenceNumber)
 	}()
 
-	packet := transport.UdpPacket{
-		Addr: addr,
-		Data: s.encoder.Encrypt(cmd),
+	payloads, err := splitForRemote(uint32(cmd.SequenceNumber), s.encoder.Encrypt(cmd), status)
+	if err != nil {
+		return false, err
 	}
 
 	attempts := 10
@@ -88,23 +203,34 @@ func (s *Session) SendCommand(ctx context.Context, cmdBytes []int) error {
 	for {
 		attempts--
 		if attempts == 0 {
-			return errors.New("failed to send command, no response from remote")
+			return sent, errors.New("failed to send command, no response from remote")
 		}
-		err := s.netLayer.Send(packet)
-		if err != nil {
-			return err
+
+		// the remote may have moved, e.g. after its NAT mapping expired
+		s.mx.Lock()
+		peer := s.lastKnownPeer
+		s.mx.Unlock()
+		for _, payload := range payloads {
+			err := s.netLayer.Send(ctx, transport.Packet{
+				Peer: peer,
+				Data: payload,
+			})
+			if err != nil {
+				return sent, err
+			}
+			sent = true
 		}
 
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return sent, ctx.Err()
 
-		case <-time.After(1 * time.Second):
+		case <-s.clock.After(ResendInterval):
 			continue
 
 		case s := <-onUpdate:
 			if s.LastCommandSequenceNumber >= cmd.SequenceNumber {
-				return nil
+				return sent, nil
 			} else {
 				continue
 			}
@@ -112,7 +238,95 @@ func (s *Session) SendCommand(ctx context.Context, cmdBytes []int) error {
 	}
 }
 
-func (s *Session) onRemoteMessage(ctx context.Context, msg transport.UdpPacket) {
+func (s *Session) notifyCommandListeners(command commands.Command, err error) {
+	s.mx.Lock()
+	listeners := append([]CommandListener(nil), s.commandListeners...)
+	s.mx.Unlock()
+
+	for _, listener := range listeners {
+		listener(command, err)
+	}
+}
+
+func (s *Session) checkCarrierSupported(carrier commands.Carrier) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	minFrequency := s.lastStatus.MinCarrierFrequency
+	maxFrequency := s.lastStatus.MaxCarrierFrequency
+	if minFrequency == 0 && maxFrequency == 0 {
+		// old firmware does not report the range and always sends at the default frequency
+		minFrequency = commands.DEFAULT_CARRIER_FREQUENCY
+		maxFrequency = commands.DEFAULT_CARRIER_FREQUENCY
+	}
+
+	if carrier.Frequency < minFrequency || carrier.Frequency > maxFrequency {
+		return fmt.Errorf("carrier frequency %v Hz is not supported by the remote, supported range is %v-%v Hz", carrier.Frequency, minFrequency, maxFrequency)
+	}
+	return nil
+}
+
+func checkCommandLength(timings []int, status Status) error {
+	maxLength := status.MaxCommandLength
+	if maxLength == 0 {
+		maxLength = LegacyMaxCommandLength
+	}
+
+	if len(timings) == 0 {
+		return errors.New("command is empty")
+	}
+	if len(timings) > maxLength {
+		return fmt.Errorf("command has %v timings, the remote accepts at most %v", len(timings), maxLength)
+	}
+
+	for i, t := range timings {
+		if t <= 0 || t > MaxTimingValue {
+			return fmt.Errorf("timing %v at position %v is out of range", t, i)
+		}
+	}
+	return nil
+}
+
+// splitForRemote returns the packets to send the message with, fragmenting
+// it if it does not fit into a single packet and the remote supports that.
+func splitForRemote(messageId uint32, message []byte, status Status) ([][]byte, error) {
+	maxPacketSize := status.MaxPacketSize
+	if maxPacketSize == 0 {
+		maxPacketSize = LegacyMaxPacketSize
+	}
+
+	if len(message) <= maxPacketSize {
+		return [][]byte{message}, nil
+	}
+
+	if status.ProtocolVersion < ProtocolVersionFragments {
+		return nil, fmt.Errorf("command is %v bytes long, the remote accepts at most %v bytes", len(message), maxPacketSize)
+	}
+
+	if len(message) > status.MaxMessageSize {
+		return nil, fmt.Errorf("command is %v bytes long, the remote reassembles at most %v bytes", len(message), status.MaxMessageSize)
+	}
+
+	payloadSize := maxPacketSize - FragmentHeaderSize
+	if payloadSize > FragmentPayloadSize {
+		payloadSize = FragmentPayloadSize
+	}
+	return splitIntoFragments(messageId, message, payloadSize)
+}
+
+func (s *Session) onRemoteMessage(ctx context.Context, msg transport.Packet) {
+	if isFragment(msg.Data) {
+		message, complete, err := s.fragments.add(msg.Peer.String(), msg.Data, s.clock.Now())
+		if err != nil {
+			log.Println("dropping fragment", err)
+			return
+		}
+		if !complete {
+			return
+		}
+		msg.Data = message
+	}
+
 	status := Status{}
 	err := s.encoder.Decrypt(msg.Data, &status)
 	if err != nil {
@@ -121,12 +335,15 @@ func (s *Session) onRemoteMessage(ctx context.Context, msg transport.UdpPacket)
 	}
 
 	var notify []chan Status
+	var listeners []StatusListener
+	now := s.clock.Now()
 	func() {
 		s.mx.Lock()
 		defer s.mx.Unlock()
 
-		s.lastKnownRemoteAddress = msg.Addr
-		s.lastTimeSeen = time.Now().Unix()
+		s.lastKnownPeer = msg.Peer
+		s.lastSeen = now
+		s.lastStatus = status
 		if status.LastCommandSequenceNumber > s.lastCommandNumber {
 			s.lastCommandNumber = status.LastCommandSequenceNumber
 		}
@@ -135,8 +352,13 @@ func (s *Session) onRemoteMessage(ctx context.Context, msg transport.UdpPacket)
 		for _, ch := range s.remoteMessageBroadcast {
 			notify = append(notify, ch)
 		}
+		listeners = append(listeners, s.statusListeners...)
 	}()
 
+	for _, listener := range listeners {
+		listener(status, now)
+	}
+
 	for _, ch := range notify {
 		select {
 		case <-ctx.Done():
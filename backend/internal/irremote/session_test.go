@@ -2,9 +2,14 @@ package irremote
 
 import (
 	"context"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
 	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
 	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
+	"github.com/Light-Keeper/ir-remote/internal/mqttbroker"
 	"github.com/davecgh/go-spew/spew"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net"
 	"testing"
 	"time"
@@ -49,11 +54,14 @@ func TestSession_Integration(t *testing.T) {
 	// listen for incoming commands, pretend to be a remote device
 	go func() {
 		// we should start
-		err := removeDevice.Send(transport.UdpPacket{
-			Addr: &net.UDPAddr{
+		for removeDevice.LocalAddr() == nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+		err := removeDevice.Send(cxt, transport.Packet{
+			Peer: transport.UdpPeer(&net.UDPAddr{
 				IP:   net.IPv4(127, 0, 0, 1),
 				Port: 1234,
-			},
+			}),
 			Data: pack(Status{LastCommandSequenceNumber: 0}),
 		})
 		println("Sent initial package ", err)
@@ -66,11 +74,11 @@ func TestSession_Integration(t *testing.T) {
 				println("Received command")
 				spew.Dump(packet)
 				println("Sending confirmation")
-				err := removeDevice.Send(transport.UdpPacket{
-					Addr: &net.UDPAddr{
+				err := removeDevice.Send(cxt, transport.Packet{
+					Peer: transport.UdpPeer(&net.UDPAddr{
 						IP:   net.IPv4(127, 0, 0, 1),
 						Port: 1234,
-					},
+					}),
 					Data: pack(Status{LastCommandSequenceNumber: 10}),
 				})
 				println("Confirmation sent, result: ", err)
@@ -83,7 +91,7 @@ func TestSession_Integration(t *testing.T) {
 		for {
 			<-time.After(2 * time.Second)
 			println("Sending command")
-			err := session.SendCommand(cxt, []int{1, 2, 3, 4, 5, 6, 7, 8})
+			err := session.SendCommand(cxt, commands.NewRawCommand([]int{1, 2, 3, 4, 5, 6, 7, 8}))
 			print("Command sent, result: ")
 			spew.Dump(err)
 		}
@@ -96,3 +104,132 @@ func TestSession_Integration(t *testing.T) {
 func pack(cmd any) []byte {
 	return encoder.NewDummyEncoder().Encrypt(cmd)
 }
+
+func TestSession_Mqtt(t *testing.T) {
+	broker, err := mqttbroker.Start()
+	require.NoError(t, err)
+	defer broker.Close()
+
+	dummyEncoder := encoder.NewDummyEncoder()
+	mqttTransport := transport.NewMqttTransport(mqtt.NewClientOptions().AddBroker(broker.Url()).SetClientID("backend"), "default")
+	session := NewSession(mqttTransport, dummyEncoder)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = mqttTransport.Run(ctx)
+	}()
+	go session.RunSession(ctx)
+
+	// the remote acknowledges every command it receives
+	received := make(chan Command, 10)
+	device := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker.Url()).SetClientID("remote"))
+	require.True(t, device.Connect().WaitTimeout(time.Second))
+	defer device.Disconnect(100)
+	device.Subscribe(mqttTransport.CommandTopic(), 0, func(_ mqtt.Client, message mqtt.Message) {
+		cmd := Command{}
+		// not on the goroutine of the test, so it can not stop it
+		if !assert.NoError(t, dummyEncoder.Decrypt(message.Payload(), &cmd)) {
+			return
+		}
+		received <- cmd
+		device.Publish(mqttTransport.StatusTopic(), 0, false, dummyEncoder.Encrypt(Status{
+			LastCommandSequenceNumber: cmd.SequenceNumber,
+			ProtocolVersion:           ProtocolVersionFragments,
+		}))
+	}).WaitTimeout(time.Second)
+
+	require.Eventually(t, func() bool {
+		device.Publish(mqttTransport.StatusTopic(), 0, false, dummyEncoder.Encrypt(Status{ProtocolVersion: ProtocolVersionFragments}))
+		return session.IsOnline()
+	}, 2*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, session.SendCommand(ctx, commands.NewRawCommand([]int{9000, 4500, 560})))
+	cmd := <-received
+	timings, err := UnpackTimings(cmd.Packed)
+	require.NoError(t, err)
+	require.Equal(t, []int{9000, 4500, 560}, timings)
+}
+
+// ackTransport is a transport with one remote that acknowledges every command
+type ackTransport struct {
+	name    string
+	encoder encoder.Encoder
+	receive chan transport.Packet
+	sent    chan Command
+}
+
+func newAckTransport(name string) *ackTransport {
+	return &ackTransport{
+		name:    name,
+		encoder: encoder.NewDummyEncoder(),
+		receive: make(chan transport.Packet, 10),
+		sent:    make(chan Command, 10),
+	}
+}
+
+func (a *ackTransport) Name() string {
+	return a.name
+}
+
+func (a *ackTransport) Send(_ context.Context, packet transport.Packet) error {
+	cmd := Command{}
+	if err := a.encoder.Decrypt(packet.Data, &cmd); err != nil {
+		return err
+	}
+	a.sent <- cmd
+	a.report(Status{LastCommandSequenceNumber: cmd.SequenceNumber})
+	return nil
+}
+
+func (a *ackTransport) Receive() <-chan transport.Packet {
+	return a.receive
+}
+
+func (a *ackTransport) report(status Status) {
+	a.receive <- transport.Packet{
+		Peer: transport.Peer{Transport: a.name, Id: "remote"},
+		Data: a.encoder.Encrypt(status),
+	}
+}
+
+func TestSession_Multiplex(t *testing.T) {
+	udp := newAckTransport(transport.UdpTransportName)
+	websocket := newAckTransport(transport.WebsocketTransportName)
+	multiplex, err := transport.NewMultiplexTransport(udp, websocket)
+	require.NoError(t, err)
+	session := NewSession(multiplex, encoder.NewDummyEncoder())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go multiplex.Run(ctx)
+	go session.RunSession(ctx)
+
+	// commands go to the transport the remote reported from last
+	websocket.report(Status{})
+	require.Eventually(t, session.IsOnline, time.Second, time.Millisecond)
+	require.NoError(t, session.SendCommand(ctx, commands.NewRawCommand([]int{9000, 4500})))
+	require.Len(t, websocket.sent, 1)
+	require.Len(t, udp.sent, 0)
+
+	udp.report(Status{LastCommandSequenceNumber: 1})
+	require.Eventually(t, func() bool {
+		session.mx.Lock()
+		defer session.mx.Unlock()
+		return session.lastKnownPeer.Transport == transport.UdpTransportName
+	}, time.Second, time.Millisecond)
+	require.NoError(t, session.SendCommand(ctx, commands.NewRawCommand([]int{9000, 4500})))
+	require.Len(t, udp.sent, 1)
+}
+
+func TestSession_CheckCarrierSupported(t *testing.T) {
+	session := NewSession(nil, nil)
+
+	// old firmware only sends at the default frequency
+	assert.NoError(t, session.checkCarrierSupported(commands.DefaultCarrier))
+	assert.Error(t, session.checkCarrierSupported(commands.ProtocolCarrier("rc5")))
+
+	session.lastStatus = Status{MinCarrierFrequency: 30000, MaxCarrierFrequency: 40000}
+	assert.NoError(t, session.checkCarrierSupported(commands.ProtocolCarrier("rc5")))
+	assert.Error(t, session.checkCarrierSupported(commands.Carrier{Frequency: 56000, DutyCycle: 50}))
+}
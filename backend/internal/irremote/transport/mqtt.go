@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// MqttTopicPrefix is the root of the topics the remotes exchange messages on
+const MqttTopicPrefix = "ir-remote"
+
+const mqttPublishTimeout = 5 * time.Second
+
+// MqttTransportName is the transport of the peers, their id is the device id
+const MqttTransportName = "mqtt"
+
+// MqttTransport exchanges the same encrypted messages as UdpTransport through
+// an MQTT broker, so the remote only needs to connect out. The remote
+// publishes to ir-remote/{device}/status and subscribes to
+// ir-remote/{device}/command. Messages are sent with QoS 0: like datagrams,
+// they may be lost and the session retries them.
+type MqttTransport struct {
+	client   mqtt.Client
+	deviceId string
+	receive  chan Packet
+	dropped  atomic.Int64
+}
+
+func NewMqttTransport(options *mqtt.ClientOptions, deviceId string) *MqttTransport {
+	t := &MqttTransport{
+		deviceId: deviceId,
+		receive:  make(chan Packet, 10),
+	}
+	options.SetAutoReconnect(true)
+	options.SetOnConnectHandler(func(client mqtt.Client) {
+		log.Println("Connected to MQTT broker, subscribing to", t.StatusTopic())
+		client.Subscribe(t.StatusTopic(), 0, t.onMessage)
+	})
+	t.client = mqtt.NewClient(options)
+	return t
+}
+
+// StatusTopic is the topic the remote publishes its messages to.
+func (t *MqttTransport) StatusTopic() string {
+	return MqttTopicPrefix + "/" + t.deviceId + "/status"
+}
+
+// CommandTopic is the topic the remote receives its messages from.
+func (t *MqttTransport) CommandTopic() string {
+	return MqttTopicPrefix + "/" + t.deviceId + "/command"
+}
+
+// Run connects to the broker and stays connected until ctx is done.
+func (t *MqttTransport) Run(ctx context.Context) error {
+	token := t.client.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	t.client.Disconnect(1000)
+	return nil
+}
+
+func (t *MqttTransport) onMessage(_ mqtt.Client, message mqtt.Message) {
+	data := message.Payload()
+	if len(data) > MaxDatagramSize {
+		log.Println("Dropping too large message from", message.Topic())
+		return
+	}
+
+	log.Println("Received", len(data), "bytes from", message.Topic())
+	// the client delivers the messages one by one, waiting would stall the connection
+	select {
+	case t.receive <- Packet{Peer: t.peer(), Data: data}:
+	default:
+		dropped := t.dropped.Add(1)
+		log.Println("Receive queue is full, dropping message from", message.Topic(), "dropped", dropped, "so far")
+	}
+}
+
+// Dropped counts the messages dropped because the receive queue was full.
+func (t *MqttTransport) Dropped() int64 {
+	return t.dropped.Load()
+}
+
+func (t *MqttTransport) peer() Peer {
+	return Peer{Transport: MqttTransportName, Id: t.deviceId}
+}
+
+func (t *MqttTransport) Name() string {
+	return MqttTransportName
+}
+
+func (t *MqttTransport) Send(ctx context.Context, packet Packet) error {
+	if packet.Peer != t.peer() {
+		return fmt.Errorf("can not send to %v over MQTT as device %v", packet.Peer, t.deviceId)
+	}
+	if !t.client.IsConnectionOpen() {
+		return errors.New("not connected to the MQTT broker")
+	}
+
+	log.Println("Sending", len(packet.Data), "bytes to", t.CommandTopic())
+	token := t.client.Publish(t.CommandTopic(), 0, false, packet.Data)
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(mqttPublishTimeout):
+		return fmt.Errorf("publishing to %v timed out", t.CommandTopic())
+	}
+}
+
+func (t *MqttTransport) Receive() <-chan Packet {
+	return t.receive
+}
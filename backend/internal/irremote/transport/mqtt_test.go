@@ -0,0 +1,79 @@
+package transport
+
+import (
+	"context"
+	"github.com/Light-Keeper/ir-remote/internal/mqttbroker"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func startMqttTransport(t *testing.T) (*MqttTransport, *mqttbroker.Broker) {
+	broker, err := mqttbroker.Start()
+	require.NoError(t, err)
+	t.Cleanup(broker.Close)
+
+	transport := NewMqttTransport(mqtt.NewClientOptions().AddBroker(broker.Url()).SetClientID("backend"), "kitchen")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- transport.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+	require.Eventually(t, transport.client.IsConnectionOpen, time.Second, 10*time.Millisecond)
+	return transport, broker
+}
+
+func TestIntegration_Mqtt(t *testing.T) {
+	transport, broker := startMqttTransport(t)
+	require.Equal(t, "ir-remote/kitchen/status", transport.StatusTopic())
+
+	// the subscription is made once connected
+	received := make(chan Packet, 1)
+	require.Eventually(t, func() bool {
+		broker.Publish(transport.StatusTopic(), []byte("status"), false)
+		select {
+		case packet := <-transport.Receive():
+			received <- packet
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	packet := <-received
+	require.Equal(t, []byte("status"), packet.Data)
+	require.Equal(t, Peer{Transport: MqttTransportName, Id: "kitchen"}, packet.Peer)
+
+	require.Error(t, transport.Send(context.Background(), Packet{Peer: Peer{Transport: MqttTransportName, Id: "bedroom"}}))
+	require.NoError(t, transport.Send(context.Background(), Packet{Peer: Peer{Transport: MqttTransportName, Id: "kitchen"}, Data: []byte("command")}))
+	require.Eventually(t, func() bool {
+		return len(broker.Messages("ir-remote/kitchen/command")) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	broker.Publish(transport.StatusTopic(), make([]byte, MaxDatagramSize+1), false)
+	select {
+	case <-transport.Receive():
+		t.Fatal("too large message was not dropped")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestIntegration_MqttQueueFull(t *testing.T) {
+	transport, broker := startMqttTransport(t)
+	require.Eventually(t, func() bool {
+		broker.Publish(transport.StatusTopic(), []byte("status"), false)
+		return len(transport.Receive()) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// nobody reads, the messages over the queue size are dropped instead of blocking the client
+	for i := 0; i < cap(transport.receive); i++ {
+		broker.Publish(transport.StatusTopic(), []byte("status"), false)
+	}
+	require.Eventually(t, func() bool {
+		return len(transport.Receive()) == cap(transport.receive) && transport.Dropped() > 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const MultiplexTransportName = "multiplex"
+
+// MultiplexTransport merges the packets of several transports, so one session
+// serves remotes on UDP and WebSocket at the same time. Packets are sent with
+// the transport named by their peer.
+type MultiplexTransport struct {
+	transports map[string]Transport
+	receive    chan Packet
+}
+
+func NewMultiplexTransport(transports ...Transport) (*MultiplexTransport, error) {
+	m := &MultiplexTransport{
+		transports: make(map[string]Transport, len(transports)),
+		receive:    make(chan Packet, 10),
+	}
+	for _, transport := range transports {
+		if _, ok := m.transports[transport.Name()]; ok {
+			return nil, fmt.Errorf("transport %v is given twice", transport.Name())
+		}
+		m.transports[transport.Name()] = transport
+	}
+	return m, nil
+}
+
+// Run forwards the received packets until ctx is done. The transports are
+// run by their owner.
+func (m *MultiplexTransport) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for _, transport := range m.transports {
+		wg.Add(1)
+		go func(transport Transport) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case packet := <-transport.Receive():
+					select {
+					case m.receive <- packet:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(transport)
+	}
+	wg.Wait()
+}
+
+func (m *MultiplexTransport) Name() string {
+	return MultiplexTransportName
+}
+
+func (m *MultiplexTransport) Send(ctx context.Context, packet Packet) error {
+	transport, ok := m.transports[packet.Peer.Transport]
+	if !ok {
+		return fmt.Errorf("no transport for %v", packet.Peer)
+	}
+	return transport.Send(ctx, packet)
+}
+
+func (m *MultiplexTransport) Receive() <-chan Packet {
+	return m.receive
+}
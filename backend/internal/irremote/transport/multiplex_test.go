@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type fakeTransport struct {
+	name    string
+	receive chan Packet
+	sent    []Packet
+}
+
+func newFakeTransport(name string) *fakeTransport {
+	return &fakeTransport{name: name, receive: make(chan Packet, 10)}
+}
+
+func (f *fakeTransport) Name() string {
+	return f.name
+}
+
+func (f *fakeTransport) Send(_ context.Context, packet Packet) error {
+	f.sent = append(f.sent, packet)
+	return nil
+}
+
+func (f *fakeTransport) Receive() <-chan Packet {
+	return f.receive
+}
+
+func TestMultiplexTransport(t *testing.T) {
+	udp := newFakeTransport(UdpTransportName)
+	websocket := newFakeTransport(WebsocketTransportName)
+	multiplex, err := NewMultiplexTransport(udp, websocket)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		multiplex.Run(ctx)
+		close(done)
+	}()
+
+	udpPeer := Peer{Transport: UdpTransportName, Id: "127.0.0.1:4944"}
+	websocketPeer := Peer{Transport: WebsocketTransportName, Id: "kitchen"}
+	udp.receive <- Packet{Peer: udpPeer, Data: []byte("udp")}
+	require.Equal(t, udpPeer, (<-multiplex.Receive()).Peer)
+	websocket.receive <- Packet{Peer: websocketPeer, Data: []byte("websocket")}
+	require.Equal(t, websocketPeer, (<-multiplex.Receive()).Peer)
+
+	require.NoError(t, multiplex.Send(context.Background(), Packet{Peer: websocketPeer, Data: []byte("command")}))
+	require.Len(t, websocket.sent, 1)
+	require.Len(t, udp.sent, 0)
+	require.Error(t, multiplex.Send(context.Background(), Packet{Peer: Peer{Transport: MqttTransportName, Id: "kitchen"}}))
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+}
+
+func TestMultiplexTransport_DuplicateName(t *testing.T) {
+	_, err := NewMultiplexTransport(newFakeTransport(UdpTransportName), newFakeTransport(UdpTransportName))
+	require.Error(t, err)
+}
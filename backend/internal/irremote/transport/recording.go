@@ -0,0 +1,149 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirectionIn and DirectionOut tell whether a recorded packet was received or sent
+const DirectionIn = "in"
+const DirectionOut = "out"
+
+// Record is a packet of a recording, one JSON object per line.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Peer      string    `json:"peer"`
+	Data      []byte    `json:"data"`
+	// Error is why sending failed, empty if the packet was sent
+	Error string `json:"error,omitempty"`
+	// Message is the decrypted payload, if a decrypter was given and the
+	// packet is not a fragment
+	Message json.RawMessage `json:"message,omitempty"`
+}
+
+// Decrypter decrypts the payloads of the packets, like encoder.Encoder.
+type Decrypter interface {
+	Decrypt(data []byte, into any) error
+}
+
+// RecordingTransport writes every packet received and sent through the
+// wrapped transport to a recording, for debugging sessions offline.
+type RecordingTransport struct {
+	inner     Transport
+	decrypter Decrypter
+	receive   chan Packet
+	now       func() time.Time
+
+	mx     sync.Mutex
+	out    *json.Encoder
+	failed bool
+}
+
+// NewRecordingTransport records the packets of inner to w. The payloads are
+// recorded decrypted too if decrypter is not nil.
+func NewRecordingTransport(inner Transport, w io.Writer, decrypter Decrypter) *RecordingTransport {
+	return &RecordingTransport{
+		inner:     inner,
+		decrypter: decrypter,
+		receive:   make(chan Packet, 10),
+		now:       time.Now,
+		out:       json.NewEncoder(w),
+	}
+}
+
+// Run records and forwards the received packets until ctx is done. The
+// wrapped transport is run by its owner.
+func (t *RecordingTransport) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packet := <-t.inner.Receive():
+			t.record(DirectionIn, packet, nil)
+			select {
+			case t.receive <- packet:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (t *RecordingTransport) Name() string {
+	return t.inner.Name()
+}
+
+func (t *RecordingTransport) Send(ctx context.Context, packet Packet) error {
+	err := t.inner.Send(ctx, packet)
+	t.record(DirectionOut, packet, err)
+	return err
+}
+
+func (t *RecordingTransport) Receive() <-chan Packet {
+	return t.receive
+}
+
+func (t *RecordingTransport) record(direction string, packet Packet, err error) {
+	record := Record{
+		Time:      t.now(),
+		Direction: direction,
+		Peer:      packet.Peer.String(),
+		Data:      packet.Data,
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if t.decrypter != nil {
+		// decrypters may work in place, the packet still goes on
+		data := append([]byte(nil), packet.Data...)
+		message := json.RawMessage{}
+		if t.decrypter.Decrypt(data, &message) == nil {
+			record.Message = message
+		}
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if err := t.out.Encode(record); err != nil && !t.failed {
+		// the session goes on without the recording
+		t.failed = true
+		log.Println("Failed to write the recording, further packets are not recorded:", err)
+	}
+}
+
+// ReadRecording reads the records written by RecordingTransport.
+func ReadRecording(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*MaxDatagramSize+64*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		record := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("invalid record on line %v: %w", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// ParsePeer parses the String of a peer.
+func ParsePeer(s string) (Peer, error) {
+	name, id, found := strings.Cut(s, ":")
+	if !found || name == "" {
+		return Peer{}, fmt.Errorf("invalid peer %q", s)
+	}
+	return Peer{Transport: name, Id: id}, nil
+}
@@ -0,0 +1,141 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestRecordingTransport(t *testing.T) {
+	aes := encoder.NewAesEncoder("secret")
+	inner := newFakeTransport(UdpTransportName)
+	out := &bytes.Buffer{}
+	recording := NewRecordingTransport(inner, out, aes)
+	require.Equal(t, UdpTransportName, recording.Name())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go recording.Run(ctx)
+
+	peer := Peer{Transport: UdpTransportName, Id: "127.0.0.1:4944"}
+	status := aes.Encrypt(map[string]int{"last_command_sequence_number": 7})
+	inner.receive <- Packet{Peer: peer, Data: append([]byte(nil), status...)}
+
+	select {
+	case packet := <-recording.Receive():
+		// the packet is still encrypted for the session
+		require.Equal(t, status, packet.Data)
+	case <-time.After(time.Second):
+		t.Fatal("no packet received")
+	}
+	require.NoError(t, recording.Send(context.Background(), Packet{Peer: peer, Data: []byte("fragment")}))
+	require.Len(t, inner.sent, 1)
+
+	records, err := ReadRecording(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	require.Equal(t, DirectionIn, records[0].Direction)
+	require.Equal(t, "udp:127.0.0.1:4944", records[0].Peer)
+	require.Equal(t, status, records[0].Data)
+	require.JSONEq(t, `{"last_command_sequence_number": 7}`, string(records[0].Message))
+
+	require.Equal(t, DirectionOut, records[1].Direction)
+	require.Equal(t, []byte("fragment"), records[1].Data)
+	require.Nil(t, records[1].Message)
+	require.False(t, records[1].Time.Before(records[0].Time))
+}
+
+func TestRecordingTransport_WriteFails(t *testing.T) {
+	inner := newFakeTransport(UdpTransportName)
+	recording := NewRecordingTransport(inner, failingWriter{}, nil)
+
+	// the session goes on
+	require.NoError(t, recording.Send(context.Background(), Packet{Peer: Peer{Transport: UdpTransportName, Id: "127.0.0.1:4944"}, Data: []byte("command")}))
+	require.NoError(t, recording.Send(context.Background(), Packet{Peer: Peer{Transport: UdpTransportName, Id: "127.0.0.1:4944"}, Data: []byte("command")}))
+	require.Len(t, inner.sent, 2)
+}
+
+func TestReadRecording(t *testing.T) {
+	records, err := ReadRecording(bytes.NewBufferString(
+		`{"time":"2024-05-01T10:00:00Z","direction":"out","peer":"mqtt:default","data":"Y29tbWFuZA==","error":"not connected"}` + "\n\n"))
+	require.NoError(t, err)
+	require.Equal(t, []Record{{
+		Time:      time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Direction: DirectionOut,
+		Peer:      "mqtt:default",
+		Data:      []byte("command"),
+		Error:     "not connected",
+	}}, records)
+
+	_, err = ReadRecording(bytes.NewBufferString("{}\nnot json\n"))
+	require.ErrorContains(t, err, "line 2")
+}
+
+func TestParsePeer(t *testing.T) {
+	peer := Peer{Transport: UdpTransportName, Id: "[::1]:4944"}
+	parsed, err := ParsePeer(peer.String())
+	require.NoError(t, err)
+	require.Equal(t, peer, parsed)
+
+	_, err = ParsePeer("udp")
+	require.Error(t, err)
+}
+
+func TestReplayTransport(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: start, Direction: DirectionIn, Peer: "udp:127.0.0.1:4944", Data: []byte("first")},
+		{Time: start.Add(time.Second), Direction: DirectionOut, Peer: "udp:127.0.0.1:4944", Data: []byte("command")},
+		{Time: start.Add(2 * time.Second), Direction: DirectionIn, Peer: "broken", Data: []byte("skipped")},
+		{Time: start.Add(3 * time.Second), Direction: DirectionIn, Peer: "udp:127.0.0.1:4944", Data: []byte("second")},
+	}
+	replay := NewReplayTransport(records, 100)
+	require.Equal(t, UdpTransportName, replay.Name())
+	require.Equal(t, 30*time.Millisecond, replay.Offset(records[3]))
+
+	done := make(chan error)
+	begin := time.Now()
+	go func() {
+		done <- replay.Run(context.Background(), func(ctx context.Context, record Record) {
+			// the session sends the recorded packet again before the next one is received
+			peer, err := ParsePeer(record.Peer)
+			require.NoError(t, err)
+			require.NoError(t, replay.Send(ctx, Packet{Peer: peer, Data: record.Data}))
+		})
+	}()
+
+	first := <-replay.Receive()
+	require.Equal(t, []byte("first"), first.Data)
+	require.Equal(t, Peer{Transport: UdpTransportName, Id: "127.0.0.1:4944"}, first.Peer)
+	require.Equal(t, []byte("second"), (<-replay.Receive()).Data)
+	require.GreaterOrEqual(t, time.Since(begin), 30*time.Millisecond)
+	require.NoError(t, <-done)
+
+	sent, changed := replay.Sent()
+	require.Len(t, sent, 1)
+	require.Equal(t, "udp:127.0.0.1:4944", sent[0].Peer)
+	require.Equal(t, []byte("command"), sent[0].Data)
+
+	require.NoError(t, replay.Send(context.Background(), Packet{Peer: first.Peer, Data: []byte("again")}))
+	select {
+	case <-changed:
+	default:
+		t.Fatal("sending did not notify")
+	}
+
+	data, err := json.Marshal(sent[0])
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"direction":"out"`)
+}
@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const ReplayTransportName = "replay"
+
+// ReplayTransport plays the received packets of a recording back at the
+// times they were recorded, scaled by speed, to reproduce a session offline.
+// The packets sent are kept instead of being sent.
+type ReplayTransport struct {
+	records []Record
+	speed   float64
+	receive chan Packet
+
+	mx   sync.Mutex
+	sent []Record
+	// changed is closed and replaced on every sent packet
+	changed chan struct{}
+}
+
+// NewReplayTransport replays the records speed times faster than recorded.
+func NewReplayTransport(records []Record, speed float64) *ReplayTransport {
+	if speed <= 0 {
+		speed = 1
+	}
+	return &ReplayTransport{
+		records: records,
+		speed:   speed,
+		receive: make(chan Packet),
+		changed: make(chan struct{}),
+	}
+}
+
+// Offset is when the record is played back, relative to the start of Run.
+func (t *ReplayTransport) Offset(record Record) time.Duration {
+	if len(t.records) == 0 {
+		return 0
+	}
+	return time.Duration(float64(record.Time.Sub(t.records[0].Time)) / t.speed)
+}
+
+// Run plays the received packets back until the recording ends or ctx is
+// done. onSent, if not nil, is called when Run reaches a sent packet of the
+// recording; the next records wait until it returns, so it can make the
+// session send the packet again in the recorded order. Received packets
+// with a peer that can not be parsed are skipped.
+func (t *ReplayTransport) Run(ctx context.Context, onSent func(ctx context.Context, record Record)) error {
+	start := time.Now()
+	for _, record := range t.records {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Until(start.Add(t.Offset(record)))):
+		}
+
+		if record.Direction != DirectionIn {
+			if onSent != nil {
+				onSent(ctx, record)
+			}
+			continue
+		}
+		peer, err := ParsePeer(record.Peer)
+		if err != nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t.receive <- Packet{Peer: peer, Data: append([]byte(nil), record.Data...)}:
+		}
+	}
+	return nil
+}
+
+// Name is the transport of the first recorded peer, so the replayed
+// session sees the same peers as the recorded one.
+func (t *ReplayTransport) Name() string {
+	for _, record := range t.records {
+		if peer, err := ParsePeer(record.Peer); err == nil {
+			return peer.Transport
+		}
+	}
+	return ReplayTransportName
+}
+
+func (t *ReplayTransport) Send(ctx context.Context, packet Packet) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.sent = append(t.sent, Record{
+		Time:      time.Now(),
+		Direction: DirectionOut,
+		Peer:      packet.Peer.String(),
+		Data:      append([]byte(nil), packet.Data...),
+	})
+	close(t.changed)
+	t.changed = make(chan struct{})
+	return nil
+}
+
+// Sent returns the packets sent so far and a channel closed once another one is sent.
+func (t *ReplayTransport) Sent() ([]Record, <-chan struct{}) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return append([]Record(nil), t.sent...), t.changed
+}
+
+func (t *ReplayTransport) Receive() <-chan Packet {
+	return t.receive
+}
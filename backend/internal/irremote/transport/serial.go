@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const SerialTransportName = "serial"
+
+// DefaultSerialBaudRate is the speed of the serial line, the same as the log output of the remote
+const DefaultSerialBaudRate = 115200
+
+const serialReopenInterval = 2 * time.Second
+
+// SerialTransport talks to a remote attached to a serial port, like an ESP
+// board on a USB-UART, exchanging the same messages as over UDP in checksummed
+// frames. The port is reopened if it fails, e.g. when the board is replugged.
+// The peer id is the path of the port.
+type SerialTransport struct {
+	path     string
+	baudRate int
+	receive  chan Packet
+
+	mx   sync.Mutex
+	port *os.File
+}
+
+func NewSerialTransport(path string, baudRate int) *SerialTransport {
+	return &SerialTransport{
+		path:     path,
+		baudRate: baudRate,
+		receive:  make(chan Packet, 10),
+	}
+}
+
+// Run reads the port until ctx is done.
+func (t *SerialTransport) Run(ctx context.Context) error {
+	for {
+		err := t.serve(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Println("Serial port", t.path, "failed, reopening:", err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(serialReopenInterval):
+		}
+	}
+}
+
+func (t *SerialTransport) serve(ctx context.Context) error {
+	port, err := openSerial(t.path, t.baudRate)
+	if err != nil {
+		return err
+	}
+	log.Println("Opened serial port", t.path, "at", t.baudRate, "baud")
+
+	t.mx.Lock()
+	t.port = port
+	t.mx.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		t.mx.Lock()
+		t.port = nil
+		t.mx.Unlock()
+		_ = port.Close()
+	}()
+
+	peer := Peer{Transport: SerialTransportName, Id: t.path}
+	decoder := &serialDecoder{}
+	buf := make([]byte, MaxDatagramSize)
+	for {
+		n, err := port.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		corrupted := decoder.corrupted
+		for _, payload := range decoder.feed(buf[:n]) {
+			log.Println("Received", len(payload), "bytes from", peer)
+			t.receive <- Packet{
+				Peer: peer,
+				Data: payload,
+			}
+		}
+		if decoder.corrupted > corrupted {
+			log.Println("Dropped", decoder.corrupted-corrupted, "corrupted frames from", peer)
+		}
+	}
+}
+
+func (t *SerialTransport) Name() string {
+	return SerialTransportName
+}
+
+func (t *SerialTransport) Send(ctx context.Context, packet Packet) error {
+	if packet.Peer.Transport != SerialTransportName || packet.Peer.Id != t.path {
+		return fmt.Errorf("can not send to %v over serial port %v", packet.Peer, t.path)
+	}
+	if len(packet.Data) > MaxDatagramSize {
+		return fmt.Errorf("message of %d bytes is too large", len(packet.Data))
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.port == nil {
+		return errors.New("serial port " + t.path + " is not open")
+	}
+
+	log.Println("Sending", len(packet.Data), "bytes to", packet.Peer)
+	_, err := t.port.Write(EncodeSerialFrame(packet.Data))
+	return err
+}
+
+func (t *SerialTransport) Receive() <-chan Packet {
+	return t.receive
+}
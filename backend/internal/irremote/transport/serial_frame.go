@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// serialSync starts every frame on the serial line. Anything between frames,
+// like the log output of the remote, is skipped.
+var serialSync = []byte{0xA5, 0x5A}
+
+// serialHeaderSize is the sync bytes and the payload length
+const serialHeaderSize = 4
+const serialChecksumSize = 2
+
+// EncodeSerialFrame frames a message for the serial line: the sync bytes, the
+// payload length as little endian uint16, the payload and the CRC-16/CCITT of
+// the length and the payload as little endian uint16.
+func EncodeSerialFrame(payload []byte) []byte {
+	frame := make([]byte, 0, serialHeaderSize+len(payload)+serialChecksumSize)
+	frame = append(frame, serialSync...)
+	frame = binary.LittleEndian.AppendUint16(frame, uint16(len(payload)))
+	frame = append(frame, payload...)
+	return binary.LittleEndian.AppendUint16(frame, crc16(frame[len(serialSync):]))
+}
+
+// serialDecoder finds the frames in the bytes read from the serial line.
+type serialDecoder struct {
+	buf []byte
+	// corrupted counts the frames dropped for a wrong checksum or length
+	corrupted int
+}
+
+// feed adds the bytes read and returns the payloads of the complete frames.
+func (d *serialDecoder) feed(data []byte) [][]byte {
+	d.buf = append(d.buf, data...)
+
+	var frames [][]byte
+	for {
+		start := bytes.Index(d.buf, serialSync)
+		if start < 0 {
+			// keep a last byte that may start the sync of the next frame
+			if len(d.buf) > 0 && d.buf[len(d.buf)-1] == serialSync[0] {
+				d.buf = d.buf[len(d.buf)-1:]
+			} else {
+				d.buf = d.buf[:0]
+			}
+			return frames
+		}
+		d.buf = d.buf[start:]
+		if len(d.buf) < serialHeaderSize {
+			return frames
+		}
+
+		length := int(binary.LittleEndian.Uint16(d.buf[len(serialSync):]))
+		if length > MaxDatagramSize {
+			d.corrupted++
+			d.buf = d.buf[1:]
+			continue
+		}
+		size := serialHeaderSize + length + serialChecksumSize
+		if len(d.buf) < size {
+			return frames
+		}
+
+		checksum := binary.LittleEndian.Uint16(d.buf[size-serialChecksumSize:])
+		if crc16(d.buf[len(serialSync):size-serialChecksumSize]) != checksum {
+			d.corrupted++
+			d.buf = d.buf[1:]
+			continue
+		}
+
+		frames = append(frames, append([]byte{}, d.buf[serialHeaderSize:size-serialChecksumSize]...))
+		d.buf = d.buf[size:]
+	}
+}
+
+// crc16 is CRC-16/CCITT-FALSE
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
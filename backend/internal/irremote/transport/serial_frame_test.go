@@ -0,0 +1,49 @@
+package transport
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCrc16(t *testing.T) {
+	// the check value of CRC-16/CCITT-FALSE
+	require.Equal(t, uint16(0x29B1), crc16([]byte("123456789")))
+}
+
+func TestSerialDecoder(t *testing.T) {
+	decoder := &serialDecoder{}
+	first := EncodeSerialFrame([]byte("first"))
+	second := EncodeSerialFrame([]byte("second"))
+
+	// log output of the remote between frames is skipped
+	stream := append([]byte("Connecting to wifi...\r\n"), first...)
+	stream = append(stream, []byte("reportStatus... \xa5")...)
+	stream = append(stream, second...)
+
+	// bytes arrive in arbitrary chunks
+	var frames [][]byte
+	for i := 0; i < len(stream); i += 3 {
+		end := i + 3
+		if end > len(stream) {
+			end = len(stream)
+		}
+		frames = append(frames, decoder.feed(stream[i:end])...)
+	}
+	require.Equal(t, [][]byte{[]byte("first"), []byte("second")}, frames)
+	require.Equal(t, 0, decoder.corrupted)
+}
+
+func TestSerialDecoder_Corrupted(t *testing.T) {
+	decoder := &serialDecoder{}
+	corrupted := EncodeSerialFrame([]byte("corrupted"))
+	corrupted[6] ^= 0x01
+
+	stream := append(corrupted, EncodeSerialFrame([]byte("valid"))...)
+	require.Equal(t, [][]byte{[]byte("valid")}, decoder.feed(stream))
+	require.Equal(t, 1, decoder.corrupted)
+
+	// a length over the limit is a false sync
+	require.Empty(t, decoder.feed([]byte{0xA5, 0x5A, 0xFF, 0xFF}))
+	require.Equal(t, 2, decoder.corrupted)
+	require.Equal(t, [][]byte{[]byte("after")}, decoder.feed(EncodeSerialFrame([]byte("after"))))
+}
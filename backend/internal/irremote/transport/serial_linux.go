@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+var baudRates = map[int]uint32{
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+	460800: unix.B460800,
+	921600: unix.B921600,
+}
+
+// openSerial opens the port in raw mode, 8N1 at the baud rate
+func openSerial(path string, baudRate int) (*os.File, error) {
+	speed, ok := baudRates[baudRate]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate %v", baudRate)
+	}
+
+	port, err := os.OpenFile(path, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fd would switch the file to blocking mode, then Close does not interrupt Read
+	conn, err := port.SyscallConn()
+	if err != nil {
+		_ = port.Close()
+		return nil, err
+	}
+	var termiosErr error
+	err = conn.Control(func(fd uintptr) {
+		termiosErr = setRaw(int(fd), speed)
+	})
+	if err == nil {
+		err = termiosErr
+	}
+	if err != nil {
+		_ = port.Close()
+		return nil, fmt.Errorf("failed to configure %v: %w", path, err)
+	}
+	return port, nil
+}
+
+func setRaw(fd int, speed uint32) error {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+
+	// the same as cfmakeraw
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB | unix.CSTOPB | unix.CBAUD
+	termios.Cflag |= unix.CS8 | unix.CREAD | unix.CLOCAL | speed
+	termios.Ispeed = speed
+	termios.Ospeed = speed
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+
+	return unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"os"
+	"testing"
+	"time"
+)
+
+// openPty returns the master side of a pseudo-terminal pair and the path of its slave
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("no pseudo-terminals:", err)
+	}
+	t.Cleanup(func() { _ = master.Close() })
+
+	conn, err := master.SyscallConn()
+	require.NoError(t, err)
+	var number int
+	var ptyErr error
+	require.NoError(t, conn.Control(func(fd uintptr) {
+		if ptyErr = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0); ptyErr != nil {
+			return
+		}
+		number, ptyErr = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+	}))
+	require.NoError(t, ptyErr)
+	return master, fmt.Sprintf("/dev/pts/%d", number)
+}
+
+func TestIntegration_Serial(t *testing.T) {
+	remote, path := openPty(t)
+	serial := NewSerialTransport(path, DefaultSerialBaudRate)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- serial.Run(ctx)
+	}()
+
+	require.Eventually(t, func() bool {
+		serial.mx.Lock()
+		defer serial.mx.Unlock()
+		return serial.port != nil
+	}, time.Second, time.Millisecond)
+
+	// the remote logs to the same line
+	_, err := remote.Write(append([]byte("reportStatus... "), EncodeSerialFrame([]byte("status"))...))
+	require.NoError(t, err)
+	select {
+	case packet := <-serial.Receive():
+		require.Equal(t, []byte("status"), packet.Data)
+		require.Equal(t, Peer{Transport: SerialTransportName, Id: path}, packet.Peer)
+	case <-time.After(time.Second):
+		t.Fatal("no packet received")
+	}
+
+	peer := Peer{Transport: SerialTransportName, Id: path}
+	require.NoError(t, serial.Send(context.Background(), Packet{Peer: peer, Data: []byte("command")}))
+	decoder := &serialDecoder{}
+	buf := make([]byte, 64)
+	var frames [][]byte
+	for len(frames) == 0 {
+		require.NoError(t, remote.SetReadDeadline(time.Now().Add(time.Second)))
+		n, err := remote.Read(buf)
+		require.NoError(t, err)
+		frames = decoder.feed(buf[:n])
+	}
+	require.Equal(t, [][]byte{[]byte("command")}, frames)
+
+	require.Error(t, serial.Send(context.Background(), Packet{Peer: Peer{Transport: SerialTransportName, Id: "/dev/ttyUSB9"}}))
+
+	cancel()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return")
+	}
+	require.Error(t, serial.Send(context.Background(), Packet{Peer: peer, Data: []byte("command")}))
+}
+
+func TestOpenSerial_UnsupportedBaudRate(t *testing.T) {
+	_, path := openPty(t)
+	_, err := openSerial(path, 12345)
+	require.ErrorContains(t, err, "unsupported baud rate")
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package transport
+
+import (
+	"errors"
+	"os"
+)
+
+func openSerial(path string, baudRate int) (*os.File, error) {
+	return nil, errors.New("serial ports are only supported on Linux")
+}
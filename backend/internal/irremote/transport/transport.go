@@ -1,13 +1,33 @@
 package transport
 
-import "net"
+import "context"
 
-type UdpPacket struct {
-	Addr *net.UDPAddr
+// Peer is the other end of a transport: the address of a remote for UDP, the
+// device id for MQTT and WebSocket. Id only has a meaning to the transport
+// named by Transport.
+type Peer struct {
+	Transport string
+	Id        string
+}
+
+func (p Peer) String() string {
+	return p.Transport + ":" + p.Id
+}
+
+func (p Peer) IsZero() bool {
+	return p == Peer{}
+}
+
+type Packet struct {
+	Peer Peer
 	Data []byte
 }
 
 type Transport interface {
-	Send(packet UdpPacket) error
-	Receive() <-chan UdpPacket
+	// Name is the Transport of the peers of the packets the transport receives
+	Name() string
+	// Send returns an error if the packet can not be sent before ctx is done,
+	// it does not tell whether the packet arrived
+	Send(ctx context.Context, packet Packet) error
+	Receive() <-chan Packet
 }
@@ -2,81 +2,485 @@ package transport
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// MaxDatagramSize is the largest datagram the transport accepts, larger ones are dropped
+const MaxDatagramSize = 2048
+
+const UdpTransportName = "udp"
+
+// the read loop retries transient errors after a delay doubling up to the maximum
+const udpRetryMinDelay = 10 * time.Millisecond
+const udpRetryMaxDelay = time.Second
+
+// udpRestartInterval is how long a failed listener waits before listening again
+const udpRestartInterval = 2 * time.Second
+
+// ErrNotRunning is returned by Send while the transport is not listening
+var ErrNotRunning = errors.New("transport is not running")
+
+// UdpOptions size the buffers of the transport.
+type UdpOptions struct {
+	// ReceiveQueueSize is how many received packets wait for the session,
+	// further packets are dropped and counted
+	ReceiveQueueSize int
+	// ReadBufferSize and WriteBufferSize are the socket buffers in bytes, the
+	// system defaults if 0
+	ReadBufferSize  int
+	WriteBufferSize int
+}
+
+var DefaultUdpOptions = UdpOptions{ReceiveQueueSize: 10}
+
+// UdpStats counts the datagrams since the transport was created.
+type UdpStats struct {
+	Received int64
+	// Dropped are the datagrams the session was too slow to take
+	Dropped int64
+	// Truncated are the datagrams larger than MaxDatagramSize
+	Truncated int64
+	// ReadErrors are the transient read errors that were retried
+	ReadErrors int64
+}
+
+// udpMaxPeers bounds how many peers the transport remembers the listener of
+const udpMaxPeers = 1024
+
+// UdpTransport listens on one or more addresses, like an IPv4 and an IPv6 one
+// or several interfaces. Replies to a peer go out of the listener the peer
+// was last seen on.
 type UdpTransport struct {
-	conn      *net.UDPConn
-	receive   chan UdpPacket
-	readiness chan struct{}
+	options         UdpOptions
+	receive         chan Packet
+	restartInterval time.Duration
+
+	mx    sync.Mutex
+	conns []*net.UDPConn
+	// dualStack are the listeners that reach IPv4 and IPv6 peers
+	dualStack map[*net.UDPConn]bool
+	// peerConns is the listener each peer was last seen on, by peer id
+	peerConns map[string]*net.UDPConn
+
+	received   atomic.Int64
+	dropped    atomic.Int64
+	truncated  atomic.Int64
+	readErrors atomic.Int64
 }
 
 func NewUdpTransport() *UdpTransport {
+	return NewUdpTransportWithOptions(DefaultUdpOptions)
+}
+
+func NewUdpTransportWithOptions(options UdpOptions) *UdpTransport {
 	return &UdpTransport{
-		receive:   make(chan UdpPacket, 10),
-		readiness: make(chan struct{}),
+		options:         options,
+		receive:         make(chan Packet, options.ReceiveQueueSize),
+		restartInterval: udpRestartInterval,
+		peerConns:       make(map[string]*net.UDPConn),
+	}
+}
+
+// Run listens on the addresses until ctx is done. Addresses that can not be
+// listened on are logged and tried again every udpRestartInterval, the
+// others are served meanwhile.
+func (t *UdpTransport) Run(ctx context.Context, addrs ...*net.UDPAddr) error {
+	if len(addrs) == 0 {
+		return errors.New("no address to listen on")
+	}
+	log.Println("Listening on", addrs)
+	if err := t.start(make([]*net.UDPConn, len(addrs)), nil); err != nil {
+		return err
 	}
+	return t.serveAll(ctx, addrs, make([]*net.UDPConn, len(addrs)))
 }
 
-func (t *UdpTransport) ListenAndServe(ctx context.Context, addr *net.UDPAddr) error {
-	log.Println("Listening on", addr)
-	conn, err := net.ListenUDP("udp", addr)
+// ListenAndServe receives datagrams on the addresses until ctx is done. It
+// fails if one of the addresses can not be listened on. A listener failing
+// with an error that retrying does not fix is listened on again after
+// udpRestartInterval, the others keep running. It may be called again once
+// it returned.
+func (t *UdpTransport) ListenAndServe(ctx context.Context, addrs ...*net.UDPAddr) error {
+	if len(addrs) == 0 {
+		return errors.New("no address to listen on")
+	}
+	log.Println("Listening on", addrs)
+	conns, err := t.listen(addrs)
 	if err != nil {
 		return err
 	}
+	return t.serveAll(ctx, addrs, conns)
+}
 
-	log.Println("Successfully started listener ", conn.LocalAddr())
+// serveAll keeps a listener on each address until ctx is done, conns are
+// the ones listening already
+func (t *UdpTransport) serveAll(ctx context.Context, addrs []*net.UDPAddr, conns []*net.UDPConn) error {
+	var wg sync.WaitGroup
+	for i := range addrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			t.keepListening(ctx, i, addrs[i], conns[i])
+		}(i)
+	}
+	<-ctx.Done()
 
-	t.conn = conn
+	var closeErr error
+	t.mx.Lock()
+	for _, conn := range t.conns {
+		if conn == nil {
+			continue
+		}
+		if err := conn.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	t.mx.Unlock()
+	wg.Wait()
 
-	wg := sync.WaitGroup{}
-	wg.Add(1)
+	t.mx.Lock()
+	t.conns = nil
+	t.dualStack = nil
+	t.peerConns = make(map[string]*net.UDPConn)
+	t.mx.Unlock()
+	return closeErr
+}
 
-	go func() {
-		defer wg.Done()
-		// ready to send and receive
-		close(t.readiness)
+// keepListening serves the i-th listener, listening on addr again whenever
+// it fails or could not be listened on
+func (t *UdpTransport) keepListening(ctx context.Context, i int, addr *net.UDPAddr, conn *net.UDPConn) {
+	for {
+		if conn == nil {
+			var err error
+			conn, err = t.listenOn(addr)
+			if err != nil {
+				log.Println("Failed to listen on", addr, "retrying in", t.restartInterval, err)
+			} else if !t.add(ctx, i, addr, conn) {
+				return
+			}
+		}
+
+		if conn != nil {
+			log.Println("Successfully started listener", conn.LocalAddr())
+			err := t.serve(ctx, conn)
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("UDP listener on", conn.LocalAddr(), "failed, restarting in", t.restartInterval, err)
+			t.remove(i, conn)
+			conn = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(t.restartInterval):
+		}
+	}
+}
+
+// listen listens on all the addresses or none of them
+func (t *UdpTransport) listen(addrs []*net.UDPAddr) ([]*net.UDPConn, error) {
+	conns := make([]*net.UDPConn, 0, len(addrs))
+	dualStack := make(map[*net.UDPConn]bool, len(addrs))
+	closeAll := func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}
+	for _, addr := range addrs {
+		conn, err := t.listenOn(addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		conns = append(conns, conn)
+		dualStack[conn] = udpNetwork(addr) == "udp"
+	}
+
+	if err := t.start(conns, dualStack); err != nil {
+		closeAll()
+		return nil, err
+	}
+	return conns, nil
+}
+
+// start registers the listeners, nil for the ones not listening yet
+func (t *UdpTransport) start(conns []*net.UDPConn, dualStack map[*net.UDPConn]bool) error {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if t.conns != nil {
+		return errors.New("UDP transport is already running")
+	}
+	if dualStack == nil {
+		dualStack = make(map[*net.UDPConn]bool, len(conns))
+	}
+	t.conns = conns
+	t.dualStack = dualStack
+	return nil
+}
+
+func (t *UdpTransport) listenOn(addr *net.UDPAddr) (*net.UDPConn, error) {
+	conn, err := net.ListenUDP(udpNetwork(addr), addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.options.ReadBufferSize > 0 {
+		err = conn.SetReadBuffer(t.options.ReadBufferSize)
+	}
+	if err == nil && t.options.WriteBufferSize > 0 {
+		err = conn.SetWriteBuffer(t.options.WriteBufferSize)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// add makes conn the i-th listener, unless ctx is done and the listeners
+// are being closed
+func (t *UdpTransport) add(ctx context.Context, i int, addr *net.UDPAddr, conn *net.UDPConn) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if ctx.Err() != nil {
+		_ = conn.Close()
+		return false
+	}
+	t.conns[i] = conn
+	t.dualStack[conn] = udpNetwork(addr) == "udp"
+	return true
+}
 
-		for {
-			buf := make([]byte, 1024)
-			n, addr, err := t.conn.ReadFromUDP(buf)
+// remove closes the failed i-th listener, its peers are answered from another one
+func (t *UdpTransport) remove(i int, conn *net.UDPConn) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	_ = conn.Close()
+	t.conns[i] = nil
+	delete(t.dualStack, conn)
+	for id, c := range t.peerConns {
+		if c == conn {
+			delete(t.peerConns, id)
+		}
+	}
+}
+
+// udpNetwork restricts a listener to the family of its address, so an IPv4
+// and an IPv6 wildcard listener can share a port. Without an address the
+// listener is dual-stack.
+func udpNetwork(addr *net.UDPAddr) string {
+	switch {
+	case addr.IP == nil:
+		return "udp"
+	case addr.IP.To4() != nil:
+		return "udp4"
+	default:
+		return "udp6"
+	}
+}
+
+// ParseUdpAddrs parses a comma separated list of listen addresses: IPs,
+// listening on defaultPort, or IPs with a port like [::1]:4944.
+func ParseUdpAddrs(list string, defaultPort int) ([]*net.UDPAddr, error) {
+	var addrs []*net.UDPAddr
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		host, port := entry, defaultPort
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			parsed, err := strconv.Atoi(p)
 			if err != nil {
-				if ctx.Err() != nil {
-					// gracefully shutdown
-					break
-				} else {
-					// is this the right way to handle this?
-					// let it crash for now
-					log.Fatal("Error reading from UDP:", err)
-				}
+				return nil, fmt.Errorf("invalid port in listen address %q", entry)
 			}
+			host, port = h, parsed
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid listen address %q", entry)
+		}
+		addrs = append(addrs, &net.UDPAddr{IP: ip, Port: port})
+	}
 
-			log.Println("Received", n, "bytes from", addr)
+	if len(addrs) == 0 {
+		return nil, errors.New("no listen address given")
+	}
+	return addrs, nil
+}
+
+// serve reads the datagrams of a listener, it returns once conn is closed or fails
+func (t *UdpTransport) serve(ctx context.Context, conn *net.UDPConn) error {
+	delay := udpRetryMinDelay
+	for {
+		// one extra byte to tell a datagram of exactly MaxDatagramSize from a truncated one
+		buf := make([]byte, MaxDatagramSize+1)
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil || !isTransientReadError(err) {
+				return err
+			}
 
-			t.receive <- UdpPacket{
-				Addr: addr,
-				Data: buf[:n],
+			t.readErrors.Add(1)
+			log.Println("Error reading from UDP, retrying in", delay, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
 			}
+			delay *= 2
+			if delay > udpRetryMaxDelay {
+				delay = udpRetryMaxDelay
+			}
+			continue
 		}
-	}()
+		delay = udpRetryMinDelay
 
-	<-ctx.Done()
-	err = t.conn.Close()
-	if err != nil {
-		return err
+		if n > MaxDatagramSize {
+			t.truncated.Add(1)
+			log.Println("Dropping truncated datagram from", addr)
+			continue
+		}
+
+		log.Println("Received", n, "bytes from", addr, "on", conn.LocalAddr())
+		t.received.Add(1)
+		peer := UdpPeer(addr)
+		t.seen(peer, conn)
+
+		select {
+		case t.receive <- Packet{Peer: peer, Data: buf[:n]}:
+		default:
+			dropped := t.dropped.Add(1)
+			log.Println("Receive queue is full, dropping datagram from", addr, "dropped", dropped, "so far")
+		}
+	}
+}
+
+func (t *UdpTransport) seen(peer Peer, conn *net.UDPConn) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if _, known := t.peerConns[peer.Id]; !known && len(t.peerConns) >= udpMaxPeers {
+		// forget any peer, its replies go out of a listener of the same family
+		for id := range t.peerConns {
+			delete(t.peerConns, id)
+			break
+		}
+	}
+	t.peerConns[peer.Id] = conn
+}
+
+// connFor returns the listener to send to addr from: the one the peer was
+// last seen on, or the first one of the family of addr
+func (t *UdpTransport) connFor(peer Peer, addr *net.UDPAddr) *net.UDPConn {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if conn, ok := t.peerConns[peer.Id]; ok {
+		return conn
+	}
+
+	for _, conn := range t.conns {
+		if conn == nil {
+			continue
+		}
+		local := conn.LocalAddr().(*net.UDPAddr)
+		if t.dualStack[conn] || (local.IP.To4() != nil) == (addr.IP.To4() != nil) {
+			return conn
+		}
 	}
-	wg.Wait()
 	return nil
 }
 
-func (t *UdpTransport) Send(packet UdpPacket) error {
-	<-t.readiness
-	log.Println("Sending", len(packet.Data), "bytes to", packet.Addr)
+// isTransientReadError tells whether reading may succeed again, like after
+// an ICMP port unreachable for an earlier datagram or a lack of memory
+func isTransientReadError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ENOBUFS) ||
+		errors.Is(err, syscall.ENOMEM)
+}
+
+// UdpPeer is the peer of a remote at addr.
+func UdpPeer(addr *net.UDPAddr) Peer {
+	return Peer{Transport: UdpTransportName, Id: addr.String()}
+}
+
+// LocalAddrs are the addresses the transport listens on, empty if it is not
+// running, without the listeners being restarted.
+func (t *UdpTransport) LocalAddrs() []*net.UDPAddr {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	addrs := make([]*net.UDPAddr, 0, len(t.conns))
+	for _, conn := range t.conns {
+		if conn == nil {
+			continue
+		}
+		addrs = append(addrs, conn.LocalAddr().(*net.UDPAddr))
+	}
+	return addrs
+}
+
+// LocalAddr is the first address the transport listens on, nil if it is not running.
+func (t *UdpTransport) LocalAddr() *net.UDPAddr {
+	addrs := t.LocalAddrs()
+	if len(addrs) == 0 {
+		return nil
+	}
+	return addrs[0]
+}
+
+func (t *UdpTransport) Stats() UdpStats {
+	return UdpStats{
+		Received:   t.received.Load(),
+		Dropped:    t.dropped.Load(),
+		Truncated:  t.truncated.Load(),
+		ReadErrors: t.readErrors.Load(),
+	}
+}
+
+func (t *UdpTransport) Name() string {
+	return UdpTransportName
+}
+
+func (t *UdpTransport) Send(ctx context.Context, packet Packet) error {
+	if packet.Peer.Transport != UdpTransportName {
+		return fmt.Errorf("can not send to %v over UDP", packet.Peer)
+	}
+	addr, err := net.ResolveUDPAddr("udp", packet.Peer.Id)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	t.mx.Lock()
+	running := t.conns != nil
+	t.mx.Unlock()
+	if !running {
+		return ErrNotRunning
+	}
+	conn := t.connFor(packet.Peer, addr)
+	if conn == nil {
+		return fmt.Errorf("no listener can reach %v", addr)
+	}
 
-	n, err := t.conn.WriteToUDP(packet.Data, packet.Addr)
+	log.Println("Sending", len(packet.Data), "bytes to", addr, "from", conn.LocalAddr())
+	n, err := conn.WriteToUDP(packet.Data, addr)
 	if err != nil {
 		return err
 	}
@@ -86,6 +490,6 @@ func (t *UdpTransport) Send(packet UdpPacket) error {
 	return nil
 }
 
-func (t *UdpTransport) Receive() <-chan UdpPacket {
+func (t *UdpTransport) Receive() <-chan Packet {
 	return t.receive
 }
@@ -2,11 +2,34 @@ package transport
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"net"
+	"os"
+	"syscall"
 	"testing"
+	"time"
 )
 
+// startUdp runs the transport on a free port until the test ends
+func startUdp(t *testing.T, udp *UdpTransport) *net.UDPAddr {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	go func() {
+		defer close(done)
+		_ = udp.ListenAndServe(ctx, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	}()
+	require.Eventually(t, func() bool { return udp.LocalAddr() != nil }, time.Second, time.Millisecond)
+	return udp.LocalAddr()
+}
+
 func TestIntegration_Udp(t *testing.T) {
 	udp := NewUdpTransport()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -22,3 +45,318 @@ func TestIntegration_Udp(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestIntegration_UdpDropsTruncatedDatagrams(t *testing.T) {
+	udp := NewUdpTransport()
+	addr := startUdp(t, udp)
+
+	client, err := net.DialUDP("udp", nil, addr)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write(make([]byte, MaxDatagramSize+1))
+	assert.NoError(t, err)
+	_, err = client.Write([]byte("small"))
+	assert.NoError(t, err)
+
+	select {
+	case packet := <-udp.Receive():
+		assert.Equal(t, []byte("small"), packet.Data)
+		assert.Equal(t, UdpPeer(client.LocalAddr().(*net.UDPAddr)), packet.Peer)
+	case <-time.After(time.Second):
+		t.Fatal("no packet received")
+	}
+	assert.Equal(t, UdpStats{Received: 1, Truncated: 1}, udp.Stats())
+}
+
+func TestIntegration_UdpSend(t *testing.T) {
+	udp := NewUdpTransport()
+	startUdp(t, udp)
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	peer := UdpPeer(client.LocalAddr().(*net.UDPAddr))
+	assert.NoError(t, udp.Send(context.Background(), Packet{Peer: peer, Data: []byte("command")}))
+	buf := make([]byte, 16)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("command"), buf[:n])
+
+	assert.Error(t, udp.Send(context.Background(), Packet{Peer: Peer{Transport: MqttTransportName, Id: "default"}}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, udp.Send(ctx, Packet{Peer: peer, Data: []byte("command")}), context.Canceled)
+}
+
+func TestIntegration_UdpSendWhenNotRunning(t *testing.T) {
+	udp := NewUdpTransport()
+	peer := UdpPeer(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4944})
+
+	// does not block until the transport is started
+	require.ErrorIs(t, udp.Send(context.Background(), Packet{Peer: peer, Data: []byte("command")}), ErrNotRunning)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- udp.ListenAndServe(ctx, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	}()
+	require.Eventually(t, func() bool { return udp.LocalAddr() != nil }, time.Second, time.Millisecond)
+	require.NoError(t, udp.Send(context.Background(), Packet{Peer: peer, Data: []byte("command")}))
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Nil(t, udp.LocalAddr())
+	require.ErrorIs(t, udp.Send(context.Background(), Packet{Peer: peer, Data: []byte("command")}), ErrNotRunning)
+}
+
+func TestIntegration_UdpCountsDrops(t *testing.T) {
+	udp := NewUdpTransportWithOptions(UdpOptions{ReceiveQueueSize: 1, ReadBufferSize: 64 * 1024})
+	addr := startUdp(t, udp)
+
+	client, err := net.DialUDP("udp", nil, addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	// the session does not take the packets
+	for i := 0; i < 3; i++ {
+		_, err = client.Write([]byte(fmt.Sprint("status ", i)))
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return udp.Stats().Received == 3 }, time.Second, time.Millisecond)
+	require.Equal(t, UdpStats{Received: 3, Dropped: 2}, udp.Stats())
+	require.Equal(t, []byte("status 0"), (<-udp.Receive()).Data)
+}
+
+func TestIntegration_UdpRestart(t *testing.T) {
+	udp := NewUdpTransport()
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- udp.ListenAndServe(ctx, addr)
+		}()
+		require.Eventually(t, func() bool { return udp.LocalAddr() != nil }, time.Second, time.Millisecond)
+
+		// only one listener at a time
+		require.ErrorContains(t, udp.ListenAndServe(ctx, addr), "already running")
+
+		client, err := net.DialUDP("udp", nil, udp.LocalAddr())
+		require.NoError(t, err)
+		_, err = client.Write([]byte("status"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("status"), (<-udp.Receive()).Data)
+		_ = client.Close()
+
+		cancel()
+		require.NoError(t, <-done)
+	}
+}
+
+func TestIntegration_UdpRunFailsToListen(t *testing.T) {
+	taken, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer taken.Close()
+
+	// Run keeps trying until ctx is done, ListenAndServe returns the error
+	udp := NewUdpTransport()
+	require.Error(t, udp.ListenAndServe(context.Background(), taken.LocalAddr().(*net.UDPAddr)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.NoError(t, udp.Run(ctx, taken.LocalAddr().(*net.UDPAddr)))
+}
+
+func TestIsTransientReadError(t *testing.T) {
+	require.True(t, isTransientReadError(&net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)}))
+	require.True(t, isTransientReadError(os.ErrDeadlineExceeded))
+	require.False(t, isTransientReadError(net.ErrClosed))
+	require.False(t, isTransientReadError(errors.New("bad file descriptor")))
+}
+
+// freePort returns a port free on both loopback addresses
+func freePort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skip("IPv6 loopback is not available:", err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	require.NoError(t, conn.Close())
+	return port
+}
+
+func TestIntegration_UdpDualStack(t *testing.T) {
+	port := freePort(t)
+	udp := NewUdpTransport()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- udp.ListenAndServe(ctx,
+			&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port},
+			&net.UDPAddr{IP: net.IPv6loopback, Port: port})
+	}()
+	require.Eventually(t, func() bool { return len(udp.LocalAddrs()) == 2 }, time.Second, time.Millisecond)
+
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback} {
+		// a connected client only accepts replies from the address it sent to
+		client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: port})
+		require.NoError(t, err)
+		_, err = client.Write([]byte("status"))
+		require.NoError(t, err)
+
+		packet := <-udp.Receive()
+		require.Equal(t, UdpPeer(client.LocalAddr().(*net.UDPAddr)), packet.Peer)
+		require.NoError(t, udp.Send(context.Background(), Packet{Peer: packet.Peer, Data: []byte("command")}))
+
+		buf := make([]byte, 16)
+		_ = client.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := client.Read(buf)
+		require.NoError(t, err, ip)
+		require.Equal(t, []byte("command"), buf[:n])
+		_ = client.Close()
+	}
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Empty(t, udp.LocalAddrs())
+}
+
+func TestIntegration_UdpSendsFromListenerOfFamily(t *testing.T) {
+	freePort(t)
+	udp := NewUdpTransport()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = udp.ListenAndServe(ctx, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &net.UDPAddr{IP: net.IPv6loopback})
+	}()
+	require.Eventually(t, func() bool { return len(udp.LocalAddrs()) == 2 }, time.Second, time.Millisecond)
+
+	// the peer has not been seen yet
+	client, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	require.NoError(t, err)
+	defer client.Close()
+	require.NoError(t, udp.Send(context.Background(), Packet{Peer: UdpPeer(client.LocalAddr().(*net.UDPAddr)), Data: []byte("command")}))
+
+	buf := make([]byte, 16)
+	_ = client.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := client.ReadFromUDP(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte("command"), buf[:n])
+	require.Equal(t, udp.LocalAddrs()[1].String(), from.String())
+}
+
+func TestIntegration_UdpListenFailureClosesAll(t *testing.T) {
+	taken, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer taken.Close()
+
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	free := probe.LocalAddr().(*net.UDPAddr)
+	require.NoError(t, probe.Close())
+
+	udp := NewUdpTransport()
+	require.Error(t, udp.ListenAndServe(context.Background(), free, taken.LocalAddr().(*net.UDPAddr)))
+	require.Nil(t, udp.LocalAddr())
+
+	// the first listener was closed again
+	conn, err := net.ListenUDP("udp4", free)
+	require.NoError(t, err)
+	_ = conn.Close()
+}
+
+// sendUdp sends the data to addr and returns what the transport received
+func sendUdp(t *testing.T, udp *UdpTransport, addr *net.UDPAddr, data string) Packet {
+	client, err := net.DialUDP("udp", nil, addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte(data))
+	require.NoError(t, err)
+
+	select {
+	case packet := <-udp.Receive():
+		require.Equal(t, []byte(data), packet.Data)
+		return packet
+	case <-time.After(time.Second):
+		t.Fatal("no datagram received on", addr)
+		return Packet{}
+	}
+}
+
+func TestIntegration_UdpRestartsFailedListener(t *testing.T) {
+	udp := NewUdpTransport()
+	udp.restartInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- udp.ListenAndServe(ctx, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	}()
+	require.Eventually(t, func() bool { return len(udp.LocalAddrs()) == 2 }, time.Second, time.Millisecond)
+	healthy := udp.LocalAddrs()[1]
+
+	// the first listener fails, the second one keeps serving
+	udp.mx.Lock()
+	failed := udp.conns[0]
+	udp.mx.Unlock()
+	require.NoError(t, failed.Close())
+	sendUdp(t, udp, healthy, "status")
+
+	require.Eventually(t, func() bool {
+		udp.mx.Lock()
+		defer udp.mx.Unlock()
+		return udp.conns[0] != nil && udp.conns[0] != failed
+	}, time.Second, time.Millisecond)
+	require.Equal(t, healthy, udp.LocalAddrs()[1])
+	sendUdp(t, udp, udp.LocalAddrs()[0], "status")
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Empty(t, udp.LocalAddrs())
+}
+
+func TestIntegration_UdpRunSkipsAddressInUse(t *testing.T) {
+	taken, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer taken.Close()
+
+	udp := NewUdpTransport()
+	udp.restartInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- udp.Run(ctx, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, taken.LocalAddr().(*net.UDPAddr))
+	}()
+	require.Eventually(t, func() bool { return len(udp.LocalAddrs()) == 1 }, time.Second, time.Millisecond)
+	sendUdp(t, udp, udp.LocalAddr(), "status")
+
+	// listened on once it is free
+	require.NoError(t, taken.Close())
+	require.Eventually(t, func() bool { return len(udp.LocalAddrs()) == 2 }, time.Second, time.Millisecond)
+	sendUdp(t, udp, taken.LocalAddr().(*net.UDPAddr), "status")
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestParseUdpAddrs(t *testing.T) {
+	addrs, err := ParseUdpAddrs("0.0.0.0, ::, [::1]:4945,127.0.0.1:4946", 4944)
+	require.NoError(t, err)
+	require.Equal(t, []string{"0.0.0.0:4944", "[::]:4944", "[::1]:4945", "127.0.0.1:4946"}, []string{
+		addrs[0].String(), addrs[1].String(), addrs[2].String(), addrs[3].String(),
+	})
+	require.Equal(t, "udp4", udpNetwork(addrs[0]))
+	require.Equal(t, "udp6", udpNetwork(addrs[1]))
+	require.Equal(t, "udp", udpNetwork(&net.UDPAddr{Port: 4944}))
+
+	_, err = ParseUdpAddrs("localhost", 4944)
+	require.Error(t, err)
+	_, err = ParseUdpAddrs("[::1]:port", 4944)
+	require.Error(t, err)
+	_, err = ParseUdpAddrs(" , ", 4944)
+	require.Error(t, err)
+}
@@ -0,0 +1,278 @@
+package transport
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebsocketPath is where the remotes connect, followed by their device id
+const WebsocketPath = "/ws/"
+
+const websocketPingInterval = 15 * time.Second
+const websocketWriteTimeout = 5 * time.Second
+
+// WebsocketTransportName is the transport of the peers, their id is the device id
+const WebsocketTransportName = "websocket"
+
+// The remotes authenticate the upgrade with their unix time in
+// WebsocketTimeHeader and the hex HMAC-SHA256 of "<device id>:<time>", keyed
+// by the shared secret, in WebsocketAuthHeader.
+const WebsocketTimeHeader = "X-Device-Time"
+const WebsocketAuthHeader = "X-Device-Auth"
+
+// WebsocketMaxClockSkew is how far the time of a remote may be off
+const WebsocketMaxClockSkew = 5 * time.Minute
+
+// WebsocketTransport serves remotes that connect over WebSocket, usually
+// with TLS, from networks that block UDP. Every binary message is one
+// datagram. A new connection of a device replaces the old one. Pings detect
+// dead connections, which TCP alone may not notice for a long time.
+type WebsocketTransport struct {
+	secret       string
+	now          func() time.Time
+	upgrader     websocket.Upgrader
+	receive      chan Packet
+	pingInterval time.Duration
+
+	mx    sync.Mutex
+	conns map[string]*websocketConn
+}
+
+type websocketConn struct {
+	conn    *websocket.Conn
+	writeMx sync.Mutex
+}
+
+// NewWebsocketTransport accepts the remotes that authenticate with the shared secret.
+func NewWebsocketTransport(sharedSecret string) *WebsocketTransport {
+	return &WebsocketTransport{
+		secret: sharedSecret,
+		now:    time.Now,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  MaxDatagramSize,
+			WriteBufferSize: MaxDatagramSize,
+		},
+		receive:      make(chan Packet, 10),
+		pingInterval: websocketPingInterval,
+		conns:        make(map[string]*websocketConn),
+	}
+}
+
+// ListenAndServe accepts the remotes on addr until ctx is done. It serves TLS
+// if certFile is set, plain HTTP for running behind a TLS terminating proxy otherwise.
+func (t *WebsocketTransport) ListenAndServe(ctx context.Context, addr string, certFile string, keyFile string) error {
+	mux := http.NewServeMux()
+	mux.Handle(WebsocketPath, t)
+	server := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+		t.closeAll()
+	}()
+
+	log.Println("WebSocket transport listening on", addr)
+	var err error
+	if certFile != "" {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// ServeHTTP upgrades a request to /ws/{device} and reads the messages of the device.
+func (t *WebsocketTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	deviceId := strings.TrimPrefix(r.URL.Path, WebsocketPath)
+	if deviceId == "" || strings.Contains(deviceId, "/") {
+		http.Error(w, "device id is required", http.StatusBadRequest)
+		return
+	}
+	if err := t.authenticate(deviceId, r.Header); err != nil {
+		log.Println("Rejected WebSocket connection of", deviceId, "from", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Failed to upgrade WebSocket connection of", deviceId, err)
+		return
+	}
+
+	c := &websocketConn{conn: conn}
+	t.connect(deviceId, c)
+	log.Println("Device", deviceId, "connected over WebSocket from", r.RemoteAddr)
+
+	stopPing := make(chan struct{})
+	go t.ping(c, stopPing)
+	t.read(c, Peer{Transport: WebsocketTransportName, Id: deviceId})
+	close(stopPing)
+
+	t.disconnect(deviceId, c)
+	log.Println("Device", deviceId, "disconnected")
+}
+
+// WebsocketAuth returns the headers authenticating the device at the time.
+func WebsocketAuth(sharedSecret string, deviceId string, at time.Time) http.Header {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	header := http.Header{}
+	header.Set(WebsocketTimeHeader, timestamp)
+	header.Set(WebsocketAuthHeader, hex.EncodeToString(websocketMac(sharedSecret, deviceId, timestamp)))
+	return header
+}
+
+func websocketMac(sharedSecret string, deviceId string, timestamp string) []byte {
+	mac := hmac.New(sha256.New, []byte(sharedSecret))
+	mac.Write([]byte(deviceId + ":" + timestamp))
+	return mac.Sum(nil)
+}
+
+func (t *WebsocketTransport) authenticate(deviceId string, header http.Header) error {
+	timestamp := header.Get(WebsocketTimeHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid time %q", timestamp)
+	}
+	skew := t.now().Sub(time.Unix(seconds, 0))
+	if skew > WebsocketMaxClockSkew || skew < -WebsocketMaxClockSkew {
+		return fmt.Errorf("time is off by %v", skew)
+	}
+
+	mac, err := hex.DecodeString(header.Get(WebsocketAuthHeader))
+	if err != nil || !hmac.Equal(mac, websocketMac(t.secret, deviceId, timestamp)) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// connect registers the connection of the device, closing the previous one
+func (t *WebsocketTransport) connect(deviceId string, c *websocketConn) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if previous, ok := t.conns[deviceId]; ok {
+		_ = previous.conn.Close()
+	}
+	t.conns[deviceId] = c
+}
+
+func (t *WebsocketTransport) disconnect(deviceId string, c *websocketConn) {
+	_ = c.conn.Close()
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	// a reconnect may have replaced the connection already
+	if t.conns[deviceId] == c {
+		delete(t.conns, deviceId)
+	}
+}
+
+func (t *WebsocketTransport) closeAll() {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for _, c := range t.conns {
+		_ = c.conn.Close()
+	}
+}
+
+func (t *WebsocketTransport) read(c *websocketConn, peer Peer) {
+	readTimeout := 3 * t.pingInterval
+	c.conn.SetReadLimit(MaxDatagramSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Println("Error reading from WebSocket of", peer, err)
+			}
+			return
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if messageType != websocket.BinaryMessage {
+			log.Println("Dropping non-binary WebSocket message from", peer)
+			continue
+		}
+
+		log.Println("Received", len(data), "bytes from", peer)
+		t.receive <- Packet{
+			Peer: peer,
+			Data: data,
+		}
+	}
+}
+
+func (t *WebsocketTransport) ping(c *websocketConn, stop <-chan struct{}) {
+	ticker := time.NewTicker(t.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.writeMx.Lock()
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketWriteTimeout))
+			c.writeMx.Unlock()
+			if err != nil {
+				_ = c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (t *WebsocketTransport) Name() string {
+	return WebsocketTransportName
+}
+
+func (t *WebsocketTransport) Send(ctx context.Context, packet Packet) error {
+	if packet.Peer.Transport != WebsocketTransportName {
+		return fmt.Errorf("can not send to %v over WebSocket", packet.Peer)
+	}
+
+	t.mx.Lock()
+	c, connected := t.conns[packet.Peer.Id]
+	t.mx.Unlock()
+	if !connected {
+		return fmt.Errorf("device %v is not connected", packet.Peer.Id)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	log.Println("Sending", len(packet.Data), "bytes to", packet.Peer)
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	deadline := time.Now().Add(websocketWriteTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	_ = c.conn.SetWriteDeadline(deadline)
+	return c.conn.WriteMessage(websocket.BinaryMessage, packet.Data)
+}
+
+func (t *WebsocketTransport) Receive() <-chan Packet {
+	return t.receive
+}
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const websocketTestSecret = "secret"
+
+func startWebsocketTransport(t *testing.T) (*WebsocketTransport, func(deviceId string) *websocket.Conn) {
+	transport, url := startWebsocketServer(t)
+	dial := func(deviceId string) *websocket.Conn {
+		conn, _, err := websocketDialer.Dial(url+deviceId, WebsocketAuth(websocketTestSecret, deviceId, time.Now()))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = conn.Close() })
+		return conn
+	}
+	return transport, dial
+}
+
+var websocketDialer = websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+
+// startWebsocketServer returns the url the device id is appended to
+func startWebsocketServer(t *testing.T) (*WebsocketTransport, string) {
+	transport := NewWebsocketTransport(websocketTestSecret)
+	transport.pingInterval = 20 * time.Millisecond
+	server := httptest.NewTLSServer(transport)
+	t.Cleanup(server.Close)
+	return transport, "wss" + strings.TrimPrefix(server.URL, "https") + WebsocketPath
+}
+
+func (t *WebsocketTransport) connected(deviceId string) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	_, ok := t.conns[deviceId]
+	return ok
+}
+
+func TestIntegration_Websocket(t *testing.T) {
+	transport, dial := startWebsocketTransport(t)
+	device := dial("kitchen")
+
+	require.NoError(t, device.WriteMessage(websocket.BinaryMessage, []byte("status")))
+	packet := <-transport.Receive()
+	require.Equal(t, []byte("status"), packet.Data)
+	require.Equal(t, Peer{Transport: WebsocketTransportName, Id: "kitchen"}, packet.Peer)
+
+	require.NoError(t, transport.Send(context.Background(), Packet{Peer: packet.Peer, Data: []byte("command")}))
+	messageType, data, err := device.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, websocket.BinaryMessage, messageType)
+	require.Equal(t, []byte("command"), data)
+
+	other := dial("bedroom")
+	require.NoError(t, other.WriteMessage(websocket.BinaryMessage, []byte("status")))
+	packet = <-transport.Receive()
+	require.Equal(t, "websocket:bedroom", packet.Peer.String())
+}
+
+func TestIntegration_WebsocketReconnect(t *testing.T) {
+	transport, dial := startWebsocketTransport(t)
+	first := dial("kitchen")
+	require.NoError(t, first.WriteMessage(websocket.BinaryMessage, []byte("status")))
+	peer := (<-transport.Receive()).Peer
+
+	// the new connection replaces the old one
+	second := dial("kitchen")
+	require.NoError(t, second.WriteMessage(websocket.BinaryMessage, []byte("status")))
+	require.Equal(t, peer, (<-transport.Receive()).Peer)
+
+	_, _, err := first.ReadMessage()
+	require.Error(t, err)
+
+	require.NoError(t, transport.Send(context.Background(), Packet{Peer: peer, Data: []byte("command")}))
+	_, data, err := second.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, []byte("command"), data)
+}
+
+func TestIntegration_WebsocketDeadConnection(t *testing.T) {
+	transport, dial := startWebsocketTransport(t)
+
+	// a device that does not read does not answer the pings either
+	device := dial("kitchen")
+	require.NoError(t, device.WriteMessage(websocket.BinaryMessage, []byte("status")))
+	peer := (<-transport.Receive()).Peer
+	require.True(t, transport.connected("kitchen"))
+
+	require.Eventually(t, func() bool {
+		return !transport.connected("kitchen")
+	}, 2*time.Second, 10*time.Millisecond)
+	require.ErrorContains(t, transport.Send(context.Background(), Packet{Peer: peer, Data: []byte("command")}), "not connected")
+}
+
+func TestIntegration_WebsocketAuth(t *testing.T) {
+	transport, url := startWebsocketServer(t)
+
+	for name, header := range map[string]http.Header{
+		"no headers":   nil,
+		"wrong secret": WebsocketAuth("other", "kitchen", time.Now()),
+		"other device": WebsocketAuth(websocketTestSecret, "bedroom", time.Now()),
+		"stale":        WebsocketAuth(websocketTestSecret, "kitchen", time.Now().Add(-WebsocketMaxClockSkew-time.Minute)),
+		"future":       WebsocketAuth(websocketTestSecret, "kitchen", time.Now().Add(WebsocketMaxClockSkew+time.Minute)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, response, err := websocketDialer.Dial(url+"kitchen", header)
+			require.Error(t, err)
+			require.Equal(t, http.StatusUnauthorized, response.StatusCode)
+			require.False(t, transport.connected("kitchen"))
+		})
+	}
+
+	// a remote with its clock a bit off
+	conn, _, err := websocketDialer.Dial(url+"kitchen", WebsocketAuth(websocketTestSecret, "kitchen", time.Now().Add(-time.Minute)))
+	require.NoError(t, err)
+	_ = conn.Close()
+}
@@ -0,0 +1,260 @@
+package lirc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultDaemonSocket is where lircd listens for clients by default
+const DefaultDaemonSocket = "/var/run/lirc/lircd"
+
+// codeTolerance is how much a timing may differ from the one of a code, in
+// percent, matching lircd's default eps
+const codeTolerance = 30
+
+// codeMinTolerance is the least difference allowed, in microseconds, matching lircd's default aeps
+const codeMinTolerance = 100
+
+// Daemon sends signals through a running lircd. lircd only sends the codes
+// of its configuration, so a signal is sent as the code of the remote with
+// the same timings; the carrier is the one of the remote.
+type Daemon struct {
+	socket string
+	remote string
+	codes  map[string][]int
+}
+
+// NewDaemon sends the codes of the remote through lircd at socket. The codes
+// are the raw codes of the remote in the configuration of lircd, by name.
+func NewDaemon(socket string, remote string, codes map[string][]int) *Daemon {
+	return &Daemon{socket: socket, remote: remote, codes: codes}
+}
+
+func (d *Daemon) Send(ctx context.Context, _ commands.Carrier, timings []int) error {
+	name, ok := d.findCode(timings)
+	if !ok {
+		return fmt.Errorf("remote %v in lircd has no code with the timings of the command", d.remote)
+	}
+	return d.SendOnce(ctx, name)
+}
+
+// SendOnce sends the named code of the remote.
+func (d *Daemon) SendOnce(ctx context.Context, code string) error {
+	_, err := d.command(ctx, "SEND_ONCE "+d.remote+" "+code)
+	return err
+}
+
+// findCode returns the name of the code closest to the timings, ignoring
+// the trailing space. Of codes as close, the first by name is returned.
+func (d *Daemon) findCode(timings []int) (string, bool) {
+	timings = withoutTrailingSpace(timings)
+	if len(timings) == 0 {
+		return "", false
+	}
+
+	names := make([]string, 0, len(d.codes))
+	for name := range d.codes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", -1
+	for _, name := range names {
+		code := withoutTrailingSpace(d.codes[name])
+		if len(code) != len(timings) {
+			continue
+		}
+		distance := 0
+		for i := range code {
+			tolerance := code[i] * codeTolerance / 100
+			if tolerance < codeMinTolerance {
+				tolerance = codeMinTolerance
+			}
+			difference := timings[i] - code[i]
+			if difference < 0 {
+				difference = -difference
+			}
+			if difference > tolerance {
+				distance = -1
+				break
+			}
+			distance += difference
+		}
+		if distance >= 0 && (bestDistance < 0 || distance < bestDistance) {
+			best, bestDistance = name, distance
+		}
+	}
+	return best, bestDistance >= 0
+}
+
+func withoutTrailingSpace(timings []int) []int {
+	if len(timings) > 0 && len(timings)%2 == 0 {
+		return timings[:len(timings)-1]
+	}
+	return timings
+}
+
+// command sends the command to lircd and returns the data of its reply:
+//
+//	BEGIN
+//	<command>
+//	SUCCESS or ERROR
+//	DATA
+//	<number of lines>
+//	<lines>
+//	END
+//
+// DATA is optional; lircd may broadcast a SIGHUP packet in between.
+func (d *Daemon) command(ctx context.Context, command string) ([]string, error) {
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", d.socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return nil, err
+	}
+
+	lines := bufio.NewScanner(conn)
+	next := func() (string, error) {
+		if !lines.Scan() {
+			if lines.Err() != nil {
+				return "", lines.Err()
+			}
+			return "", errors.New("lircd closed the connection")
+		}
+		return strings.TrimSpace(lines.Text()), nil
+	}
+
+	for {
+		line, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if line != "BEGIN" {
+			continue
+		}
+		echo, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if echo != command {
+			// a broadcast, like SIGHUP after a configuration reload
+			continue
+		}
+		return readReply(next)
+	}
+}
+
+func readReply(next func() (string, error)) ([]string, error) {
+	result, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if result != "SUCCESS" && result != "ERROR" {
+		return nil, fmt.Errorf("unexpected lircd reply %q", result)
+	}
+
+	var data []string
+	line, err := next()
+	if err != nil {
+		return nil, err
+	}
+	if line == "DATA" {
+		countLine, err := next()
+		if err != nil {
+			return nil, err
+		}
+		count, err := strconv.Atoi(countLine)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lircd data length %q", countLine)
+		}
+		for i := 0; i < count; i++ {
+			line, err := next()
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, line)
+		}
+		if line, err = next(); err != nil {
+			return nil, err
+		}
+	}
+	if line != "END" {
+		return nil, fmt.Errorf("unexpected lircd reply %q", line)
+	}
+
+	if result == "ERROR" {
+		return nil, fmt.Errorf("lircd: %v", strings.Join(data, " "))
+	}
+	return data, nil
+}
+
+// ParseRawCodes reads the raw codes of the remote from a lircd configuration
+// file, like the one irctl convert -to lirc writes.
+func ParseRawCodes(data []byte, remote string) (map[string][]int, error) {
+	codes := map[string][]int{}
+	inRemote := false
+	isRemote := false
+	inRawCodes := false
+	code := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch {
+		case len(fields) >= 2 && fields[0] == "begin" && fields[1] == "remote":
+			inRemote = true
+			isRemote = false
+		case len(fields) >= 2 && fields[0] == "end" && fields[1] == "remote":
+			inRemote = false
+		case inRemote && !inRawCodes && len(fields) >= 2 && fields[0] == "name":
+			isRemote = fields[1] == remote
+		case len(fields) >= 2 && fields[0] == "begin" && fields[1] == "raw_codes":
+			inRawCodes = true
+			code = ""
+		case len(fields) >= 2 && fields[0] == "end" && fields[1] == "raw_codes":
+			inRawCodes = false
+		case inRawCodes && isRemote && fields[0] == "name":
+			if len(fields) < 2 {
+				return nil, errors.New("raw code without a name")
+			}
+			code = fields[1]
+			codes[code] = nil
+		case inRawCodes && isRemote && code != "":
+			for _, field := range fields {
+				t, err := strconv.Atoi(field)
+				if err != nil {
+					return nil, fmt.Errorf("invalid timing %q of code %v", field, code)
+				}
+				codes[code] = append(codes[code], t)
+			}
+		}
+	}
+
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("lircd configuration has no raw codes of remote %v", remote)
+	}
+	for name, timings := range codes {
+		if len(timings) == 0 {
+			return nil, fmt.Errorf("raw code %v of remote %v has no timings", name, remote)
+		}
+	}
+	return codes, nil
+}
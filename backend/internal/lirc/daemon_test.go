@@ -0,0 +1,162 @@
+package lirc
+
+import (
+	"bufio"
+	"context"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/formats"
+	"github.com/stretchr/testify/require"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeLircd answers the commands like lircd, with the reply returned by answer
+type fakeLircd struct {
+	socket string
+
+	mx       sync.Mutex
+	received []string
+}
+
+func startFakeLircd(t *testing.T, answer func(command string) string) *fakeLircd {
+	socket := filepath.Join(t.TempDir(), "lircd")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	fake := &fakeLircd{socket: socket}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				lines := bufio.NewScanner(conn)
+				for lines.Scan() {
+					fake.mx.Lock()
+					fake.received = append(fake.received, lines.Text())
+					fake.mx.Unlock()
+					_, _ = conn.Write([]byte(answer(lines.Text())))
+				}
+			}()
+		}
+	}()
+	return fake
+}
+
+func (f *fakeLircd) commands() []string {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]string(nil), f.received...)
+}
+
+func TestDaemon_Send(t *testing.T) {
+	lircd := startFakeLircd(t, func(command string) string {
+		// a configuration reload is broadcast before the reply
+		return "BEGIN\nSIGHUP\nEND\nBEGIN\n" + command + "\nSUCCESS\nEND\n"
+	})
+	daemon := NewDaemon(lircd.socket, "ac", map[string][]int{
+		"off":    {9000, 4500, 560, 560, 560},
+		"cold20": {9000, 4500, 560, 1690, 560, 40000},
+	})
+
+	require.NoError(t, daemon.Send(context.Background(), commands.DefaultCarrier, []int{8950, 4520, 540, 1700, 580, 20000}))
+	require.NoError(t, daemon.Send(context.Background(), commands.DefaultCarrier, []int{9000, 4500, 560, 560, 560}))
+	require.Equal(t, []string{"SEND_ONCE ac cold20", "SEND_ONCE ac off"}, lircd.commands())
+
+	err := daemon.Send(context.Background(), commands.DefaultCarrier, []int{9000, 4500, 560, 3000, 560})
+	require.ErrorContains(t, err, "has no code")
+	require.ErrorContains(t, daemon.Send(context.Background(), commands.DefaultCarrier, nil), "has no code")
+	require.Len(t, lircd.commands(), 2)
+}
+
+func TestDaemon_FindCode(t *testing.T) {
+	daemon := NewDaemon("", "ac", map[string][]int{
+		"cold20": {9000, 4500, 560, 1690, 560},
+		"cold21": {9000, 4500, 560, 1500, 560},
+		"empty":  {},
+		"same":   {9000, 4500, 560, 1690, 560},
+	})
+
+	// the closest code, the first by name of equal ones
+	for i := 0; i < 10; i++ {
+		name, ok := daemon.findCode([]int{9000, 4500, 560, 1650, 560})
+		require.True(t, ok)
+		require.Equal(t, "cold20", name)
+
+		name, ok = daemon.findCode([]int{9000, 4500, 560, 1550, 560})
+		require.True(t, ok)
+		require.Equal(t, "cold21", name)
+	}
+
+	_, ok := daemon.findCode([]int{})
+	require.False(t, ok)
+}
+
+func TestDaemon_Error(t *testing.T) {
+	lircd := startFakeLircd(t, func(command string) string {
+		return "BEGIN\n" + command + "\nERROR\nDATA\n1\nunknown remote: \"ac\"\nEND\n"
+	})
+	err := NewDaemon(lircd.socket, "ac", nil).SendOnce(context.Background(), "off")
+	require.EqualError(t, err, `lircd: unknown remote: "ac"`)
+
+	err = NewDaemon(filepath.Join(t.TempDir(), "missing"), "ac", nil).SendOnce(context.Background(), "off")
+	require.Error(t, err)
+}
+
+func TestParseRawCodes(t *testing.T) {
+	config, err := formats.Format(formats.FormatLirc, formats.Signal{
+		Timings: []int{9000, 4500, 560, 1690, 560, 1690, 560, 560, 560},
+		Carrier: commands.DefaultCarrier,
+	})
+	require.NoError(t, err)
+
+	codes, err := ParseRawCodes(config, "irctl")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]int{"command": {9000, 4500, 560, 1690, 560, 1690, 560, 560, 560}}, codes)
+
+	config = []byte(strings.Join([]string{
+		"begin remote",
+		"  name tv",
+		"  begin codes",
+		"    KEY_POWER 0x1",
+		"  end codes",
+		"end remote",
+		"begin remote",
+		"  name ac",
+		"  flags RAW_CODES",
+		"  begin raw_codes",
+		"    name off",
+		"      9000 4500",
+		"      560",
+		"    name on",
+		"      9000 4500 560 1690 560",
+		"  end raw_codes",
+		"end remote",
+	}, "\n"))
+	codes, err = ParseRawCodes(config, "ac")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]int{"off": {9000, 4500, 560}, "on": {9000, 4500, 560, 1690, 560}}, codes)
+
+	_, err = ParseRawCodes(config, "tv")
+	require.Error(t, err)
+
+	// a name without timings
+	config = []byte(strings.Join([]string{
+		"begin remote",
+		"  name ac",
+		"  begin raw_codes",
+		"    name off",
+		"    name on",
+		"      9000 4500 560",
+		"  end raw_codes",
+		"end remote",
+	}, "\n"))
+	_, err = ParseRawCodes(config, "ac")
+	require.ErrorContains(t, err, "raw code off of remote ac has no timings")
+}
@@ -0,0 +1,156 @@
+package lirc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/formats"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// the ioctl requests and features of the kernel LIRC ABI, see linux/lirc.h
+const (
+	lircGetFeatures         = 0x80046900
+	lircSetSendCarrier      = 0x40046913
+	lircSetSendDutyCycle    = 0x40046915
+	lircSetRecMode          = 0x40046912
+	lircSetMeasureCarrier   = 0x4004691d
+	lircModeMode2           = 0x00000004
+	lircCanSendPulse        = 0x00000002
+	lircCanSetSendCarrier   = 0x00000100
+	lircCanSetSendDutyCycle = 0x00000200
+	lircCanRecMode2         = 0x00040000
+	lircCanMeasureCarrier   = 0x02000000
+)
+
+// ioctlFunc issues an ioctl with a pointer to a 32 bit value
+type ioctlFunc func(file *os.File, request uint, value *uint32) error
+
+// Device is a kernel LIRC device like /dev/lirc0, sending and receiving
+// pulses and spaces in mode2.
+type Device struct {
+	path     string
+	file     *os.File
+	ioctl    ioctlFunc
+	features uint32
+
+	// mx serializes the sends, the carrier is set per signal
+	mx sync.Mutex
+}
+
+// OpenDevice opens the LIRC device at path.
+func OpenDevice(path string) (*Device, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	device, err := newDevice(path, file, deviceIoctl)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return device, nil
+}
+
+func newDevice(path string, file *os.File, ioctl ioctlFunc) (*Device, error) {
+	d := &Device{path: path, file: file, ioctl: ioctl}
+	if err := ioctl(file, lircGetFeatures, &d.features); err != nil {
+		return nil, fmt.Errorf("%v is not a LIRC device: %w", path, err)
+	}
+	return d, nil
+}
+
+func (d *Device) Close() error {
+	return d.file.Close()
+}
+
+// Send transmits the signal with the carrier, if the device can set it.
+func (d *Device) Send(_ context.Context, carrier commands.Carrier, timings []int) error {
+	if d.features&lircCanSendPulse == 0 {
+		return fmt.Errorf("%v can not send", d.path)
+	}
+	data, err := encodePulses(timings)
+	if err != nil {
+		return err
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.features&lircCanSetSendCarrier != 0 {
+		frequency := uint32(carrier.Frequency)
+		if err := d.ioctl(d.file, lircSetSendCarrier, &frequency); err != nil {
+			return fmt.Errorf("failed to set carrier frequency %v Hz: %w", carrier.Frequency, err)
+		}
+	}
+	if d.features&lircCanSetSendDutyCycle != 0 {
+		dutyCycle := uint32(carrier.DutyCycle)
+		if err := d.ioctl(d.file, lircSetSendDutyCycle, &dutyCycle); err != nil {
+			return fmt.Errorf("failed to set duty cycle %v%%: %w", carrier.DutyCycle, err)
+		}
+	}
+
+	// the kernel returns once the signal is transmitted
+	_, err = d.file.Write(data)
+	return err
+}
+
+// Receive waits for a signal and returns it for learning. The carrier is the
+// measured one if the device can measure it, the default one otherwise.
+func (d *Device) Receive(ctx context.Context) (formats.Signal, error) {
+	if d.features&lircCanRecMode2 == 0 {
+		return formats.Signal{}, fmt.Errorf("%v can not receive", d.path)
+	}
+	mode := uint32(lircModeMode2)
+	if err := d.ioctl(d.file, lircSetRecMode, &mode); err != nil {
+		return formats.Signal{}, fmt.Errorf("failed to switch %v to mode2: %w", d.path, err)
+	}
+	if d.features&lircCanMeasureCarrier != 0 {
+		enable := uint32(1)
+		if err := d.ioctl(d.file, lircSetMeasureCarrier, &enable); err != nil {
+			return formats.Signal{}, fmt.Errorf("failed to enable carrier measurement: %w", err)
+		}
+	}
+
+	// interrupt the blocked read once ctx is done
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = d.file.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	defer func() { _ = d.file.SetReadDeadline(time.Time{}) }()
+
+	decoder := mode2Decoder{}
+	buf := make([]byte, 4*256)
+	pending := 0
+	for {
+		n, err := d.file.Read(buf[pending:])
+		if ctx.Err() != nil {
+			return formats.Signal{}, ctx.Err()
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return formats.Signal{}, err
+		}
+
+		pending += n
+		values := pending / 4
+		for i := 0; i < values; i++ {
+			timings := decoder.feed(nativeEndian.Uint32(buf[4*i:]))
+			if timings != nil {
+				return decoder.signal(timings), nil
+			}
+		}
+		pending = copy(buf, buf[4*values:pending])
+	}
+}
@@ -0,0 +1,27 @@
+package lirc
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+	"unsafe"
+)
+
+func deviceIoctl(file *os.File, request uint, value *uint32) error {
+	// Receive stops a pending Read with SetReadDeadline, which only works while the
+	// file stays non-blocking, and Fd would take it out of the poller for good
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno unix.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall(unix.SYS_IOCTL, fd, uintptr(request), uintptr(unsafe.Pointer(value)))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package lirc
+
+import (
+	"errors"
+	"os"
+)
+
+func deviceIoctl(file *os.File, request uint, value *uint32) error {
+	return errors.New("LIRC devices are only supported on Linux")
+}
@@ -0,0 +1,182 @@
+// Package lirc sends and receives IR signals with the IR hardware of the
+// machine the backend runs on, like an IR LED on a GPIO of a Raspberry Pi,
+// through a kernel LIRC device or a running lircd.
+package lirc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/formats"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"sync"
+	"unsafe"
+)
+
+// the mode2 values the kernel reads and writes, see linux/lirc.h
+const (
+	mode2Space     = 0x00000000
+	mode2Pulse     = 0x01000000
+	mode2Frequency = 0x02000000
+	mode2Timeout   = 0x03000000
+	mode2Overflow  = 0x04000000
+	mode2Mask      = 0xFF000000
+	mode2ValueMask = 0x00FFFFFF
+)
+
+// SignalGap is the space in microseconds that ends a received signal if the
+// device does not report timeouts.
+const SignalGap = 200000
+
+// MaxSendLength is the most pulses and spaces the kernel accepts in one write
+const MaxSendLength = 512
+
+// nativeEndian is the byte order of the mode2 values, the kernel uses the one of the machine
+var nativeEndian = func() interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+} {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// Output sends signals with an IR LED.
+type Output interface {
+	Send(ctx context.Context, carrier commands.Carrier, timings []int) error
+}
+
+// Remote sends commands through an Output, in place of a remote reached
+// over the network.
+type Remote struct {
+	output Output
+
+	mx        sync.Mutex
+	listeners []irremote.CommandListener
+}
+
+func NewRemote(output Output) *Remote {
+	return &Remote{output: output}
+}
+
+func (r *Remote) SendCommand(ctx context.Context, command commands.Command) error {
+	carrier := command.Carrier()
+	if err := carrier.Validate(); err != nil {
+		return err
+	}
+	timings := command.ToSignalSequence()
+	if len(timings) == 0 {
+		return errors.New("command is empty")
+	}
+
+	err := r.output.Send(ctx, carrier, timings)
+
+	r.mx.Lock()
+	listeners := append([]irremote.CommandListener(nil), r.listeners...)
+	r.mx.Unlock()
+	for _, listener := range listeners {
+		listener(command, err)
+	}
+	return err
+}
+
+// AddCommandListener registers a listener called after every sent command.
+func (r *Remote) AddCommandListener(listener irremote.CommandListener) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.listeners = append(r.listeners, listener)
+}
+
+// encodePulses converts the timings to the mode2 values written to the
+// device: alternating pulses and spaces that start and end with a pulse.
+func encodePulses(timings []int) ([]byte, error) {
+	if len(timings) == 0 {
+		return nil, errors.New("command is empty")
+	}
+	if len(timings)%2 == 0 {
+		// the trailing space is the silence after the signal
+		timings = timings[:len(timings)-1]
+	}
+	if len(timings) > MaxSendLength {
+		return nil, fmt.Errorf("command has %v timings, the device accepts at most %v", len(timings), MaxSendLength)
+	}
+
+	data := make([]byte, 0, 4*len(timings))
+	for i, t := range timings {
+		if t <= 0 || t > mode2ValueMask {
+			return nil, fmt.Errorf("timing %v at position %v is out of range", t, i)
+		}
+		data = nativeEndian.AppendUint32(data, uint32(t))
+	}
+	return data, nil
+}
+
+// mode2Decoder assembles the mode2 values read from the device into signals.
+type mode2Decoder struct {
+	timings   []int
+	frequency int
+}
+
+// feed adds a value and returns the signal it completes, if any.
+func (d *mode2Decoder) feed(value uint32) []int {
+	duration := int(value & mode2ValueMask)
+	switch value & mode2Mask {
+	case mode2Pulse:
+		if len(d.timings)%2 == 1 {
+			// consecutive pulses are one long pulse
+			d.timings[len(d.timings)-1] += duration
+		} else {
+			d.timings = append(d.timings, duration)
+		}
+
+	case mode2Space:
+		if len(d.timings) == 0 {
+			// the silence before the signal
+			return nil
+		}
+		if duration >= SignalGap {
+			return d.complete()
+		}
+		if len(d.timings)%2 == 0 {
+			d.timings[len(d.timings)-1] += duration
+		} else {
+			d.timings = append(d.timings, duration)
+		}
+
+	case mode2Frequency:
+		d.frequency = duration
+
+	case mode2Timeout:
+		return d.complete()
+
+	case mode2Overflow:
+		// the receiver lost a part of the signal
+		d.timings = nil
+	}
+	return nil
+}
+
+func (d *mode2Decoder) complete() []int {
+	signal := d.timings
+	d.timings = nil
+	if len(signal) == 0 {
+		return nil
+	}
+	if len(signal)%2 == 0 {
+		signal = signal[:len(signal)-1]
+	}
+	return signal
+}
+
+// signal attaches the measured carrier to the timings of a received signal
+func (d *mode2Decoder) signal(timings []int) formats.Signal {
+	carrier := commands.DefaultCarrier
+	if d.frequency != 0 {
+		carrier.Frequency = d.frequency
+	}
+	return formats.Signal{Timings: timings, Carrier: carrier}
+}
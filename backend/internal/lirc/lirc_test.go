@@ -0,0 +1,167 @@
+package lirc
+
+import (
+	"context"
+	"errors"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// fakeDevice stands in for the ioctls of a LIRC device, the pulses go to a regular file
+type fakeDevice struct {
+	features uint32
+	settings map[uint]uint32
+}
+
+func (f *fakeDevice) ioctl(_ *os.File, request uint, value *uint32) error {
+	if request == lircGetFeatures {
+		*value = f.features
+		return nil
+	}
+	if f.settings == nil {
+		return syscall.ENOTTY
+	}
+	f.settings[request] = *value
+	return nil
+}
+
+func openFake(t *testing.T, features uint32, content []uint32) (*Device, *fakeDevice, string) {
+	path := filepath.Join(t.TempDir(), "lirc0")
+	data := make([]byte, 0, 4*len(content))
+	for _, value := range content {
+		data = nativeEndian.AppendUint32(data, value)
+	}
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = file.Close() })
+
+	fake := &fakeDevice{features: features, settings: map[uint]uint32{}}
+	device, err := newDevice(path, file, fake.ioctl)
+	require.NoError(t, err)
+	return device, fake, path
+}
+
+func TestDevice_Send(t *testing.T) {
+	device, fake, path := openFake(t, lircCanSendPulse|lircCanSetSendCarrier|lircCanSetSendDutyCycle, nil)
+
+	err := device.Send(context.Background(), commands.Carrier{Frequency: 36000, DutyCycle: 25}, []int{9000, 4500, 560, 1690, 560, 40000})
+	require.NoError(t, err)
+	require.Equal(t, uint32(36000), fake.settings[lircSetSendCarrier])
+	require.Equal(t, uint32(25), fake.settings[lircSetSendDutyCycle])
+
+	// the trailing space is not written
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, data, 5*4)
+	require.Equal(t, uint32(9000), nativeEndian.Uint32(data[0:]))
+	require.Equal(t, uint32(560), nativeEndian.Uint32(data[16:]))
+
+	require.Error(t, device.Send(context.Background(), commands.DefaultCarrier, []int{9000, 0, 560}))
+	require.Error(t, device.Send(context.Background(), commands.DefaultCarrier, make([]int, MaxSendLength+1)))
+}
+
+func TestDevice_SendWithoutCarrier(t *testing.T) {
+	device, fake, _ := openFake(t, lircCanSendPulse, nil)
+	require.NoError(t, device.Send(context.Background(), commands.DefaultCarrier, []int{500, 500, 500}))
+	require.Empty(t, fake.settings)
+
+	receiver, _, _ := openFake(t, lircCanRecMode2, nil)
+	require.ErrorContains(t, receiver.Send(context.Background(), commands.DefaultCarrier, []int{500}), "can not send")
+}
+
+func TestDevice_NotLirc(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, nil, 0o644))
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = newDevice(path, file, func(*os.File, uint, *uint32) error { return syscall.ENOTTY })
+	require.ErrorContains(t, err, "is not a LIRC device")
+}
+
+func TestDevice_Receive(t *testing.T) {
+	device, fake, _ := openFake(t, lircCanRecMode2|lircCanMeasureCarrier, []uint32{
+		mode2Space | 16000000,
+		mode2Frequency | 38000,
+		mode2Pulse | 9000,
+		mode2Space | 4500,
+		mode2Pulse | 560,
+		mode2Pulse | 10,
+		mode2Space | 1690,
+		mode2Pulse | 560,
+		mode2Timeout | 125000,
+		mode2Pulse | 300,
+	})
+
+	signal, err := device.Receive(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []int{9000, 4500, 570, 1690, 560}, signal.Timings)
+	require.Equal(t, commands.Carrier{Frequency: 38000, DutyCycle: commands.DEFAULT_DUTY_CYCLE}, signal.Carrier)
+	require.Equal(t, uint32(lircModeMode2), fake.settings[lircSetRecMode])
+	require.Equal(t, uint32(1), fake.settings[lircSetMeasureCarrier])
+}
+
+func TestDevice_ReceiveIncomplete(t *testing.T) {
+	device, _, _ := openFake(t, lircCanRecMode2, []uint32{mode2Pulse | 9000, mode2Space | 4500})
+	_, err := device.Receive(context.Background())
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = device.Receive(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMode2Decoder(t *testing.T) {
+	decoder := mode2Decoder{}
+	require.Nil(t, decoder.feed(mode2Pulse|500))
+	require.Nil(t, decoder.feed(mode2Space|500))
+	require.Nil(t, decoder.feed(mode2Overflow))
+	require.Nil(t, decoder.feed(mode2Pulse|600))
+	require.Nil(t, decoder.feed(mode2Space|700))
+	require.Nil(t, decoder.feed(mode2Pulse|800))
+	require.Equal(t, []int{600, 700, 800}, decoder.feed(mode2Space|SignalGap))
+
+	// a timeout without a signal
+	require.Nil(t, decoder.feed(mode2Timeout|125000))
+	require.Equal(t, 0, decoder.frequency)
+	require.Equal(t, commands.DefaultCarrier, decoder.signal([]int{1}).Carrier)
+}
+
+type recordingOutput struct {
+	sent []int
+	err  error
+}
+
+func (r *recordingOutput) Send(_ context.Context, _ commands.Carrier, timings []int) error {
+	r.sent = timings
+	return r.err
+}
+
+func TestRemote(t *testing.T) {
+	output := &recordingOutput{}
+	remote := NewRemote(output)
+
+	var notified []error
+	remote.AddCommandListener(func(command commands.Command, err error) {
+		notified = append(notified, err)
+	})
+
+	require.NoError(t, remote.SendCommand(context.Background(), commands.NewRawCommand([]int{500, 500, 500})))
+	require.Equal(t, []int{500, 500, 500}, output.sent)
+
+	output.err = errors.New("device is gone")
+	require.Error(t, remote.SendCommand(context.Background(), commands.NewRawCommand([]int{500})))
+	require.Equal(t, []error{nil, output.err}, notified)
+
+	// rejected before sending
+	require.Error(t, remote.SendCommand(context.Background(), commands.NewRawCommand(nil)))
+	require.Len(t, notified, 2)
+}
@@ -0,0 +1,417 @@
+// Package mqttbroker is a minimal in-process MQTT 3.1.1 broker for tests. It
+// supports QoS 0 and 1 publishes (delivered at QoS 0), retained messages,
+// wildcard subscriptions and last wills.
+package mqttbroker
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+)
+
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+type message struct {
+	topic   string
+	payload []byte
+	retain  bool
+}
+
+type client struct {
+	conn          net.Conn
+	writeMx       sync.Mutex
+	subscriptions map[string]bool
+	will          *message
+}
+
+type Broker struct {
+	listener net.Listener
+
+	mx       sync.Mutex
+	clients  map[*client]bool
+	retained map[string][]byte
+	history  map[string][][]byte
+	wg       sync.WaitGroup
+}
+
+// Start listens on a random local port.
+func Start() (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Broker{
+		listener: listener,
+		clients:  make(map[*client]bool),
+		retained: make(map[string][]byte),
+		history:  make(map[string][][]byte),
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// Url is the address clients connect to, like tcp://127.0.0.1:1883.
+func (b *Broker) Url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Close stops the broker and drops the connections without sending their wills.
+func (b *Broker) Close() {
+	_ = b.listener.Close()
+
+	b.mx.Lock()
+	for c := range b.clients {
+		c.will = nil
+		_ = c.conn.Close()
+	}
+	b.mx.Unlock()
+	b.wg.Wait()
+}
+
+// Retained returns the retained message of the topic.
+func (b *Broker) Retained(topic string) ([]byte, bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	payload, ok := b.retained[topic]
+	return payload, ok
+}
+
+// Messages returns the payloads published to the topic, oldest first.
+func (b *Broker) Messages(topic string) [][]byte {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return append([][]byte{}, b.history[topic]...)
+}
+
+// Publish delivers a message as if a client published it.
+func (b *Broker) Publish(topic string, payload []byte, retain bool) {
+	b.route(message{topic: topic, payload: payload, retain: retain})
+}
+
+// Disconnect drops every client connection as a network failure would, their
+// wills are published.
+func (b *Broker) Disconnect() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for c := range b.clients {
+		_ = c.conn.Close()
+	}
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &client{conn: conn, subscriptions: make(map[string]bool)}
+		b.mx.Lock()
+		b.clients[c] = true
+		b.mx.Unlock()
+
+		b.wg.Add(1)
+		go b.serve(c)
+	}
+}
+
+func (b *Broker) serve(c *client) {
+	defer b.wg.Done()
+	defer func() {
+		_ = c.conn.Close()
+		b.mx.Lock()
+		delete(b.clients, c)
+		will := c.will
+		b.mx.Unlock()
+		if will != nil {
+			b.route(*will)
+		}
+	}()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		header, body, err := readPacket(reader)
+		if err != nil {
+			return
+		}
+		if err := b.handle(c, header, body); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Println("mqttbroker:", err)
+			}
+			return
+		}
+	}
+}
+
+func (b *Broker) handle(c *client, header byte, body []byte) error {
+	r := &reader{data: body}
+	switch header >> 4 {
+	case packetConnect:
+		r.string() // protocol name
+		r.byte()   // protocol level
+		flags := r.byte()
+		r.uint16() // keep alive
+		r.string() // client id
+		if flags&0x04 != 0 {
+			topic := r.string()
+			payload := r.bytes()
+			b.mx.Lock()
+			c.will = &message{topic: topic, payload: payload, retain: flags&0x20 != 0}
+			b.mx.Unlock()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		return c.write(packetConnack<<4, []byte{0, 0})
+
+	case packetPublish:
+		qos := (header >> 1) & 0x03
+		topic := r.string()
+		var id uint16
+		if qos > 0 {
+			id = r.uint16()
+		}
+		if r.err != nil {
+			return r.err
+		}
+		b.route(message{topic: topic, payload: r.rest(), retain: header&0x01 != 0})
+		if qos > 0 {
+			return c.write(packetPuback<<4, binary.BigEndian.AppendUint16(nil, id))
+		}
+		return nil
+
+	case packetSubscribe:
+		id := r.uint16()
+		var filters []string
+		codes := make([]byte, 0)
+		for !r.done() {
+			filters = append(filters, r.string())
+			r.byte()
+			codes = append(codes, 0)
+		}
+		if r.err != nil {
+			return r.err
+		}
+
+		b.mx.Lock()
+		for _, filter := range filters {
+			c.subscriptions[filter] = true
+		}
+		var retained []message
+		for topic, payload := range b.retained {
+			for _, filter := range filters {
+				if matches(filter, topic) {
+					retained = append(retained, message{topic: topic, payload: payload, retain: true})
+					break
+				}
+			}
+		}
+		b.mx.Unlock()
+
+		if err := c.write(packetSuback<<4, append(binary.BigEndian.AppendUint16(nil, id), codes...)); err != nil {
+			return err
+		}
+		for _, m := range retained {
+			if err := c.publish(m); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case packetUnsubscribe:
+		id := r.uint16()
+		b.mx.Lock()
+		for !r.done() && r.err == nil {
+			delete(c.subscriptions, r.string())
+		}
+		b.mx.Unlock()
+		return c.write(packetUnsuback<<4, binary.BigEndian.AppendUint16(nil, id))
+
+	case packetPingreq:
+		return c.write(packetPingresp<<4, nil)
+
+	case packetDisconnect:
+		b.mx.Lock()
+		c.will = nil
+		b.mx.Unlock()
+		return io.EOF
+
+	default:
+		return fmt.Errorf("unsupported packet type %v", header>>4)
+	}
+}
+
+func (b *Broker) route(m message) {
+	b.mx.Lock()
+	b.history[m.topic] = append(b.history[m.topic], m.payload)
+	if m.retain {
+		if len(m.payload) == 0 {
+			delete(b.retained, m.topic)
+		} else {
+			b.retained[m.topic] = m.payload
+		}
+	}
+	var receivers []*client
+	for c := range b.clients {
+		for filter := range c.subscriptions {
+			if matches(filter, m.topic) {
+				receivers = append(receivers, c)
+				break
+			}
+		}
+	}
+	b.mx.Unlock()
+
+	// retain is only set on messages sent because of a new subscription
+	m.retain = false
+	for _, c := range receivers {
+		_ = c.publish(m)
+	}
+}
+
+func (c *client) publish(m message) error {
+	header := byte(packetPublish << 4)
+	if m.retain {
+		header |= 0x01
+	}
+	body := appendString(nil, m.topic)
+	return c.write(header, append(body, m.payload...))
+}
+
+func (c *client) write(header byte, body []byte) error {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	_, err := c.conn.Write(append(packet, body...))
+	return err
+}
+
+// matches tells whether the topic matches the filter with + and # wildcards.
+func matches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+func readPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendString(data []byte, s string) []byte {
+	data = binary.BigEndian.AppendUint16(data, uint16(len(s)))
+	return append(data, s...)
+}
+
+// reader decodes the fields of a packet, remembering the first error.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil || len(r.data) < n {
+		r.err = errors.New("packet is too short")
+		return nil
+	}
+	result := r.data[:n]
+	r.data = r.data[n:]
+	return result
+}
+
+func (r *reader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) bytes() []byte {
+	return r.take(int(r.uint16()))
+}
+
+func (r *reader) string() string {
+	return string(r.bytes())
+}
+
+func (r *reader) rest() []byte {
+	result := r.data
+	r.data = nil
+	return result
+}
+
+func (r *reader) done() bool {
+	return len(r.data) == 0 || r.err != nil
+}
@@ -0,0 +1,47 @@
+package mqttbroker
+
+import (
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMatches(t *testing.T) {
+	require.True(t, matches("a/+/c", "a/b/c"))
+	require.True(t, matches("a/#", "a/b/c"))
+	require.True(t, matches("a/b", "a/b"))
+	require.False(t, matches("a/+", "a/b/c"))
+	require.False(t, matches("a/b/c", "a/b"))
+}
+
+func TestBroker(t *testing.T) {
+	broker, err := Start()
+	require.NoError(t, err)
+	defer broker.Close()
+
+	broker.Publish("retained/topic", []byte("kept"), true)
+
+	received := make(chan mqtt.Message, 10)
+	client := mqtt.NewClient(mqtt.NewClientOptions().AddBroker(broker.Url()).SetClientID("test"))
+	token := client.Connect()
+	require.True(t, token.WaitTimeout(time.Second))
+	require.NoError(t, token.Error())
+	defer client.Disconnect(100)
+
+	token = client.Subscribe("retained/#", 1, func(_ mqtt.Client, message mqtt.Message) {
+		received <- message
+	})
+	require.True(t, token.WaitTimeout(time.Second))
+
+	message := <-received
+	require.Equal(t, "kept", string(message.Payload()))
+	require.True(t, message.Retained())
+
+	token = client.Publish("retained/other", 1, false, "live")
+	require.True(t, token.WaitTimeout(time.Second))
+	message = <-received
+	require.Equal(t, "retained/other", message.Topic())
+	require.False(t, message.Retained())
+	require.Len(t, broker.Messages("retained/other"), 1)
+}
@@ -0,0 +1,241 @@
+package ota
+
+import (
+	"context"
+	"crypto"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImageExtension is the extension of the image files, named after their version
+const ImageExtension = ".bin"
+
+// Image is a firmware image signed the way the ESP8266 core does it: the
+// RSA signature of the SHA-256 of the program is appended to it, followed by
+// the signature length as a little endian uint32.
+type Image struct {
+	Version string `json:"version"`
+	Size    int    `json:"size"`
+	// Sha256 and Md5 are of the whole file, the remote checks the MD5 while downloading
+	Sha256 string `json:"sha256"`
+	Md5    string `json:"md5"`
+	path   string
+}
+
+// Repository keeps the firmware images of a directory that are signed with
+// the key the firmware trusts.
+type Repository struct {
+	dir       string
+	publicKey *rsa.PublicKey
+
+	mx     sync.Mutex
+	images map[string]Image
+}
+
+func OpenRepository(dir string, publicKey *rsa.PublicKey) (*Repository, error) {
+	r := &Repository{dir: dir, publicKey: publicKey}
+	return r, r.Reload()
+}
+
+// Reload scans the directory again. Images that fail the signature check are skipped.
+func (r *Repository) Reload() error {
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*"+ImageExtension))
+	if err != nil {
+		return err
+	}
+
+	images := make(map[string]Image)
+	for _, path := range paths {
+		image, err := loadImage(path, r.publicKey)
+		if err != nil {
+			log.Println("skipping firmware image", path, err)
+			continue
+		}
+		images[image.Version] = image
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.images = images
+	return nil
+}
+
+func loadImage(path string, publicKey *rsa.PublicKey) (Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Image{}, err
+	}
+	if err := VerifySignature(data, publicKey); err != nil {
+		return Image{}, err
+	}
+
+	sha := sha256.Sum256(data)
+	md := md5.Sum(data)
+	return Image{
+		Version: strings.TrimSuffix(filepath.Base(path), ImageExtension),
+		Size:    len(data),
+		Sha256:  hex.EncodeToString(sha[:]),
+		Md5:     hex.EncodeToString(md[:]),
+		path:    path,
+	}, nil
+}
+
+// VerifySignature checks the signature appended to the image.
+func VerifySignature(data []byte, publicKey *rsa.PublicKey) error {
+	if publicKey == nil {
+		return errors.New("no public key to verify the signature with")
+	}
+	if len(data) < 4 {
+		return errors.New("image is too short")
+	}
+	signatureLength := int(binary.LittleEndian.Uint32(data[len(data)-4:]))
+	if signatureLength == 0 || signatureLength > len(data)-4 {
+		return errors.New("image is not signed")
+	}
+
+	programLength := len(data) - 4 - signatureLength
+	signature := data[programLength : len(data)-4]
+	hash := sha256.Sum256(data[:programLength])
+	if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	return nil
+}
+
+// ParsePublicKey reads a PEM encoded RSA public key, like the public.key of the firmware build.
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+// Images returns the images, oldest version first.
+func (r *Repository) Images() []Image {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	result := make([]Image, 0, len(r.images))
+	for _, image := range r.images {
+		result = append(result, image)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return CompareVersions(result[i].Version, result[j].Version) < 0
+	})
+	return result
+}
+
+func (r *Repository) Image(version string) (Image, bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	image, ok := r.images[version]
+	return image, ok
+}
+
+// ServeHTTP serves /{version}.bin with the MD5 header the ESP8266 updater checks.
+func (r *Repository) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	name := strings.TrimPrefix(request.URL.Path, "/")
+	image, ok := r.Image(strings.TrimSuffix(name, ImageExtension))
+	if !ok || !strings.HasSuffix(name, ImageExtension) {
+		http.NotFound(w, request)
+		return
+	}
+
+	file, err := os.Open(image.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("x-MD5", image.Md5)
+	http.ServeContent(w, request, name, stat.ModTime(), file)
+}
+
+// CompareVersions orders versions like 1.2.10 by their numeric parts, parts
+// that are not numbers are compared as text.
+func CompareVersions(a string, b string) int {
+	split := func(version string) []string {
+		return strings.FieldsFunc(strings.TrimPrefix(version, "v"), func(r rune) bool {
+			return r == '.' || r == '-'
+		})
+	}
+
+	partsA, partsB := split(a), split(b)
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		numberA, errA := strconv.Atoi(partsA[i])
+		numberB, errB := strconv.Atoi(partsB[i])
+		switch {
+		case errA == nil && errB == nil && numberA != numberB:
+			if numberA < numberB {
+				return -1
+			}
+			return 1
+		case (errA != nil || errB != nil) && partsA[i] != partsB[i]:
+			return strings.Compare(partsA[i], partsB[i])
+		}
+	}
+	return len(partsA) - len(partsB)
+}
+
+// ListenAndServe serves the images under /ota/ on addr until ctx is done. The
+// remotes download them without authorization, so nothing else is served there.
+func (r *Repository) ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/ota/", http.StripPrefix("/ota", r))
+	server := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.Println("Firmware images listening on", addr)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
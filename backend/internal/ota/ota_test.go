@@ -0,0 +1,307 @@
+package ota
+
+import (
+	"context"
+	"crypto"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"github.com/Light-Keeper/ir-remote/internal/health"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sign(t *testing.T, program []byte, key *rsa.PrivateKey) []byte {
+	hash := sha256.Sum256(program)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	require.NoError(t, err)
+
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(signature)))
+	return append(append(append([]byte{}, program...), signature...), length...)
+}
+
+func newRepository(t *testing.T, versions ...string) (*Repository, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	for _, version := range versions {
+		image := sign(t, []byte("firmware "+version), key)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, version+ImageExtension), image, 0o644))
+	}
+
+	repository, err := OpenRepository(dir, &key.PublicKey)
+	require.NoError(t, err)
+	return repository, key
+}
+
+func TestRepository(t *testing.T) {
+	repository, key := newRepository(t, "1.10.0", "1.9.2")
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(repository.dir, "2.0.0.bin"), sign(t, []byte("evil"), other), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(repository.dir, "2.1.0.bin"), []byte("unsigned"), 0o644))
+	require.NoError(t, repository.Reload())
+
+	images := repository.Images()
+	require.Len(t, images, 2)
+	require.Equal(t, "1.9.2", images[0].Version)
+	require.Equal(t, "1.10.0", images[1].Version)
+
+	data := sign(t, []byte("firmware 1.10.0"), key)
+	sum := md5.Sum(data)
+	require.Equal(t, len(data), images[1].Size)
+	require.Equal(t, hex.EncodeToString(sum[:]), images[1].Md5)
+
+	response := httptest.NewRecorder()
+	repository.ServeHTTP(response, httptest.NewRequest("GET", "/1.10.0.bin", nil))
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, images[1].Md5, response.Header().Get("x-MD5"))
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.NoError(t, VerifySignature(body, &key.PublicKey))
+
+	response = httptest.NewRecorder()
+	repository.ServeHTTP(response, httptest.NewRequest("GET", "/2.0.0.bin", nil))
+	require.Equal(t, 404, response.Code)
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	parsed, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	require.NoError(t, err)
+	require.True(t, key.PublicKey.Equal(parsed))
+
+	_, err = ParsePublicKey([]byte("not a key"))
+	require.Error(t, err)
+}
+
+func TestCompareVersions(t *testing.T) {
+	require.Equal(t, 0, CompareVersions("1.2.3", "v1.2.3"))
+	require.Less(t, CompareVersions("1.2.3", "1.10.0"), 0)
+	require.Greater(t, CompareVersions("1.2.3", "1.2"), 0)
+	require.Less(t, CompareVersions("1.2.3-beta", "1.2.3-rc"), 0)
+}
+
+type fakeRemote struct {
+	status    irremote.Status
+	statusAt  time.Time
+	offers    []irremote.FirmwareOffer
+	listeners []irremote.StatusListener
+	offline   bool
+}
+
+func (f *fakeRemote) OfferFirmware(_ context.Context, offer irremote.FirmwareOffer) error {
+	if f.offline {
+		return context.DeadlineExceeded
+	}
+	f.offers = append(f.offers, offer)
+	return nil
+}
+
+func (f *fakeRemote) LastStatus() (irremote.Status, time.Time) {
+	return f.status, f.statusAt
+}
+
+func (f *fakeRemote) AddStatusListener(listener irremote.StatusListener) {
+	f.listeners = append(f.listeners, listener)
+}
+
+func (f *fakeRemote) report(status irremote.Status) {
+	f.status = status
+	f.statusAt = time.Now()
+	for _, listener := range f.listeners {
+		listener(status, time.Now())
+	}
+}
+
+func newManager(t *testing.T, monitor *health.Monitor) (*Manager, *fakeRemote, *[]Rollout) {
+	repository, _ := newRepository(t, "1.0.0", "1.1.0")
+	manager := NewManager(repository, "http://backend:8080/")
+	remote := &fakeRemote{status: irremote.Status{FirmwareVersion: "1.0.0"}}
+	manager.AddDevice("default", remote, monitor)
+
+	changes := &[]Rollout{}
+	manager.OnChange(func(rollout Rollout) {
+		*changes = append(*changes, rollout)
+	})
+	return manager, remote, changes
+}
+
+func states(rollouts []Rollout) []RolloutState {
+	result := make([]RolloutState, 0, len(rollouts))
+	for _, rollout := range rollouts {
+		result = append(result, rollout.State)
+	}
+	return result
+}
+
+func TestManager_Rollout(t *testing.T) {
+	manager, remote, changes := newManager(t, nil)
+
+	_, err := manager.Rollout(context.Background(), "3.0.0", nil)
+	require.Error(t, err)
+
+	rollouts, err := manager.Rollout(context.Background(), "1.1.0", nil)
+	require.NoError(t, err)
+	require.Len(t, rollouts, 1)
+	require.Equal(t, RolloutInstalling, rollouts[0].State)
+	require.Equal(t, "1.0.0", rollouts[0].PreviousVersion)
+	require.Len(t, remote.offers, 1)
+	require.Equal(t, "http://backend:8080/ota/1.1.0.bin", remote.offers[0].Url)
+
+	_, err = manager.Rollout(context.Background(), "1.1.0", []string{"default"})
+	require.ErrorContains(t, err, "is being updated")
+
+	// the acknowledgement of the offer still comes from the old firmware
+	remote.report(irremote.Status{FirmwareVersion: "1.0.0"})
+	remote.report(irremote.Status{FirmwareVersion: "1.1.0"})
+	require.Equal(t, []RolloutState{RolloutInstalling, RolloutSucceeded}, states(*changes))
+	require.Equal(t, RolloutSucceeded, manager.Rollouts()[0].State)
+}
+
+func TestManager_ConcurrentRollouts(t *testing.T) {
+	manager, remote, _ := newManager(t, nil)
+
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := manager.Rollout(context.Background(), "1.1.0", nil)
+			errs <- err
+		}()
+	}
+	started := 0
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err == nil {
+			started++
+		} else {
+			require.ErrorContains(t, err, "is being updated")
+		}
+	}
+	require.Equal(t, 1, started)
+	require.Len(t, remote.offers, 1)
+}
+
+func TestManager_UpdateError(t *testing.T) {
+	manager, remote, _ := newManager(t, nil)
+
+	_, err := manager.Rollout(context.Background(), "1.1.0", nil)
+	require.NoError(t, err)
+	remote.report(irremote.Status{FirmwareVersion: "1.0.0", UpdateError: "MD5 check failed"})
+
+	rollout := manager.Rollouts()[0]
+	require.Equal(t, RolloutFailed, rollout.State)
+	require.Equal(t, "MD5 check failed", rollout.Error)
+}
+
+func TestManager_OfflineRemote(t *testing.T) {
+	manager, remote, _ := newManager(t, nil)
+	remote.offline = true
+
+	rollouts, err := manager.Rollout(context.Background(), "1.1.0", nil)
+	require.NoError(t, err)
+	require.Equal(t, RolloutFailed, rollouts[0].State)
+}
+
+func TestManager_RollbackWhenNotBack(t *testing.T) {
+	manager, remote, changes := newManager(t, nil)
+
+	_, err := manager.Rollout(context.Background(), "1.1.0", nil)
+	require.NoError(t, err)
+	remote.report(irremote.Status{FirmwareVersion: "0.0.0-broken"})
+
+	manager.check(context.Background(), time.Now().Add(time.Minute))
+	require.Equal(t, RolloutInstalling, manager.Rollouts()[0].State)
+
+	manager.check(context.Background(), time.Now().Add(RolloutTimeout+time.Minute))
+	require.Equal(t, RolloutRollingBack, manager.Rollouts()[0].State)
+	require.Len(t, remote.offers, 2)
+	require.Equal(t, "1.0.0", remote.offers[1].Version)
+
+	remote.report(irremote.Status{FirmwareVersion: "1.0.0"})
+	rollout := manager.Rollouts()[0]
+	require.Equal(t, RolloutRolledBack, rollout.State)
+	require.Contains(t, rollout.Error, "did not come back with 1.1.0")
+	require.Equal(t, []RolloutState{RolloutInstalling, RolloutRollingBack, RolloutRolledBack}, states(*changes))
+}
+
+func TestManager_NotInstalled(t *testing.T) {
+	manager, remote, _ := newManager(t, nil)
+
+	_, err := manager.Rollout(context.Background(), "1.1.0", nil)
+	require.NoError(t, err)
+	remote.report(irremote.Status{FirmwareVersion: "1.0.0"})
+
+	manager.check(context.Background(), time.Now().Add(RolloutTimeout+time.Minute))
+	rollout := manager.Rollouts()[0]
+	require.Equal(t, RolloutFailed, rollout.State)
+	require.Equal(t, "the remote is still running 1.0.0", rollout.Error)
+	require.Len(t, remote.offers, 1)
+}
+
+func TestManager_SilentRemote(t *testing.T) {
+	manager, remote, _ := newManager(t, nil)
+	remote.report(irremote.Status{FirmwareVersion: "1.0.0"})
+
+	// the status from before the offer does not tell the update was not installed
+	_, err := manager.Rollout(context.Background(), "1.1.0", nil)
+	require.NoError(t, err)
+	manager.check(context.Background(), time.Now().Add(RolloutTimeout+time.Minute))
+	rollout := manager.Rollouts()[0]
+	require.Equal(t, RolloutRollingBack, rollout.State)
+	require.Contains(t, rollout.Error, "did not come back with 1.1.0")
+	require.Len(t, remote.offers, 2)
+	require.Equal(t, "1.0.0", remote.offers[1].Version)
+
+	remote.report(irremote.Status{FirmwareVersion: "1.0.0"})
+	require.Equal(t, RolloutRolledBack, manager.Rollouts()[0].State)
+}
+
+func TestManager_RollbackOnRebootLoop(t *testing.T) {
+	monitor := health.NewMonitor()
+	manager, remote, _ := newManager(t, monitor)
+
+	_, err := manager.Rollout(context.Background(), "1.1.0", nil)
+	require.NoError(t, err)
+	remote.report(irremote.Status{FirmwareVersion: "1.1.0"})
+
+	now := time.Now()
+	for i := 0; i < health.RebootLoopCount+1; i++ {
+		running, booted := int64(100), int64(1)
+		monitor.Observe(irremote.Status{FirmwareVersion: "1.1.0", UptimeSeconds: &running}, now)
+		monitor.Observe(irremote.Status{FirmwareVersion: "1.1.0", UptimeSeconds: &booted}, now)
+	}
+
+	// the remote is offline between reboots, the offer is retried
+	remote.offline = true
+	manager.check(context.Background(), now)
+	require.Equal(t, RolloutRollingBack, manager.Rollouts()[0].State)
+	require.Len(t, remote.offers, 1)
+
+	remote.offline = false
+	manager.check(context.Background(), now.Add(10*time.Second))
+	require.Len(t, remote.offers, 2)
+	require.Equal(t, "1.0.0", remote.offers[1].Version)
+
+	remote.report(irremote.Status{FirmwareVersion: "1.0.0"})
+	rollout := manager.Rollouts()[0]
+	require.Equal(t, RolloutRolledBack, rollout.State)
+	require.Equal(t, "the remote is in a reboot loop", rollout.Error)
+}
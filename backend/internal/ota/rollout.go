@@ -0,0 +1,438 @@
+package ota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/health"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RolloutTimeout is how long a remote has to come back online with the new
+// firmware before the previous one is installed again.
+const RolloutTimeout = 5 * time.Minute
+
+// RollbackWatch is how long after a successful update a reboot loop still
+// rolls the remote back.
+const RollbackWatch = 15 * time.Minute
+
+const offerTimeout = 15 * time.Second
+
+type RolloutState string
+
+const (
+	// RolloutInstalling is a remote that accepted the offer and is expected to
+	// come back with the new version
+	RolloutInstalling  RolloutState = "installing"
+	RolloutSucceeded   RolloutState = "succeeded"
+	RolloutFailed      RolloutState = "failed"
+	RolloutRollingBack RolloutState = "rolling_back"
+	RolloutRolledBack  RolloutState = "rolled_back"
+)
+
+// Rollout is the progress of a firmware update of one remote.
+type Rollout struct {
+	Device          string       `json:"device"`
+	Version         string       `json:"version"`
+	PreviousVersion string       `json:"previous_version,omitempty"`
+	State           RolloutState `json:"state"`
+	Error           string       `json:"error,omitempty"`
+	StartedAt       time.Time    `json:"started_at"`
+	UpdatedAt       time.Time    `json:"updated_at"`
+	deadline        time.Time
+	offered         bool
+	// offeredAt is when the remote accepted the offer, statuses received
+	// before tell nothing about the update
+	offeredAt time.Time
+}
+
+// Done tells whether the rollout needs no more attention.
+func (r Rollout) Done() bool {
+	return r.State == RolloutFailed || r.State == RolloutRolledBack ||
+		(r.State == RolloutSucceeded && r.UpdatedAt.Add(RollbackWatch).Before(time.Now()))
+}
+
+func (r Rollout) String() string {
+	text := fmt.Sprintf("%v: %v %v", r.Device, r.Version, strings.ReplaceAll(string(r.State), "_", " "))
+	if r.Error != "" {
+		text += ", " + r.Error
+	}
+	return text
+}
+
+// Remote is a remote that installs firmware, irremote.Session implements it.
+type Remote interface {
+	OfferFirmware(ctx context.Context, offer irremote.FirmwareOffer) error
+	LastStatus() (irremote.Status, time.Time)
+	AddStatusListener(listener irremote.StatusListener)
+}
+
+type device struct {
+	remote  Remote
+	health  *health.Monitor
+	rollout *Rollout
+}
+
+// Manager rolls firmware images of a repository out to remotes, tracks the
+// progress from the statuses they report and installs the previous version
+// again if a remote does not come back with the new one.
+type Manager struct {
+	images  *Repository
+	baseUrl string
+
+	mx        sync.Mutex
+	devices   map[string]*device
+	listeners []func(rollout Rollout)
+}
+
+// NewManager creates a manager offering images at baseUrl, where the
+// repository is served under /ota/.
+func NewManager(images *Repository, baseUrl string) *Manager {
+	return &Manager{
+		images:  images,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		devices: make(map[string]*device),
+	}
+}
+
+// AddDevice registers a remote. A reboot loop reported by monitor after an
+// update rolls the remote back; monitor is optional.
+func (m *Manager) AddDevice(id string, remote Remote, monitor *health.Monitor) {
+	m.mx.Lock()
+	m.devices[id] = &device{remote: remote, health: monitor}
+	m.mx.Unlock()
+
+	remote.AddStatusListener(func(status irremote.Status, at time.Time) {
+		m.observe(id, status, at)
+	})
+}
+
+// OnChange registers a function called whenever a rollout changes its state.
+func (m *Manager) OnChange(listener func(rollout Rollout)) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Repository serves the images.
+func (m *Manager) Repository() *Repository {
+	return m.images
+}
+
+func (m *Manager) Images() []Image {
+	return m.images.Images()
+}
+
+// Latest returns the newest image.
+func (m *Manager) Latest() (Image, bool) {
+	images := m.images.Images()
+	if len(images) == 0 {
+		return Image{}, false
+	}
+	return images[len(images)-1], true
+}
+
+// Rollouts returns the last rollout of every device.
+func (m *Manager) Rollouts() []Rollout {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	result := make([]Rollout, 0, len(m.devices))
+	for _, d := range m.devices {
+		if d.rollout != nil {
+			result = append(result, *d.rollout)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Device < result[j].Device
+	})
+	return result
+}
+
+// FirmwareVersion returns the version the remote reports running.
+func (m *Manager) FirmwareVersion(id string) string {
+	m.mx.Lock()
+	d, ok := m.devices[id]
+	m.mx.Unlock()
+	if !ok {
+		return ""
+	}
+	status, _ := d.remote.LastStatus()
+	return status.FirmwareVersion
+}
+
+// Rollout offers the image of version to the devices, all of them if ids is
+// empty. Offers a device rejects are reported in the state of its rollout.
+func (m *Manager) Rollout(ctx context.Context, version string, ids []string) ([]Rollout, error) {
+	if _, ok := m.images.Image(version); !ok {
+		return nil, fmt.Errorf("unknown firmware version %q", version)
+	}
+
+	// checked and started at once, so a concurrent rollout can not start too
+	m.mx.Lock()
+	if len(ids) == 0 {
+		for id := range m.devices {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+	for _, id := range ids {
+		d, ok := m.devices[id]
+		if !ok {
+			m.mx.Unlock()
+			return nil, fmt.Errorf("unknown device %q", id)
+		}
+		if d.rollout != nil && !d.rollout.Done() {
+			m.mx.Unlock()
+			return nil, fmt.Errorf("device %v is being updated to %v", id, d.rollout.Version)
+		}
+	}
+	rollouts := make([]*Rollout, 0, len(ids))
+	for _, id := range ids {
+		rollouts = append(rollouts, m.start(id, version))
+	}
+	m.mx.Unlock()
+
+	for i, rollout := range rollouts {
+		if rollout.State == RolloutInstalling {
+			m.offer(ctx, ids[i], rollout, version)
+		}
+		m.notify(rollout)
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	result := make([]Rollout, 0, len(rollouts))
+	for _, rollout := range rollouts {
+		result = append(result, *rollout)
+	}
+	return result, nil
+}
+
+// start makes the rollout of the device, m.mx must be held.
+func (m *Manager) start(id string, version string) *Rollout {
+	d := m.devices[id]
+	status, _ := d.remote.LastStatus()
+	now := time.Now()
+	rollout := &Rollout{
+		Device:          id,
+		Version:         version,
+		PreviousVersion: status.FirmwareVersion,
+		State:           RolloutInstalling,
+		StartedAt:       now,
+		UpdatedAt:       now,
+		deadline:        now.Add(RolloutTimeout),
+	}
+	if status.FirmwareVersion == version {
+		rollout.State = RolloutSucceeded
+		rollout.Error = "already running this version"
+	}
+	d.rollout = rollout
+	return rollout
+}
+
+// offer sends the image to the remote, failing the rollout if it is rejected.
+func (m *Manager) offer(ctx context.Context, id string, rollout *Rollout, version string) {
+	m.mx.Lock()
+	remote := m.devices[id].remote
+	m.mx.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, offerTimeout)
+	defer cancel()
+	err := m.offerImage(ctx, remote, version)
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	rollout.UpdatedAt = time.Now()
+	if err != nil {
+		rollout.State = RolloutFailed
+		rollout.Error = err.Error()
+		return
+	}
+	rollout.offered = true
+	rollout.offeredAt = rollout.UpdatedAt
+}
+
+func (m *Manager) offerImage(ctx context.Context, remote Remote, version string) error {
+	image, ok := m.images.Image(version)
+	if !ok {
+		return fmt.Errorf("no image of version %q", version)
+	}
+	return remote.OfferFirmware(ctx, irremote.FirmwareOffer{
+		Version: image.Version,
+		Url:     m.baseUrl + "/ota/" + image.Version + ImageExtension,
+		Size:    image.Size,
+		Md5:     image.Md5,
+	})
+}
+
+func (m *Manager) observe(id string, status irremote.Status, at time.Time) {
+	m.mx.Lock()
+	d := m.devices[id]
+	rollout := d.rollout
+	changed := false
+	if rollout != nil && rollout.offered {
+		changed = advance(rollout, status, at)
+	}
+	m.mx.Unlock()
+
+	if changed {
+		m.notify(rollout)
+	}
+}
+
+// advance moves the rollout on from a status the remote reported.
+func advance(rollout *Rollout, status irremote.Status, at time.Time) bool {
+	switch {
+	case rollout.State == RolloutInstalling && status.FirmwareVersion == rollout.Version:
+		rollout.State = RolloutSucceeded
+	case rollout.State == RolloutInstalling && status.UpdateError != "":
+		rollout.State = RolloutFailed
+		rollout.Error = status.UpdateError
+	case rollout.State == RolloutRollingBack && status.FirmwareVersion == rollout.PreviousVersion:
+		rollout.State = RolloutRolledBack
+	case rollout.State == RolloutRollingBack && status.UpdateError != "":
+		rollout.State = RolloutFailed
+		rollout.Error = "rollback failed: " + status.UpdateError
+	default:
+		return false
+	}
+	rollout.UpdatedAt = at
+	return true
+}
+
+// Run checks the rollouts for remotes that did not come back in time or that
+// keep rebooting with the new firmware.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check(ctx, time.Now())
+		}
+	}
+}
+
+func (m *Manager) check(ctx context.Context, now time.Time) {
+	type work struct {
+		id      string
+		rollout *Rollout
+	}
+	var rollbacks []work
+	var changed []*Rollout
+
+	m.mx.Lock()
+	for id, d := range m.devices {
+		rollout := d.rollout
+		if rollout == nil {
+			continue
+		}
+
+		looping := d.health != nil && d.health.Status(now).RebootLoop
+		switch {
+		case rollout.State == RolloutInstalling && rollout.offered && now.After(rollout.deadline):
+			// a remote that did not report since the offer did not come back
+			status, at := d.remote.LastStatus()
+			if at.After(rollout.offeredAt) && status.FirmwareVersion == rollout.PreviousVersion && status.FirmwareVersion != "" {
+				rollout.State = RolloutFailed
+				rollout.Error = "the remote is still running " + status.FirmwareVersion
+				rollout.UpdatedAt = now
+				changed = append(changed, rollout)
+				continue
+			}
+			rollout.Error = fmt.Sprintf("the remote did not come back with %v in %v", rollout.Version, RolloutTimeout)
+			rollbacks = append(rollbacks, work{id, rollout})
+
+		case rollout.State == RolloutSucceeded && looping && now.Before(rollout.UpdatedAt.Add(RollbackWatch)) &&
+			rollout.PreviousVersion != "":
+			rollout.Error = "the remote is in a reboot loop"
+			rollbacks = append(rollbacks, work{id, rollout})
+
+		case rollout.State == RolloutRollingBack && !rollout.offered && now.Before(rollout.deadline):
+			rollbacks = append(rollbacks, work{id, rollout})
+
+		case rollout.State == RolloutRollingBack && now.After(rollout.deadline):
+			rollout.State = RolloutFailed
+			rollout.Error = "the remote did not come back with " + rollout.PreviousVersion
+			rollout.UpdatedAt = now
+			changed = append(changed, rollout)
+		}
+	}
+	m.mx.Unlock()
+
+	for _, w := range rollbacks {
+		m.rollback(ctx, w.id, w.rollout, now)
+		changed = append(changed, w.rollout)
+	}
+	for _, rollout := range changed {
+		m.notify(rollout)
+	}
+}
+
+// rollback offers the previous version again. A remote in a reboot loop may
+// be offline when the offer is sent, it is retried until the deadline.
+func (m *Manager) rollback(ctx context.Context, id string, rollout *Rollout, now time.Time) {
+	m.mx.Lock()
+	firstAttempt := rollout.State != RolloutRollingBack
+	if firstAttempt {
+		log.Println("rolling back device", id, "to", rollout.PreviousVersion+":", rollout.Error)
+		rollout.State = RolloutRollingBack
+		rollout.offered = false
+		rollout.deadline = now.Add(RolloutTimeout)
+	}
+	previous := rollout.PreviousVersion
+	m.mx.Unlock()
+
+	if previous == "" {
+		m.fail(rollout, errors.New("the previous version is unknown, can not roll back"), now)
+		return
+	}
+	if _, ok := m.images.Image(previous); !ok {
+		m.fail(rollout, fmt.Errorf("no image of the previous version %v to roll back to", previous), now)
+		return
+	}
+
+	m.mx.Lock()
+	remote := m.devices[id].remote
+	m.mx.Unlock()
+
+	offerCtx, cancel := context.WithTimeout(ctx, offerTimeout)
+	defer cancel()
+	if err := m.offerImage(offerCtx, remote, previous); err != nil {
+		log.Println("failed to offer the previous firmware to device", id, err)
+		return
+	}
+
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	rollout.offered = true
+	rollout.UpdatedAt = now
+}
+
+func (m *Manager) fail(rollout *Rollout, err error, now time.Time) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	rollout.State = RolloutFailed
+	rollout.Error = err.Error()
+	rollout.UpdatedAt = now
+}
+
+func (m *Manager) notify(rollout *Rollout) {
+	m.mx.Lock()
+	copied := *rollout
+	listeners := append([]func(Rollout){}, m.listeners...)
+	m.mx.Unlock()
+
+	for _, listener := range listeners {
+		listener(copied)
+	}
+}
@@ -0,0 +1,433 @@
+package reverse
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/waveform"
+	"sort"
+	"strconv"
+)
+
+// Parameters ParseLabel recognises, named after the AcState fields.
+const PARAM_POWER = "power"
+const PARAM_MODE = "mode"
+const PARAM_TEMPERATURE = "temperature"
+const PARAM_FAN = "fan"
+const PARAM_SWING = "swing"
+
+// Timings are the averaged durations of the pulse distance encoding, zero if not seen.
+type Timings struct {
+	HeaderMark  int
+	HeaderSpace int
+	BitMark     int
+	OneSpace    int
+	ZeroSpace   int
+	// Gap is the shortest space separating frames
+	Gap int
+}
+
+// Frame is a part of the signal between headers or gaps. Frames are packed
+// into the state one after another, each one starting at a new byte.
+type Frame struct {
+	Header bool
+	Bits   int
+	// Offset of the first byte of the frame in the state
+	Offset int
+}
+
+func (f Frame) Bytes() int {
+	return (f.Bits + 7) / 8
+}
+
+// Field is a run of bits, in the order they are sent, that depends on a parameter.
+type Field struct {
+	Param    string
+	FirstBit int
+	Bits     int
+	// Linear fields hold the value minus Offset, others are described by Table
+	Linear bool
+	Offset int
+	Table  map[string]uint64
+}
+
+// Checksum is a byte computed from the bytes [Start, End) of the state. The
+// algorithm result is adjusted by Constant: added for sum, xored for the rest.
+type Checksum struct {
+	Byte      int
+	Algorithm string
+	Start     int
+	End       int
+	Constant  byte
+	// CRC parameters, for crc8 algorithms
+	Poly      byte
+	Reflected bool
+}
+
+type Analysis struct {
+	MsbFirst bool
+	Timings  Timings
+	Frames   []Frame
+	Names    []string
+	Params   []map[string]string
+	States   [][]byte
+	// Constant bytes are the same in all samples
+	Constant  []bool
+	Checksums []Checksum
+	Fields    []Field
+	// Unexplained bits change, but not together with any parameter
+	Unexplained []int
+}
+
+// Analyze decodes the samples as pulse distance signals, packs their bits into
+// bytes and looks for constant bytes, checksums and fields following the
+// parameters. All samples must have the same frames.
+func Analyze(samples []Sample, msbFirst bool) (Analysis, error) {
+	if len(samples) < 2 {
+		return Analysis{}, errors.New("at least two samples are required")
+	}
+
+	a := Analysis{
+		MsbFirst:  msbFirst,
+		Names:     make([]string, len(samples)),
+		Params:    completeParams(samples),
+		States:    make([][]byte, len(samples)),
+		Checksums: make([]Checksum, 0),
+		Fields:    make([]Field, 0),
+	}
+
+	timings := timingsCollector{}
+	for i, sample := range samples {
+		frames, bits := splitFrames(sample.Signal, &timings)
+		if i == 0 {
+			a.Frames = frames
+		} else if err := sameFrames(a.Frames, frames); err != nil {
+			return Analysis{}, fmt.Errorf("sample %v: %w", sample.Name, err)
+		}
+
+		a.Names[i] = sample.Name
+		a.States[i] = packBits(frames, bits, msbFirst)
+	}
+	a.Timings = timings.average()
+
+	if len(a.States[0]) == 0 {
+		return Analysis{}, errors.New("samples have no bits")
+	}
+
+	a.Constant = make([]bool, len(a.States[0]))
+	for b := range a.Constant {
+		a.Constant[b] = true
+		for _, state := range a.States {
+			a.Constant[b] = a.Constant[b] && state[b] == a.States[0][b]
+		}
+	}
+
+	checksumBytes := make(map[int]bool)
+	for _, frame := range a.Frames {
+		for b := frame.Offset; b < frame.Offset+frame.Bytes(); b++ {
+			if a.Constant[b] {
+				continue
+			}
+			if checksum, ok := findChecksum(a.States, b, frame.Offset); ok {
+				a.Checksums = append(a.Checksums, checksum)
+				checksumBytes[b] = true
+			}
+		}
+	}
+
+	a.findFields(checksumBytes)
+	return a, nil
+}
+
+// splitFrames returns the frames of the signal and their bits
+func splitFrames(signal []int, timings *timingsCollector) ([]Frame, [][]bool) {
+	w := waveform.Analyze(signal)
+
+	frames := make([]Frame, 0, len(w.Frames))
+	bits := make([][]bool, 0, len(w.Frames))
+	offset := 0
+	for i, start := range w.Frames {
+		end := len(signal)
+		if i+1 < len(w.Frames) {
+			end = w.Frames[i+1]
+		}
+		if i > 0 {
+			timings.gap(signal[start-1])
+		}
+
+		_, bitAtStart := w.BitAt(start)
+		frame := Frame{Header: !bitAtStart, Offset: offset}
+		if frame.Header && start+1 < len(signal) {
+			timings.header(signal[start], signal[start+1])
+		}
+
+		frameBits := make([]bool, 0)
+		for _, bit := range w.Bits {
+			if bit.Position >= start && bit.Position < end {
+				frameBits = append(frameBits, bit.Value)
+				timings.bit(signal[bit.Position], signal[bit.Position+1], bit.Value)
+			}
+		}
+
+		frame.Bits = len(frameBits)
+		offset += frame.Bytes()
+		frames = append(frames, frame)
+		bits = append(bits, frameBits)
+	}
+	return frames, bits
+}
+
+func sameFrames(expected []Frame, actual []Frame) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("expected %v frames, got %v", len(expected), len(actual))
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			return fmt.Errorf("expected frame %v of %v bits, got %v", i, expected[i].Bits, actual[i].Bits)
+		}
+	}
+	return nil
+}
+
+func packBits(frames []Frame, bits [][]bool, msbFirst bool) []byte {
+	size := 0
+	for _, frame := range frames {
+		size += frame.Bytes()
+	}
+
+	state := make([]byte, size)
+	for i, frame := range frames {
+		for j, bit := range bits[i] {
+			if bit {
+				index := frame.Offset*8 + j
+				state[index/8] |= bitWeight(index, msbFirst)
+			}
+		}
+	}
+	return state
+}
+
+// bitWeight is the mask of the bit with the given index, in the order bits
+// are sent, within its byte.
+func bitWeight(index int, msbFirst bool) byte {
+	if msbFirst {
+		return 1 << uint(7-index%8)
+	}
+	return 1 << uint(index%8)
+}
+
+func bitValue(state []byte, index int, msbFirst bool) bool {
+	return state[index/8]&bitWeight(index, msbFirst) != 0
+}
+
+func (a *Analysis) findFields(checksumBytes map[int]bool) {
+	type explainedBit struct {
+		index int
+		param string
+	}
+
+	paramNames := make([]string, 0, len(a.Params[0]))
+	for name := range a.Params[0] {
+		paramNames = append(paramNames, name)
+	}
+	sort.Slice(paramNames, func(i, j int) bool {
+		// the parameter with fewer distinct values is the more specific explanation
+		ci, cj := a.distinctValues(paramNames[i]), a.distinctValues(paramNames[j])
+		if ci != cj {
+			return ci < cj
+		}
+		return paramNames[i] < paramNames[j]
+	})
+
+	a.Unexplained = make([]int, 0)
+	explained := make([]explainedBit, 0)
+	changedBits := make(map[int]bool)
+	for index := 0; index < 8*len(a.States[0]); index++ {
+		if a.Constant[index/8] || checksumBytes[index/8] {
+			continue
+		}
+
+		values := make([]bool, len(a.States))
+		changed := false
+		for i, state := range a.States {
+			values[i] = bitValue(state, index, a.MsbFirst)
+			changed = changed || values[i] != values[0]
+		}
+		if !changed {
+			continue
+		}
+		changedBits[index] = true
+
+		param := ""
+		for _, name := range paramNames {
+			if a.determines(name, values) {
+				param = name
+				break
+			}
+		}
+
+		if param == "" {
+			a.Unexplained = append(a.Unexplained, index)
+		} else {
+			explained = append(explained, explainedBit{index: index, param: param})
+		}
+	}
+
+	// bits of a parameter separated only by bits that never changed, like the
+	// middle bit of a mode field when the samples have only two modes, are
+	// likely a single field
+	continues := func(previous explainedBit, bit explainedBit) bool {
+		if previous.param != bit.param || bit.index-previous.index > 8 {
+			return false
+		}
+		for index := previous.index + 1; index < bit.index; index++ {
+			if changedBits[index] {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i, bit := range explained {
+		if i > 0 && continues(explained[i-1], bit) {
+			field := &a.Fields[len(a.Fields)-1]
+			field.Bits = bit.index - field.FirstBit + 1
+			continue
+		}
+		a.Fields = append(a.Fields, Field{Param: bit.param, FirstBit: bit.index, Bits: 1})
+	}
+
+	for i := range a.Fields {
+		a.describeField(&a.Fields[i])
+	}
+}
+
+func (a *Analysis) distinctValues(param string) int {
+	values := make(map[string]bool)
+	for _, params := range a.Params {
+		values[params[param]] = true
+	}
+	return len(values)
+}
+
+// determines checks that samples with equal parameter values have equal bits
+func (a *Analysis) determines(param string, bits []bool) bool {
+	bitByValue := make(map[string]bool)
+	for i, params := range a.Params {
+		if bit, ok := bitByValue[params[param]]; ok && bit != bits[i] {
+			return false
+		}
+		bitByValue[params[param]] = bits[i]
+	}
+	return true
+}
+
+// Raw returns the value of the field in the state. The first bit sent is the
+// least significant one, unless the analysis is MSB first.
+func (a *Analysis) Raw(state []byte, field Field) uint64 {
+	var raw uint64
+	for i := 0; i < field.Bits; i++ {
+		if !bitValue(state, field.FirstBit+i, a.MsbFirst) {
+			continue
+		}
+		if a.MsbFirst {
+			raw |= 1 << uint(field.Bits-1-i)
+		} else {
+			raw |= 1 << uint(i)
+		}
+	}
+	return raw
+}
+
+func (a *Analysis) describeField(field *Field) {
+	field.Table = make(map[string]uint64)
+	field.Linear = true
+	for i, state := range a.States {
+		value := a.Params[i][field.Param]
+		raw := a.Raw(state, *field)
+		field.Table[value] = raw
+
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			field.Linear = false
+			continue
+		}
+		if i == 0 {
+			field.Offset = number - int(raw)
+		} else if number-int(raw) != field.Offset {
+			field.Linear = false
+		}
+	}
+	if !field.Linear {
+		field.Offset = 0
+	}
+}
+
+// Masks returns the bytes the field occupies with the masks of its bits.
+func (a *Analysis) Masks(field Field) ([]int, []byte) {
+	bytes := make([]int, 0)
+	masks := make([]byte, 0)
+	for i := field.FirstBit; i < field.FirstBit+field.Bits; i++ {
+		if len(bytes) == 0 || bytes[len(bytes)-1] != i/8 {
+			bytes = append(bytes, i/8)
+			masks = append(masks, 0)
+		}
+		masks[len(masks)-1] |= bitWeight(i, a.MsbFirst)
+	}
+	return bytes, masks
+}
+
+type timingsCollector struct {
+	headerMarks  []int
+	headerSpaces []int
+	bitMarks     []int
+	oneSpaces    []int
+	zeroSpaces   []int
+	gaps         []int
+}
+
+func (c *timingsCollector) header(mark int, space int) {
+	c.headerMarks = append(c.headerMarks, mark)
+	c.headerSpaces = append(c.headerSpaces, space)
+}
+
+func (c *timingsCollector) bit(mark int, space int, value bool) {
+	c.bitMarks = append(c.bitMarks, mark)
+	if value {
+		c.oneSpaces = append(c.oneSpaces, space)
+	} else {
+		c.zeroSpaces = append(c.zeroSpaces, space)
+	}
+}
+
+func (c *timingsCollector) gap(space int) {
+	c.gaps = append(c.gaps, space)
+}
+
+func (c *timingsCollector) average() Timings {
+	gap := 0
+	for _, space := range c.gaps {
+		if gap == 0 || space < gap {
+			gap = space
+		}
+	}
+
+	return Timings{
+		HeaderMark:  average(c.headerMarks),
+		HeaderSpace: average(c.headerSpaces),
+		BitMark:     average(c.bitMarks),
+		OneSpace:    average(c.oneSpaces),
+		ZeroSpace:   average(c.zeroSpaces),
+		Gap:         gap,
+	}
+}
+
+func average(values []int) int {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0
+	for _, value := range values {
+		sum += value
+	}
+	return (sum + len(values)/2) / len(values)
+}
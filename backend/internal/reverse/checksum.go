@@ -0,0 +1,145 @@
+package reverse
+
+import (
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+)
+
+const ALGORITHM_COMPLEMENT = "complement"
+const ALGORITHM_SUM = "sum"
+const ALGORITHM_XOR = "xor"
+
+type checksumAlgorithm struct {
+	name    string
+	compute func(data []byte) byte
+	// additive algorithms are adjusted by adding a constant, the rest by xor
+	additive bool
+	// CRC parameters, if the algorithm is one
+	poly      byte
+	reflected bool
+}
+
+// crc8Variants are the polynomials of the common CRC-8 flavours. An initial
+// value or a final xor only changes the result by a constant for data of
+// fixed length, so they are covered by the xored constant.
+var crc8Variants = []struct {
+	poly      byte
+	reflected bool
+}{
+	{0x07, false}, // CRC-8, CRC-8/ITU
+	{0x31, false}, // CRC-8/NRSC-5
+	{0x1D, false}, // CRC-8/SAE-J1850
+	{0x2F, false}, // CRC-8/AUTOSAR
+	{0x9B, false}, // CRC-8/CDMA2000
+	{0x8C, true},  // CRC-8/MAXIM, reflected 0x31
+	{0xE0, true},  // CRC-8/ROHC reflected 0x07
+	{0xB8, true},  // CRC-8/DARC reflected 0x1D
+	{0xD9, true},  // CRC-8/WCDMA reflected 0x9B
+}
+
+var checksumAlgorithms = buildChecksumAlgorithms()
+
+func buildChecksumAlgorithms() []checksumAlgorithm {
+	result := []checksumAlgorithm{
+		{name: ALGORITHM_SUM, compute: commands.SumBytes, additive: true},
+		{name: ALGORITHM_XOR, compute: commands.XorBytes},
+	}
+	for _, variant := range crc8Variants {
+		variant := variant
+		result = append(result, checksumAlgorithm{
+			name: crc8Name(variant.poly, variant.reflected),
+			compute: func(data []byte) byte {
+				return commands.Crc8(variant.poly, variant.reflected, data)
+			},
+			poly:      variant.poly,
+			reflected: variant.reflected,
+		})
+	}
+	return result
+}
+
+func crc8Name(poly byte, reflected bool) string {
+	if reflected {
+		return fmt.Sprintf("crc8/%#02x/reflected", poly)
+	}
+	return fmt.Sprintf("crc8/%#02x", poly)
+}
+
+// findChecksum checks whether byte b of every state is the complement of the
+// previous byte, or a checksum of the bytes before it, counting either from
+// the start of its frame or from the start of the state.
+func findChecksum(states [][]byte, b int, frameOffset int) (Checksum, bool) {
+	if b > frameOffset {
+		complement := true
+		for _, state := range states {
+			complement = complement && state[b] == ^state[b-1]
+		}
+		if complement {
+			return Checksum{Byte: b, Algorithm: ALGORITHM_COMPLEMENT, Start: b - 1, End: b, Constant: 0xFF}, true
+		}
+	}
+
+	starts := []int{frameOffset}
+	if frameOffset > 0 {
+		starts = append(starts, 0)
+	}
+
+	for _, start := range starts {
+		if start >= b {
+			continue
+		}
+		for _, algorithm := range checksumAlgorithms {
+			if constant, ok := matchChecksum(states, algorithm, b, start); ok {
+				return Checksum{
+					Byte:      b,
+					Algorithm: algorithm.name,
+					Start:     start,
+					End:       b,
+					Constant:  constant,
+					Poly:      algorithm.poly,
+					Reflected: algorithm.reflected,
+				}, true
+			}
+		}
+	}
+	return Checksum{}, false
+}
+
+func matchChecksum(states [][]byte, algorithm checksumAlgorithm, b int, start int) (byte, bool) {
+	var constant byte
+	for i, state := range states {
+		computed := algorithm.compute(state[start:b])
+
+		var difference byte
+		if algorithm.additive {
+			difference = state[b] - computed
+		} else {
+			difference = state[b] ^ computed
+		}
+
+		if i == 0 {
+			constant = difference
+		} else if difference != constant {
+			return 0, false
+		}
+	}
+	return constant, true
+}
+
+// Compute returns the checksum of the state.
+func (c Checksum) Compute(state []byte) byte {
+	if c.Algorithm == ALGORITHM_COMPLEMENT {
+		return ^state[c.Start]
+	}
+
+	for _, algorithm := range checksumAlgorithms {
+		if algorithm.name != c.Algorithm {
+			continue
+		}
+		if algorithm.additive {
+			return algorithm.compute(state[c.Start:c.End]) + c.Constant
+		}
+		return algorithm.compute(state[c.Start:c.End]) ^ c.Constant
+	}
+	panic("unknown checksum algorithm " + c.Algorithm)
+}
@@ -0,0 +1,505 @@
+package reverse
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"go/format"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenerateCodec writes a draft codec for the commands package in the style
+// of the hand written ones. Fields of the AcState that fit into a single byte
+// are mapped, everything else is left as TODO comments.
+func GenerateCodec(protocol string, a *Analysis) ([]byte, error) {
+	g := &codecGenerator{
+		a:      a,
+		buf:    &bytes.Buffer{},
+		name:   camelCase(protocol),
+		prefix: constPrefix(protocol),
+		msb:    a.MsbFirst,
+	}
+	g.encoding = strings.ToLower(g.name[:1]) + g.name[1:] + "Encoding"
+
+	g.generate(protocol)
+
+	source, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return g.buf.Bytes(), fmt.Errorf("generated code does not compile: %w", err)
+	}
+	return source, nil
+}
+
+type codecGenerator struct {
+	a        *Analysis
+	buf      *bytes.Buffer
+	name     string
+	prefix   string
+	encoding string
+	msb      bool
+}
+
+func (g *codecGenerator) p(format string, args ...any) {
+	fmt.Fprintf(g.buf, format+"\n", args...)
+}
+
+func (g *codecGenerator) generate(protocol string) {
+	a := g.a
+	t := a.Timings
+	command := g.name + "Command"
+	signature := g.signatureLength()
+
+	g.p("package commands")
+	g.p("")
+	g.p("import (")
+	if signature > 0 {
+		g.p(`"bytes"`)
+	}
+	g.p(`"fmt"`)
+	g.p(")")
+	g.p("")
+	g.p("// Generated by irctl analyze from %v samples, review before use:", len(a.States))
+	g.p("// bits that never changed in the samples are not part of any field.")
+	g.p("// Register the codec in acProtocols to make it available.")
+	g.p("")
+	g.p("const %v_HDR_MARK = %v", g.prefix, t.HeaderMark)
+	g.p("const %v_HDR_SPACE = %v", g.prefix, t.HeaderSpace)
+	g.p("const %v_BIT_MARK = %v", g.prefix, t.BitMark)
+	g.p("const %v_ONE_SPACE = %v", g.prefix, t.OneSpace)
+	g.p("const %v_ZERO_SPACE = %v", g.prefix, t.ZeroSpace)
+	if len(a.Frames) > 1 {
+		g.p("const %v_GAP = %v", g.prefix, t.Gap)
+	}
+	g.p("")
+	g.p("const %v_STATE_LENGTH = %v", g.prefix, len(a.States[0]))
+	if minTemperature, maxTemperature, ok := g.temperatureRange(); ok {
+		g.p("const %v_MIN_TEMP = %v", g.prefix, minTemperature)
+		g.p("const %v_MAX_TEMP = %v", g.prefix, maxTemperature)
+	}
+	if signature > 0 {
+		g.p("")
+		g.p("var %vSignature = []byte{%v}", g.unexportedName(), hexBytes(a.States[0][:signature]))
+	}
+	g.p("")
+	g.p("var %v = pulseDistance{", g.encoding)
+	g.p("headerMark: %v_HDR_MARK,", g.prefix)
+	g.p("headerSpace: %v_HDR_SPACE,", g.prefix)
+	g.p("bitMark: %v_BIT_MARK,", g.prefix)
+	g.p("oneSpace: %v_ONE_SPACE,", g.prefix)
+	g.p("zeroSpace: %v_ZERO_SPACE,", g.prefix)
+	g.p("}")
+	g.p("")
+
+	g.p("// %v is the %v protocol:", command, protocol)
+	g.p("//")
+	for i, frame := range a.Frames {
+		header := "no header"
+		if frame.Header {
+			header = "header"
+		}
+		g.p("//\tframe %v: %v, %v bits, %v", i, header, frame.Bits, byteRange(frame.Offset, frame.Offset+frame.Bytes()))
+	}
+	for _, field := range a.Fields {
+		g.p("//\t%v: %v, %v", field.Param, a.Location(field), field.Describe())
+	}
+	for _, checksum := range a.Checksums {
+		g.p("//\tbyte %v: %v", checksum.Byte, checksum.Describe())
+	}
+	g.p("type %v struct {", command)
+	g.p("state [%v_STATE_LENGTH]byte", g.prefix)
+	g.p("}")
+	g.p("")
+	g.p("var _ AcCommand = &%v{}", command)
+	if !g.msb {
+		g.p("var _ PayloadCommand = &%v{}", command)
+	}
+	g.p("")
+
+	g.p("func New%v() *%v {", command, command)
+	g.p("return &%v{", command)
+	g.p("state: [%v_STATE_LENGTH]byte{%v},", g.prefix, hexBytes(a.States[0]))
+	g.p("}")
+	g.p("}")
+	g.p("")
+
+	g.generateParse(command, signature)
+	g.generateEncode(command)
+	g.generateState(command)
+	g.generateSetState(command)
+
+	g.p("func (c *%v) updateChecksums() {", command)
+	if len(a.Checksums) == 0 {
+		g.p("// TODO: no checksum was found in the samples")
+	}
+	for _, checksum := range a.Checksums {
+		g.p("c.state[%v] = %v", checksum.Byte, checksumExpression(checksum, "c.state"))
+	}
+	g.p("}")
+	g.p("")
+	g.p("func (c *%v) Carrier() Carrier {", command)
+	g.p("return DefaultCarrier")
+	g.p("}")
+	g.p("")
+	g.p("func (c *%v) DebugString() string {", command)
+	g.p(`return fmt.Sprintf("%v Command: %% X", c.state)`, g.name)
+	g.p("}")
+	if !g.msb {
+		g.p("")
+		g.p("func (c *%v) PayloadBits() []bool {", command)
+		g.p("return bytesToBitsLSB(c.state[:])")
+		g.p("}")
+	}
+}
+
+func (g *codecGenerator) generateParse(command string, signature int) {
+	g.p("func (c *%v) ParseFromSignalSequence(signalSequence []int) error {", command)
+	g.p("reader := newSignalReader(%v, signalSequence)", g.encoding)
+	g.p("")
+	g.p("var state [%v_STATE_LENGTH]byte", g.prefix)
+	g.p("var bits uint64")
+	g.p("var err error")
+	for i, frame := range g.a.Frames {
+		g.p("")
+		if frame.Header {
+			g.p("if err := reader.readHeader(); err != nil {")
+			g.p("return err")
+			g.p("}")
+		}
+
+		if full := frame.Bits / 8; full > 0 {
+			g.p("for i := 0; i < %v; i++ {", full)
+			g.p("if bits, err = reader.readBits(8, %v); err != nil {", g.msb)
+			g.p("return err")
+			g.p("}")
+			g.p("state[%v+i] = byte(bits)", frame.Offset)
+			g.p("}")
+		}
+		if rest := frame.Bits % 8; rest > 0 {
+			g.p("if bits, err = reader.readBits(%v, %v); err != nil {", rest, g.msb)
+			g.p("return err")
+			g.p("}")
+			if g.msb {
+				g.p("state[%v] = byte(bits << %v)", frame.Offset+frame.Bits/8, 8-rest)
+			} else {
+				g.p("state[%v] = byte(bits)", frame.Offset+frame.Bits/8)
+			}
+		}
+
+		if i == len(g.a.Frames)-1 {
+			g.p("if err := reader.readFooter(0); err != nil {")
+		} else {
+			g.p("if err := reader.readFooter(%v_GAP / 2); err != nil {", g.prefix)
+		}
+		g.p("return err")
+		g.p("}")
+	}
+	g.p("")
+	g.p("if err := reader.done(); err != nil {")
+	g.p("return err")
+	g.p("}")
+
+	if signature > 0 {
+		g.p("")
+		g.p("if !bytes.Equal(state[:%v], %vSignature) {", signature, g.unexportedName())
+		g.p(`return fmt.Errorf("invalid %v frame. unexpected signature %% X", state[:%v])`, strings.ToLower(g.name), signature)
+		g.p("}")
+	}
+
+	for _, checksum := range g.a.Checksums {
+		g.p("")
+		g.p("if expected := %v; state[%v] != expected {", checksumExpression(checksum, "state"), checksum.Byte)
+		g.p(`return fmt.Errorf("invalid %v frame. expected byte %v to be %%#02x, got %%#02x", expected, state[%v])`, strings.ToLower(g.name), checksum.Byte, checksum.Byte)
+		g.p("}")
+	}
+
+	g.p("")
+	g.p("c.state = state")
+	g.p("return nil")
+	g.p("}")
+	g.p("")
+}
+
+func (g *codecGenerator) generateEncode(command string) {
+	g.p("func (c *%v) ToSignalSequence() []int {", command)
+	g.p("seq := make([]int, 0)")
+	for i, frame := range g.a.Frames {
+		if frame.Header {
+			g.p("seq = %v.appendHeader(seq)", g.encoding)
+		}
+		if full := frame.Bits / 8; full > 0 {
+			g.p("for _, b := range c.state[%v:%v] {", frame.Offset, frame.Offset+full)
+			g.p("seq = %v.appendBits(seq, uint64(b), 8, %v)", g.encoding, g.msb)
+			g.p("}")
+		}
+		if rest := frame.Bits % 8; rest > 0 {
+			last := frame.Offset + frame.Bits/8
+			if g.msb {
+				g.p("seq = %v.appendBits(seq, uint64(c.state[%v]>>%v), %v, true)", g.encoding, last, 8-rest, rest)
+			} else {
+				g.p("seq = %v.appendBits(seq, uint64(c.state[%v]), %v, false)", g.encoding, last, rest)
+			}
+		}
+		if i == len(g.a.Frames)-1 {
+			g.p("seq = %v.appendFooter(seq, 0)", g.encoding)
+		} else {
+			g.p("seq = %v.appendFooter(seq, %v_GAP)", g.encoding, g.prefix)
+		}
+	}
+	g.p("return seq")
+	g.p("}")
+	g.p("")
+}
+
+// mappedFields returns the fields of AcState parameters that can be mapped:
+// the parameter has a single field and it lies within one byte.
+func (g *codecGenerator) mappedFields() map[string]Field {
+	count := make(map[string]int)
+	for _, field := range g.a.Fields {
+		count[field.Param]++
+	}
+
+	result := make(map[string]Field)
+	for _, field := range g.a.Fields {
+		bytes, _ := g.a.Masks(field)
+		if count[field.Param] != 1 || len(bytes) != 1 {
+			continue
+		}
+		switch field.Param {
+		case PARAM_TEMPERATURE:
+			if field.Linear {
+				result[field.Param] = field
+			}
+		case PARAM_MODE, PARAM_FAN:
+			result[field.Param] = field
+		case PARAM_POWER, PARAM_SWING:
+			_, on := field.Table["true"]
+			_, off := field.Table["false"]
+			if on && off {
+				result[field.Param] = field
+			}
+		}
+	}
+	return result
+}
+
+func (g *codecGenerator) unmappedFields() []Field {
+	mapped := g.mappedFields()
+	result := make([]Field, 0)
+	for _, field := range g.a.Fields {
+		if _, ok := mapped[field.Param]; !ok {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+func (g *codecGenerator) generateState(command string) {
+	mapped := g.mappedFields()
+
+	g.p("func (c *%v) State() AcState {", command)
+	g.p("state := AcState{Power: true}")
+	for _, param := range sortedKeys(mapped) {
+		field := mapped[param]
+		raw := g.rawExpression(field)
+		switch param {
+		case PARAM_TEMPERATURE:
+			g.p("state.Temperature = int(%v) + %v", raw, field.Offset)
+		case PARAM_POWER, PARAM_SWING:
+			g.p("state.%v = %v == %#02x", exportedName(param), raw, field.Table["true"])
+		case PARAM_MODE, PARAM_FAN:
+			g.p("switch %v {", raw)
+			for _, value := range sortedKeys(field.Table) {
+				if constant, ok := acConstant(param, value); ok {
+					g.p("case %#02x:", field.Table[value])
+					g.p("state.%v = %v", exportedName(param), constant)
+				}
+			}
+			g.p("}")
+		}
+	}
+	for _, field := range g.unmappedFields() {
+		g.p("// TODO: %v: %v, %v", field.Param, g.a.Location(field), field.Describe())
+	}
+	g.p("return state")
+	g.p("}")
+	g.p("")
+}
+
+func (g *codecGenerator) generateSetState(command string) {
+	mapped := g.mappedFields()
+
+	g.p("func (c *%v) SetState(state AcState) error {", command)
+	if _, ok := mapped[PARAM_TEMPERATURE]; ok {
+		g.p("if err := validateTemperature(state.Temperature, %v_MIN_TEMP, %v_MAX_TEMP); err != nil {", g.prefix, g.prefix)
+		g.p("return err")
+		g.p("}")
+		g.p("")
+	}
+
+	for _, param := range sortedKeys(mapped) {
+		field := mapped[param]
+		bytes, masks := g.a.Masks(field)
+		b, mask := bytes[0], masks[0]
+		shift := bits.TrailingZeros8(mask)
+
+		switch param {
+		case PARAM_TEMPERATURE:
+			g.p("c.state[%v] = c.state[%v]&^%#02x | byte(state.Temperature-%v)%v&%#02x", b, b, mask, field.Offset, shiftLeft(shift), mask)
+		case PARAM_POWER, PARAM_SWING:
+			g.p("%v := byte(%#02x)", param, field.Table["false"])
+			g.p("if state.%v {", exportedName(param))
+			g.p("%v = %#02x", param, field.Table["true"])
+			g.p("}")
+			g.p("c.state[%v] = c.state[%v]&^%#02x | %v%v", b, b, mask, param, shiftLeft(shift))
+		case PARAM_MODE, PARAM_FAN:
+			g.p("var %v byte", param)
+			g.p("switch state.%v {", exportedName(param))
+			for _, value := range sortedKeys(field.Table) {
+				if constant, ok := acConstant(param, value); ok {
+					g.p("case %v:", constant)
+					g.p("%v = %#02x", param, field.Table[value])
+				}
+			}
+			g.p("default:")
+			g.p(`return fmt.Errorf("%v %%v is not supported", state.%v)`, param, exportedName(param))
+			g.p("}")
+			g.p("c.state[%v] = c.state[%v]&^%#02x | %v%v", b, b, mask, param, shiftLeft(shift))
+		}
+		g.p("")
+	}
+	for _, field := range g.unmappedFields() {
+		g.p("// TODO: %v: %v, %v", field.Param, g.a.Location(field), field.Describe())
+	}
+
+	g.p("c.updateChecksums()")
+	g.p("return nil")
+	g.p("}")
+	g.p("")
+}
+
+func (g *codecGenerator) rawExpression(field Field) string {
+	bytes, masks := g.a.Masks(field)
+	shift := bits.TrailingZeros8(masks[0])
+	if shift == 0 {
+		return fmt.Sprintf("c.state[%v]&%#02x", bytes[0], masks[0])
+	}
+	return fmt.Sprintf("c.state[%v]&%#02x>>%v", bytes[0], masks[0], shift)
+}
+
+// signatureLength is the number of constant bytes the state starts with.
+func (g *codecGenerator) signatureLength() int {
+	length := 0
+	for length < len(g.a.Constant) && g.a.Constant[length] {
+		length++
+	}
+	return length
+}
+
+func (g *codecGenerator) temperatureRange() (int, int, bool) {
+	minTemperature, maxTemperature, ok := 0, 0, false
+	for _, params := range g.a.Params {
+		temperature, err := strconv.Atoi(params[PARAM_TEMPERATURE])
+		if err != nil {
+			continue
+		}
+		if !ok || temperature < minTemperature {
+			minTemperature = temperature
+		}
+		if !ok || temperature > maxTemperature {
+			maxTemperature = temperature
+		}
+		ok = true
+	}
+	return minTemperature, maxTemperature, ok
+}
+
+func (g *codecGenerator) unexportedName() string {
+	return strings.ToLower(g.name[:1]) + g.name[1:]
+}
+
+func shiftLeft(shift int) string {
+	if shift == 0 {
+		return ""
+	}
+	return fmt.Sprintf("<<%v", shift)
+}
+
+func checksumExpression(checksum Checksum, state string) string {
+	data := fmt.Sprintf("%v[%v:%v]", state, checksum.Start, checksum.End)
+	switch checksum.Algorithm {
+	case ALGORITHM_COMPLEMENT:
+		return fmt.Sprintf("^%v[%v]", state, checksum.Start)
+	case ALGORITHM_SUM:
+		return fmt.Sprintf("SumBytes(%v) + %#02x", data, checksum.Constant)
+	case ALGORITHM_XOR:
+		return fmt.Sprintf("XorBytes(%v) ^ %#02x", data, checksum.Constant)
+	default:
+		return fmt.Sprintf("Crc8(%#02x, %v, %v) ^ %#02x", checksum.Poly, checksum.Reflected, data, checksum.Constant)
+	}
+}
+
+func acConstant(param string, value string) (string, bool) {
+	switch param {
+	case PARAM_MODE:
+		if _, err := commands.ParseAcMode(value); err == nil {
+			return "AcMode" + exportedName(value), true
+		}
+	case PARAM_FAN:
+		if _, err := commands.ParseAcFan(value); err == nil {
+			return "AcFan" + exportedName(value), true
+		}
+	}
+	return "", false
+}
+
+func hexBytes(data []byte) string {
+	parts := make([]string, len(data))
+	for i, b := range data {
+		parts[i] = fmt.Sprintf("0x%02X", b)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func words(protocol string) []string {
+	return strings.FieldsFunc(protocol, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func exportedName(word string) string {
+	return strings.ToUpper(word[:1]) + word[1:]
+}
+
+// camelCase turns a protocol name like mitsubishi-heavy into MitsubishiHeavy
+func camelCase(protocol string) string {
+	result := ""
+	for _, word := range words(protocol) {
+		result += exportedName(strings.ToLower(word))
+	}
+	if result == "" {
+		return "Unknown"
+	}
+	return result
+}
+
+// constPrefix turns a protocol name like mitsubishi-heavy into MITSUBISHI_HEAVY
+func constPrefix(protocol string) string {
+	prefix := strings.ToUpper(strings.Join(words(protocol), "_"))
+	if prefix == "" {
+		return "UNKNOWN"
+	}
+	return prefix
+}
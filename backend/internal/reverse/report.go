@@ -0,0 +1,130 @@
+package reverse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Report is the draft field map: frames, constant bytes, checksums and
+// fields found, followed by the states of the samples.
+func (a *Analysis) Report() string {
+	result := &strings.Builder{}
+
+	order := "LSB"
+	if a.MsbFirst {
+		order = "MSB"
+	}
+	fmt.Fprintf(result, "%v samples, %v frames, %v bytes, %v first\n", len(a.States), len(a.Frames), len(a.States[0]), order)
+	t := a.Timings
+	fmt.Fprintf(result, "timings: header %v/%v, bit mark %v, zero space %v, one space %v, gap %v\n\n", t.HeaderMark, t.HeaderSpace, t.BitMark, t.ZeroSpace, t.OneSpace, t.Gap)
+
+	result.WriteString("frames:\n")
+	for i, frame := range a.Frames {
+		header := "no header"
+		if frame.Header {
+			header = "header"
+		}
+		fmt.Fprintf(result, "  %v: %v, %v bits, %v\n", i, header, frame.Bits, byteRange(frame.Offset, frame.Offset+frame.Bytes()))
+	}
+
+	result.WriteString("\nconstant bytes:\n")
+	for b := 0; b < len(a.Constant); {
+		if !a.Constant[b] {
+			b++
+			continue
+		}
+		end := b
+		for end < len(a.Constant) && a.Constant[end] {
+			end++
+		}
+		fmt.Fprintf(result, "  %v: % X\n", byteRange(b, end), a.States[0][b:end])
+		b = end
+	}
+
+	if len(a.Checksums) > 0 {
+		result.WriteString("\nchecksums:\n")
+	}
+	for _, checksum := range a.Checksums {
+		fmt.Fprintf(result, "  byte %v: %v\n", checksum.Byte, checksum.Describe())
+	}
+
+	if len(a.Fields) > 0 {
+		result.WriteString("\nfields:\n")
+	}
+	for _, field := range a.Fields {
+		fmt.Fprintf(result, "  %v: %v, %v\n", field.Param, a.Location(field), field.Describe())
+	}
+
+	if len(a.Unexplained) > 0 {
+		bits := make([]string, len(a.Unexplained))
+		for i, index := range a.Unexplained {
+			bits[i] = fmt.Sprintf("%v (byte %v mask %#02x)", index, index/8, bitWeight(index, a.MsbFirst))
+		}
+		fmt.Fprintf(result, "\nbits changing with no parameter: %v\n", strings.Join(bits, ", "))
+	}
+
+	nameWidth := 0
+	for _, name := range a.Names {
+		if len(name) > nameWidth {
+			nameWidth = len(name)
+		}
+	}
+	result.WriteString("\nsamples:\n")
+	for i, state := range a.States {
+		fmt.Fprintf(result, "  %-*v % X\n", nameWidth, a.Names[i], state)
+	}
+	return result.String()
+}
+
+// Location describes the bytes and bit masks the field occupies.
+func (a *Analysis) Location(field Field) string {
+	bytes, masks := a.Masks(field)
+	parts := make([]string, len(bytes))
+	for i := range bytes {
+		parts[i] = fmt.Sprintf("byte %v mask %#02x", bytes[i], masks[i])
+	}
+	return strings.Join(parts, " + ")
+}
+
+// Describe explains how the parameter value maps to the field.
+func (f Field) Describe() string {
+	if f.Linear {
+		return fmt.Sprintf("raw = value - %v", f.Offset)
+	}
+
+	values := make([]string, 0, len(f.Table))
+	for value := range f.Table {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%v=%0*b", value, f.Bits, f.Table[value])
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c Checksum) Describe() string {
+	if c.Algorithm == ALGORITHM_COMPLEMENT {
+		return fmt.Sprintf("complement of byte %v", c.Start)
+	}
+
+	adjustment := ""
+	switch {
+	case c.Constant == 0:
+	case c.Algorithm == ALGORITHM_SUM:
+		adjustment = fmt.Sprintf(" + %#02x", c.Constant)
+	default:
+		adjustment = fmt.Sprintf(" ^ %#02x", c.Constant)
+	}
+	return fmt.Sprintf("%v(%v)%v", c.Algorithm, byteRange(c.Start, c.End), adjustment)
+}
+
+func byteRange(start int, end int) string {
+	if end-start == 1 {
+		return fmt.Sprintf("byte %v", start)
+	}
+	return fmt.Sprintf("bytes %v-%v", start, end-1)
+}
@@ -0,0 +1,160 @@
+package reverse
+
+import (
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/stretchr/testify/require"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func acSamples(t *testing.T, protocol string, modes []commands.AcMode, temperatures []int) []Sample {
+	samples := make([]Sample, 0)
+	for _, mode := range modes {
+		for _, temperature := range temperatures {
+			cmd, err := commands.NewAcCommand(protocol)
+			require.NoError(t, err)
+			require.NoError(t, cmd.SetState(commands.AcState{Power: true, Mode: mode, Temperature: temperature}))
+
+			name := fmt.Sprintf("%v_%v.ir", mode, temperature)
+			samples = append(samples, Sample{Name: name, Signal: cmd.ToSignalSequence(), Params: ParseLabel(name)})
+		}
+	}
+	return samples
+}
+
+func TestParseLabel(t *testing.T) {
+	require.Equal(t, map[string]string{"mode": "cool", "temperature": "20"}, ParseLabel("captures/cool_20.ir"))
+	require.Equal(t, map[string]string{"mode": "heat", "fan": "high", "turbo": "true", "power": "false", "vane": "3"}, ParseLabel("Heat_high_turbo_off_vane=3.json"))
+}
+
+func TestAnalyze_Gree(t *testing.T) {
+	samples := acSamples(t, "gree", []commands.AcMode{commands.AcModeCool, commands.AcModeHeat, commands.AcModeDry}, []int{20, 21, 24, 27})
+
+	a, err := Analyze(samples, false)
+	require.NoError(t, err)
+	require.Equal(t, []Frame{{Header: true, Bits: 35, Offset: 0}, {Header: false, Bits: 32, Offset: 5}}, a.Frames)
+	require.Equal(t, commands.GREE_MSG_SPACE, a.Timings.Gap)
+
+	require.Len(t, a.Fields, 2)
+	require.Equal(t, Field{Param: "mode", FirstBit: 0, Bits: 3, Table: map[string]uint64{"cool": 1, "dry": 2, "heat": 4}}, a.Fields[0])
+	require.Equal(t, "byte 1 mask 0x0f", a.Location(a.Fields[1]))
+	require.True(t, a.Fields[1].Linear)
+	require.Equal(t, commands.GREE_MIN_TEMP, a.Fields[1].Offset)
+
+	// the nibble checksum is none of the byte checksums
+	require.Empty(t, a.Checksums)
+	require.Equal(t, []int{68, 69, 70, 71}, a.Unexplained)
+	require.Contains(t, a.Report(), "temperature: byte 1 mask 0x0f, raw = value - 16")
+}
+
+func TestAnalyze_Checksums(t *testing.T) {
+	samples := acSamples(t, "daikin", []commands.AcMode{commands.AcModeCool, commands.AcModeHeat}, []int{18, 22, 25})
+	a, err := Analyze(samples, false)
+	require.NoError(t, err)
+	require.Len(t, a.Frames, 4)
+	require.Equal(t, []Checksum{{Byte: 35, Algorithm: ALGORITHM_SUM, Start: 17, End: 35}}, a.Checksums)
+
+	samples = acSamples(t, "mitsubishi-heavy", []commands.AcMode{commands.AcModeCool, commands.AcModeHeat}, []int{18, 22, 25})
+	a, err = Analyze(samples, false)
+	require.NoError(t, err)
+	for _, checksum := range a.Checksums {
+		require.Equal(t, ALGORITHM_COMPLEMENT, checksum.Algorithm)
+		require.Equal(t, checksum.Byte-1, checksum.Start)
+	}
+	require.NotEmpty(t, a.Checksums)
+}
+
+func TestAnalyze_Crc(t *testing.T) {
+	// a made up protocol: signature, temperature and a CRC-8/MAXIM of both
+	encoding := []int{3000, 1500}
+	samples := make([]Sample, 0)
+	for temperature := 18; temperature < 24; temperature++ {
+		state := []byte{0xA5, byte(temperature)}
+		state = append(state, commands.Crc8(0x8C, true, state))
+
+		signal := append([]int(nil), encoding...)
+		for _, b := range state {
+			for i := 0; i < 8; i++ {
+				space := 400
+				if b&(1<<uint(i)) != 0 {
+					space = 1200
+				}
+				signal = append(signal, 400, space)
+			}
+		}
+		signal = append(signal, 400)
+
+		name := fmt.Sprintf("%v.ir", temperature)
+		samples = append(samples, Sample{Name: name, Signal: signal, Params: ParseLabel(name)})
+	}
+
+	a, err := Analyze(samples, false)
+	require.NoError(t, err)
+	require.Equal(t, []Checksum{{Byte: 2, Algorithm: "crc8/0x8c/reflected", Start: 0, End: 2, Poly: 0x8C, Reflected: true}}, a.Checksums)
+	require.Equal(t, "crc8/0x8c/reflected(bytes 0-1)", a.Checksums[0].Describe())
+	require.Equal(t, a.States[3][2], a.Checksums[0].Compute(a.States[3]))
+}
+
+func TestAnalyze_DifferentFrames(t *testing.T) {
+	_, err := Analyze([]Sample{
+		{Name: "gree", Signal: commands.NewGreeCommand().ToSignalSequence()},
+		{Name: "lg", Signal: commands.NewLgCommand().ToSignalSequence()},
+	}, false)
+	require.Error(t, err)
+}
+
+// TestGenerateCodec type checks the generated codecs together with the commands package
+func TestGenerateCodec(t *testing.T) {
+	cases := []struct {
+		protocol string
+		samples  []Sample
+		msbFirst bool
+	}{
+		{"gree-clone", acSamples(t, "gree", []commands.AcMode{commands.AcModeCool, commands.AcModeHeat}, []int{20, 23, 26}), false},
+		{"daikin clone", acSamples(t, "daikin", []commands.AcMode{commands.AcModeCool, commands.AcModeDry}, []int{18, 22, 25}), false},
+		{"lg_clone", acSamples(t, "lg", []commands.AcMode{commands.AcModeCool, commands.AcModeFan}, []int{18, 22, 25}), true},
+	}
+
+	for _, c := range cases {
+		a, err := Analyze(c.samples, c.msbFirst)
+		require.NoError(t, err)
+
+		source, err := GenerateCodec(c.protocol, &a)
+		require.NoError(t, err, string(source))
+		typeCheckWithCommands(t, source)
+	}
+}
+
+func typeCheckWithCommands(t *testing.T, generated []byte) {
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0)
+
+	paths, err := filepath.Glob("../commands/*.go")
+	require.NoError(t, err)
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		source, err := os.ReadFile(path)
+		require.NoError(t, err)
+		file, err := parser.ParseFile(fset, path, source, 0)
+		require.NoError(t, err)
+		files = append(files, file)
+	}
+
+	file, err := parser.ParseFile(fset, "generated.go", generated, 0)
+	require.NoError(t, err)
+	files = append(files, file)
+
+	config := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	_, err = config.Check("commands", fset, files, nil)
+	require.NoError(t, err, string(generated))
+}
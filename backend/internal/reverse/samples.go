@@ -0,0 +1,99 @@
+package reverse
+
+import (
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Sample is a capture of an unknown remote with the settings it was sent with.
+type Sample struct {
+	Name   string
+	Signal []int
+	Params map[string]string
+}
+
+// ParseLabel reads remote settings from a file name like cool_20_high_swing.ir.
+// The name is split on underscores and every part is recognised as:
+//
+//	name=value   any parameter
+//	cool, heat   mode, any of the AcMode names
+//	20           temperature
+//	low, high    fan, any of the AcFan names except auto, which is a mode
+//	on, off      power
+//	anything     a flag, e.g. turbo, that is false in samples that lack it
+func ParseLabel(name string) map[string]string {
+	base := filepath.Base(name)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+
+	params := make(map[string]string)
+	for _, token := range strings.Split(strings.ToLower(base), "_") {
+		if token == "" {
+			continue
+		}
+
+		if key, value, ok := strings.Cut(token, "="); ok {
+			params[key] = value
+			continue
+		}
+
+		if _, err := commands.ParseAcMode(token); err == nil {
+			params[PARAM_MODE] = token
+			continue
+		}
+
+		if _, err := strconv.Atoi(token); err == nil {
+			params[PARAM_TEMPERATURE] = token
+			continue
+		}
+
+		if fan, err := commands.ParseAcFan(token); err == nil && fan != commands.AcFanAuto {
+			params[PARAM_FAN] = token
+			continue
+		}
+
+		switch token {
+		case "on":
+			params[PARAM_POWER] = "true"
+		case "off":
+			params[PARAM_POWER] = "false"
+		default:
+			params[token] = "true"
+		}
+	}
+	return params
+}
+
+// completeParams makes every sample have every parameter: flags missing
+// from a sample are false there, power is on unless labelled off and other
+// missing parameters are empty.
+func completeParams(samples []Sample) []map[string]string {
+	flags := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, sample := range samples {
+		for name, value := range sample.Params {
+			names[name] = true
+			if value == "true" {
+				flags[name] = true
+			}
+		}
+	}
+
+	result := make([]map[string]string, len(samples))
+	for i, sample := range samples {
+		result[i] = make(map[string]string)
+		for name := range names {
+			value, ok := sample.Params[name]
+			switch {
+			case ok:
+			case name == PARAM_POWER:
+				value = "true"
+			case flags[name]:
+				value = "false"
+			}
+			result[i][name] = value
+		}
+	}
+	return result
+}
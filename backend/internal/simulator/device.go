@@ -0,0 +1,163 @@
+package simulator
+
+import (
+	"github.com/Light-Keeper/ir-remote/internal/clock"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
+	"sync"
+	"time"
+)
+
+// PingInterval is how often the firmware reports its status when idle
+const PingInterval = 5 * time.Second
+
+// Execution is a command the device sent to the AC.
+type Execution struct {
+	// At is the time elapsed since the start of the simulation
+	At             time.Duration
+	SequenceNumber int64
+	Timings        []int
+}
+
+// Device emulates the firmware of a remote: it executes commands with a
+// sequence number above the last one it executed, reports its status right
+// after every command and every PingInterval, and forgets the last sequence
+// number when it reboots. It does not reassemble fragments and reports a
+// packet size that fits the commands without them.
+type Device struct {
+	clock   *clock.Fake
+	network *Network
+	encoder encoder.Encoder
+
+	mx            sync.Mutex
+	address       string
+	powered       bool
+	lastCommandId int64
+	bootedAt      time.Duration
+	// schedule invalidates the pings scheduled before a reboot or a command
+	schedule int
+	executed []Execution
+}
+
+// NewDevice connects a device to the network at the address and boots it.
+func NewDevice(clock *clock.Fake, network *Network, encoder encoder.Encoder, address string) *Device {
+	d := &Device{
+		clock:   clock,
+		network: network,
+		encoder: encoder,
+		address: address,
+	}
+	network.attach(d, "", address)
+	d.Boot()
+	return d
+}
+
+// Boot powers the device on, or reboots it if it is on. The device reports
+// its status right after booting.
+func (d *Device) Boot() {
+	d.mx.Lock()
+	d.powered = true
+	d.lastCommandId = 0
+	d.bootedAt = d.clock.Elapsed()
+	d.mx.Unlock()
+
+	d.ping()
+}
+
+// PowerOff makes the device silent until it boots again.
+func (d *Device) PowerOff() {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.powered = false
+	d.schedule++
+}
+
+// Rebind moves the device to another address, like a NAT mapping that
+// expired. Packets to the old address are lost.
+func (d *Device) Rebind(address string) {
+	d.mx.Lock()
+	previous := d.address
+	d.address = address
+	d.mx.Unlock()
+
+	d.network.attach(d, previous, address)
+}
+
+// Executed returns the commands executed so far.
+func (d *Device) Executed() []Execution {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return append([]Execution(nil), d.executed...)
+}
+
+// ping reports the status now and every PingInterval from now on
+func (d *Device) ping() {
+	d.mx.Lock()
+	d.schedule++
+	schedule := d.schedule
+	d.mx.Unlock()
+
+	d.report()
+	d.clock.AfterFunc(PingInterval, func() {
+		d.mx.Lock()
+		current := d.schedule == schedule && d.powered
+		d.mx.Unlock()
+		if current {
+			d.ping()
+		}
+	})
+}
+
+func (d *Device) report() {
+	d.mx.Lock()
+	uptime := int64((d.clock.Elapsed() - d.bootedAt) / time.Second)
+	status := irremote.Status{
+		LastCommandSequenceNumber: d.lastCommandId,
+		MinCarrierFrequency:       30000,
+		MaxCarrierFrequency:       60000,
+		ProtocolVersion:           irremote.ProtocolVersionOta,
+		MaxCommandLength:          600,
+		MaxPacketSize:             irremote.LegacyMaxPacketSize,
+		MaxMessageSize:            4096,
+		FirmwareVersion:           "simulated",
+		UptimeSeconds:             &uptime,
+	}
+	address := d.address
+	powered := d.powered
+	d.mx.Unlock()
+
+	if powered {
+		d.network.fromDevice(address, d.encoder.Encrypt(status))
+	}
+}
+
+// receive handles a packet like the firmware loop
+func (d *Device) receive(data []byte) {
+	cmd := irremote.Command{}
+	if err := d.encoder.Decrypt(data, &cmd); err != nil {
+		return
+	}
+
+	d.mx.Lock()
+	if !d.powered {
+		d.mx.Unlock()
+		return
+	}
+	timings := cmd.Data
+	if cmd.Packed != nil {
+		timings, _ = irremote.UnpackTimings(cmd.Packed)
+	}
+	// invalid commands are not acknowledged
+	if cmd.SequenceNumber > d.lastCommandId && len(timings) > 0 {
+		d.lastCommandId = cmd.SequenceNumber
+		d.executed = append(d.executed, Execution{
+			At:             d.clock.Elapsed(),
+			SequenceNumber: cmd.SequenceNumber,
+			Timings:        timings,
+		})
+	}
+	d.mx.Unlock()
+
+	// the firmware reports right away and pings again after the interval
+	d.ping()
+}
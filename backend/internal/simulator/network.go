@@ -0,0 +1,147 @@
+package simulator
+
+import (
+	"context"
+	"github.com/Light-Keeper/ir-remote/internal/clock"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/transport"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Conditions are the faults of the simulated network, the same both ways.
+type Conditions struct {
+	// Loss is the probability of a packet being lost, from 0 to 1
+	Loss float64
+	// Latency is how long a packet takes to arrive
+	Latency time.Duration
+}
+
+// NetworkStats counts the packets of the simulated network.
+type NetworkStats struct {
+	Sent int
+	Lost int
+	// Unreachable packets were sent to an address no device has
+	Unreachable int
+}
+
+// Network is the UDP network between the backend and the simulated
+// devices. It is the transport of the backend session; packets arrive on
+// the fake clock, so Step must be called for anything to happen.
+type Network struct {
+	clock   *clock.Fake
+	receive chan transport.Packet
+
+	mx         sync.Mutex
+	rand       *rand.Rand
+	conditions Conditions
+	devices    map[string]*Device
+	stats      NetworkStats
+	// delivered counts the packets that arrived at the backend
+	delivered int
+}
+
+// NewNetwork makes a network deciding which packets are lost with the seed,
+// so a scenario loses the same packets every run.
+func NewNetwork(clock *clock.Fake, seed int64) *Network {
+	return &Network{
+		clock:   clock,
+		receive: make(chan transport.Packet, 100),
+		rand:    rand.New(rand.NewSource(seed)),
+		devices: make(map[string]*Device),
+	}
+}
+
+func (n *Network) SetConditions(conditions Conditions) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.conditions = conditions
+}
+
+func (n *Network) Conditions() Conditions {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.conditions
+}
+
+func (n *Network) Stats() NetworkStats {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.stats
+}
+
+func (n *Network) Name() string {
+	return transport.UdpTransportName
+}
+
+// Send delivers the packet to the device at the peer address after the
+// latency, unless it is lost on the way.
+func (n *Network) Send(ctx context.Context, packet transport.Packet) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data := append([]byte(nil), packet.Data...)
+	n.transmit(func() {
+		n.mx.Lock()
+		device, ok := n.devices[packet.Peer.Id]
+		if !ok {
+			n.stats.Unreachable++
+		}
+		n.mx.Unlock()
+
+		if ok {
+			device.receive(data)
+		}
+	})
+	return nil
+}
+
+func (n *Network) Receive() <-chan transport.Packet {
+	return n.receive
+}
+
+// fromDevice delivers a packet of a device to the backend
+func (n *Network) fromDevice(address string, data []byte) {
+	n.transmit(func() {
+		n.mx.Lock()
+		n.delivered++
+		n.mx.Unlock()
+		n.receive <- transport.Packet{
+			Peer: transport.Peer{Transport: transport.UdpTransportName, Id: address},
+			Data: data,
+		}
+	})
+}
+
+// transmit calls deliver after the latency, unless the packet is lost
+func (n *Network) transmit(deliver func()) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.stats.Sent++
+	if n.rand.Float64() < n.conditions.Loss {
+		n.stats.Lost++
+		return
+	}
+	n.clock.AfterFunc(n.conditions.Latency, deliver)
+}
+
+// attach makes the device reachable at the address, the previous one of the
+// device is not any more
+func (n *Network) attach(device *Device, previous string, address string) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	delete(n.devices, previous)
+	n.devices[address] = device
+}
+
+// pending is the number of packets the backend has not taken yet
+func (n *Network) pending() int {
+	return len(n.receive)
+}
+
+func (n *Network) deliveredCount() int {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	return n.delivered
+}
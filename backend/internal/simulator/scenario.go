@@ -0,0 +1,133 @@
+package simulator
+
+import (
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/health"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Scenario is a script of buttons pressed and faults, played on a new
+// simulation for Duration.
+type Scenario struct {
+	Name     string
+	Seed     int64
+	Duration time.Duration
+	Steps    []Step
+}
+
+// Step is an action taken At a time from the start of the scenario.
+type Step struct {
+	At     time.Duration
+	Action Action
+}
+
+// Action is something the user, the network or the remote does.
+type Action func(s *Simulator)
+
+func PressButton(state commands.AcState) Action {
+	return func(s *Simulator) { s.Press(state) }
+}
+
+// SetLoss makes the network lose packets with the probability from 0 to 1.
+func SetLoss(loss float64) Action {
+	return func(s *Simulator) {
+		conditions := s.Network.Conditions()
+		conditions.Loss = loss
+		s.Network.SetConditions(conditions)
+	}
+}
+
+// SetLatency makes the packets sent from now on take the latency to arrive.
+func SetLatency(latency time.Duration) Action {
+	return func(s *Simulator) {
+		conditions := s.Network.Conditions()
+		conditions.Latency = latency
+		s.Network.SetConditions(conditions)
+	}
+}
+
+// Rebind moves the remote to another address.
+func Rebind(address string) Action {
+	return func(s *Simulator) { s.Device.Rebind(address) }
+}
+
+// Reboot restarts the remote, it forgets the last command executed.
+func Reboot() Action {
+	return func(s *Simulator) { s.Device.Boot() }
+}
+
+func PowerOff() Action {
+	return func(s *Simulator) { s.Device.PowerOff() }
+}
+
+// JumpClock sets the clock of the backend by d, like NTP stepping it.
+func JumpClock(d time.Duration) Action {
+	return func(s *Simulator) { s.Clock.Jump(d) }
+}
+
+// Outcome is what happened in a scenario.
+type Outcome struct {
+	Presses       []Press
+	Transmissions []Transmission
+	Executed      []Execution
+	Presence      []Presence
+	Health        health.Status
+	Network       NetworkStats
+}
+
+// Run plays the scenario. The steps run in the order of their times, steps
+// at the same time in the order given.
+func (sc Scenario) Run() Outcome {
+	steps := append([]Step(nil), sc.Steps...)
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].At < steps[j].At
+	})
+
+	s := New(sc.Seed)
+	defer s.Close()
+	for _, step := range steps {
+		s.Run(step.At - s.Clock.Elapsed())
+		step.Action(s)
+	}
+	s.Run(sc.Duration - s.Clock.Elapsed())
+
+	return Outcome{
+		Presses:       s.Presses(),
+		Transmissions: s.Transmissions(),
+		Executed:      s.Device.Executed(),
+		Presence:      s.Presence(),
+		Health:        s.Health.Status(s.Clock.Now()),
+		Network:       s.Network.Stats(),
+	}
+}
+
+// ExactlyOnce tells why the commands transmitted were not all acknowledged
+// and executed once each in order, nil if they were.
+func (o Outcome) ExactlyOnce() error {
+	for _, transmission := range o.Transmissions {
+		if transmission.Err != nil {
+			return fmt.Errorf("command transmitted at %v failed: %w", transmission.At, transmission.Err)
+		}
+	}
+	if len(o.Executed) != len(o.Transmissions) {
+		return fmt.Errorf("%v commands transmitted, %v executed", len(o.Transmissions), len(o.Executed))
+	}
+	for i, execution := range o.Executed {
+		if !reflect.DeepEqual(execution.Timings, o.Transmissions[i].Timings) {
+			return fmt.Errorf("command %v executed at %v is not the one transmitted at %v", i, execution.At, o.Transmissions[i].At)
+		}
+	}
+	return nil
+}
+
+// Online lists the presence changes without their times.
+func (o Outcome) Online() []bool {
+	result := make([]bool, 0, len(o.Presence))
+	for _, presence := range o.Presence {
+		result = append(result, presence.Online)
+	}
+	return result
+}
@@ -0,0 +1,248 @@
+package simulator
+
+import (
+	"context"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/bot"
+	"github.com/Light-Keeper/ir-remote/internal/clock"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/health"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/Light-Keeper/ir-remote/internal/irremote/encoder"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Start is the time the simulations start at
+var Start = time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+// DeviceAddress is where the simulated remote is until it is rebound
+const DeviceAddress = "192.168.1.50:4944"
+
+// ProbeInterval is how often the presence of the remote is checked
+const ProbeInterval = time.Second
+
+// the goroutines of the backend have settled when nothing happened for
+// settleRounds pauses in a row
+const settleRounds = 3
+const settlePause = 50 * time.Microsecond
+
+// Presence is when the session saw the remote go online or offline.
+type Presence struct {
+	At     time.Duration
+	Online bool
+}
+
+// Press is a button pressed in the bot and what came of it.
+type Press struct {
+	At    time.Duration
+	State commands.AcState
+	// Done is when SetDesiredState returned
+	Done   time.Duration
+	Result acstate.SetResult
+	Err    error
+}
+
+// Transmission is a command the session transmitted, Err is nil if the
+// remote acknowledged it.
+type Transmission struct {
+	At      time.Duration
+	Timings []int
+	Err     error
+}
+
+// Simulator runs a backend session with the AC control of the bot against an
+// emulated remote over a simulated network. Everything runs on a fake
+// clock, so minutes of a session take milliseconds.
+type Simulator struct {
+	Clock   *clock.Fake
+	Network *Network
+	Device  *Device
+	Session *irremote.Session
+	AC      *acstate.Device
+	Health  *health.Monitor
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mx            sync.Mutex
+	statuses      int
+	presence      []Presence
+	presses       []Press
+	transmissions []Transmission
+	pressing      sync.WaitGroup
+}
+
+// New starts a simulation, seed decides the packets lost.
+func New(seed int64) *Simulator {
+	fake := clock.NewFake(Start)
+	network := NewNetwork(fake, seed)
+	messageEncoder := encoder.NewDummyEncoder()
+	session := irremote.NewSessionWithClock(network, messageEncoder, fake)
+
+	ac := acstate.NewTracker(0).Device("default")
+	ac.Control(session, bot.LearnedEncoder())
+	monitor := health.NewMonitor()
+	monitor.Watch(session)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Simulator{
+		Clock:   fake,
+		Network: network,
+		Session: session,
+		AC:      ac,
+		Health:  monitor,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	session.AddStatusListener(func(irremote.Status, time.Time) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		s.statuses++
+	})
+	session.AddCommandListener(func(command commands.Command, err error) {
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		s.transmissions = append(s.transmissions, Transmission{
+			At:      s.Clock.Elapsed(),
+			Timings: command.ToSignalSequence(),
+			Err:     err,
+		})
+	})
+
+	go func() {
+		defer close(s.done)
+		session.RunSession(ctx)
+	}()
+	s.Device = NewDevice(fake, network, messageEncoder, DeviceAddress)
+	s.probe()
+	return s
+}
+
+// Close stops the session, the buttons still pressed fail.
+func (s *Simulator) Close() {
+	s.cancel()
+	s.pressing.Wait()
+	<-s.done
+}
+
+// Press sets the AC state like a button of the bot. It returns once the
+// command was transmitted or failed; Run the clock for it to complete.
+func (s *Simulator) Press(state commands.AcState) {
+	before := s.activity()
+	at := s.Clock.Elapsed()
+	s.pressing.Add(1)
+	go func() {
+		defer s.pressing.Done()
+		result, err := s.AC.SetDesiredState(s.ctx, state, false)
+		s.mx.Lock()
+		defer s.mx.Unlock()
+		s.presses = append(s.presses, Press{At: at, State: state, Done: s.Clock.Elapsed(), Result: result, Err: err})
+	}()
+
+	// the first packet goes out before the clock moves on
+	for s.activity() == before {
+		runtime.Gosched()
+		time.Sleep(settlePause)
+	}
+	s.settle()
+}
+
+// Run advances the clock by d, firing every timer in order and letting the
+// backend handle it before the next.
+func (s *Simulator) Run(d time.Duration) {
+	until := s.Clock.Elapsed() + d
+	s.settle()
+	for {
+		delivered, fired := s.Network.deliveredCount(), s.Clock.Fired()
+		if !s.Clock.Step(until) {
+			break
+		}
+		// the backend only wakes up for packets and its own timers
+		if s.Network.deliveredCount() != delivered || s.Clock.Fired() != fired {
+			s.settle()
+		} else {
+			s.observePresence()
+		}
+	}
+	s.observePresence()
+}
+
+// Presence returns the presence changes seen so far, starting offline.
+func (s *Simulator) Presence() []Presence {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]Presence(nil), s.presence...)
+}
+
+// Presses returns the buttons pressed that are done.
+func (s *Simulator) Presses() []Press {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]Press(nil), s.presses...)
+}
+
+// Transmissions returns the commands the session transmitted.
+func (s *Simulator) Transmissions() []Transmission {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return append([]Transmission(nil), s.transmissions...)
+}
+
+// probe checks the presence every ProbeInterval, like a client polling it
+func (s *Simulator) probe() {
+	s.observePresence()
+	s.Clock.AfterFunc(ProbeInterval, s.probe)
+}
+
+func (s *Simulator) observePresence() {
+	online := s.Session.IsOnline()
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	last := len(s.presence) > 0 && s.presence[len(s.presence)-1].Online
+	if online != last {
+		s.presence = append(s.presence, Presence{At: s.Clock.Elapsed(), Online: online})
+	}
+}
+
+type activity struct {
+	sent     int
+	timers   int64
+	statuses int
+	presses  int
+}
+
+// activity changes whenever the backend does something observable
+func (s *Simulator) activity() activity {
+	result := activity{
+		sent:   s.Network.Stats().Sent,
+		timers: s.Clock.Created(),
+	}
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	result.statuses = s.statuses
+	result.presses = len(s.presses)
+	return result
+}
+
+// settle waits until the backend has handled everything delivered so far
+// and waits for the clock again
+func (s *Simulator) settle() {
+	last := s.activity()
+	for stable := 0; stable < settleRounds; {
+		runtime.Gosched()
+		time.Sleep(settlePause)
+		current := s.activity()
+		if current == last && s.Network.pending() == 0 {
+			stable++
+		} else {
+			stable = 0
+			last = current
+		}
+	}
+	s.observePresence()
+}
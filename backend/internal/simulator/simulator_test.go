@@ -0,0 +1,205 @@
+package simulator
+
+import (
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var cool24 = commands.AcState{Power: true, Mode: commands.AcModeCool, Temperature: 24}
+var cool20 = commands.AcState{Power: true, Mode: commands.AcModeCool, Temperature: 20}
+var dry20 = commands.AcState{Power: true, Mode: commands.AcModeDry, Temperature: 20}
+var off = commands.AcState{}
+
+func TestScenarios(t *testing.T) {
+	for _, tt := range []struct {
+		scenario Scenario
+		// online are the presence changes expected
+		online  []bool
+		reboots int
+		// duplicated is true if a command is expected to be executed twice
+		duplicated bool
+		check      func(t *testing.T, outcome Outcome)
+	}{
+		{
+			scenario: Scenario{
+				Name:     "steady",
+				Duration: time.Minute,
+				Steps: []Step{
+					{At: time.Second, Action: PressButton(cool24)},
+					{At: 10 * time.Second, Action: PressButton(dry20)},
+					{At: 20 * time.Second, Action: PressButton(off)},
+				},
+			},
+			online: []bool{true},
+			check: func(t *testing.T, outcome Outcome) {
+				require.Len(t, outcome.Executed, 3)
+				require.Equal(t, 0, outcome.Network.Lost)
+			},
+		},
+		{
+			scenario: Scenario{
+				Name:     "30% loss",
+				Seed:     1,
+				Duration: 2 * time.Minute,
+				Steps: []Step{
+					{At: 0, Action: SetLoss(0.3)},
+					{At: time.Second, Action: PressButton(cool24)},
+					{At: 20 * time.Second, Action: PressButton(dry20)},
+					{At: 40 * time.Second, Action: PressButton(cool20)},
+					{At: 60 * time.Second, Action: PressButton(off)},
+				},
+			},
+			online: []bool{true},
+			check: func(t *testing.T, outcome Outcome) {
+				require.Len(t, outcome.Executed, 4)
+				require.Greater(t, outcome.Network.Lost, 0)
+			},
+		},
+		{
+			scenario: Scenario{
+				Name:     "latency spike",
+				Duration: time.Minute,
+				Steps: []Step{
+					{At: 0, Action: SetLatency(20 * time.Millisecond)},
+					{At: 5 * time.Second, Action: SetLatency(2 * time.Second)},
+					{At: 10 * time.Second, Action: PressButton(cool24)},
+					{At: 30 * time.Second, Action: SetLatency(20 * time.Millisecond)},
+				},
+			},
+			online: []bool{true},
+			check: func(t *testing.T, outcome Outcome) {
+				// resent while the acknowledgement is on the way, executed once
+				require.Len(t, outcome.Presses, 1)
+				require.GreaterOrEqual(t, outcome.Presses[0].Done-outcome.Presses[0].At, 4*time.Second)
+				require.Len(t, outcome.Executed, 1)
+			},
+		},
+		{
+			scenario: Scenario{
+				Name:     "NAT rebinding",
+				Duration: time.Minute,
+				Steps: []Step{
+					{At: time.Second, Action: PressButton(cool24)},
+					{At: 10 * time.Second, Action: Rebind("203.0.113.7:61000")},
+					{At: 10 * time.Second, Action: PressButton(dry20)},
+				},
+			},
+			online: []bool{true},
+			check: func(t *testing.T, outcome Outcome) {
+				require.Len(t, outcome.Executed, 2)
+				require.Greater(t, outcome.Network.Unreachable, 0)
+			},
+		},
+		{
+			scenario: Scenario{
+				Name:     "reboot between commands",
+				Duration: time.Minute,
+				Steps: []Step{
+					{At: time.Second, Action: PressButton(cool24)},
+					{At: 10 * time.Second, Action: Reboot()},
+					{At: 20 * time.Second, Action: PressButton(dry20)},
+				},
+			},
+			online:  []bool{true},
+			reboots: 1,
+			check: func(t *testing.T, outcome Outcome) {
+				// the remote starts over from 0, the session keeps counting
+				require.Len(t, outcome.Executed, 2)
+				require.Equal(t, int64(1), outcome.Executed[0].SequenceNumber)
+				require.Equal(t, int64(2), outcome.Executed[1].SequenceNumber)
+			},
+		},
+		{
+			scenario: Scenario{
+				Name:     "power cut",
+				Duration: 2 * time.Minute,
+				Steps: []Step{
+					{At: 10 * time.Second, Action: PowerOff()},
+					{At: 60 * time.Second, Action: PressButton(cool24)},
+					{At: 70 * time.Second, Action: Reboot()},
+					{At: 80 * time.Second, Action: PressButton(cool24)},
+				},
+			},
+			online:  []bool{true, false, true},
+			reboots: 1,
+			check: func(t *testing.T, outcome Outcome) {
+				// offline 3*ExpectedPingInterval, six pings of the remote, after the last status
+				require.Equal(t, 40*time.Second, outcome.Presence[1].At)
+				require.Len(t, outcome.Presses, 2)
+				require.ErrorContains(t, outcome.Presses[0].Err, "offline")
+				require.NoError(t, outcome.Presses[1].Err)
+			},
+		},
+		{
+			scenario: Scenario{
+				Name:     "reboot before the resend of a lost acknowledgement",
+				Duration: 30 * time.Second,
+				Steps: []Step{
+					{At: 0, Action: SetLatency(100 * time.Millisecond)},
+					{At: 11 * time.Second, Action: PressButton(cool24)},
+					// the command arrives, its acknowledgement is lost
+					{At: 11*time.Second + 50*time.Millisecond, Action: SetLoss(1)},
+					{At: 11*time.Second + 500*time.Millisecond, Action: SetLoss(0)},
+					{At: 11*time.Second + 500*time.Millisecond, Action: Reboot()},
+				},
+			},
+			online:     []bool{true},
+			reboots:    1,
+			duplicated: true,
+			check: func(t *testing.T, outcome Outcome) {
+				// the remote forgot it executed the command, so the resend executes it again
+				require.Len(t, outcome.Presses, 1)
+				require.NoError(t, outcome.Presses[0].Err)
+				require.Len(t, outcome.Transmissions, 1)
+				require.Len(t, outcome.Executed, 2)
+				require.Equal(t, outcome.Executed[0].SequenceNumber, outcome.Executed[1].SequenceNumber)
+				require.Equal(t, outcome.Executed[0].Timings, outcome.Executed[1].Timings)
+			},
+		},
+		{
+			scenario: Scenario{
+				Name:     "clock jumps forward",
+				Duration: time.Minute,
+				Steps: []Step{
+					{At: 10 * time.Second, Action: JumpClock(time.Hour)},
+					{At: 20 * time.Second, Action: PressButton(cool24)},
+				},
+			},
+			// until the next status
+			online: []bool{true, false, true},
+			check: func(t *testing.T, outcome Outcome) {
+				require.Equal(t, 15*time.Second, outcome.Presence[2].At)
+				require.Len(t, outcome.Executed, 1)
+			},
+		},
+		{
+			scenario: Scenario{
+				Name:     "clock jumps back before a power cut",
+				Duration: 2 * time.Minute,
+				Steps: []Step{
+					{At: 10 * time.Second, Action: JumpClock(-time.Hour)},
+					{At: 20 * time.Second, Action: PowerOff()},
+				},
+			},
+			// a status from before the jump does not keep the remote online for an hour
+			online: []bool{true, false, true, false},
+			check: func(t *testing.T, outcome Outcome) {
+				require.Equal(t, 50*time.Second, outcome.Presence[3].At)
+			},
+		},
+	} {
+		t.Run(tt.scenario.Name, func(t *testing.T) {
+			outcome := tt.scenario.Run()
+			if tt.duplicated {
+				require.ErrorContains(t, outcome.ExactlyOnce(), "1 commands transmitted, 2 executed")
+			} else {
+				require.NoError(t, outcome.ExactlyOnce())
+			}
+			require.Equal(t, tt.online, outcome.Online(), outcome.Presence)
+			require.Equal(t, tt.reboots, outcome.Health.Reboots)
+			tt.check(t, outcome)
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package telemetry
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sort"
+	"time"
+)
+
+const CHART_WIDTH = 800
+const CHART_HEIGHT = 400
+const CHART_LEFT_MARGIN = 50
+const CHART_RIGHT_MARGIN = 15
+const CHART_TOP_MARGIN = 15
+const CHART_BOTTOM_MARGIN = 30
+const FONT_SCALE = 2
+
+// MAX_TIME_LABELS limits the vertical grid lines
+const MAX_TIME_LABELS = 8
+
+var (
+	colorBackground  = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	colorGrid        = color.RGBA{R: 0xe0, G: 0xe0, B: 0xe0, A: 0xff}
+	colorLabel       = color.RGBA{R: 0x55, G: 0x55, B: 0x55, A: 0xff}
+	colorAcOn        = color.RGBA{R: 0xdd, G: 0xee, B: 0xff, A: 0xff}
+	colorSetpoint    = color.RGBA{R: 0x33, G: 0x66, B: 0xcc, A: 0xff}
+	colorTemperature = color.RGBA{R: 0xdd, G: 0x33, B: 0x22, A: 0xff}
+)
+
+// timeSteps are the candidate distances between the time grid lines
+var timeSteps = []time.Duration{
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour,
+	24 * time.Hour, 2 * 24 * time.Hour, 7 * 24 * time.Hour, 14 * 24 * time.Hour,
+}
+
+// RenderChart draws the room temperature of the samples in [from, to) as a
+// red line, the AC setpoint as a blue one, and shades the periods the AC was
+// on. Times are labelled in the location of from.
+func RenderChart(samples []Sample, from time.Time, to time.Time) ([]byte, error) {
+	if len(samples) == 0 {
+		return nil, errors.New("no readings in the period")
+	}
+	if !to.After(from) {
+		return nil, errors.New("empty period")
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, CHART_WIDTH, CHART_HEIGHT))
+	fill := func(x0, y0, x1, y1 int, c color.Color) {
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	fill(0, 0, CHART_WIDTH, CHART_HEIGHT, colorBackground)
+
+	left, right := CHART_LEFT_MARGIN, CHART_WIDTH-CHART_RIGHT_MARGIN
+	top, bottom := CHART_TOP_MARGIN, CHART_HEIGHT-CHART_BOTTOM_MARGIN
+	low, high := temperatureRange(samples)
+
+	x := func(t time.Time) int {
+		return left + int(float64(right-left)*float64(t.Sub(from))/float64(to.Sub(from)))
+	}
+	y := func(temperature float64) int {
+		return top + int(float64(bottom-top)*(high-temperature)/(high-low))
+	}
+
+	// samples further apart than maxGap are not connected
+	spacing := typicalSpacing(samples)
+	maxGap := 3 * spacing
+	end := func(i int) time.Time {
+		if i+1 < len(samples) && samples[i+1].Time.Sub(samples[i].Time) <= maxGap {
+			return samples[i+1].Time
+		}
+		return samples[i].Time.Add(spacing)
+	}
+
+	for i, sample := range samples {
+		if sample.Power >= 0.5 {
+			fill(x(sample.Time), top, x(end(i)), bottom, colorAcOn)
+		}
+	}
+
+	step := temperatureStep(high - low)
+	for t := math.Ceil(low/step) * step; t <= high; t += step {
+		fill(left, y(t), right, y(t)+1, colorGrid)
+		label := fmt.Sprintf("%v°", t)
+		drawText(img, left-textWidth(label, FONT_SCALE)-6, y(t)-GLYPH_HEIGHT*FONT_SCALE/2, label, FONT_SCALE, colorLabel)
+	}
+
+	timeStep, format := timeGrid(to.Sub(from))
+	for _, t := range gridTimes(from, to, timeStep) {
+		fill(x(t), top, x(t)+1, bottom, colorGrid)
+		label := t.Format(format)
+		drawText(img, x(t)-textWidth(label, FONT_SCALE)/2, bottom+8, label, FONT_SCALE, colorLabel)
+	}
+
+	fill(left, bottom, right, bottom+1, colorLabel)
+	fill(left, top, left+1, bottom, colorLabel)
+
+	for i := 0; i+1 < len(samples); i++ {
+		a, b := samples[i], samples[i+1]
+		if b.Time.Sub(a.Time) > maxGap {
+			continue
+		}
+		if a.Setpoint != nil && b.Setpoint != nil {
+			drawLine(img, x(a.Time), y(*a.Setpoint), x(b.Time), y(*b.Setpoint), colorSetpoint)
+		}
+		drawLine(img, x(a.Time), y(a.Temperature), x(b.Time), y(b.Temperature), colorTemperature)
+		drawLine(img, x(a.Time), y(a.Temperature)+1, x(b.Time), y(b.Temperature)+1, colorTemperature)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// temperatureRange covers the temperatures and setpoints with whole degrees of margin
+func temperatureRange(samples []Sample) (float64, float64) {
+	low, high := math.Inf(1), math.Inf(-1)
+	include := func(t float64) {
+		low = math.Min(low, t)
+		high = math.Max(high, t)
+	}
+	for _, sample := range samples {
+		include(sample.Temperature)
+		if sample.Setpoint != nil {
+			include(*sample.Setpoint)
+		}
+	}
+
+	low, high = math.Floor(low-0.5), math.Ceil(high+0.5)
+	if high-low < 2 {
+		high = low + 2
+	}
+	return low, high
+}
+
+func temperatureStep(span float64) float64 {
+	for _, step := range []float64{1, 2, 5} {
+		if span/step <= 10 {
+			return step
+		}
+	}
+	return 10
+}
+
+// typicalSpacing is the median distance between samples
+func typicalSpacing(samples []Sample) time.Duration {
+	if len(samples) < 2 {
+		return RecordInterval
+	}
+
+	spacings := make([]time.Duration, 0, len(samples)-1)
+	for i := 1; i < len(samples); i++ {
+		spacings = append(spacings, samples[i].Time.Sub(samples[i-1].Time))
+	}
+	sort.Slice(spacings, func(i, j int) bool { return spacings[i] < spacings[j] })
+	if spacings[len(spacings)/2] <= 0 {
+		return RecordInterval
+	}
+	return spacings[len(spacings)/2]
+}
+
+// timeGrid picks the distance between time labels and their format
+func timeGrid(window time.Duration) (time.Duration, string) {
+	step := timeSteps[len(timeSteps)-1]
+	for _, candidate := range timeSteps {
+		if window/candidate <= MAX_TIME_LABELS {
+			step = candidate
+			break
+		}
+	}
+
+	if step < 24*time.Hour {
+		return step, "15:04"
+	}
+	return step, "02.01"
+}
+
+// gridTimes are the times in [from, to) that are whole multiples of the step
+// since the local midnight before from
+func gridTimes(from time.Time, to time.Time, step time.Duration) []time.Time {
+	result := make([]time.Time, 0)
+	midnight := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for t := midnight; t.Before(to); t = t.Add(step) {
+		if !t.Before(from) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func drawLine(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, c color.Color) {
+	steps := abs(x1 - x0)
+	if abs(y1-y0) > steps {
+		steps = abs(y1 - y0)
+	}
+	if steps == 0 {
+		img.Set(x0, y0, c)
+		return
+	}
+	for i := 0; i <= steps; i++ {
+		img.Set(x0+(x1-x0)*i/steps, y0+(y1-y0)*i/steps, c)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
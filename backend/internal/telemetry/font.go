@@ -0,0 +1,55 @@
+package telemetry
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+const GLYPH_WIDTH = 3
+const GLYPH_HEIGHT = 5
+
+// glyphs is a tiny pixel font for the chart labels, so rendering needs no font files
+var glyphs = map[rune][GLYPH_HEIGHT]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'.': {"...", "...", "...", "...", ".#."},
+	'-': {"...", "...", "###", "...", "..."},
+	'°': {"###", "#.#", "###", "...", "..."},
+	'C': {"###", "#..", "#..", "#..", "###"},
+}
+
+// textWidth is the width of the text drawn at the scale
+func textWidth(text string, scale int) int {
+	count := len([]rune(text))
+	if count == 0 {
+		return 0
+	}
+	return (count*(GLYPH_WIDTH+1) - 1) * scale
+}
+
+// drawText draws the text with its top left corner at x, y. Unknown characters are blank.
+func drawText(img draw.Image, x int, y int, text string, scale int, c color.Color) {
+	for _, r := range text {
+		glyph := glyphs[r]
+		for row, line := range glyph {
+			for column, pixel := range line {
+				if pixel != '#' {
+					continue
+				}
+				px, py := x+column*scale, y+row*scale
+				draw.Draw(img, image.Rect(px, py, px+scale, py+scale), image.NewUniform(c), image.Point{}, draw.Src)
+			}
+		}
+		x += (GLYPH_WIDTH + 1) * scale
+	}
+}
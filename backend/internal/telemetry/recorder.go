@@ -0,0 +1,97 @@
+package telemetry
+
+import (
+	"context"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"log"
+	"time"
+)
+
+// RecordInterval is how often a sample is stored
+const RecordInterval = time.Minute
+
+// DownsampleInterval is how often old samples are merged
+const DownsampleInterval = time.Hour
+
+// MaxReadingAge is the age of a sensor reading after which it is not recorded
+const MaxReadingAge = 2 * time.Minute
+
+// Sensor reports the room temperature, usually an *irremote.Session.
+type Sensor interface {
+	LastStatus() (irremote.Status, time.Time)
+}
+
+// Recorder stores the sensor readings of a device together with the believed
+// state of its AC.
+type Recorder struct {
+	store  *Store
+	device string
+	sensor Sensor
+	state  *acstate.Device
+	now    func() time.Time
+}
+
+func NewRecorder(store *Store, device string, sensor Sensor, state *acstate.Device) *Recorder {
+	return &Recorder{
+		store:  store,
+		device: device,
+		sensor: sensor,
+		state:  state,
+		now:    time.Now,
+	}
+}
+
+func (r *Recorder) Run(ctx context.Context) {
+	record := time.NewTicker(RecordInterval)
+	defer record.Stop()
+	downsample := time.NewTicker(DownsampleInterval)
+	defer downsample.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-record.C:
+			if err := r.record(); err != nil {
+				log.Println("failed to record telemetry", err)
+			}
+		case <-downsample.C:
+			if err := r.store.Downsample(r.now()); err != nil {
+				log.Println("failed to downsample telemetry", err)
+			}
+		}
+	}
+}
+
+// record stores the last reading, if the device has a sensor and is online
+func (r *Recorder) record() error {
+	status, readAt := r.sensor.LastStatus()
+	now := r.now()
+	if status.Temperature == nil || now.Sub(readAt) > MaxReadingAge {
+		return nil
+	}
+
+	sample := Sample{
+		Time:        now,
+		Temperature: *status.Temperature,
+		Humidity:    status.Humidity,
+	}
+	if state := r.state.State().State; state.Power {
+		setpoint := float64(state.Temperature)
+		sample.Power = 1
+		sample.Setpoint = &setpoint
+	}
+	return r.store.Add(r.device, sample)
+}
+
+// Chart renders the samples of the last window, with times in the location.
+func (r *Recorder) Chart(window time.Duration, location *time.Location) ([]byte, error) {
+	to := r.now()
+	from := to.Add(-window)
+	samples, err := r.store.Query(r.device, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return RenderChart(samples, from.In(location), to.In(location))
+}
@@ -0,0 +1,149 @@
+package telemetry
+
+import (
+	"database/sql"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"time"
+)
+
+// Sample is a room reading with the believed AC state at the time. Downsampled
+// samples average the samples of their interval.
+type Sample struct {
+	Time        time.Time
+	Temperature float64
+	Humidity    *float64
+	// Power is the fraction of the time the AC was on, 0 or 1 for raw samples
+	Power float64
+	// Setpoint is the AC temperature while it was on, nil if it was off
+	Setpoint *float64
+}
+
+// tier keeps samples of the resolution for the time, then merges them into
+// the next tier. The last tier is kept forever.
+type tier struct {
+	resolution time.Duration
+	keep       time.Duration
+}
+
+var tiers = []tier{
+	{resolution: 0, keep: 48 * time.Hour},
+	{resolution: 10 * time.Minute, keep: 30 * 24 * time.Hour},
+	{resolution: time.Hour},
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS samples (
+	device      TEXT    NOT NULL,
+	time        INTEGER NOT NULL,
+	resolution  INTEGER NOT NULL,
+	temperature REAL    NOT NULL,
+	humidity    REAL,
+	power       REAL    NOT NULL,
+	setpoint    REAL
+);
+CREATE INDEX IF NOT EXISTS samples_device_time ON samples (device, time);
+`
+
+// Store keeps the samples of all devices in an SQLite database.
+type Store struct {
+	db *sql.DB
+}
+
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite does not support concurrent writers
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create telemetry schema: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Add(device string, sample Sample) error {
+	_, err := s.db.Exec(
+		"INSERT INTO samples (device, time, resolution, temperature, humidity, power, setpoint) VALUES (?, ?, 0, ?, ?, ?, ?)",
+		device, sample.Time.Unix(), sample.Temperature, sample.Humidity, sample.Power, sample.Setpoint,
+	)
+	return err
+}
+
+// Query returns the samples of the device in [from, to), oldest first.
+func (s *Store) Query(device string, from time.Time, to time.Time) ([]Sample, error) {
+	rows, err := s.db.Query(
+		"SELECT time, temperature, humidity, power, setpoint FROM samples WHERE device = ? AND time >= ? AND time < ? ORDER BY time",
+		device, from.Unix(), to.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]Sample, 0)
+	for rows.Next() {
+		var unix int64
+		var humidity, setpoint sql.NullFloat64
+		sample := Sample{}
+		if err := rows.Scan(&unix, &sample.Temperature, &humidity, &sample.Power, &setpoint); err != nil {
+			return nil, err
+		}
+
+		sample.Time = time.Unix(unix, 0)
+		if humidity.Valid {
+			sample.Humidity = &humidity.Float64
+		}
+		if setpoint.Valid {
+			sample.Setpoint = &setpoint.Float64
+		}
+		result = append(result, sample)
+	}
+	return result, rows.Err()
+}
+
+// Downsample merges samples older than their tier keeps them into averages of
+// the next tier.
+func (s *Store) Downsample(now time.Time) error {
+	for i := 0; i+1 < len(tiers); i++ {
+		resolution := int64(tiers[i+1].resolution / time.Second)
+		// only whole intervals, so an interval is never merged twice
+		cutoff := now.Add(-tiers[i].keep).Unix() / resolution * resolution
+
+		if err := s.merge(int64(tiers[i].resolution/time.Second), resolution, cutoff); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) merge(from int64, to int64, cutoff int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO samples (device, time, resolution, temperature, humidity, power, setpoint)
+		SELECT device, time / ?1 * ?1, ?1, AVG(temperature), AVG(humidity), AVG(power), AVG(setpoint)
+		FROM samples WHERE resolution = ?2 AND time < ?3
+		GROUP BY device, time / ?1`,
+		to, from, cutoff,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM samples WHERE resolution = ? AND time < ?", from, cutoff); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,181 @@
+package telemetry
+
+import (
+	"bytes"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/stretchr/testify/require"
+	"image/png"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+func openTestStore(t *testing.T) *Store {
+	store, err := Open(filepath.Join(t.TempDir(), "telemetry.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestStore_Query(t *testing.T) {
+	store := openTestStore(t)
+
+	require.NoError(t, store.Add("default", Sample{Time: start, Temperature: 25, Humidity: float(40)}))
+	require.NoError(t, store.Add("default", Sample{Time: start.Add(time.Minute), Temperature: 24.5, Power: 1, Setpoint: float(22)}))
+	require.NoError(t, store.Add("other", Sample{Time: start, Temperature: 20}))
+
+	samples, err := store.Query("default", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	require.Equal(t, 40.0, *samples[0].Humidity)
+	require.Nil(t, samples[0].Setpoint)
+	require.Equal(t, 24.5, samples[1].Temperature)
+	require.Equal(t, 22.0, *samples[1].Setpoint)
+	require.Nil(t, samples[1].Humidity)
+	require.True(t, samples[1].Time.Equal(start.Add(time.Minute)))
+}
+
+func TestStore_Downsample(t *testing.T) {
+	store := openTestStore(t)
+
+	// an hour of samples, the AC on for the second half
+	for i := 0; i < 60; i++ {
+		sample := Sample{Time: start.Add(time.Duration(i) * time.Minute), Temperature: float64(20 + i%10)}
+		if i >= 30 {
+			sample.Power = 1
+			sample.Setpoint = float(22)
+		}
+		require.NoError(t, store.Add("default", sample))
+	}
+
+	// nothing is old enough
+	require.NoError(t, store.Downsample(start.Add(24*time.Hour)))
+	samples, err := store.Query("default", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 60)
+
+	require.NoError(t, store.Downsample(start.Add(49*time.Hour)))
+	samples, err = store.Query("default", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 6)
+	require.Equal(t, 24.5, samples[0].Temperature)
+	require.Equal(t, 0.0, samples[0].Power)
+	require.Nil(t, samples[0].Setpoint)
+	require.Equal(t, 1.0, samples[5].Power)
+	require.Equal(t, 22.0, *samples[5].Setpoint)
+
+	// downsampling again changes nothing, then ten minute samples become hourly
+	require.NoError(t, store.Downsample(start.Add(49*time.Hour)))
+	samples, err = store.Query("default", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 6)
+
+	require.NoError(t, store.Downsample(start.Add(31*24*time.Hour)))
+	samples, err = store.Query("default", start, start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	require.Equal(t, 0.5, samples[0].Power)
+	require.Equal(t, 24.5, samples[0].Temperature)
+}
+
+type fakeSensor struct {
+	status irremote.Status
+	readAt time.Time
+}
+
+func (f *fakeSensor) LastStatus() (irremote.Status, time.Time) {
+	return f.status, f.readAt
+}
+
+func TestRecorder(t *testing.T) {
+	store := openTestStore(t)
+	sensor := &fakeSensor{}
+	state := acstate.NewTracker(acstate.DefaultStaleAfter).Device("default")
+	recorder := NewRecorder(store, "default", sensor, state)
+	now := start
+	recorder.now = func() time.Time { return now }
+
+	// no sensor
+	require.NoError(t, recorder.record())
+
+	sensor.status.Temperature = float(26)
+	sensor.readAt = now
+	require.NoError(t, recorder.record())
+
+	now = now.Add(time.Minute)
+	sensor.readAt = now
+	state.Correct(commands.AcState{Power: true, Mode: commands.AcModeCool, Temperature: 23})
+	require.NoError(t, recorder.record())
+
+	// stale reading
+	now = now.Add(time.Hour)
+	require.NoError(t, recorder.record())
+
+	samples, err := store.Query("default", start, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	require.Equal(t, 0.0, samples[0].Power)
+	require.Equal(t, 23.0, *samples[1].Setpoint)
+
+	chart, err := recorder.Chart(2*time.Hour, time.UTC)
+	require.NoError(t, err)
+	_, err = png.Decode(bytes.NewReader(chart))
+	require.NoError(t, err)
+}
+
+func TestRenderChart(t *testing.T) {
+	samples := make([]Sample, 0)
+	for i := 0; i < 24*60; i += 10 {
+		sample := Sample{Time: start.Add(time.Duration(i) * time.Minute), Temperature: 24 + float64(i%120)/60}
+		if i%120 >= 60 {
+			sample.Power = 1
+			sample.Setpoint = float(22)
+		}
+		samples = append(samples, sample)
+	}
+
+	chart, err := RenderChart(samples, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(chart))
+	require.NoError(t, err)
+	require.Equal(t, CHART_WIDTH, img.Bounds().Dx())
+	require.Equal(t, CHART_HEIGHT, img.Bounds().Dy())
+
+	colors := make(map[[4]uint32]bool)
+	for x := 0; x < CHART_WIDTH; x++ {
+		for y := 0; y < CHART_HEIGHT; y++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			colors[[4]uint32{r, g, b, a}] = true
+		}
+	}
+	for _, c := range []interface{ RGBA() (r, g, b, a uint32) }{colorAcOn, colorSetpoint, colorTemperature, colorLabel} {
+		r, g, b, a := c.RGBA()
+		require.True(t, colors[[4]uint32{r, g, b, a}])
+	}
+
+	_, err = RenderChart(nil, start, start.Add(time.Hour))
+	require.Error(t, err)
+}
+
+func TestTimeGrid(t *testing.T) {
+	step, format := timeGrid(24 * time.Hour)
+	require.Equal(t, 3*time.Hour, step)
+	require.Equal(t, "15:04", format)
+
+	step, format = timeGrid(7 * 24 * time.Hour)
+	require.Equal(t, 24*time.Hour, step)
+	require.Equal(t, "02.01", format)
+
+	times := gridTimes(start.Add(90*time.Minute), start.Add(12*time.Hour), 3*time.Hour)
+	require.Len(t, times, 3)
+	require.Equal(t, start.Add(3*time.Hour), times[0])
+}
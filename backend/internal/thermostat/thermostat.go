@@ -0,0 +1,218 @@
+package thermostat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// CheckInterval is how often the room temperature is compared to the band
+const CheckInterval = 30 * time.Second
+
+// MaxReadingAge is the age of a sensor reading after which it is not trusted
+const MaxReadingAge = 2 * time.Minute
+
+// Sensor reports the room temperature, usually an *irremote.Session.
+type Sensor interface {
+	LastStatus() (irremote.Status, time.Time)
+}
+
+// Config keeps the room temperature between Low and High. In cool mode the AC
+// is switched on above High and off below Low, in heat mode the other way
+// round, in auto mode it cools above the band and heats below it. The AC is
+// set to the edge of the band it heads to: Low when cooling, High when heating.
+type Config struct {
+	Enabled bool            `json:"enabled"`
+	Mode    commands.AcMode `json:"mode"`
+	Low     float64         `json:"low"`
+	High    float64         `json:"high"`
+	Fan     commands.AcFan  `json:"fan"`
+	// the AC is not switched again sooner, to protect the compressor
+	MinOnSeconds  int `json:"min_on_seconds"`
+	MinOffSeconds int `json:"min_off_seconds"`
+}
+
+var DefaultConfig = Config{
+	Mode:          commands.AcModeCool,
+	Low:           23,
+	High:          25,
+	MinOnSeconds:  5 * 60,
+	MinOffSeconds: 3 * 60,
+}
+
+func (c Config) Validate() error {
+	if c.Mode != commands.AcModeCool && c.Mode != commands.AcModeHeat && c.Mode != commands.AcModeAuto {
+		return fmt.Errorf("thermostat mode must be cool, heat or auto, not %v", c.Mode)
+	}
+	if c.Low >= c.High {
+		return fmt.Errorf("low temperature %v must be below high temperature %v", c.Low, c.High)
+	}
+	if c.MinOnSeconds < 0 || c.MinOffSeconds < 0 {
+		return errors.New("minimum on and off times can not be negative")
+	}
+	return nil
+}
+
+func (c Config) String() string {
+	if !c.Enabled {
+		return "off"
+	}
+	return fmt.Sprintf("%v %v-%v°C", c.Mode, c.Low, c.High)
+}
+
+// Status is the configuration with what the thermostat has seen and done.
+type Status struct {
+	Config      Config     `json:"config"`
+	Temperature *float64   `json:"temperature,omitempty"`
+	LastAction  string     `json:"last_action,omitempty"`
+	SwitchedAt  *time.Time `json:"switched_at,omitempty"`
+}
+
+// Thermostat switches the AC of a device to keep the room temperature
+// reported by the device sensor inside the configured band.
+type Thermostat struct {
+	sensor Sensor
+	device *acstate.Device
+	now    func() time.Time
+
+	mx          sync.Mutex
+	config      Config
+	temperature *float64
+	lastAction  string
+	switchedAt  time.Time
+}
+
+func New(sensor Sensor, device *acstate.Device) *Thermostat {
+	return &Thermostat{
+		sensor: sensor,
+		device: device,
+		now:    time.Now,
+		config: DefaultConfig,
+	}
+}
+
+func (t *Thermostat) Config() Config {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.config
+}
+
+func (t *Thermostat) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.config = config
+	return nil
+}
+
+func (t *Thermostat) Status() Status {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	result := Status{Config: t.config, Temperature: t.temperature, LastAction: t.lastAction}
+	if !t.switchedAt.IsZero() {
+		switchedAt := t.switchedAt
+		result.SwitchedAt = &switchedAt
+	}
+	return result
+}
+
+func (t *Thermostat) Run(ctx context.Context) {
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.check(ctx); err != nil {
+				log.Println("thermostat:", err)
+			}
+		}
+	}
+}
+
+// check switches the AC if the room temperature left the band
+func (t *Thermostat) check(ctx context.Context) error {
+	status, readAt := t.sensor.LastStatus()
+	now := t.now()
+
+	t.mx.Lock()
+	config := t.config
+	switchedAt := t.switchedAt
+	if status.Temperature != nil && now.Sub(readAt) <= MaxReadingAge {
+		temperature := *status.Temperature
+		t.temperature = &temperature
+	} else {
+		t.temperature = nil
+	}
+	temperature := t.temperature
+	t.mx.Unlock()
+
+	if !config.Enabled || temperature == nil {
+		return nil
+	}
+
+	current := t.device.State().State
+	desired, ok := config.decide(*temperature, current)
+	if !ok {
+		return nil
+	}
+
+	minimum := time.Duration(config.MinOffSeconds) * time.Second
+	if current.Power {
+		minimum = time.Duration(config.MinOnSeconds) * time.Second
+	}
+	if desired.Power != current.Power && now.Sub(switchedAt) < minimum {
+		return nil
+	}
+
+	result, err := t.device.SetDesiredState(ctx, desired, false)
+	if err != nil {
+		return err
+	}
+	if !result.Sent {
+		return nil
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.lastAction = fmt.Sprintf("%v at %.1f°C", desired, *temperature)
+	if desired.Power != current.Power {
+		t.switchedAt = now
+	}
+	return nil
+}
+
+// decide returns the state the AC should switch to, if any. Inside the band
+// the AC keeps doing what it does, which is the hysteresis.
+func (c Config) decide(temperature float64, current commands.AcState) (commands.AcState, bool) {
+	cooling := current.Power && current.Mode == commands.AcModeCool
+	heating := current.Power && current.Mode == commands.AcModeHeat
+	canCool := c.Mode == commands.AcModeCool || c.Mode == commands.AcModeAuto
+	canHeat := c.Mode == commands.AcModeHeat || c.Mode == commands.AcModeAuto
+
+	switch {
+	// the AC is turned off before going from cooling to heating and back
+	case temperature <= c.Low && cooling, temperature >= c.High && heating:
+		off := current
+		off.Power = false
+		return off, true
+	case temperature >= c.High && canCool && !cooling:
+		return commands.AcState{Power: true, Mode: commands.AcModeCool, Temperature: int(math.Floor(c.Low)), Fan: c.Fan}, true
+	case temperature <= c.Low && canHeat && !heating:
+		return commands.AcState{Power: true, Mode: commands.AcModeHeat, Temperature: int(math.Ceil(c.High)), Fan: c.Fan}, true
+	}
+	return commands.AcState{}, false
+}
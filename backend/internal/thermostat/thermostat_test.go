@@ -0,0 +1,159 @@
+package thermostat
+
+import (
+	"context"
+	"github.com/Light-Keeper/ir-remote/internal/acstate"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/Light-Keeper/ir-remote/internal/irremote"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type fakeSensor struct {
+	temperature *float64
+	readAt      time.Time
+}
+
+func (f *fakeSensor) LastStatus() (irremote.Status, time.Time) {
+	return irremote.Status{Temperature: f.temperature}, f.readAt
+}
+
+type fakeRemote struct {
+	listeners []irremote.CommandListener
+	sent      []commands.AcState
+}
+
+func (f *fakeRemote) SendCommand(_ context.Context, command commands.Command) error {
+	f.sent = append(f.sent, command.(commands.AcCommand).State())
+	for _, listener := range f.listeners {
+		listener(command, nil)
+	}
+	return nil
+}
+
+func (f *fakeRemote) AddCommandListener(listener irremote.CommandListener) {
+	f.listeners = append(f.listeners, listener)
+}
+
+type testThermostat struct {
+	*Thermostat
+	sensor *fakeSensor
+	remote *fakeRemote
+	now    time.Time
+}
+
+func newTestThermostat(t *testing.T, config Config) *testThermostat {
+	encoder, err := acstate.ProtocolEncoder("gree")
+	require.NoError(t, err)
+
+	remote := &fakeRemote{}
+	device := acstate.NewTracker(acstate.DefaultStaleAfter).Device("default")
+	device.Control(remote, encoder)
+
+	result := &testThermostat{
+		sensor: &fakeSensor{},
+		remote: remote,
+		now:    time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC),
+	}
+	result.Thermostat = New(result.sensor, device)
+	result.Thermostat.now = func() time.Time { return result.now }
+	config.Enabled = true
+	require.NoError(t, result.SetConfig(config))
+	return result
+}
+
+// measure reports the temperature after the time passes and runs a check
+func (tt *testThermostat) measure(t *testing.T, after time.Duration, temperature float64) {
+	tt.now = tt.now.Add(after)
+	tt.sensor.temperature = &temperature
+	tt.sensor.readAt = tt.now
+	require.NoError(t, tt.check(context.Background()))
+}
+
+func TestThermostat_Cool(t *testing.T) {
+	tt := newTestThermostat(t, DefaultConfig)
+
+	tt.measure(t, 0, 24)
+	require.Empty(t, tt.remote.sent)
+
+	tt.measure(t, time.Minute, 25.5)
+	require.Equal(t, []commands.AcState{{Power: true, Mode: commands.AcModeCool, Temperature: 23}}, tt.remote.sent)
+
+	// inside the band the AC keeps cooling
+	tt.measure(t, time.Minute, 24)
+	require.Len(t, tt.remote.sent, 1)
+
+	// the compressor runs for at least 5 minutes
+	tt.measure(t, time.Minute, 22.5)
+	require.Len(t, tt.remote.sent, 1)
+
+	tt.measure(t, 3*time.Minute, 22.5)
+	require.Len(t, tt.remote.sent, 2)
+	require.False(t, tt.remote.sent[1].Power)
+
+	// and rests for at least 3 minutes
+	tt.measure(t, time.Minute, 26)
+	require.Len(t, tt.remote.sent, 2)
+	tt.measure(t, 2*time.Minute, 26)
+	require.Len(t, tt.remote.sent, 3)
+	require.True(t, tt.remote.sent[2].Power)
+
+	status := tt.Status()
+	require.Equal(t, 26.0, *status.Temperature)
+	require.Equal(t, "cool 23°C fan:auto swing:false at 26.0°C", status.LastAction)
+	require.Equal(t, tt.now, *status.SwitchedAt)
+}
+
+func TestThermostat_Auto(t *testing.T) {
+	config := DefaultConfig
+	config.Mode = commands.AcModeAuto
+	config.MinOnSeconds = 0
+	config.MinOffSeconds = 0
+	tt := newTestThermostat(t, config)
+
+	tt.measure(t, 0, 21)
+	require.Equal(t, []commands.AcState{{Power: true, Mode: commands.AcModeHeat, Temperature: 25}}, tt.remote.sent)
+
+	tt.measure(t, time.Minute, 25)
+	require.Len(t, tt.remote.sent, 2)
+	require.False(t, tt.remote.sent[1].Power)
+
+	// the AC is off inside the band and only starts cooling above it
+	tt.measure(t, time.Minute, 24.5)
+	require.Len(t, tt.remote.sent, 2)
+	tt.measure(t, time.Minute, 26)
+	require.Equal(t, commands.AcModeCool, tt.remote.sent[2].Mode)
+}
+
+func TestThermostat_NoReading(t *testing.T) {
+	tt := newTestThermostat(t, DefaultConfig)
+
+	require.NoError(t, tt.check(context.Background()))
+	require.Nil(t, tt.Status().Temperature)
+
+	// readings too old are ignored
+	temperature := 30.0
+	tt.sensor.temperature = &temperature
+	tt.sensor.readAt = tt.now.Add(-time.Hour)
+	require.NoError(t, tt.check(context.Background()))
+	require.Empty(t, tt.remote.sent)
+
+	config := DefaultConfig
+	config.Enabled = false
+	require.NoError(t, tt.SetConfig(config))
+	tt.measure(t, time.Minute, 30)
+	require.Empty(t, tt.remote.sent)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	require.NoError(t, DefaultConfig.Validate())
+
+	config := DefaultConfig
+	config.Low = 26
+	require.Error(t, config.Validate())
+
+	config = DefaultConfig
+	config.Mode = commands.AcModeDry
+	require.Error(t, config.Validate())
+}
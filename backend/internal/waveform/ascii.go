@@ -0,0 +1,79 @@
+package waveform
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Durations longer than ASCII_MAX_CHARS bit marks are drawn shortened.
+const ASCII_MAX_CHARS = 8
+
+const asciiPrefixWidth = 7
+
+// RenderAscii draws the waveform for a terminal of the given width: one
+// column per bit mark, marks as '#', spaces as '_' and shortened durations
+// broken with '~'. The line under the wave holds bit values and a '^' where
+// decoding failed. Every row starts with the position of its first duration.
+func RenderAscii(w Waveform, width int) string {
+	if len(w.Signal) == 0 {
+		return w.Title + "\n"
+	}
+
+	unit := medianMark(w.Signal)
+	rowWidth := width - asciiPrefixWidth
+	if rowWidth < ASCII_MAX_CHARS {
+		rowWidth = ASCII_MAX_CHARS
+	}
+	elements, rows := layout(w, rowWidth, unit, ASCII_MAX_CHARS*unit)
+
+	waves := make([][]byte, rows)
+	notes := make([][]byte, rows)
+	firstPositions := make([]int, rows)
+	frameRows := make(map[int]int)
+	for i, e := range elements {
+		if i == 0 || elements[i-1].row != e.row {
+			firstPositions[e.row] = e.position
+		}
+
+		symbol := byte('_')
+		if e.position%2 == 0 {
+			symbol = '#'
+		}
+		chars := []byte(strings.Repeat(string(symbol), e.width))
+		if e.capped {
+			chars[e.width/2] = '~'
+		}
+		waves[e.row] = append(waves[e.row], chars...)
+
+		note := []byte(strings.Repeat(" ", e.width))
+		if bit, ok := w.BitAt(e.position); ok && e.position%2 == 0 {
+			note[0] = bitText(bit)[0]
+		}
+		if e.position == w.Failure {
+			note[0] = '^'
+		}
+		notes[e.row] = append(notes[e.row], note...)
+
+		if e.position%2 == 0 && w.isFrameStart(e.position) {
+			frameRows[e.row] = len(frameRows)
+		}
+	}
+
+	result := &strings.Builder{}
+	if w.Title != "" {
+		result.WriteString(w.Title + "\n")
+	}
+	for row := 0; row < rows; row++ {
+		if frame, ok := frameRows[row]; ok {
+			fmt.Fprintf(result, "frame %v\n", frame)
+		}
+		fmt.Fprintf(result, "%*d %s\n", asciiPrefixWidth-1, firstPositions[row], waves[row])
+		if note := strings.TrimRight(string(notes[row]), " "); note != "" {
+			fmt.Fprintf(result, "%*s %s\n", asciiPrefixWidth-1, "", note)
+		}
+	}
+	if w.FailureReason != "" {
+		result.WriteString("^ " + w.FailureReason + "\n")
+	}
+	return result.String()
+}
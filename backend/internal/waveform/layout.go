@@ -0,0 +1,52 @@
+package waveform
+
+// element is a duration of the signal placed on a row of the picture.
+type element struct {
+	position int
+	row      int
+	x        int
+	width    int
+	// capped is true if the duration was too long and is drawn shortened
+	capped bool
+}
+
+// layout places the durations left to right, unit microseconds per column,
+// durations longer than maxDuration are shortened. Every frame starts a new
+// row, as does an element that does not fit the current one.
+func layout(w Waveform, rowWidth int, unit int, maxDuration int) ([]element, int) {
+	elements := make([]element, 0, len(w.Signal))
+	row, x := 0, 0
+	for i, duration := range w.Signal {
+		capped := duration > maxDuration
+		if capped {
+			duration = maxDuration
+		}
+
+		width := (duration + unit/2) / unit
+		if width < 1 {
+			width = 1
+		}
+
+		if x > 0 && (x+width > rowWidth || (i%2 == 0 && w.isFrameStart(i))) {
+			row++
+			x = 0
+		}
+
+		elements = append(elements, element{position: i, row: row, x: x, width: width, capped: capped})
+		x += width
+	}
+
+	rows := 0
+	if len(elements) > 0 {
+		rows = row + 1
+	}
+	return elements, rows
+}
+
+// bitWidth is the width of the bit starting at the element, its mark and space.
+func bitWidth(elements []element, i int) int {
+	if i+1 < len(elements) && elements[i+1].row == elements[i].row {
+		return elements[i].width + elements[i+1].width
+	}
+	return elements[i].width
+}
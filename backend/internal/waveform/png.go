@@ -0,0 +1,79 @@
+package waveform
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+)
+
+var (
+	colorBackground = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	colorMark       = color.RGBA{R: 0xcc, G: 0xdd, B: 0xee, A: 0xff}
+	colorWave       = color.RGBA{A: 0xff}
+	colorBit        = color.RGBA{R: 0xbb, G: 0xbb, B: 0xbb, A: 0xff}
+	colorByte       = color.RGBA{R: 0x66, G: 0x66, B: 0x66, A: 0xff}
+	colorFrame      = color.RGBA{R: 0x22, G: 0xaa, B: 0x22, A: 0xff}
+	colorFailure    = color.RGBA{R: 0xff, G: 0x99, B: 0x99, A: 0xff}
+)
+
+// RenderPng draws the same picture as RenderSvg for clients that do not
+// display SVG, like Telegram. It has no text: bit values are drawn as tall
+// (one) or short (zero) bars under the bits.
+func RenderPng(w Waveform) ([]byte, error) {
+	elements, rows := layout(w, ROW_WIDTH, US_PER_PIXEL, MAX_DRAWN_DURATION)
+	width, height := pictureSize(rows)
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fill := func(x0, y0, x1, y1 int, c color.Color) {
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	fill(0, 0, width, height, colorBackground)
+
+	for i, e := range elements {
+		x := LEFT_MARGIN + e.x
+		top := rowTop(e.row)
+
+		if e.position == w.Failure {
+			fill(x, top, x+e.width, top+ROW_HEIGHT, colorFailure)
+		}
+
+		if e.position%2 != 0 {
+			fill(x, top+waveLow, x+e.width+1, top+waveLow+1, colorWave)
+			continue
+		}
+
+		fill(x, top+waveHigh, x+e.width, top+waveLow, colorMark)
+		fill(x, top+waveHigh, x+e.width+1, top+waveHigh+1, colorWave)
+		fill(x, top+waveHigh, x+1, top+waveLow, colorWave)
+		fill(x+e.width, top+waveHigh, x+e.width+1, top+waveLow, colorWave)
+
+		if w.isFrameStart(e.position) {
+			for y := top; y < top+ROW_HEIGHT; y += 6 {
+				fill(x, y, x+1, y+4, colorFrame)
+			}
+		}
+
+		if bit, ok := w.BitAt(e.position); ok {
+			c := colorBit
+			if w.BitIndex(e.position)%8 == 0 {
+				c = colorByte
+			}
+			fill(x, top+waveLow, x+1, top+waveLow+6, c)
+
+			barHeight := 3
+			if bit.Value {
+				barHeight = 10
+			}
+			middle := x + bitWidth(elements, i)/2
+			fill(middle-2, top+waveLow+18-barHeight, middle+2, top+waveLow+18, colorWave)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package waveform
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+)
+
+// Picture geometry shared by the SVG and PNG renderers, in pixels unless noted.
+const US_PER_PIXEL = 20
+const MAX_DRAWN_DURATION = 10000 // µs
+const ROW_WIDTH = 1600
+const ROW_HEIGHT = 70
+const LEFT_MARGIN = 60
+const RIGHT_MARGIN = 10
+const HEADER_HEIGHT = 50
+
+const waveHigh = 15
+const waveLow = 45
+
+func pictureSize(rows int) (int, int) {
+	return LEFT_MARGIN + ROW_WIDTH + RIGHT_MARGIN, HEADER_HEIGHT + rows*ROW_HEIGHT
+}
+
+func rowTop(row int) int {
+	return HEADER_HEIGHT + row*ROW_HEIGHT
+}
+
+// RenderSvg draws the waveform: marks are filled, bit boundaries are thin
+// lines with the bit value below, every 8th is darker. Frames start on a new
+// row with a green line and the position where decoding failed is red.
+func RenderSvg(w Waveform) []byte {
+	elements, rows := layout(w, ROW_WIDTH, US_PER_PIXEL, MAX_DRAWN_DURATION)
+	width, height := pictureSize(rows)
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" viewBox="0 0 %v %v" font-family="monospace" font-size="11">`+"\n", width, height, width, height)
+	fmt.Fprintf(buf, `<rect width="%v" height="%v" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(buf, `<text x="%v" y="20" font-size="14">%v</text>`+"\n", LEFT_MARGIN, html.EscapeString(w.Title))
+	if w.FailureReason != "" {
+		fmt.Fprintf(buf, `<text x="%v" y="38" fill="#c00">%v</text>`+"\n", LEFT_MARGIN, html.EscapeString(w.FailureReason))
+	}
+
+	path := &bytes.Buffer{}
+	frame := 0
+	for i, e := range elements {
+		x := LEFT_MARGIN + e.x
+		top := rowTop(e.row)
+
+		if i == 0 || elements[i-1].row != e.row {
+			fmt.Fprintf(buf, `<text x="%v" y="%v" fill="#888">%v</text>`+"\n", 4, top+waveLow, e.position)
+			fmt.Fprintf(path, "M%v,%v ", x, top+waveLow)
+		}
+
+		if e.position == w.Failure {
+			fmt.Fprintf(buf, `<rect x="%v" y="%v" width="%v" height="%v" fill="#f00" fill-opacity="0.3"/>`+"\n", x, top, e.width, ROW_HEIGHT)
+		}
+
+		if e.position%2 == 0 {
+			fmt.Fprintf(buf, `<rect x="%v" y="%v" width="%v" height="%v" fill="#cde"/>`+"\n", x, top+waveHigh, e.width, waveLow-waveHigh)
+			fmt.Fprintf(path, "L%v,%v L%v,%v L%v,%v ", x, top+waveHigh, x+e.width, top+waveHigh, x+e.width, top+waveLow)
+
+			if w.isFrameStart(e.position) {
+				fmt.Fprintf(buf, `<line x1="%v" y1="%v" x2="%v" y2="%v" stroke="#2a2" stroke-dasharray="4,2"/>`+"\n", x, top, x, top+ROW_HEIGHT)
+				fmt.Fprintf(buf, `<text x="%v" y="%v" fill="#2a2">frame %v</text>`+"\n", x+3, top+10, frame)
+				frame++
+			}
+
+			if bit, ok := w.BitAt(e.position); ok {
+				stroke := "#bbb"
+				if w.BitIndex(e.position)%8 == 0 {
+					stroke = "#666"
+				}
+				fmt.Fprintf(buf, `<line x1="%v" y1="%v" x2="%v" y2="%v" stroke="%v"/>`+"\n", x, top+waveHigh-3, x, top+waveLow+5, stroke)
+				fmt.Fprintf(buf, `<text x="%v" y="%v" text-anchor="middle">%v</text>`+"\n", x+bitWidth(elements, i)/2, top+waveLow+17, bitText(bit))
+			}
+		} else {
+			fmt.Fprintf(path, "L%v,%v ", x+e.width, top+waveLow)
+		}
+
+		if e.capped {
+			middle := x + e.width/2
+			fmt.Fprintf(buf, `<path d="M%v,%v l6,-12 M%v,%v l6,-12" stroke="#888"/>`+"\n", middle-6, top+waveLow+6, middle, top+waveLow+6)
+		}
+	}
+
+	fmt.Fprintf(buf, `<path d="%v" fill="none" stroke="black"/>`+"\n", bytes.TrimSpace(path.Bytes()))
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+func bitText(bit Bit) string {
+	if bit.Value {
+		return "1"
+	}
+	return "0"
+}
@@ -0,0 +1,168 @@
+package waveform
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"sort"
+)
+
+// A mark longer than HEADER_MARK_RATIO bit marks starts a frame.
+const HEADER_MARK_RATIO = 2
+
+// A space longer than FRAME_GAP_RATIO bit marks after a bit separates frames.
+const FRAME_GAP_RATIO = 6
+
+// A bit space longer than ONE_SPACE_RATIO bit marks is a one.
+const ONE_SPACE_RATIO = 2
+
+// Bit is a mark/space pair of a pulse distance encoded signal.
+type Bit struct {
+	// Position of the bit mark in the signal
+	Position int
+	Value    bool
+}
+
+// Waveform is a mark/space sequence annotated for rendering.
+type Waveform struct {
+	Signal []int
+	Title  string
+	Bits   []Bit
+	// Frames are the positions of the first mark of every frame
+	Frames []int
+	// Failure is the position at which decoding failed, -1 if it did not
+	Failure       int
+	FailureReason string
+}
+
+// Analyze splits the signal into frames and bits, assuming a pulse distance
+// encoding, which is what all the supported protocols use. The bit mark is
+// taken to be the median mark, headers and gaps are recognised by being
+// much longer than it.
+func Analyze(signal []int) Waveform {
+	w := Waveform{
+		Signal:  signal,
+		Bits:    make([]Bit, 0, len(signal)/2),
+		Frames:  make([]int, 0),
+		Failure: -1,
+	}
+	if len(signal) == 0 {
+		return w
+	}
+
+	bitMark := medianMark(signal)
+	isBitMark := func(duration int) bool {
+		return duration <= HEADER_MARK_RATIO*bitMark
+	}
+
+	w.Frames = append(w.Frames, 0)
+	for i := 0; i < len(signal); i += 2 {
+		if i > 0 {
+			headerMark := !isBitMark(signal[i])
+			gap := signal[i-1] > FRAME_GAP_RATIO*bitMark && isBitMark(signal[i-2])
+			if headerMark || gap {
+				w.Frames = append(w.Frames, i)
+			}
+		}
+
+		if !isBitMark(signal[i]) || i+1 >= len(signal) || signal[i+1] > FRAME_GAP_RATIO*bitMark {
+			continue
+		}
+		w.Bits = append(w.Bits, Bit{Position: i, Value: signal[i+1] > ONE_SPACE_RATIO*bitMark})
+	}
+
+	return w
+}
+
+// Describe analyzes the signal and decodes it with the given protocol, or
+// with every known protocol if it is empty. If decoding fails, the waveform
+// points at the duration that was rejected.
+func Describe(signal []int, protocol string) Waveform {
+	w := Analyze(signal)
+
+	var cmd commands.Command
+	var err error
+	if protocol == "" {
+		protocol, cmd, err = commands.Decode(signal)
+	} else {
+		cmd, err = commands.NewCommand(protocol)
+		if err == nil {
+			err = cmd.ParseFromSignalSequence(signal)
+		}
+	}
+
+	if err == nil {
+		w.Title = protocol
+		if debug, ok := cmd.(interface{ DebugString() string }); ok {
+			w.Title = protocol + ": " + debug.DebugString()
+		}
+		return w
+	}
+
+	var decodeError *commands.DecodeError
+	if errors.As(err, &decodeError) {
+		var signalError *commands.SignalError
+		var ok bool
+		if protocol, signalError, ok = decodeError.Furthest(); ok {
+			err = signalError
+		} else {
+			w.Title = "unknown protocol"
+			w.FailureReason = "no protocol matched the signal"
+			return w
+		}
+	}
+
+	w.Title = protocol + ": decoding failed"
+	w.FailureReason = err.Error()
+	if position, ok := commands.ErrorPosition(err); ok {
+		w.Failure = position
+		w.FailureReason = fmt.Sprintf("%v: %v", protocol, err)
+	}
+	return w
+}
+
+// BitAt returns the bit starting at the position, if any.
+func (w Waveform) BitAt(position int) (Bit, bool) {
+	i := sort.Search(len(w.Bits), func(i int) bool {
+		return w.Bits[i].Position >= position
+	})
+	if i < len(w.Bits) && w.Bits[i].Position == position {
+		return w.Bits[i], true
+	}
+	return Bit{}, false
+}
+
+// BitIndex returns the index of the bit starting at the position within its frame.
+func (w Waveform) BitIndex(position int) int {
+	frameStart := 0
+	for _, frame := range w.Frames {
+		if frame <= position {
+			frameStart = frame
+		}
+	}
+
+	return sort.Search(len(w.Bits), func(i int) bool {
+		return w.Bits[i].Position >= position
+	}) - sort.Search(len(w.Bits), func(i int) bool {
+		return w.Bits[i].Position >= frameStart
+	})
+}
+
+func (w Waveform) isFrameStart(position int) bool {
+	i := sort.SearchInts(w.Frames, position)
+	return i < len(w.Frames) && w.Frames[i] == position
+}
+
+func medianMark(signal []int) int {
+	marks := make([]int, 0, len(signal)/2+1)
+	for i := 0; i < len(signal); i += 2 {
+		marks = append(marks, signal[i])
+	}
+	sort.Ints(marks)
+
+	median := marks[len(marks)/2]
+	if median <= 0 {
+		return 1
+	}
+	return median
+}
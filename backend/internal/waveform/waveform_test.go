@@ -0,0 +1,56 @@
+package waveform
+
+import (
+	"bytes"
+	"github.com/Light-Keeper/ir-remote/internal/commands"
+	"github.com/stretchr/testify/require"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	signal := commands.NewGreeCommand().ToSignalSequence()
+
+	w := Analyze(signal)
+	// the second block of a gree frame follows a gap without a header
+	require.Equal(t, []int{0, 74}, w.Frames)
+	require.Len(t, w.Bits, 4*8+commands.GREE_BLOCK_FOOTER_BITS+4*8)
+	require.Equal(t, Bit{Position: 2, Value: true}, w.Bits[0])
+	require.Equal(t, 0, w.BitIndex(74))
+	require.Equal(t, -1, w.Failure)
+}
+
+func TestDescribe_Failure(t *testing.T) {
+	signal := commands.NewGreeCommand().ToSignalSequence()
+	signal[21] = 3000
+
+	w := Describe(signal, "")
+	require.Equal(t, 21, w.Failure)
+	require.Contains(t, w.FailureReason, "gree: invalid signal sequence")
+
+	ascii := RenderAscii(w, 100)
+	lines := strings.Split(ascii, "\n")
+	require.Equal(t, "gree: decoding failed", lines[0])
+	require.Contains(t, ascii, "^ gree: invalid signal sequence")
+
+	svg := RenderSvg(w)
+	require.True(t, bytes.HasPrefix(svg, []byte("<svg ")))
+	require.Contains(t, string(svg), `fill="#f00"`)
+
+	data, err := RenderPng(w)
+	require.NoError(t, err)
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, LEFT_MARGIN+ROW_WIDTH+RIGHT_MARGIN, config.Width)
+}
+
+func TestDescribe_Decoded(t *testing.T) {
+	signal := commands.NewLgCommand().ToSignalSequence()
+
+	w := Describe(signal, "lg")
+	require.Equal(t, -1, w.Failure)
+	require.Empty(t, w.FailureReason)
+	require.True(t, strings.HasPrefix(w.Title, "lg: "))
+	require.Len(t, w.Bits, 28)
+}
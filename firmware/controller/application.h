@@ -1,13 +1,29 @@
 #include <IRsend.h>
+#include <ESP8266WiFi.h>
 #include <ArduinoJson.h>
+#include <libb64/cdecode.h>
 #include "logger.h"
+#include "packed.h"
+#include "fragments.h"
+#include "ota.h"
 
 #ifndef APPLICATION_H
 #define APPLICATION_H
 
+#define DEFAULT_CARRIER_FREQUENCY 38000
+#define DEFAULT_DUTY_CYCLE 50
+#define MIN_CARRIER_FREQUENCY 30000
+#define MAX_CARRIER_FREQUENCY 60000
+
+// version 2 accepts packed timings, version 3 fragmented messages, version 4 firmware offers
+#define PROTOCOL_VERSION 4
+// long AC frames like Daikin have close to 600 timings
+#define COMMAND_BUFFER_LEN 600
+
 class Application {
     public:
-        Application(int pinNumber) : irsend(pinNumber, true) {
+        Application(int pinNumber, size_t maxPacketSize, Ota &ota) : irsend(pinNumber, true), ota(ota) {
+            this->maxPacketSize = maxPacketSize;
             irsend.begin();
         }
 
@@ -17,9 +33,29 @@ class Application {
             Logger.println(number);
 
             if (number > lastCommandId) {
+                if (json.containsKey("firmware")) {
+                    // a rejected offer is reported in update_error
+                    lastCommandId = number;
+                    ota.offer(json["firmware"]);
+                    return;
+                }
+
+                uint32_t frequency = json["frequency"] | DEFAULT_CARRIER_FREQUENCY;
+                uint8_t dutyCycle = json["duty_cycle"] | DEFAULT_DUTY_CYCLE;
+                size_t commandLen;
+                if (json.containsKey("packed")) {
+                    commandLen = packedIntoCommandBuffer(json["packed"], commandBuffer, COMMAND_BUFFER_LEN);
+                } else {
+                    commandLen = jsonArrayIntoCommandBuffer(json["data"], commandBuffer, COMMAND_BUFFER_LEN);
+                }
+
+                if (commandLen == 0) {
+                    // not acknowledged, so the backend does not report it as sent
+                    Logger.println("Invalid command, ignoring");
+                    return;
+                }
                 lastCommandId = number;
-                size_t commandLen = jsonArrayIntoCommandBuffer(json["data"], commandBuffer, sizeof(commandBuffer));
-                executeCommand(commandBuffer, commandLen);
+                executeCommand(commandBuffer, commandLen, frequency, dutyCycle);
             }
         }
 
@@ -27,6 +63,18 @@ class Application {
             Logger.print("reportStatus... ");
             json.clear();
             json["last_command_sequence_number"] = lastCommandId;
+            json["min_carrier_frequency"] = MIN_CARRIER_FREQUENCY;
+            json["max_carrier_frequency"] = MAX_CARRIER_FREQUENCY;
+            json["protocol_version"] = PROTOCOL_VERSION;
+            json["max_command_length"] = COMMAND_BUFFER_LEN;
+            json["max_packet_size"] = maxPacketSize;
+            json["max_message_size"] = MESSAGE_BUFFER_SIZE;
+            json["firmware_version"] = FIRMWARE_VERSION;
+            // micros64() does not wrap like millis() does after 49.7 days
+            json["uptime_seconds"] = (uint64_t)(micros64() / 1000000);
+            json["rssi"] = WiFi.RSSI();
+            json["free_heap"] = ESP.getFreeHeap();
+            json["reset_reason"] = ESP.getResetReason();
         }
 
     private:
@@ -44,20 +92,51 @@ class Application {
             return i;
         }
 
-        void executeCommand(uint16_t *commandBuffer, size_t commandLen) {
-            Logger.print("Executing command: ");
+        size_t packedIntoCommandBuffer(const char *base64, uint16_t *buffer, size_t bufferLen) {
+            size_t base64Len = strlen(base64);
+            if (base64_decode_expected_len(base64Len) > sizeof(packedBuffer)) {
+                Logger.println("Packed command is too long!");
+                return 0;
+            }
+
+            int packedLen = base64_decode_chars(base64, base64Len, (char *)packedBuffer);
+            size_t commandLen = unpackTimings(packedBuffer, packedLen, buffer, bufferLen);
+            if (commandLen == 0) {
+                Logger.println("Failed to unpack command!");
+            }
+            return commandLen;
+        }
+
+        void executeCommand(uint16_t *commandBuffer, size_t commandLen, uint32_t frequency, uint8_t dutyCycle) {
+            Logger.print("Executing command at ");
+            Logger.print(frequency);
+            Logger.print(" Hz: ");
             for (int i = 0; i < commandLen; i++) {
                 Logger.print(commandBuffer[i]);
                 Logger.print(" ");
             }
             Logger.println();
-            irsend.sendRaw(commandBuffer, commandLen, 38);  
+
+            // same as irsend.sendRaw, which does not allow to set the duty cycle
+            irsend.enableIROut(frequency, dutyCycle);
+            for (size_t i = 0; i < commandLen; i++) {
+                if (i & 1) {
+                    irsend.space(commandBuffer[i]);
+                } else {
+                    irsend.mark(commandBuffer[i]);
+                }
+            }
+            irsend.ledOff();
         }
 
     private:
         IRsend irsend;
+        Ota &ota;
 
-        uint16_t commandBuffer[300];
+        uint16_t commandBuffer[COMMAND_BUFFER_LEN];
+        // packed timings take less than 2 bytes per timing, the dictionary included
+        uint8_t packedBuffer[COMMAND_BUFFER_LEN * 2];
+        size_t maxPacketSize;
         int lastTimeStatusSent = 0;
         int lastCommandId = 0;
 };
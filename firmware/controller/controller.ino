@@ -4,7 +4,12 @@
 #include "logger.h"
 #include "application.h"
 #include "network.h"
+#include "mqtt_network.h"
+#include "ws_network.h"
+#include "serial_network.h"
 #include "crypto.h"
+#include "sensor.h"
+#include "ota.h"
 
 #ifndef FIRMWARE_WIFI_SSID
     #error "FIRMWARE_WIFI_SSID macro is not defined!"
@@ -14,8 +19,32 @@
     #error "FIRMWARE_WIFI_PASS macro is not defined!"
 #endif
 
-#ifndef FIRMWARE_REMOTE_HOST
-    #error "FIRMWARE_REMOTE_HOST macro is not defined!"
+#if !defined(FIRMWARE_REMOTE_HOST) && !defined(FIRMWARE_MQTT_HOST) && !defined(FIRMWARE_WS_HOST) && !defined(FIRMWARE_SERIAL)
+    #error "FIRMWARE_REMOTE_HOST, FIRMWARE_MQTT_HOST, FIRMWARE_WS_HOST or FIRMWARE_SERIAL macro must be defined!"
+#endif
+
+#ifndef FIRMWARE_WS_PORT
+    #define FIRMWARE_WS_PORT 443
+#endif
+
+#if defined(FIRMWARE_WS_HOST) && !defined(FIRMWARE_WS_FINGERPRINT)
+    #error "FIRMWARE_WS_FINGERPRINT macro, the SHA-1 fingerprint of the certificate of FIRMWARE_WS_HOST, is not defined!"
+#endif
+
+#ifndef FIRMWARE_MQTT_PORT
+    #define FIRMWARE_MQTT_PORT 1883
+#endif
+
+#ifndef FIRMWARE_MQTT_USER
+    #define FIRMWARE_MQTT_USER ""
+#endif
+
+#ifndef FIRMWARE_MQTT_PASS
+    #define FIRMWARE_MQTT_PASS ""
+#endif
+
+#ifndef FIRMWARE_DEVICE_ID
+    #define FIRMWARE_DEVICE_ID "default"
 #endif
 
 #ifndef FIRMWARE_SHARED_SECRET
@@ -26,14 +55,32 @@
 #define REMOTE_UDP_PORT 4944
 
 #define LED_PIN 2
+#define IO_BUFFER_SIZE 2048
 #define IDLE_PING_INTERVAL (5 * 1000) // 5 seconds
 
+// the messages go through an MQTT broker when FIRMWARE_MQTT_HOST is set, a
+// WebSocket when FIRMWARE_WS_HOST is set, the USB serial port when
+// FIRMWARE_SERIAL is set, over UDP otherwise
+#if defined(FIRMWARE_MQTT_HOST)
+MqttNetwork network(FIRMWARE_WIFI_SSID, FIRMWARE_WIFI_PASS, FIRMWARE_MQTT_HOST, FIRMWARE_MQTT_PORT,
+                    FIRMWARE_MQTT_USER, FIRMWARE_MQTT_PASS, FIRMWARE_DEVICE_ID, IO_BUFFER_SIZE);
+#elif defined(FIRMWARE_WS_HOST)
+WsNetwork network(FIRMWARE_WIFI_SSID, FIRMWARE_WIFI_PASS, FIRMWARE_WS_HOST, FIRMWARE_WS_PORT, FIRMWARE_DEVICE_ID,
+                  FIRMWARE_SHARED_SECRET, FIRMWARE_WS_FINGERPRINT);
+#elif defined(FIRMWARE_SERIAL)
+SerialNetwork network;
+#else
 Network network(FIRMWARE_WIFI_SSID, FIRMWARE_WIFI_PASS, LOCAL_UDP_PORT, REMOTE_UDP_PORT, FIRMWARE_REMOTE_HOST);
-Application application(LED_PIN);
+#endif
+Ota ota;
+Application application(LED_PIN, IO_BUFFER_SIZE, ota);
 Crypto crypto(FIRMWARE_SHARED_SECRET);
+Reassembler reassembler;
+Sensor sensor;
 
-char iobuffer[2048];
-DynamicJsonDocument json(8 * 1024);
+char iobuffer[IO_BUFFER_SIZE];
+// fits a command of COMMAND_BUFFER_LEN unpacked timings
+DynamicJsonDocument json(12 * 1024);
 uint64 nextTimeSendStatus = 0;
 
 
@@ -43,17 +90,33 @@ void setup() {
     Logger.println("WIFI_PASS: " + String(FIRMWARE_WIFI_PASS));
     Logger.println("LOCAL_UDP_PORT: " + String(LOCAL_UDP_PORT));
     Logger.println("REMOTE_UDP_PORT: " + String(REMOTE_UDP_PORT));
+#if defined(FIRMWARE_MQTT_HOST)
+    Logger.println("mqttHost: " + String(FIRMWARE_MQTT_HOST));
+#elif defined(FIRMWARE_WS_HOST)
+    Logger.println("wsHost: " + String(FIRMWARE_WS_HOST));
+#elif defined(FIRMWARE_SERIAL)
+    Logger.println("serial: 115200 baud");
+#else
     Logger.println("remoteHost: " + String(FIRMWARE_REMOTE_HOST));
+#endif
     
     network.Connect();
+    sensor.begin();
 }
 
 void loop() {   
     uint64 now = millis();
+    sensor.update(now);
     size_t len = network.Receive(iobuffer, sizeof(iobuffer));
-    
+    char *message = iobuffer;
+
+    if (len > 0 && Reassembler::isFragment((uint8_t *)iobuffer, len)) {
+        len = reassembler.add((uint8_t *)iobuffer, len, now);
+        message = (char *)reassembler.message();
+    }
+
     if (len > 0) {
-        bool successfullDecrypt = crypto.decrypt(iobuffer, len, json);
+        bool successfullDecrypt = crypto.decrypt(message, len, json);
         if (successfullDecrypt) {
             application.consumeCommand(json);
             nextTimeSendStatus = now;
@@ -63,7 +126,12 @@ void loop() {
     if (nextTimeSendStatus <= now) {
         nextTimeSendStatus = now + IDLE_PING_INTERVAL;
         application.reportStatus(json);
+        sensor.reportStatus(json);
+        ota.reportStatus(json);
         size_t len = crypto.encrypt(json, iobuffer, sizeof(iobuffer));
         network.Send(iobuffer, len);
+
+        // the offer is acknowledged before the download blocks the loop
+        ota.install();
     }   
 }
\ No newline at end of file
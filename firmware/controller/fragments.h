@@ -0,0 +1,102 @@
+#include <stdint.h>
+#include <stddef.h>
+#include <string.h>
+
+#ifndef FRAGMENTS_H
+#define FRAGMENTS_H
+
+// Reassembles messages the backend splits into fragments, see fragments.go:
+//   char[4]  "IRFR"
+//   uint32   message id
+//   uint8    fragment index
+//   uint8    fragment count
+//   uint16   offset of the payload in the message
+//   uint16   total message length
+// all big endian, followed by the payload.
+
+#define FRAGMENT_MAGIC "IRFR"
+#define FRAGMENT_HEADER_SIZE 14
+#define FRAGMENT_MAX_COUNT 32
+#define FRAGMENT_TIMEOUT_MS 5000
+#define MESSAGE_BUFFER_SIZE 4096
+
+class Reassembler {
+    public:
+        static bool isFragment(const uint8_t *data, size_t len) {
+            return len >= FRAGMENT_HEADER_SIZE && memcmp(data, FRAGMENT_MAGIC, 4) == 0;
+        }
+
+        // Returns the length of the message once all of its fragments are received, 0 otherwise.
+        size_t add(const uint8_t *data, size_t len, uint32_t now) {
+            if (!isFragment(data, len)) {
+                return 0;
+            }
+
+            uint32_t id = ((uint32_t)data[4] << 24) | ((uint32_t)data[5] << 16) | ((uint32_t)data[6] << 8) | data[7];
+            uint8_t index = data[8];
+            uint8_t count = data[9];
+            uint16_t offset = (data[10] << 8) | data[11];
+            uint16_t total = (data[12] << 8) | data[13];
+            size_t payloadLen = len - FRAGMENT_HEADER_SIZE;
+
+            if (count == 0 || count > FRAGMENT_MAX_COUNT || index >= count || total > MESSAGE_BUFFER_SIZE || offset + payloadLen > total) {
+                return 0;
+            }
+
+            bool expired = now - startedAt > FRAGMENT_TIMEOUT_MS;
+            if (!inProgress || expired || id != messageId || count != fragmentCount || total != messageLen) {
+                inProgress = true;
+                messageId = id;
+                fragmentCount = count;
+                messageLen = total;
+                receivedMask = 0;
+                receivedBytes = 0;
+                startedAt = now;
+            }
+
+            if (receivedMask & ((uint32_t)1 << index)) {
+                return 0;
+            }
+
+            // fragments may not overlap, so the message has no gaps once receivedBytes reaches its length
+            uint16_t end = offset + payloadLen;
+            for (uint8_t i = 0; i < count; i++) {
+                if ((receivedMask & ((uint32_t)1 << i)) && offset < fragmentEnds[i] && fragmentOffsets[i] < end) {
+                    inProgress = false;
+                    return 0;
+                }
+            }
+
+            memcpy(buffer + offset, data + FRAGMENT_HEADER_SIZE, payloadLen);
+            receivedMask |= (uint32_t)1 << index;
+            fragmentOffsets[index] = offset;
+            fragmentEnds[index] = end;
+            receivedBytes += payloadLen;
+
+            uint32_t allReceived = count == 32 ? 0xFFFFFFFF : ((uint32_t)1 << count) - 1;
+            if (receivedMask != allReceived) {
+                return 0;
+            }
+
+            inProgress = false;
+            return receivedBytes == messageLen ? messageLen : 0;
+        }
+
+        uint8_t *message() {
+            return buffer;
+        }
+
+    private:
+        uint8_t buffer[MESSAGE_BUFFER_SIZE];
+        bool inProgress = false;
+        uint32_t messageId = 0;
+        uint8_t fragmentCount = 0;
+        uint16_t messageLen = 0;
+        uint32_t receivedMask = 0;
+        uint16_t fragmentOffsets[FRAGMENT_MAX_COUNT];
+        uint16_t fragmentEnds[FRAGMENT_MAX_COUNT];
+        uint32_t receivedBytes = 0;
+        uint32_t startedAt = 0;
+};
+
+#endif
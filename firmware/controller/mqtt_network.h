@@ -0,0 +1,142 @@
+#include <Arduino.h>
+#include <ESP8266WiFi.h>
+#include <PubSubClient.h>
+#include "logger.h"
+
+#ifndef MQTT_NETWORK_H
+#define MQTT_NETWORK_H
+
+#define MQTT_TOPIC_PREFIX "ir-remote/"
+#define MQTT_RECONNECT_INTERVAL (5 * 1000) // 5 seconds
+
+// MqttNetwork exchanges the messages through an MQTT broker instead of UDP,
+// so the remote only needs outbound connectivity. It publishes to
+// ir-remote/{device}/status and receives from ir-remote/{device}/command,
+// with QoS 0 like datagrams. It connects anonymously if brokerUser is empty.
+class MqttNetwork {
+    public:
+        MqttNetwork(const char *ssid, const char *pass, const char *brokerHost, uint16_t brokerPort,
+                    const char *brokerUser, const char *brokerPass, const char *deviceId, size_t maxMessageSize)
+            : client(wifiClient) {
+            this->ssid = ssid;
+            this->pass = pass;
+            this->brokerHost = brokerHost;
+            this->brokerPort = brokerPort;
+            this->brokerUser = brokerUser;
+            this->brokerPass = brokerPass;
+            this->deviceId = deviceId;
+            this->maxMessageSize = maxMessageSize;
+            this->statusTopic = String(MQTT_TOPIC_PREFIX) + deviceId + "/status";
+            this->commandTopic = String(MQTT_TOPIC_PREFIX) + deviceId + "/command";
+            instance = this;
+        }
+
+        void Connect() {
+            WiFi.mode(WIFI_STA);
+            WiFi.begin(this->ssid, this->pass);
+
+            Logger.print("Connecting to ");
+            Logger.println(this->ssid);
+            while (WiFi.status() != WL_CONNECTED) {
+                delay(100);
+                Logger.print(".");
+            }
+
+            Logger.print("Connected! IP address: ");
+            Logger.println(WiFi.localIP());
+
+            WiFi.setAutoReconnect(true);
+            WiFi.persistent(true);
+
+            // the topic and the packet header need room next to the message
+            this->client.setBufferSize(this->maxMessageSize + this->statusTopic.length() + 16);
+            this->client.setServer(this->brokerHost, this->brokerPort);
+            this->client.setCallback(MqttNetwork::onMessage);
+            this->connectBroker();
+        }
+
+        void Send(char *buffer, size_t len) {
+            Logger.print("Publishing message... len: ");
+            Logger.println(len);
+            if (!this->client.connected()) {
+                Logger.println("Not connected to the broker, dropping");
+                return;
+            }
+            this->client.publish(this->statusTopic.c_str(), (const uint8_t *)buffer, len, false);
+        }
+
+        int Receive(char *buffer, size_t len) {
+            if (!this->client.connected()) {
+                this->connectBroker();
+            }
+
+            this->received = 0;
+            this->receiveBuffer = buffer;
+            this->receiveBufferLen = len;
+            this->client.loop();
+            return this->received;
+        }
+
+    private:
+        void connectBroker() {
+            uint64 now = millis();
+            if (this->lastConnectAttempt != 0 && now - this->lastConnectAttempt < MQTT_RECONNECT_INTERVAL) {
+                return;
+            }
+            this->lastConnectAttempt = now;
+
+            Logger.print("Connecting to MQTT broker ");
+            Logger.println(this->brokerHost);
+            String clientId = String("ir-remote-") + this->deviceId;
+            bool anonymous = strlen(this->brokerUser) == 0;
+            if (!this->client.connect(clientId.c_str(),
+                                      anonymous ? nullptr : this->brokerUser,
+                                      anonymous ? nullptr : this->brokerPass)) {
+                Logger.print("Failed to connect to the broker, state ");
+                Logger.println(this->client.state());
+                return;
+            }
+            this->client.subscribe(this->commandTopic.c_str(), 0);
+            Logger.print("Subscribed to ");
+            Logger.println(this->commandTopic);
+        }
+
+        // onMessage copies a message received during loop() into the buffer of Receive
+        static void onMessage(char *topic, byte *payload, unsigned int length) {
+            MqttNetwork *self = instance;
+            if (self->receiveBuffer == nullptr || self->received > 0) {
+                Logger.println("Message arrived outside of Receive, dropping");
+                return;
+            }
+            if (length > self->receiveBufferLen) {
+                Logger.println("Message does not fit into the buffer, dropping");
+                return;
+            }
+            memcpy(self->receiveBuffer, payload, length);
+            self->received = length;
+        }
+
+        static MqttNetwork *instance;
+
+        WiFiClient wifiClient;
+        PubSubClient client;
+        const char *ssid;
+        const char *pass;
+        const char *brokerHost;
+        uint16_t brokerPort;
+        const char *brokerUser;
+        const char *brokerPass;
+        const char *deviceId;
+        size_t maxMessageSize;
+        String statusTopic;
+        String commandTopic;
+        uint64 lastConnectAttempt = 0;
+
+        char *receiveBuffer = nullptr;
+        size_t receiveBufferLen = 0;
+        int received = 0;
+};
+
+MqttNetwork *MqttNetwork::instance = nullptr;
+
+#endif
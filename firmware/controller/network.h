@@ -76,6 +76,11 @@ class Network {
             if (packetSize) {
                 Logger.print("Received packet of size: ");
                 Logger.println(packetSize);
+                if (packetSize > len) {
+                    Logger.println("Packet does not fit into the buffer, dropping");
+                    this->udp.flush();
+                    return 0;
+                }
                 int bytesRead = this->udp.read(buffer, len);
                 Logger.println(bytesRead);
                 return bytesRead;
@@ -0,0 +1,81 @@
+#include <ArduinoJson.h>
+#include <ESP8266WiFi.h>
+#include <ESP8266httpUpdate.h>
+#include "logger.h"
+
+#ifndef OTA_H
+#define OTA_H
+
+#ifndef FIRMWARE_VERSION
+    #define FIRMWARE_VERSION "dev"
+#endif
+
+// The core defines ARDUINO_SIGNING when the sketch is built with a public.key
+// next to it, the updater then rejects images not signed with private.key.
+// The images are downloaded over plain HTTP, so the firmware is not built
+// without the key; make them with `task keys:ota` and keep private.key secret.
+#if __has_include(<Updater_Signing.h>)
+    #include <Updater_Signing.h>
+#endif
+#if !defined(ARDUINO_SIGNING) || !ARDUINO_SIGNING
+    #error "OTA updates need a signing key, run task keys:ota to create public.key and private.key"
+#endif
+
+#define OTA_URL_LEN 256
+
+// Ota installs the firmware the backend offers. The image is downloaded over
+// HTTP after the offer is acknowledged; the updater checks the MD5 the server
+// sends and the signature appended to the image. The remote reboots into the
+// new firmware, failures are reported as update_error until the next offer.
+class Ota {
+    public:
+        void offer(JsonObject firmware) {
+            const char *version = firmware["version"] | "";
+            const char *url = firmware["url"] | "";
+            error = "";
+            if (strcmp(version, FIRMWARE_VERSION) == 0) {
+                Logger.println("Offered firmware is already running");
+                return;
+            }
+            if (strlen(url) == 0 || strlen(url) >= OTA_URL_LEN) {
+                error = "invalid firmware url";
+                return;
+            }
+
+            Logger.print("Offered firmware ");
+            Logger.println(version);
+            strcpy(this->url, url);
+            pending = true;
+        }
+
+        // install downloads the offered image, it does not return if the update succeeds
+        void install() {
+            if (!pending) {
+                return;
+            }
+            pending = false;
+
+            Logger.print("Installing firmware from ");
+            Logger.println(url);
+            WiFiClient client;
+            ESPhttpUpdate.rebootOnUpdate(true);
+            if (ESPhttpUpdate.update(client, url, FIRMWARE_VERSION) == HTTP_UPDATE_FAILED) {
+                error = ESPhttpUpdate.getLastErrorString();
+                Logger.print("Firmware update failed: ");
+                Logger.println(error);
+            }
+        }
+
+        void reportStatus(DynamicJsonDocument &json) {
+            if (error.length() > 0) {
+                json["update_error"] = error;
+            }
+        }
+
+    private:
+        char url[OTA_URL_LEN];
+        bool pending = false;
+        String error;
+};
+
+#endif
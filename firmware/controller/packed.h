@@ -0,0 +1,79 @@
+#include <stdint.h>
+#include <stddef.h>
+
+#ifndef PACKED_H
+#define PACKED_H
+
+// Decoder for the packed timings of protocol version 2, see PackTimings in the backend:
+//   uvarint   number of timings
+//   uvarint   dictionary size
+//   uvarint[] dictionary
+//   bits      index of every timing, MSB first
+// Returns the number of timings written into `out`, or 0 if the data is invalid or does not fit.
+
+#define PACKED_MAX_DICTIONARY 64
+
+static bool readUvarint(const uint8_t *&data, const uint8_t *end, uint32_t &value) {
+    value = 0;
+    for (int shift = 0; shift < 32; shift += 7) {
+        if (data >= end) {
+            return false;
+        }
+        uint8_t b = *data++;
+        value |= (uint32_t)(b & 0x7F) << shift;
+        if (!(b & 0x80)) {
+            return true;
+        }
+    }
+    return false;
+}
+
+static size_t unpackTimings(const uint8_t *data, size_t len, uint16_t *out, size_t outLen) {
+    const uint8_t *end = data + len;
+    uint32_t count, dictionarySize;
+
+    if (!readUvarint(data, end, count) || !readUvarint(data, end, dictionarySize)) {
+        return 0;
+    }
+
+    if (count == 0 || count > outLen || dictionarySize == 0 || dictionarySize > PACKED_MAX_DICTIONARY) {
+        return 0;
+    }
+
+    uint16_t dictionary[PACKED_MAX_DICTIONARY];
+    for (uint32_t i = 0; i < dictionarySize; i++) {
+        uint32_t value;
+        if (!readUvarint(data, end, value) || value > 0xFFFF) {
+            return 0;
+        }
+        dictionary[i] = value;
+    }
+
+    int width = 1;
+    while ((1u << width) < dictionarySize) {
+        width++;
+    }
+
+    uint32_t acc = 0;
+    int accBits = 0;
+    for (uint32_t i = 0; i < count; i++) {
+        while (accBits < width) {
+            if (data >= end) {
+                return 0;
+            }
+            acc = (acc << 8) | *data++;
+            accBits += 8;
+        }
+
+        uint32_t index = (acc >> (accBits - width)) & ((1u << width) - 1);
+        accBits -= width;
+        if (index >= dictionarySize) {
+            return 0;
+        }
+        out[i] = dictionary[index];
+    }
+
+    return count;
+}
+
+#endif
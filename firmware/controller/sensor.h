@@ -0,0 +1,61 @@
+#include <ArduinoJson.h>
+#include "logger.h"
+
+#ifdef FIRMWARE_DHT_PIN
+#include <DHTesp.h>
+#endif
+
+#ifndef SENSOR_H
+#define SENSOR_H
+
+#define SENSOR_READ_INTERVAL (30 * 1000) // 30 seconds
+
+// Sensor reads the room temperature and humidity from a DHT sensor connected
+// to FIRMWARE_DHT_PIN. Without the macro the firmware has no sensor and the
+// readings are left out of the status.
+class Sensor {
+    public:
+        void begin() {
+#ifdef FIRMWARE_DHT_PIN
+            dht.setup(FIRMWARE_DHT_PIN, DHTesp::AUTO_DETECT);
+#endif
+        }
+
+        void update(uint64 now) {
+#ifdef FIRMWARE_DHT_PIN
+            if (now < nextRead) {
+                return;
+            }
+            nextRead = now + SENSOR_READ_INTERVAL;
+
+            TempAndHumidity reading = dht.getTempAndHumidity();
+            valid = dht.getStatus() == DHTesp::ERROR_NONE;
+            if (!valid) {
+                Logger.print("Failed to read sensor: ");
+                Logger.println(dht.getStatusString());
+                return;
+            }
+            temperature = reading.temperature;
+            humidity = reading.humidity;
+#endif
+        }
+
+        void reportStatus(DynamicJsonDocument &json) {
+            if (!valid) {
+                return;
+            }
+            json["temperature"] = temperature;
+            json["humidity"] = humidity;
+        }
+
+    private:
+#ifdef FIRMWARE_DHT_PIN
+        DHTesp dht;
+#endif
+        uint64 nextRead = 0;
+        bool valid = false;
+        float temperature = 0;
+        float humidity = 0;
+};
+
+#endif
@@ -0,0 +1,113 @@
+#include <Arduino.h>
+#include "logger.h"
+
+#ifndef SERIAL_NETWORK_H
+#define SERIAL_NETWORK_H
+
+#define SERIAL_SYNC_1 0xA5
+#define SERIAL_SYNC_2 0x5A
+
+// SerialNetwork exchanges the messages with a backend attached over USB, see
+// serial_frame.go. Every message is framed as:
+//   uint8[2] 0xA5 0x5A
+//   uint16   payload length
+//   payload
+//   uint16   CRC-16/CCITT-FALSE of the length and the payload
+// all little endian. The logger shares the port, the backend skips anything
+// outside of a valid frame.
+class SerialNetwork {
+    public:
+        void Connect() {
+            // the logger has already opened the port
+            Logger.println("Exchanging messages over the serial port");
+        }
+
+        void Send(char *buffer, size_t len) {
+            uint8_t header[4] = {SERIAL_SYNC_1, SERIAL_SYNC_2, (uint8_t)(len & 0xFF), (uint8_t)(len >> 8)};
+            uint16_t crc = crc16(0xFFFF, header + 2, 2);
+            crc = crc16(crc, (uint8_t *)buffer, len);
+            uint8_t trailer[2] = {(uint8_t)(crc & 0xFF), (uint8_t)(crc >> 8)};
+
+            Serial.write(header, sizeof(header));
+            Serial.write((uint8_t *)buffer, len);
+            Serial.write(trailer, sizeof(trailer));
+        }
+
+        int Receive(char *buffer, size_t len) {
+            while (Serial.available() > 0) {
+                uint8_t b = Serial.read();
+                switch (this->state) {
+                    case WAIT_SYNC_1:
+                        if (b == SERIAL_SYNC_1) {
+                            this->state = WAIT_SYNC_2;
+                        }
+                        break;
+                    case WAIT_SYNC_2:
+                        this->state = b == SERIAL_SYNC_2 ? READ_LENGTH : (b == SERIAL_SYNC_1 ? WAIT_SYNC_2 : WAIT_SYNC_1);
+                        this->position = 0;
+                        break;
+                    case READ_LENGTH:
+                        this->length |= (uint16_t)b << (8 * this->position);
+                        if (++this->position == 2) {
+                            if (this->length > len) {
+                                Logger.println("Frame does not fit into the buffer, dropping");
+                                this->reset();
+                                break;
+                            }
+                            this->position = 0;
+                            this->state = this->length == 0 ? READ_CRC : READ_PAYLOAD;
+                        }
+                        break;
+                    case READ_PAYLOAD:
+                        buffer[this->position++] = b;
+                        if (this->position == this->length) {
+                            this->position = 0;
+                            this->state = READ_CRC;
+                        }
+                        break;
+                    case READ_CRC:
+                        this->crc |= (uint16_t)b << (8 * this->position);
+                        if (++this->position == 2) {
+                            uint8_t length[2] = {(uint8_t)(this->length & 0xFF), (uint8_t)(this->length >> 8)};
+                            uint16_t expected = crc16(crc16(0xFFFF, length, 2), (uint8_t *)buffer, this->length);
+                            int received = this->length;
+                            bool valid = expected == this->crc;
+                            this->reset();
+                            if (valid) {
+                                return received;
+                            }
+                            Logger.println("Frame checksum mismatch, dropping");
+                        }
+                        break;
+                }
+            }
+            return 0;
+        }
+
+    private:
+        enum State { WAIT_SYNC_1, WAIT_SYNC_2, READ_LENGTH, READ_PAYLOAD, READ_CRC };
+
+        void reset() {
+            this->state = WAIT_SYNC_1;
+            this->length = 0;
+            this->crc = 0;
+            this->position = 0;
+        }
+
+        static uint16_t crc16(uint16_t crc, const uint8_t *data, size_t len) {
+            for (size_t i = 0; i < len; i++) {
+                crc ^= (uint16_t)data[i] << 8;
+                for (int bit = 0; bit < 8; bit++) {
+                    crc = crc & 0x8000 ? (crc << 1) ^ 0x1021 : crc << 1;
+                }
+            }
+            return crc;
+        }
+
+        State state = WAIT_SYNC_1;
+        uint16_t length = 0;
+        uint16_t crc = 0;
+        size_t position = 0;
+};
+
+#endif
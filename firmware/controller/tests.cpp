@@ -1,6 +1,81 @@
 #include <stdio.h>
+#include "packed.h"
+#include "fragments.h"
+
+int testUnpackTimings() {
+    // PackTimings([]int{4350, 4300, 500, 1650, 500, 600})
+    const uint8_t packed[] = {0x06, 0x05, 0xf4, 0x03, 0xd8, 0x04, 0xf2, 0x0c, 0xcc, 0x21, 0xfe, 0x21, 0x8c, 0x20, 0x40};
+    const uint16_t expected[] = {4350, 4300, 500, 1650, 500, 600};
+    uint16_t out[10];
+
+    size_t len = unpackTimings(packed, sizeof(packed), out, 10);
+    if (len != 6) {
+        printf("unpackTimings: expected 6 timings, got %zu\n", len);
+        return 1;
+    }
+
+    for (size_t i = 0; i < len; i++) {
+        if (out[i] != expected[i]) {
+            printf("unpackTimings: timing %zu: expected %d, got %d\n", i, expected[i], out[i]);
+            return 1;
+        }
+    }
+
+    if (unpackTimings(packed, sizeof(packed), out, 5) != 0) {
+        printf("unpackTimings: expected buffer overflow to be rejected\n");
+        return 1;
+    }
 
-int main() {
-    printf("Hello, World!\n");
     return 0;
 }
+
+int testReassembler() {
+    // message "hello world" split into "hello " and "world"
+    const uint8_t first[] = {'I', 'R', 'F', 'R', 0, 0, 0, 7, 0, 2, 0, 0, 0, 11, 'h', 'e', 'l', 'l', 'o', ' '};
+    const uint8_t second[] = {'I', 'R', 'F', 'R', 0, 0, 0, 7, 1, 2, 0, 6, 0, 11, 'w', 'o', 'r', 'l', 'd'};
+    static Reassembler reassembler;
+
+    if (reassembler.add(second, sizeof(second), 100) != 0) {
+        printf("Reassembler: message completed too early\n");
+        return 1;
+    }
+
+    size_t len = reassembler.add(first, sizeof(first), 200);
+    if (len != 11 || memcmp(reassembler.message(), "hello world", 11) != 0) {
+        printf("Reassembler: expected \"hello world\", got %zu bytes\n", len);
+        return 1;
+    }
+
+    // the first fragment expires before the second one arrives
+    reassembler.add(first, sizeof(first), 1000);
+    if (reassembler.add(second, sizeof(second), 1000 + FRAGMENT_TIMEOUT_MS + 1) != 0) {
+        printf("Reassembler: expected expired fragment to be dropped\n");
+        return 1;
+    }
+
+    // "hello" and "world" leave the space in between unset
+    const uint8_t shortFirst[] = {'I', 'R', 'F', 'R', 0, 0, 0, 8, 0, 2, 0, 0, 0, 11, 'h', 'e', 'l', 'l', 'o'};
+    const uint8_t shortSecond[] = {'I', 'R', 'F', 'R', 0, 0, 0, 8, 1, 2, 0, 6, 0, 11, 'w', 'o', 'r', 'l', 'd'};
+    reassembler.add(shortFirst, sizeof(shortFirst), 2000);
+    if (reassembler.add(shortSecond, sizeof(shortSecond), 2000) != 0) {
+        printf("Reassembler: expected message with a gap to be dropped\n");
+        return 1;
+    }
+
+    // "world" moved to 5 overlaps "hello "
+    const uint8_t overlapping[] = {'I', 'R', 'F', 'R', 0, 0, 0, 9, 1, 2, 0, 5, 0, 11, 'w', 'o', 'r', 'l', 'd'};
+    const uint8_t firstOf9[] = {'I', 'R', 'F', 'R', 0, 0, 0, 9, 0, 2, 0, 0, 0, 11, 'h', 'e', 'l', 'l', 'o', ' '};
+    reassembler.add(firstOf9, sizeof(firstOf9), 3000);
+    if (reassembler.add(overlapping, sizeof(overlapping), 3000) != 0) {
+        printf("Reassembler: expected overlapping fragment to be dropped\n");
+        return 1;
+    }
+
+    return 0;
+}
+
+int main() {
+    int failed = testUnpackTimings() || testReassembler();
+    printf(failed ? "FAILED\n" : "OK\n");
+    return failed;
+}
@@ -0,0 +1,192 @@
+#include <Arduino.h>
+#include <ESP8266WiFi.h>
+#include <WebSocketsClient.h>
+#include <bearssl/bearssl.h>
+#include <time.h>
+#include "logger.h"
+
+#ifndef WS_NETWORK_H
+#define WS_NETWORK_H
+
+#define WS_PATH_PREFIX "/ws/"
+#define WS_RECONNECT_INTERVAL (5 * 1000) // 5 seconds
+#define WS_NTP_SERVER "pool.ntp.org"
+#define WS_MIN_TIME 1700000000 // the clock is not set before NTP answers
+#define WS_FINGERPRINT_LEN 20
+
+// WsNetwork exchanges the messages over a WebSocket with TLS for networks
+// that block UDP. Every binary message is one datagram. The backend pings the
+// connection, the library answers the pings and reconnects when it drops.
+// Only the server with the SHA-1 fingerprint, like "AB:CD:..." printed by
+// openssl x509 -noout -fingerprint -sha1, is trusted. The upgrade is signed
+// with the shared secret and the time from NTP.
+class WsNetwork {
+    public:
+        WsNetwork(const char *ssid, const char *pass, const char *host, uint16_t port, const char *deviceId,
+                  const char *sharedSecret, const char *fingerprint) {
+            this->ssid = ssid;
+            this->pass = pass;
+            this->host = host;
+            this->port = port;
+            this->deviceId = deviceId;
+            this->sharedSecret = sharedSecret;
+            this->path = String(WS_PATH_PREFIX) + deviceId;
+            this->validFingerprint = parseFingerprint(fingerprint, this->fingerprint);
+            instance = this;
+        }
+
+        void Connect() {
+            WiFi.mode(WIFI_STA);
+            WiFi.begin(this->ssid, this->pass);
+
+            Logger.print("Connecting to ");
+            Logger.println(this->ssid);
+            while (WiFi.status() != WL_CONNECTED) {
+                delay(100);
+                Logger.print(".");
+            }
+
+            Logger.print("Connected! IP address: ");
+            Logger.println(WiFi.localIP());
+
+            WiFi.setAutoReconnect(true);
+            WiFi.persistent(true);
+
+            if (!this->validFingerprint) {
+                Logger.println("Invalid server fingerprint, not connecting");
+                return;
+            }
+
+            Logger.print("Waiting for the time from NTP");
+            configTime(0, 0, WS_NTP_SERVER);
+            while (time(nullptr) < WS_MIN_TIME) {
+                delay(100);
+                Logger.print(".");
+            }
+            Logger.println();
+
+            Logger.print("Connecting to wss://");
+            Logger.print(this->host);
+            Logger.println(this->path);
+            this->authenticate();
+            this->client.beginSSL(this->host, this->port, this->path.c_str(), this->fingerprint);
+            this->client.onEvent(WsNetwork::onEvent);
+            this->client.setReconnectInterval(WS_RECONNECT_INTERVAL);
+        }
+
+        void Send(char *buffer, size_t len) {
+            Logger.print("Sending message... len: ");
+            Logger.println(len);
+            if (!this->client.isConnected()) {
+                Logger.println("WebSocket is not connected, dropping");
+                return;
+            }
+            this->client.sendBIN((uint8_t *)buffer, len);
+        }
+
+        int Receive(char *buffer, size_t len) {
+            this->received = 0;
+            this->receiveBuffer = buffer;
+            this->receiveBufferLen = len;
+            this->client.loop();
+            return this->received;
+        }
+
+    private:
+        // onEvent copies a message received during loop() into the buffer of Receive
+        static void onEvent(WStype_t type, uint8_t *payload, size_t length) {
+            WsNetwork *self = instance;
+            switch (type) {
+                case WStype_CONNECTED:
+                    Logger.println("WebSocket connected");
+                    break;
+                case WStype_DISCONNECTED:
+                    Logger.println("WebSocket disconnected");
+                    // the library reconnects with the headers, sign them with the time of the reconnect
+                    self->authenticate();
+                    break;
+                case WStype_BIN:
+                    if (self->received > 0 || length > self->receiveBufferLen) {
+                        Logger.println("Message does not fit into the buffer, dropping");
+                        break;
+                    }
+                    memcpy(self->receiveBuffer, payload, length);
+                    self->received = length;
+                    break;
+                default:
+                    break;
+            }
+        }
+
+        // authenticate sets the headers the backend checks: the time and the
+        // hex HMAC-SHA256 of "<device id>:<time>" keyed by the shared secret
+        void authenticate() {
+            String timestamp = String((unsigned long)time(nullptr));
+            String message = String(this->deviceId) + ":" + timestamp;
+
+            br_hmac_key_context key;
+            br_hmac_key_init(&key, &br_sha256_vtable, this->sharedSecret, strlen(this->sharedSecret));
+            br_hmac_context hmac;
+            br_hmac_init(&hmac, &key, 0);
+            br_hmac_update(&hmac, message.c_str(), message.length());
+            uint8_t mac[32];
+            br_hmac_out(&hmac, mac);
+
+            String headers = "X-Device-Time: " + timestamp + "\r\nX-Device-Auth: ";
+            for (size_t i = 0; i < sizeof(mac); i++) {
+                char hex[3];
+                sprintf(hex, "%02x", mac[i]);
+                headers += hex;
+            }
+            this->client.setExtraHeaders(headers.c_str());
+        }
+
+        // parseFingerprint reads the hex bytes of the fingerprint, ignoring separators
+        static bool parseFingerprint(const char *text, uint8_t *out) {
+            size_t len = 0;
+            int high = -1;
+            for (const char *c = text; *c != '\0'; c++) {
+                int digit;
+                if (*c >= '0' && *c <= '9') {
+                    digit = *c - '0';
+                } else if (*c >= 'a' && *c <= 'f') {
+                    digit = *c - 'a' + 10;
+                } else if (*c >= 'A' && *c <= 'F') {
+                    digit = *c - 'A' + 10;
+                } else {
+                    continue;
+                }
+                if (high < 0) {
+                    high = digit;
+                    continue;
+                }
+                if (len == WS_FINGERPRINT_LEN) {
+                    return false;
+                }
+                out[len++] = high << 4 | digit;
+                high = -1;
+            }
+            return len == WS_FINGERPRINT_LEN && high < 0;
+        }
+
+        static WsNetwork *instance;
+
+        WebSocketsClient client;
+        const char *ssid;
+        const char *pass;
+        const char *host;
+        uint16_t port;
+        const char *deviceId;
+        const char *sharedSecret;
+        String path;
+        uint8_t fingerprint[WS_FINGERPRINT_LEN];
+        bool validFingerprint;
+
+        char *receiveBuffer = nullptr;
+        size_t receiveBufferLen = 0;
+        int received = 0;
+};
+
+WsNetwork *WsNetwork::instance = nullptr;
+
+#endif